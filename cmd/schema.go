@@ -0,0 +1,70 @@
+package cmd
+
+import (
+	"encoding/json"
+	"log"
+	"os"
+
+	"github.com/microfast-ch/rea/internal/document"
+	"github.com/spf13/cobra"
+)
+
+func init() {
+	rootCmd.AddCommand(schemaCmd)
+	schemaCmd.AddCommand(schemaInferCmd)
+}
+
+var schemaCmd = &cobra.Command{
+	Use:   "schema",
+	Short: "Work with the JSON Schema of the model of a template",
+}
+
+var schemaInferCmd = &cobra.Command{
+	Use:   "infer",
+	Short: "Generate a starting schema from the variables that are referenced by a template",
+	Run:   schemaInferCmdRun,
+}
+
+func schemaInferCmdRun(cmd *cobra.Command, args []string) {
+	tmplFile, err := cmd.Flags().GetString("template")
+	if err != nil {
+		log.Fatalf("reading template flag: %s", err)
+	}
+
+	outputFile, err := cmd.Flags().GetString("output")
+	if err != nil {
+		log.Fatalf("reading output flag: %s", err)
+	}
+
+	docTemplate, err := document.NewFromFile(tmplFile)
+	if err != nil {
+		log.Fatalf("error loading template file %s: %v", tmplFile, err)
+	}
+
+	s, err := docTemplate.InferSchema()
+	if err != nil {
+		log.Fatalf("inferring schema of %s: %s", tmplFile, err)
+	}
+
+	data, err := json.MarshalIndent(s, "", "  ")
+	if err != nil {
+		log.Fatalf("encoding schema: %s", err)
+	}
+
+	data = append(data, '\n')
+
+	if outputFile == "" {
+		_, err = os.Stdout.Write(data)
+	} else {
+		err = os.WriteFile(outputFile, data, 0o600)
+	}
+
+	if err != nil {
+		log.Fatalf("writing schema: %s", err)
+	}
+}
+
+func init() {
+	schemaInferCmd.Flags().StringP("template", "t", "template.ott", "template document")
+	schemaInferCmd.Flags().StringP("output", "o", "", "schema file (default: stdout), a schema named like the template, e.g. letter.schema.json, validates its models")
+}
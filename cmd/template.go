@@ -2,11 +2,19 @@ package cmd
 
 import (
 	"bufio"
+	"bytes"
+	"encoding/json"
+	"io"
 	"io/ioutil"
 	"log"
 	"os"
+	"time"
 
+	"github.com/microfast-ch/rea/internal/convert"
 	"github.com/microfast-ch/rea/internal/document"
+	"github.com/microfast-ch/rea/internal/engine"
+	"github.com/microfast-ch/rea/internal/pdf"
+	"github.com/microfast-ch/rea/internal/schema"
 	"github.com/microfast-ch/rea/pkg/bundle"
 	"github.com/spf13/cobra"
 	"gopkg.in/yaml.v3"
@@ -23,7 +31,7 @@ var templateCmd = &cobra.Command{
 	Run: templateCmdRun,
 }
 
-// nolint:funlen
+// nolint:funlen,gocognit,gocyclo
 func templateCmdRun(cmd *cobra.Command, args []string) {
 	// Get flag variables
 	tmplFile, err := cmd.Flags().GetString("template")
@@ -59,16 +67,162 @@ func templateCmdRun(cmd *cobra.Command, args []string) {
 		log.Fatalf("error loading template file %s: %v", tmplFile, err)
 	}
 
-	// Create bundle writer
+	library, err := cmd.Flags().GetString("library")
+	if err != nil {
+		log.Fatalf("reading library flag: %s", err)
+	}
+
+	if library != "" {
+		docTemplate.SetTemplateLibrary(library)
+	}
+
+	stripMacros, err := cmd.Flags().GetBool("strip-macros")
+	if err != nil {
+		log.Fatalf("reading strip-macros flag: %s", err)
+	}
+
+	docTemplate.SetStripMacros(stripMacros)
+
+	deterministic, err := cmd.Flags().GetBool("deterministic")
+	if err != nil {
+		log.Fatalf("reading deterministic flag: %s", err)
+	}
+
+	docTemplate.SetDeterministic(deterministic)
+
+	invalidChars, err := cmd.Flags().GetString("invalid-chars")
+	if err != nil {
+		log.Fatalf("reading invalid-chars flag: %s", err)
+	}
+
+	charPolicy, err := engine.ParseCharPolicy(invalidChars)
+	if err != nil {
+		log.Fatalf("invalid-chars flag: %s", err)
+	}
+
+	docTemplate.SetCharPolicy(charPolicy)
+
+	strict, err := cmd.Flags().GetBool("strict")
+	if err != nil {
+		log.Fatalf("reading strict flag: %s", err)
+	}
+
+	lenient, err := cmd.Flags().GetBool("lenient")
+	if err != nil {
+		log.Fatalf("reading lenient flag: %s", err)
+	}
+
+	switch {
+	case strict && lenient:
+		log.Fatalf("strict and lenient flags are mutually exclusive")
+	case strict:
+		docTemplate.SetUndefinedMode(engine.UndefinedStrict)
+	case lenient:
+		docTemplate.SetUndefinedMode(engine.UndefinedLenient)
+	}
+
+	schemaFile, err := cmd.Flags().GetString("schema")
+	if err != nil {
+		log.Fatalf("reading schema flag: %s", err)
+	}
+
+	if schemaFile != "" {
+		data, err := ioutil.ReadFile(schemaFile)
+		if err != nil {
+			log.Fatalf("reading schema file %s: %s", schemaFile, err)
+		}
+
+		s, err := schema.Parse(data)
+		if err != nil {
+			log.Fatalf("schema file %s: %s", schemaFile, err)
+		}
+
+		docTemplate.SetSchema(s)
+	}
+
+	thumbnailFile, err := cmd.Flags().GetString("thumbnail")
+	if err != nil {
+		log.Fatalf("reading thumbnail flag: %s", err)
+	}
+
+	if thumbnailFile != "" {
+		thumbnail, err := ioutil.ReadFile(thumbnailFile)
+		if err != nil {
+			log.Fatalf("reading thumbnail file %s: %s", thumbnailFile, err)
+		}
+
+		docTemplate.SetThumbnail(thumbnail)
+	}
+
+	// The output format is the one of the output file, unless set explicitly
+	format, err := cmd.Flags().GetString("format")
+	if err != nil {
+		log.Fatalf("reading format flag: %s", err)
+	}
+
+	if format == "" {
+		format = convert.Format(outputFile)
+	}
+
+	pdfa, err := cmd.Flags().GetInt("pdfa")
+	if err != nil {
+		log.Fatalf("reading pdfa flag: %s", err)
+	}
+
+	if pdfa < 0 || pdfa > 3 {
+		log.Fatalf("unsupported PDF/A part %d, must be 1, 2 or 3", pdfa)
+	}
+
+	docTemplate.SetOutputFormat(format, &convert.Soffice{PDFA: pdfa})
+
+	attach, err := cmd.Flags().GetStringSlice("attach")
+	if err != nil {
+		log.Fatalf("reading attach flag: %s", err)
+	}
+
+	attachModel, attachBundle := false, false
+
+	for _, a := range attach {
+		switch a {
+		case "model":
+			attachModel = true
+		case "bundle":
+			attachBundle = true
+		default:
+			log.Fatalf("unsupported attachment %q, must be model or bundle", a)
+		}
+	}
+
+	if len(attach) > 0 && format != "pdf" {
+		log.Fatalf("attachments are only supported for pdf output, not %s", format)
+	}
+
+	if len(attach) > 0 && pdfa != 0 && pdfa != 3 {
+		log.Fatalf("attachments are only allowed by PDF/A-3, not by PDF/A-%d", pdfa)
+	}
+
+	// Create bundle writer, an attached bundle is kept in memory as well
 	var bundleW *bundle.Writer
 
+	var bundleData bytes.Buffer
+
+	var bundleOut []io.Writer
+
 	if bundleFile != "" {
 		bundleFD, err := os.Create(bundleFile)
 		if err != nil {
 			log.Fatalf("creating bundle file %s: %s", bundleFile, err)
 		}
 
-		bundleW = bundle.New(bundleFD, debug)
+		bundleOut = append(bundleOut, bundleFD)
+	}
+
+	if attachBundle {
+		bundleOut = append(bundleOut, &bundleData)
+	}
+
+	if len(bundleOut) > 0 {
+		bundleW = bundle.New(io.MultiWriter(bundleOut...), debug)
 	}
 
 	modelFile, err := cmd.Flags().GetString("model")
@@ -88,8 +242,15 @@ func templateCmdRun(cmd *cobra.Command, args []string) {
 		log.Fatalf("unable to unmarshal yaml to model: %v", err)
 	}
 
-	// Run rendering and first write bundle before throwing error
-	tpd, err := docTemplate.Write(&model, outputBuf)
+	// Run rendering and first write bundle before throwing error, attachments are added afterwards
+	var rendered bytes.Buffer
+
+	var docOut io.Writer = outputBuf
+	if len(attach) > 0 {
+		docOut = &rendered
+	}
+
+	tpd, err := docTemplate.Write(&model, docOut)
 	if err != nil {
 		log.Fatalf("executing templating: %s", err)
 	}
@@ -108,6 +269,35 @@ func templateCmdRun(cmd *cobra.Command, args []string) {
 		}
 	}
 
+	if len(attach) > 0 {
+		files := []pdf.File{}
+
+		if attachModel {
+			data, err := json.MarshalIndent(&model, "", "  ")
+			if err != nil {
+				log.Fatalf("encoding model for attachment: %s", err)
+			}
+
+			files = append(files, pdf.File{Name: "model.json", MediaType: "application/json",
+				Description: "Model of the document", Data: data, ModTime: time.Now()})
+		}
+
+		if attachBundle {
+			files = append(files, pdf.File{Name: "bundle.tar", MediaType: "application/x-tar",
+				Description: "Job bundle of the document", Data: bundleData.Bytes(), ModTime: time.Now()})
+		}
+
+		data, err := pdf.Attach(rendered.Bytes(), files)
+		if err != nil {
+			log.Fatalf("attaching files to document: %s", err)
+		}
+
+		_, err = outputBuf.Write(data)
+		if err != nil {
+			log.Fatalf("writing output document: %s", err)
+		}
+	}
+
 	// Finish
 	err = outputBuf.Flush()
 	if err != nil {
@@ -126,4 +316,15 @@ func init() {
 	templateCmd.Flags().StringP("output", "o", "document.odt", "output document")
 	templateCmd.Flags().StringP("bundle", "b", "", "tar file to which the job bundle should be written")
 	templateCmd.Flags().BoolP("debug", "d", false, "write debug information to job bundle")
+	templateCmd.Flags().StringP("library", "l", "", "directory from which includes are resolved (default: directory of the template)")
+	templateCmd.Flags().Bool("deterministic", false, "write byte-identical output documents for identical input")
+	templateCmd.Flags().String("invalid-chars", "replace", "handling of printed characters not allowed in XML: replace, strip or error")
+	templateCmd.Flags().StringP("format", "f", "", "format of the output document, e.g. docx or pdf (default: extension of the output file)")
+	templateCmd.Flags().Int("pdfa", 0, "PDF/A part of pdf output, e.g. 2 for PDF/A-2b (default: no PDF/A)")
+	templateCmd.Flags().StringSlice("attach", nil, "files embedded into pdf output for provenance: model, bundle")
+	templateCmd.Flags().String("thumbnail", "", "PNG image used as thumbnail of the output document (default: thumbnail is removed)")
+	templateCmd.Flags().String("schema", "", "JSON Schema the model is validated against (default: schema of the template, if any)")
+	templateCmd.Flags().Bool("strict", false, "fail on undefined variables and printed nil values")
+	templateCmd.Flags().Bool("lenient", false, "print nil values as empty strings")
+	templateCmd.Flags().Bool("strip-macros", false, "remove the macros of macro-enabled Word documents and templates")
 }
@@ -0,0 +1,64 @@
+package cmd
+
+import (
+	"encoding/json"
+	"log"
+	"os"
+
+	"github.com/microfast-ch/rea/internal/document"
+	"github.com/spf13/cobra"
+)
+
+func init() {
+	rootCmd.AddCommand(varsCmd)
+}
+
+var varsCmd = &cobra.Command{
+	Use:   "vars",
+	Short: "List the model values that are read by a template as JSON",
+	Run:   varsCmdRun,
+}
+
+func varsCmdRun(cmd *cobra.Command, args []string) {
+	tmplFile, err := cmd.Flags().GetString("template")
+	if err != nil {
+		log.Fatalf("reading template flag: %s", err)
+	}
+
+	outputFile, err := cmd.Flags().GetString("output")
+	if err != nil {
+		log.Fatalf("reading output flag: %s", err)
+	}
+
+	docTemplate, err := document.NewFromFile(tmplFile)
+	if err != nil {
+		log.Fatalf("error loading template file %s: %v", tmplFile, err)
+	}
+
+	vars, err := docTemplate.Variables()
+	if err != nil {
+		log.Fatalf("reading variables of %s: %s", tmplFile, err)
+	}
+
+	data, err := json.MarshalIndent(vars, "", "  ")
+	if err != nil {
+		log.Fatalf("encoding variables: %s", err)
+	}
+
+	data = append(data, '\n')
+
+	if outputFile == "" {
+		_, err = os.Stdout.Write(data)
+	} else {
+		err = os.WriteFile(outputFile, data, 0o600)
+	}
+
+	if err != nil {
+		log.Fatalf("writing variables: %s", err)
+	}
+}
+
+func init() {
+	varsCmd.Flags().StringP("template", "t", "template.ott", "template document")
+	varsCmd.Flags().StringP("output", "o", "", "output file (default: stdout)")
+}
@@ -0,0 +1,135 @@
+// Package convert converts documents between formats with an external converter.
+package convert
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/microfast-ch/rea/internal/utils"
+)
+
+var ErrConvert = errors.New("convertErr")
+
+// Converter converts documents between formats. Formats are file extensions without dot, e.g. `docx` or `pdf`.
+type Converter interface {
+	Convert(in io.Reader, from, to string, out io.Writer) error
+}
+
+// Format returns the format of a file by its extension, e.g. `docx` for `letter.DOCX`.
+func Format(path string) string {
+	return strings.ToLower(strings.TrimPrefix(filepath.Ext(path), "."))
+}
+
+// Soffice converts documents with a headless LibreOffice.
+type Soffice struct {
+	Path    string        // Path of the soffice binary, looked up in PATH if empty
+	Timeout time.Duration // Maximum duration of a conversion, unlimited if zero
+	PDFA    int           // PDF/A part of PDF results, e.g. 2 for PDF/A-2b, no PDF/A if zero
+}
+
+// pdfFilters are the PDF export filters of LibreOffice by the format of the converted document.
+var pdfFilters = map[string]string{
+	"ods": "calc_pdf_Export", "fods": "calc_pdf_Export", "xlsx": "calc_pdf_Export",
+	"odp": "impress_pdf_Export", "fodp": "impress_pdf_Export", "pptx": "impress_pdf_Export",
+}
+
+// Convert writes the input document in the given format to out. Each conversion runs with its own
+// LibreOffice profile, so several conversions can run at the same time.
+func (s *Soffice) Convert(in io.Reader, from, to string, out io.Writer) error {
+	bin, err := s.binary()
+	if err != nil {
+		return err
+	}
+
+	dir, err := ioutil.TempDir("", "rea-convert-")
+	if err != nil {
+		return fmt.Errorf("creating conversion directory: %w", err)
+	}
+	defer os.RemoveAll(dir)
+
+	input := filepath.Join(dir, "document."+from)
+
+	data, err := ioutil.ReadAll(in)
+	if err != nil {
+		return fmt.Errorf("reading document: %w", err)
+	}
+
+	err = ioutil.WriteFile(input, data, 0600)
+	if err != nil {
+		return fmt.Errorf("writing document for conversion: %w", err)
+	}
+
+	ctx := context.Background()
+
+	if s.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, s.Timeout)
+
+		defer cancel()
+	}
+
+	outDir := filepath.Join(dir, "out")
+	profile := "file://" + filepath.ToSlash(filepath.Join(dir, "profile"))
+
+	// nolint:gosec // The binary is configured by the caller, the arguments are not passed to a shell
+	cmd := exec.CommandContext(ctx, bin, "-env:UserInstallation="+profile,
+		"--headless", "--convert-to", s.convertTo(from, to), "--outdir", outDir, input)
+
+	output, err := cmd.CombinedOutput()
+	if err != nil {
+		return utils.FormatError(ErrConvert, fmt.Sprintf("running %s: %s: %s", bin, err, bytes.TrimSpace(output)))
+	}
+
+	// The format can be followed by the filter, e.g. `pdf:writer_pdf_Export`
+	ext, _, _ := strings.Cut(to, ":")
+
+	result, err := ioutil.ReadFile(filepath.Join(outDir, "document."+ext))
+	if err != nil {
+		return utils.FormatError(ErrConvert, fmt.Sprintf("converting %s to %s: %s", from, to, bytes.TrimSpace(output)))
+	}
+
+	_, err = out.Write(result)
+	if err != nil {
+		return fmt.Errorf("writing converted document: %w", err)
+	}
+
+	return nil
+}
+
+// convertTo returns the argument of `--convert-to`, which selects the PDF/A part for PDF results.
+func (s *Soffice) convertTo(from, to string) string {
+	if to != "pdf" || s.PDFA == 0 {
+		return to
+	}
+
+	filter, ok := pdfFilters[from]
+	if !ok {
+		filter = "writer_pdf_Export"
+	}
+
+	return fmt.Sprintf(`pdf:%s:{"SelectPdfVersion":{"type":"long","value":"%d"}}`, filter, s.PDFA)
+}
+
+// binary returns the path of the soffice binary.
+func (s *Soffice) binary() (string, error) {
+	if s.Path != "" {
+		return s.Path, nil
+	}
+
+	for _, name := range []string{"soffice", "libreoffice"} {
+		if bin, err := exec.LookPath(name); err == nil {
+			return bin, nil
+		}
+	}
+
+	return "", utils.FormatError(ErrConvert, "soffice not found in PATH, LibreOffice is required to convert documents")
+}
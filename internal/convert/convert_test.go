@@ -0,0 +1,79 @@
+package convert
+
+import (
+	"bytes"
+	"io/ioutil"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+// fakeSoffice is a script that behaves like `soffice --convert-to`, it prefixes the document with the format.
+const fakeSoffice = `#!/bin/sh
+for arg; do
+	case "$prev" in
+	--convert-to) to="$arg" ;;
+	--outdir) outdir="$arg" ;;
+	esac
+	prev="$arg"
+done
+case "$arg" in
+*.fail) echo "conversion failed" >&2; exit 1 ;;
+*.skip) exit 0 ;;
+esac
+mkdir -p "$outdir"
+name=$(basename "$arg")
+{ printf '%s:' "$to"; cat "$arg"; } > "$outdir/${name%.*}.${to%%:*}"
+`
+
+func newFakeSoffice(t *testing.T) *Soffice {
+	t.Helper()
+
+	if runtime.GOOS == "windows" {
+		t.Skip("fake soffice is a shell script")
+	}
+
+	path := filepath.Join(t.TempDir(), "soffice")
+	require.Nil(t, ioutil.WriteFile(path, []byte(fakeSoffice), 0700))
+
+	return &Soffice{Path: path}
+}
+
+func TestSofficeConvert(t *testing.T) {
+	s := newFakeSoffice(t)
+
+	out := new(bytes.Buffer)
+	err := s.Convert(strings.NewReader("document"), "odt", "docx", out)
+	require.Nil(t, err)
+	require.Equal(t, "docx:document", out.String())
+
+	// The filter is passed to soffice, but is not part of the file name
+	out.Reset()
+	err = s.Convert(strings.NewReader("document"), "odt", "pdf:writer_pdf_Export", out)
+	require.Nil(t, err)
+	require.Equal(t, "pdf:writer_pdf_Export:document", out.String())
+
+	// PDF/A is selected with the export filter of the document type
+	s.PDFA = 2
+	out.Reset()
+	err = s.Convert(strings.NewReader("document"), "ods", "pdf", out)
+	require.Nil(t, err)
+	require.Equal(t, `pdf:calc_pdf_Export:{"SelectPdfVersion":{"type":"long","value":"2"}}:document`, out.String())
+
+	s.PDFA = 0
+	err = s.Convert(strings.NewReader("document"), "fail", "docx", out)
+	require.ErrorIs(t, err, ErrConvert)
+	require.Contains(t, err.Error(), "conversion failed")
+
+	err = s.Convert(strings.NewReader("document"), "skip", "docx", out)
+	require.ErrorIs(t, err, ErrConvert)
+}
+
+func TestFormat(t *testing.T) {
+	require.Equal(t, "docx", Format("out/letter.DOCX"))
+	require.Equal(t, "fodt", Format("letter.fodt"))
+	require.Equal(t, "", Format("letter"))
+}
@@ -0,0 +1,111 @@
+package document
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/microfast-ch/rea/internal/convert"
+	"github.com/microfast-ch/rea/internal/odf"
+	"github.com/microfast-ch/rea/internal/ooxml"
+	"github.com/microfast-ch/rea/internal/utils"
+)
+
+// SetOutputFormat sets the format of the written document as file extension, e.g. `docx` or `fodt`.
+// ODF documents are written flat or packaged as requested, other formats than the one of the template
+// are converted with the converter, e.g. a document of an ODT template to DOCX.
+func (p *PackagedDocument) SetOutputFormat(format string, conv convert.Converter) {
+	p.outputFormat = strings.ToLower(strings.TrimPrefix(format, "."))
+	p.converter = conv
+}
+
+// odfRepresentation returns the representation in which ODF documents are written. An output
+// format of the packaged or flat ODF format of the document takes precedence over SetODFOutput.
+func (p *PackagedDocument) odfRepresentation() ODFOutput {
+	if ext := odfExtension(p.doc); ext != "" {
+		switch p.outputFormat {
+		case ext:
+			return ODFOutputPackage
+		case "f" + ext:
+			return ODFOutputFlat
+		}
+	}
+
+	return p.odfOutput
+}
+
+// writeConverted writes the document to a buffer and converts it to the output format.
+func (p *PackagedDocument) writeConverted(model *Model, out io.Writer) (*ProcessingData, error) {
+	from := p.nativeFormat()
+
+	if p.converter == nil {
+		return nil, utils.FormatError(convert.ErrConvert, fmt.Sprintf("no converter from %s to %s", from, p.outputFormat))
+	}
+
+	var buf bytes.Buffer
+
+	templateData, err := p.write(model, &buf)
+	if err != nil {
+		return templateData, err
+	}
+
+	err = p.converter.Convert(&buf, from, p.outputFormat, out)
+	if err != nil {
+		return templateData, fmt.Errorf("converting %s to %s: %w", from, p.outputFormat, err)
+	}
+
+	return templateData, nil
+}
+
+// nativeFormat returns the format of the document that is written without conversion as file extension.
+func (p *PackagedDocument) nativeFormat() string {
+	switch doc := p.doc.(type) {
+	case *odf.Odf:
+		if p.odfRepresentation() == ODFOutputFlat {
+			return "f" + odfExtension(doc)
+		}
+
+		return odfExtension(doc)
+	case *odf.Flat:
+		if p.odfRepresentation() == ODFOutputPackage {
+			return odfExtension(doc)
+		}
+
+		return "f" + odfExtension(doc)
+	case *ooxml.OOXML:
+		switch ooxml.DocumentContentType(doc.MIMEType(), p.stripMacros) {
+		case ooxml.SpreadsheetContentType:
+			return "xlsx"
+		case ooxml.PresentationContentType:
+			return "pptx"
+		case ooxml.MacroDocumentContentType:
+			return "docm"
+		default:
+			return "docx"
+		}
+	default:
+		return ""
+	}
+}
+
+// odfExtension returns the file extension of packaged ODF documents of the document's type,
+// or an empty string if it is no ODF document.
+func odfExtension(doc Format) string {
+	switch doc.(type) {
+	case *odf.Odf, *odf.Flat:
+	default:
+		return ""
+	}
+
+	switch odf.DocumentMIMEType(doc.MIMEType()) {
+	case odf.MIMETypeText:
+		return "odt"
+	case odf.MIMETypeSpreadsheet:
+		return "ods"
+	case odf.MIMETypePresentation:
+		return "odp"
+	default:
+		return ""
+	}
+}
@@ -0,0 +1,91 @@
+package document
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"testing"
+
+	"github.com/microfast-ch/rea/internal/convert"
+	"github.com/microfast-ch/rea/internal/odf"
+	"github.com/stretchr/testify/require"
+)
+
+// fakeConverter records the conversions and writes the formats instead of a converted document.
+type fakeConverter struct {
+	conversions []string
+}
+
+func (c *fakeConverter) Convert(in io.Reader, from, to string, out io.Writer) error {
+	c.conversions = append(c.conversions, from+"->"+to)
+	_, err := fmt.Fprintf(out, "%s->%s", from, to)
+
+	return err
+}
+
+func TestOutputFormat(t *testing.T) {
+	model := &Model{Data: map[string]any{"items": []any{"Apple"}}}
+
+	tests := []struct {
+		template    string
+		format      string
+		conversions []string
+	}{
+		{"Conditional1.odt", "", nil},
+		{"Conditional1.odt", "odt", nil},
+		{"Conditional1.odt", "fodt", nil},
+		{"Conditional1.fodt", "odt", nil},
+		{"Conditional1.docx", "DOCX", nil},
+		{"Conditional1.odt", "docx", []string{"odt->docx"}},
+		{"Conditional1.fodt", "pdf", []string{"fodt->pdf"}},
+		{"Conditional1.docx", "odt", []string{"docx->odt"}},
+	}
+
+	for _, tc := range tests {
+		tmpl, err := NewFromFile("../../testdata/" + tc.template)
+		require.Nil(t, err)
+
+		conv := &fakeConverter{}
+		tmpl.SetOutputFormat(tc.format, conv)
+
+		out := bytes.NewBuffer([]byte(""))
+		_, err = tmpl.Write(model, out)
+		require.Nil(t, err, tc)
+		require.Equal(t, tc.conversions, conv.conversions, tc)
+
+		if len(tc.conversions) > 0 {
+			require.Equal(t, tc.conversions[0], out.String())
+		}
+	}
+
+	// The representation of ODF documents follows the format
+	tmpl, err := NewFromFile("../../testdata/Conditional1.odt")
+	require.Nil(t, err)
+
+	tmpl.SetOutputFormat("fodt", nil)
+
+	out := bytes.NewBuffer([]byte(""))
+	_, err = tmpl.Write(model, out)
+	require.Nil(t, err)
+
+	_, err = odf.NewFlat(bytes.NewReader(out.Bytes()), int64(out.Len()))
+	require.Nil(t, err)
+
+	// The format doesn't change the representation set by SetODFOutput
+	require.Equal(t, ODFOutputTemplate, tmpl.odfOutput)
+
+	tmpl.SetOutputFormat("", nil)
+
+	out.Reset()
+	_, err = tmpl.Write(model, out)
+	require.Nil(t, err)
+
+	_, err = odf.NewFlat(bytes.NewReader(out.Bytes()), int64(out.Len()))
+	require.NotNil(t, err)
+
+	// Without converter, other formats can't be written
+	tmpl.SetOutputFormat("docx", nil)
+
+	_, err = tmpl.Write(model, out)
+	require.ErrorIs(t, err, convert.ErrConvert)
+}
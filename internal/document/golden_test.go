@@ -0,0 +1,234 @@
+package document
+
+import (
+	"bytes"
+	"encoding/xml"
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/stretchr/testify/require"
+	"gopkg.in/yaml.v3"
+)
+
+var update = flag.Bool("update", false, "update golden files in testdata/golden")
+
+// runGolden templates the given file with the model and compares the resulting XML, normalized
+// with normalizeXML, with the golden file in testdata/golden. The paths are relative to testdata
+// and without model the template is rendered with an empty one.
+func runGolden(t *testing.T, tmplFile, modelFile string) {
+	t.Helper()
+
+	tmpl, err := NewFromFile(filepath.Join("../../testdata", tmplFile))
+	require.Nil(t, err)
+
+	var model Model
+
+	if modelFile != "" {
+		modelData, err := ioutil.ReadFile(filepath.Join("../../testdata", modelFile))
+		require.Nil(t, err)
+		require.Nil(t, yaml.Unmarshal(modelData, &model))
+	}
+
+	out := bytes.NewBuffer([]byte(""))
+	tpd, err := tmpl.Write(&model, out)
+	require.Nil(t, err)
+
+	got, err := normalizeXML([]byte(tpd.XMLResult))
+	require.Nil(t, err)
+
+	goldenFile := filepath.Join("../../testdata/golden", filepath.Base(tmplFile)+".xml")
+	if *update {
+		require.Nil(t, ioutil.WriteFile(goldenFile, []byte(got), 0600))
+	}
+
+	want, err := ioutil.ReadFile(goldenFile)
+	require.Nil(t, err)
+
+	if diff := cmp.Diff(string(want), got); diff != "" {
+		t.Errorf("%s mismatch (-want +got):\n%s", goldenFile, diff)
+	}
+}
+
+// normalizeXML writes each token on its own line, indented by its depth and with sorted
+// attributes, so differences to the goldens are shown line by line. Line breaks in
+// character data are escaped to keep the lines intact.
+func normalizeXML(data []byte) (string, error) {
+	var b strings.Builder
+
+	d := xml.NewDecoder(bytes.NewReader(data))
+	depth := 0
+
+	for {
+		tok, err := d.RawToken()
+		if err == io.EOF {
+			return b.String(), nil
+		}
+
+		if err != nil {
+			return "", fmt.Errorf("reading xml token: %w", err)
+		}
+
+		if _, ok := tok.(xml.EndElement); ok {
+			depth--
+		}
+
+		b.WriteString(strings.Repeat("  ", depth))
+
+		switch v := tok.(type) {
+		case xml.StartElement:
+			attrs := make([]string, len(v.Attr))
+			for i, a := range v.Attr {
+				attrs[i] = fmt.Sprintf(` %s="%s"`, rawName(a.Name), escapeText(a.Value))
+			}
+
+			sort.Strings(attrs)
+			fmt.Fprintf(&b, "<%s%s>", rawName(v.Name), strings.Join(attrs, ""))
+
+			depth++
+		case xml.EndElement:
+			fmt.Fprintf(&b, "</%s>", rawName(v.Name))
+		case xml.CharData:
+			b.WriteString(escapeText(string(v)))
+		case xml.Comment:
+			fmt.Fprintf(&b, "<!--%s-->", v)
+		case xml.ProcInst:
+			fmt.Fprintf(&b, "<?%s %s?>", v.Target, v.Inst)
+		case xml.Directive:
+			fmt.Fprintf(&b, "<!%s>", v)
+		}
+
+		b.WriteByte('\n')
+	}
+}
+
+// rawName returns the name with the prefix as written in the document.
+func rawName(name xml.Name) string {
+	if name.Space == "" {
+		return name.Local
+	}
+
+	return name.Space + ":" + name.Local
+}
+
+func escapeText(s string) string {
+	var b strings.Builder
+
+	_ = xml.EscapeText(&b, []byte(s))
+
+	return b.String()
+}
+
+// Templates without blocks are written as semantically identical XML, i.e. with the same
+// elements, attributes and text after resolving the namespaces.
+func TestRoundTrip(t *testing.T) {
+	for _, tc := range []struct {
+		file string
+		part string
+	}{
+		{"Basic1.ott", "content.xml"},
+		{"Plain1.docx", "word/document.xml"},
+		{"../examples/bill.odt", "content.xml"},
+	} {
+		tmpl, err := NewFromFile(filepath.Join("../../testdata", tc.file))
+		require.Nil(t, err)
+
+		fd, err := tmpl.doc.Open(tc.part)
+		require.Nil(t, err)
+
+		want, err := ioutil.ReadAll(fd)
+		require.Nil(t, err)
+		fd.Close()
+
+		// The byte order mark is not part of the XML
+		want = bytes.TrimPrefix(want, []byte("\xef\xbb\xbf"))
+
+		tpd, err := tmpl.Write(&Model{}, ioutil.Discard)
+		require.Nil(t, err)
+
+		if diff := cmp.Diff(semanticTokens(t, want), semanticTokens(t, []byte(tpd.XMLResult))); diff != "" {
+			t.Errorf("%s mismatch (-want +got):\n%s", tc.file, diff)
+		}
+	}
+}
+
+// semanticTokens returns the tokens of the XML with resolved namespaces and sorted attributes.
+func semanticTokens(t *testing.T, data []byte) []xml.Token {
+	t.Helper()
+
+	d := xml.NewDecoder(bytes.NewReader(data))
+	tokens := []xml.Token{}
+
+	for {
+		tok, err := d.Token()
+		if err == io.EOF {
+			return tokens
+		}
+
+		require.Nil(t, err)
+
+		tok = xml.CopyToken(tok)
+		if v, ok := tok.(xml.StartElement); ok {
+			sort.Slice(v.Attr, func(i, j int) bool {
+				return rawName(v.Attr[i].Name) < rawName(v.Attr[j].Name)
+			})
+		}
+
+		tokens = append(tokens, tok)
+	}
+}
+
+func TestConditionalRemovalODT(t *testing.T) {
+	runGolden(t, "Conditional1.odt", "Conditional1.yaml")
+}
+
+func TestConditionalRemovalOOXML(t *testing.T) {
+	runGolden(t, "Conditional1.docx", "Conditional1.yaml")
+}
+
+func TestConditionalRemovalFODT(t *testing.T) {
+	runGolden(t, "Conditional1.fodt", "Conditional1.yaml")
+}
+
+func TestSpreadsheetODS(t *testing.T) {
+	runGolden(t, "Spreadsheet1.ots", "Spreadsheet1.yaml")
+}
+
+func TestPresentationODP(t *testing.T) {
+	runGolden(t, "Presentation1.otp", "Presentation1.yaml")
+}
+
+func TestSpreadsheetXLSX(t *testing.T) {
+	runGolden(t, "Spreadsheet1.xlsx", "Spreadsheet1.yaml")
+}
+
+func TestPresentationPPTX(t *testing.T) {
+	runGolden(t, "Presentation1.pptx", "Presentation1.yaml")
+}
+
+func TestExampleLetter(t *testing.T) {
+	runGolden(t, "../examples/letter.odt", "../examples/letter.yaml")
+}
+
+func TestExampleBill(t *testing.T) {
+	runGolden(t, "../examples/bill.odt", "")
+}
+
+func TestExampleIterations(t *testing.T) {
+	runGolden(t, "../examples/iterations.odt", "")
+}
+
+// The templated variants of the examples cover the output that depends on the model.
+func TestTemplatedBill(t *testing.T) {
+	runGolden(t, "Bill1.odt", "Bill1.yaml")
+}
+
+func TestTemplatedIterations(t *testing.T) {
+	runGolden(t, "Iterations1.odt", "Iterations1.yaml")
+}
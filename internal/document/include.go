@@ -0,0 +1,295 @@
+package document
+
+import (
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"github.com/djboris9/xmltree"
+	"github.com/microfast-ch/rea/internal/engine"
+	"github.com/microfast-ch/rea/internal/odf"
+	"github.com/microfast-ch/rea/internal/utils"
+	"golang.org/x/exp/slices"
+)
+
+var ErrInclude = errors.New("includeErr")
+
+// SetTemplateLibrary sets the directory from which includes are resolved.
+// By default it is the directory of the template file.
+func (p *PackagedDocument) SetTemplateLibrary(dir string) {
+	p.library = dir
+}
+
+// resolveInclude returns the path of the file in the template library for the include name.
+// The name is a slash separated path relative to the library, with or without extension.
+func (p *PackagedDocument) resolveInclude(name string, exts []string) (string, error) {
+	if p.library == "" {
+		return "", utils.FormatError(ErrInclude, fmt.Sprintf("no template library set to resolve include %q", name))
+	}
+
+	clean := path.Clean(name)
+	if path.IsAbs(clean) || clean == ".." || strings.HasPrefix(clean, "../") {
+		return "", utils.FormatError(ErrInclude, fmt.Sprintf("include %q is outside of the template library", name))
+	}
+
+	candidates := []string{}
+
+	for _, ext := range exts {
+		if path.Ext(clean) == ext {
+			candidates = []string{clean}
+			break
+		}
+
+		candidates = append(candidates, clean+ext)
+	}
+
+	for _, c := range candidates {
+		file := filepath.Join(p.library, filepath.FromSlash(c))
+		if _, err := os.Stat(file); err == nil {
+			return file, nil
+		}
+	}
+
+	return "", utils.FormatError(ErrInclude, fmt.Sprintf("include %q not found in template library %s", name, p.library))
+}
+
+// odfIncludes holds the fragments of the ODF includes and the resources they require.
+type odfIncludes struct {
+	fragments map[string]*xmltree.Node
+	styles    []*xmltree.Node // Automatic styles, renamed to be unique
+	fontFaces []*xmltree.Node // Font face declarations
+	media     odf.Overrides   // Media files, renamed to be unique
+}
+
+// loadOdfIncludes loads all includes referenced by tree and by the includes themselves.
+func (p *PackagedDocument) loadOdfIncludes(tree *xmltree.Node) (*odfIncludes, error) {
+	inc := &odfIncludes{
+		fragments: map[string]*xmltree.Node{},
+		media:     odf.Overrides{},
+	}
+
+	for queue := engine.FindIncludes(tree); len(queue) > 0; queue = queue[1:] {
+		name := queue[0]
+		if _, ok := inc.fragments[name]; ok {
+			continue
+		}
+
+		file, err := p.resolveInclude(name, []string{".odt", ".ott"})
+		if err != nil {
+			return nil, err
+		}
+
+		doc, err := odf.NewFromFile(file)
+		if err != nil {
+			return nil, fmt.Errorf("loading include %q: %w", name, err)
+		}
+
+		fragment, err := inc.add(doc, fmt.Sprintf("inc%d-", len(inc.fragments)+1))
+		doc.Close()
+
+		if err != nil {
+			return nil, fmt.Errorf("loading include %q: %w", name, err)
+		}
+
+		inc.fragments[name] = fragment
+		queue = append(queue, engine.FindIncludes(fragment)...)
+	}
+
+	return inc, nil
+}
+
+// add extracts the body text of the document as fragment and collects its automatic styles,
+// font faces and media. Automatic styles and media are prefixed, so they don't clash with others.
+func (inc *odfIncludes) add(doc *odf.Odf, prefix string) (*xmltree.Node, error) {
+	content, err := getODFContent(doc)
+	if err != nil {
+		return nil, err
+	}
+
+	root := utils.FindChild(content, nsOffice, "document-content")
+	text := utils.FindChild(utils.FindChild(root, nsOffice, "body"), nsOffice, "text")
+
+	if text == nil {
+		return nil, utils.FormatError(ErrInclude, "content.xml has no text body")
+	}
+
+	// Declarations and forms are not part of the text flow
+	fragment := bodyFragment(text, func(elem xml.StartElement) bool {
+		return strings.HasSuffix(elem.Name.Local, "-decls") || elem.Name.Local == "forms"
+	})
+
+	// Rename the automatic styles and their references
+	renames := map[string]string{}
+	styles := utils.ElementChildren(utils.FindChild(root, nsOffice, "automatic-styles"))
+
+	for _, s := range styles {
+		if name, ok := getAttr(s, nsStyle, "name"); ok {
+			renames[name] = prefix + name
+		}
+	}
+
+	for _, n := range append(styles, fragment) {
+		renameStyles(n, renames)
+	}
+
+	inc.styles = append(inc.styles, styles...)
+	inc.fontFaces = append(inc.fontFaces, utils.ElementChildren(utils.FindChild(root, nsOffice, "font-face-decls"))...)
+
+	err = inc.addMedia(doc, fragment, prefix)
+	if err != nil {
+		return nil, err
+	}
+
+	return fragment, nil
+}
+
+// addMedia copies the media files referenced in the fragment and updates the references.
+func (inc *odfIncludes) addMedia(doc *odf.Odf, fragment *xmltree.Node, prefix string) error {
+	copied := map[string]string{}
+
+	return xmltree.Walk(fragment, func(node *xmltree.Node, depth uint) error {
+		elem, ok := node.Token.(xml.StartElement)
+		if !ok {
+			return nil
+		}
+
+		for i, a := range elem.Attr {
+			// Only references to files inside the package are copied
+			if a.Name.Space != nsXlink || a.Name.Local != "href" ||
+				strings.Contains(a.Value, ":") || strings.HasPrefix(a.Value, "#") {
+				continue
+			}
+
+			src := strings.TrimPrefix(a.Value, "./")
+
+			dst, ok := copied[src]
+			if !ok {
+				fd, err := doc.Open(src)
+				if err != nil {
+					return utils.FormatError(ErrInclude, fmt.Sprintf("opening media %s: %s", src, err))
+				}
+
+				data, err := ioutil.ReadAll(fd)
+				fd.Close()
+
+				if err != nil {
+					return utils.FormatError(ErrInclude, fmt.Sprintf("reading media %s: %s", src, err))
+				}
+
+				dst = path.Join(path.Dir(src), prefix+path.Base(src))
+				copied[src] = dst
+				inc.media[dst] = odf.Override{Data: data}
+			}
+
+			elem.Attr[i].Value = dst
+		}
+
+		return nil
+	})
+}
+
+// merge adds the automatic styles and font faces of the includes to the template content.
+func (inc *odfIncludes) merge(content *xmltree.Node) error {
+	root := odfRoot(content)
+
+	autoStyles := utils.FindChild(root, nsOffice, "automatic-styles")
+	if autoStyles == nil && len(inc.styles) > 0 {
+		return utils.FormatError(ErrInclude, "template content.xml has no automatic styles")
+	}
+
+	for _, s := range inc.styles {
+		appendChild(autoStyles, s)
+	}
+
+	// Font faces are only added if the name is unknown, a missing font falls back to the default font
+	fontDecls := utils.FindChild(root, nsOffice, "font-face-decls")
+	if fontDecls == nil {
+		return nil
+	}
+
+	fonts := map[string]bool{}
+
+	for _, f := range utils.ElementChildren(fontDecls) {
+		name, _ := getAttr(f, nsStyle, "name")
+		fonts[name] = true
+	}
+
+	for _, f := range inc.fontFaces {
+		name, _ := getAttr(f, nsStyle, "name")
+		if !fonts[name] {
+			fonts[name] = true
+			appendChild(fontDecls, f)
+		}
+	}
+
+	return nil
+}
+
+// renameStyles updates the style names and all style references in the tree by the given renames.
+func renameStyles(tree *xmltree.Node, renames map[string]string) {
+	_ = xmltree.Walk(tree, func(node *xmltree.Node, depth uint) error {
+		elem, ok := node.Token.(xml.StartElement)
+		if !ok {
+			return nil
+		}
+
+		for i, a := range elem.Attr {
+			isName := a.Name.Space == nsStyle && a.Name.Local == "name"
+			if newName, ok := renames[a.Value]; ok && (isName || strings.HasSuffix(a.Name.Local, "style-name")) {
+				elem.Attr[i].Value = newName
+			}
+		}
+
+		return nil
+	})
+}
+
+// bodyFragment returns a fragment with the children of body, without the elements that are skipped.
+func bodyFragment(body *xmltree.Node, skip func(elem xml.StartElement) bool) *xmltree.Node {
+	fragment := &xmltree.Node{}
+
+	for _, n := range body.Nodes {
+		switch elem := n.Token.(type) {
+		case xml.StartElement:
+			if skip(elem) {
+				continue
+			}
+		case xml.EndElement:
+			// The end of body is part of its children
+			continue
+		}
+
+		fragment.Nodes = append(fragment.Nodes, n)
+	}
+
+	return fragment
+}
+
+// appendChild adds child as last child element of parent, before the end of parent.
+func appendChild(parent, child *xmltree.Node) {
+	child.Parent = parent
+	idx := len(parent.Nodes)
+
+	if idx > 0 {
+		if _, ok := parent.Nodes[idx-1].Token.(xml.EndElement); ok {
+			idx--
+		}
+	}
+
+	parent.Nodes = slices.Insert(parent.Nodes, idx, child)
+}
+
+// getAttr returns the value of the attribute with the given name of an element node.
+func getAttr(node *xmltree.Node, space, local string) (string, bool) {
+	elem, ok := node.Token.(xml.StartElement)
+	if !ok {
+		return "", false
+	}
+
+	return utils.AttrValue(elem, space, local)
+}
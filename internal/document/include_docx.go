@@ -0,0 +1,619 @@
+package document
+
+import (
+	"bytes"
+	"encoding/xml"
+	"fmt"
+	"io/ioutil"
+	"path"
+	"strconv"
+	"strings"
+
+	"github.com/djboris9/xmltree"
+	"github.com/microfast-ch/rea/internal/engine"
+	"github.com/microfast-ch/rea/internal/ooxml"
+	"github.com/microfast-ch/rea/internal/utils"
+	"golang.org/x/exp/slices"
+)
+
+const (
+	stylesRelType        = "/styles"
+	numberingRelType     = "/numbering"
+	imageRelType         = "/image"
+	numberingRelTypeURL  = "http://schemas.openxmlformats.org/officeDocument/2006/relationships/numbering"
+	numberingContentType = "application/vnd.openxmlformats-officedocument.wordprocessingml.numbering+xml"
+)
+
+// docxIncludes holds the fragments of the DOCX includes and the resources they require.
+// Styles that the template doesn't define are copied, the styles of the template take precedence as
+// when pasting in Word. Numberings, relationships and images are renamed, so they don't clash with others.
+type docxIncludes struct {
+	fragments         map[string]*xmltree.Node
+	styleIDs          map[string]bool      // Styles of the template and the added styles
+	styles            []*xmltree.Node      // Style definitions that are added
+	abstractNums      []*xmltree.Node      // Abstract numberings with the level definitions, renumbered
+	nums              []*xmltree.Node      // Numbering instances, renumbered
+	lastNumID         int                  // Highest numbering instance id in use
+	lastAbstractNumID int                  // Highest abstract numbering id in use
+	rels              []ooxml.Relationship // Relationships of the main part, renamed
+	media             ooxml.Overrides      // Images, renamed
+}
+
+// loadOoxmlIncludes loads all includes referenced by tree and by the includes themselves.
+// Only images and external targets, like hyperlinks, are supported as relationships of includes.
+func (p *PackagedDocument) loadOoxmlIncludes(tmpl *ooxml.OOXML, tree *xmltree.Node) (*docxIncludes, error) {
+	inc := &docxIncludes{
+		fragments: map[string]*xmltree.Node{},
+		styleIDs:  map[string]bool{},
+		media:     ooxml.Overrides{},
+	}
+
+	queue := engine.FindIncludes(tree)
+	if len(queue) == 0 {
+		return inc, nil
+	}
+
+	err := inc.scanTemplate(tmpl)
+	if err != nil {
+		return nil, err
+	}
+
+	for ; len(queue) > 0; queue = queue[1:] {
+		name := queue[0]
+		if _, ok := inc.fragments[name]; ok {
+			continue
+		}
+
+		file, err := p.resolveInclude(name, []string{".docx"})
+		if err != nil {
+			return nil, err
+		}
+
+		doc, err := ooxml.NewFromFile(file)
+		if err != nil {
+			return nil, fmt.Errorf("loading include %q: %w", name, err)
+		}
+
+		fragment, err := inc.add(doc, fmt.Sprintf("inc%d-", len(inc.fragments)+1))
+		doc.Close()
+
+		if err != nil {
+			return nil, fmt.Errorf("loading include %q: %w", name, err)
+		}
+
+		inc.fragments[name] = fragment
+		queue = append(queue, engine.FindIncludes(fragment)...)
+	}
+
+	return inc, nil
+}
+
+// scanTemplate collects the styles and the numbering ids of the template.
+func (inc *docxIncludes) scanTemplate(tmpl *ooxml.OOXML) error {
+	_, styles, err := wordPart(tmpl, stylesRelType)
+	if err != nil {
+		return err
+	}
+
+	for _, s := range utils.ElementChildren(utils.FindChild(styles, nsWord, "styles")) {
+		if id, ok := getAttr(s, nsWord, "styleId"); ok {
+			inc.styleIDs[id] = true
+		}
+	}
+
+	_, numbering, err := wordPart(tmpl, numberingRelType)
+	if err != nil {
+		return err
+	}
+
+	for _, n := range utils.ElementChildren(utils.FindChild(numbering, nsWord, "numbering")) {
+		if id, err := strconv.Atoi(attrOrEmpty(n, "numId")); err == nil && id > inc.lastNumID {
+			inc.lastNumID = id
+		}
+
+		if id, err := strconv.Atoi(attrOrEmpty(n, "abstractNumId")); err == nil && id > inc.lastAbstractNumID {
+			inc.lastAbstractNumID = id
+		}
+	}
+
+	return nil
+}
+
+// add extracts the body of the document as fragment and collects the styles, numberings and
+// relationships it uses.
+func (inc *docxIncludes) add(doc *ooxml.OOXML, prefix string) (*xmltree.Node, error) {
+	content, err := getOOXMLPart(doc, doc.MainPart())
+	if err != nil {
+		return nil, err
+	}
+
+	body := utils.FindChild(utils.FindChild(content, nsWord, "document"), nsWord, "body")
+	if body == nil {
+		return nil, utils.FormatError(ErrInclude, "include has no document body")
+	}
+
+	// The section properties belong to the included document
+	fragment := bodyFragment(body, func(elem xml.StartElement) bool {
+		return elem.Name.Local == "sectPr"
+	})
+
+	styles, err := inc.addStyles(doc, fragment)
+	if err != nil {
+		return nil, err
+	}
+
+	err = inc.addNumberings(doc, append(styles, fragment))
+	if err != nil {
+		return nil, err
+	}
+
+	err = inc.addRelationships(doc, fragment, prefix)
+	if err != nil {
+		return nil, err
+	}
+
+	return fragment, nil
+}
+
+// addStyles copies the styles that the fragment uses and the template doesn't define, with the
+// styles they are based on. It returns the copied styles.
+func (inc *docxIncludes) addStyles(doc *ooxml.OOXML, fragment *xmltree.Node) ([]*xmltree.Node, error) {
+	queue := wordValues(fragment, "pStyle", "rStyle", "tblStyle")
+
+	missing := false
+	for _, id := range queue {
+		missing = missing || !inc.styleIDs[id]
+	}
+
+	if !missing {
+		return nil, nil
+	}
+
+	_, tree, err := wordPart(doc, stylesRelType)
+	if err != nil {
+		return nil, err
+	}
+
+	defined := map[string]*xmltree.Node{}
+
+	for _, s := range utils.ElementChildren(utils.FindChild(tree, nsWord, "styles")) {
+		if id, ok := getAttr(s, nsWord, "styleId"); ok {
+			defined[id] = s
+		}
+	}
+
+	var added []*xmltree.Node
+
+	for ; len(queue) > 0; queue = queue[1:] {
+		// Undefined styles fall back to the default style, as they do in Word
+		s, ok := defined[queue[0]]
+		if !ok || inc.styleIDs[queue[0]] {
+			continue
+		}
+
+		inc.styleIDs[queue[0]] = true
+
+		s = engine.CopyNode(s, nil)
+		added = append(added, s)
+		queue = append(queue, wordValues(s, "basedOn", "next", "link")...)
+	}
+
+	inc.styles = append(inc.styles, added...)
+
+	return added, nil
+}
+
+// addNumberings copies the numberings that the trees use with new ids and updates the references.
+func (inc *docxIncludes) addNumberings(doc *ooxml.OOXML, trees []*xmltree.Node) error {
+	var refs []*xml.Attr
+
+	for _, tree := range trees {
+		// Numbering 0 removes the numbering of a paragraph
+		refs = append(refs, wordAttrs(tree, nsWord, func(elem xml.StartElement, a xml.Attr) bool {
+			return elem.Name.Local == "numId" && a.Name.Local == "val" && a.Value != "0"
+		})...)
+	}
+
+	if len(refs) == 0 {
+		return nil
+	}
+
+	_, tree, err := wordPart(doc, numberingRelType)
+	if err != nil {
+		return err
+	}
+
+	nums := map[string]*xmltree.Node{}
+	abstractNums := map[string]*xmltree.Node{}
+
+	for _, n := range utils.ElementChildren(utils.FindChild(tree, nsWord, "numbering")) {
+		switch elem := n.Token.(xml.StartElement); elem.Name.Local {
+		case "num":
+			nums[attrOrEmpty(n, "numId")] = n
+		case "abstractNum":
+			abstractNums[attrOrEmpty(n, "abstractNumId")] = n
+		}
+	}
+
+	numIDs := map[string]string{}         // New numbering ids by id of the include
+	abstractNumIDs := map[string]string{} // New abstract numbering ids by id of the include
+
+	for _, ref := range refs {
+		id, ok := numIDs[ref.Value]
+		if ok {
+			ref.Value = id
+			continue
+		}
+
+		num, ok := nums[ref.Value]
+		if !ok {
+			return utils.FormatError(ErrInclude, fmt.Sprintf("numbering %s is not defined", ref.Value))
+		}
+
+		num = engine.CopyNode(num, nil)
+
+		// The abstract numbering holds the level definitions and can be shared by numberings
+		abstractRef := utils.FindChild(num, nsWord, "abstractNumId")
+		abstractID := attrOrEmpty(abstractRef, "val")
+
+		newAbstractID, ok := abstractNumIDs[abstractID]
+		if !ok {
+			abstract, ok := abstractNums[abstractID]
+			if !ok {
+				return utils.FormatError(ErrInclude, fmt.Sprintf("abstract numbering %s is not defined", abstractID))
+			}
+
+			inc.lastAbstractNumID++
+			newAbstractID = strconv.Itoa(inc.lastAbstractNumID)
+			abstractNumIDs[abstractID] = newAbstractID
+
+			// The list identifier is optional and needs to be unique, so it is left to the office suite
+			abstract = engine.CopyNode(abstract, nil)
+			setAttr(abstract, nsWord, "abstractNumId", newAbstractID)
+			removeChild(abstract, nsWord, "nsid")
+			inc.abstractNums = append(inc.abstractNums, abstract)
+		}
+
+		setAttr(abstractRef, nsWord, "val", newAbstractID)
+
+		inc.lastNumID++
+		id = strconv.Itoa(inc.lastNumID)
+		numIDs[ref.Value] = id
+
+		setAttr(num, nsWord, "numId", id)
+		removeAttr(num, "durableId")
+		inc.nums = append(inc.nums, num)
+
+		ref.Value = id
+	}
+
+	return nil
+}
+
+// addRelationships copies the relationships of the fragment with new ids and updates the references.
+// Images are copied with a new name, other parts of the package, like charts or embedded documents,
+// are not supported.
+func (inc *docxIncludes) addRelationships(doc *ooxml.OOXML, fragment *xmltree.Node, prefix string) error {
+	refs := wordAttrs(fragment, nsRels, func(elem xml.StartElement, a xml.Attr) bool {
+		return true
+	})
+
+	if len(refs) == 0 {
+		return nil
+	}
+
+	rels, err := loadRelationships(doc, doc.MainPart())
+	if err != nil {
+		return err
+	}
+
+	added := map[string]bool{}
+
+	for _, ref := range refs {
+		id := prefix + ref.Value
+
+		if !added[id] {
+			rel, ok := rels[ref.Value]
+
+			switch {
+			case !ok:
+				return utils.FormatError(ErrInclude, fmt.Sprintf("relationship %s is not defined", ref.Value))
+			case rel.External:
+			case strings.HasSuffix(rel.Type, imageRelType):
+				rel.Target, err = inc.addImage(doc, rel.Target, prefix)
+				if err != nil {
+					return err
+				}
+			default:
+				return utils.FormatError(ErrInclude,
+					fmt.Sprintf("%s is referenced as %s, only images and external targets are supported", rel.Target, rel.Type))
+			}
+
+			added[id] = true
+			rel.ID = id
+			inc.rels = append(inc.rels, rel)
+		}
+
+		ref.Value = id
+	}
+
+	return nil
+}
+
+// addImage copies the image with a prefixed name and returns the new name.
+func (inc *docxIncludes) addImage(doc *ooxml.OOXML, name, prefix string) (string, error) {
+	fd, err := doc.Open(name)
+	if err != nil {
+		return "", utils.FormatError(ErrInclude, fmt.Sprintf("opening image %s: %s", name, err))
+	}
+	defer fd.Close()
+
+	data, err := ioutil.ReadAll(fd)
+	if err != nil {
+		return "", utils.FormatError(ErrInclude, fmt.Sprintf("reading image %s: %s", name, err))
+	}
+
+	dst := path.Join(path.Dir(name), prefix+path.Base(name))
+	inc.media[dst] = ooxml.Override{Data: data}
+
+	return dst, nil
+}
+
+// merge adds the styles, numberings, relationships and images of the includes to the overrides of the template.
+func (inc *docxIncludes) merge(tmpl *ooxml.OOXML, ov ooxml.Overrides) error {
+	if len(inc.styles) > 0 {
+		name, tree, err := wordPart(tmpl, stylesRelType)
+		if err != nil {
+			return err
+		}
+
+		root := utils.FindChild(tree, nsWord, "styles")
+		if root == nil {
+			return utils.FormatError(ErrInclude, "template has no styles for the styles of the includes")
+		}
+
+		for _, s := range inc.styles {
+			appendChild(root, s)
+		}
+
+		if err := encodeOverride(ov, name, tree, ""); err != nil {
+			return err
+		}
+	}
+
+	if len(inc.nums) > 0 {
+		if err := inc.mergeNumberings(tmpl, ov); err != nil {
+			return err
+		}
+	}
+
+	for name, media := range inc.media {
+		ov[name] = media
+	}
+
+	if len(inc.rels) == 0 {
+		return nil
+	}
+
+	relsPart := ooxml.RelsPart(tmpl.MainPart())
+
+	rels, err := overriddenPart(tmpl, ov, relsPart)
+	if err != nil {
+		return err
+	}
+
+	var newRels strings.Builder
+
+	for _, rel := range inc.rels {
+		var target bytes.Buffer
+
+		mode := ""
+
+		if rel.External {
+			_ = xml.EscapeText(&target, []byte(rel.Target))
+			mode = ` TargetMode="External"`
+		} else {
+			_ = xml.EscapeText(&target, []byte(relativeTarget(tmpl.MainPart(), rel.Target)))
+		}
+
+		fmt.Fprintf(&newRels, `<Relationship Id="%s" Type="%s" Target="%s"%s/>`, rel.ID, rel.Type, target.String(), mode)
+	}
+
+	content, err := insertBefore(string(rels), "</Relationships>", newRels.String())
+	if err != nil {
+		return err
+	}
+
+	ov[relsPart] = ooxml.Override{Data: []byte(content)}
+
+	return nil
+}
+
+// mergeNumberings adds the numberings of the includes to the numbering part of the template,
+// which is created if the template has none.
+func (inc *docxIncludes) mergeNumberings(tmpl *ooxml.OOXML, ov ooxml.Overrides) error {
+	name, tree, err := wordPart(tmpl, numberingRelType)
+	if err != nil {
+		return err
+	}
+
+	mediaType := ""
+
+	if name == "" {
+		name = path.Join(path.Dir(tmpl.MainPart()), "numbering.xml")
+		mediaType = numberingContentType
+
+		tree, err = xmltree.Parse([]byte(xml.Header + `<w:numbering xmlns:w="` + nsWord + `"></w:numbering>`))
+		if err != nil {
+			return fmt.Errorf("creating numbering: %w", err)
+		}
+
+		inc.rels = append(inc.rels, ooxml.Relationship{ID: "incNumbering", Type: numberingRelTypeURL, Target: name})
+	}
+
+	root := utils.FindChild(tree, nsWord, "numbering")
+
+	// Abstract numberings precede the numbering instances, which precede the cleanup marker
+	insertChildren(root, inc.abstractNums, "num", "numIdMacAtCleanup")
+	insertChildren(root, inc.nums, "numIdMacAtCleanup")
+
+	return encodeOverride(ov, name, tree, mediaType)
+}
+
+// wordPart returns the name and tree of the part that is related to the main part by the relationship type.
+// It returns an empty name if there is no such part.
+func wordPart(doc *ooxml.OOXML, relType string) (string, *xmltree.Node, error) {
+	rels, err := loadRelationships(doc, doc.MainPart())
+	if err != nil {
+		return "", nil, err
+	}
+
+	for _, rel := range rels {
+		if strings.HasSuffix(rel.Type, relType) && !rel.External {
+			tree, err := getOOXMLPart(doc, rel.Target)
+			return rel.Target, tree, err
+		}
+	}
+
+	return "", nil, nil
+}
+
+// overriddenPart returns the content of the part, as overridden if it is.
+func overriddenPart(tmpl *ooxml.OOXML, ov ooxml.Overrides, name string) ([]byte, error) {
+	if v, ok := ov[name]; ok && !v.Delete {
+		return v.Data, nil
+	}
+
+	return tmpl.ReadPart(name)
+}
+
+// encodeOverride sets the override of the part to the tree.
+func encodeOverride(ov ooxml.Overrides, name string, tree *xmltree.Node, mediaType string) error {
+	var buf bytes.Buffer
+
+	if err := encodeTree(&buf, tree); err != nil {
+		return fmt.Errorf("encoding %s: %w", name, err)
+	}
+
+	ov[name] = ooxml.Override{Data: buf.Bytes(), MediaType: mediaType}
+
+	return nil
+}
+
+// relativeTarget returns the target of a relationship of the source part to the part.
+func relativeTarget(source, part string) string {
+	if dir := path.Dir(source) + "/"; strings.HasPrefix(part, dir) {
+		return strings.TrimPrefix(part, dir)
+	}
+
+	return "/" + part
+}
+
+// insertChildren inserts the children before the first child element with one of the names, or at the end.
+func insertChildren(parent *xmltree.Node, children []*xmltree.Node, before ...string) {
+	idx := len(parent.Nodes)
+	if idx > 0 {
+		if _, ok := parent.Nodes[idx-1].Token.(xml.EndElement); ok {
+			idx--
+		}
+	}
+
+	for i, n := range parent.Nodes {
+		if elem, ok := n.Token.(xml.StartElement); ok && slices.Contains(before, elem.Name.Local) {
+			idx = i
+			break
+		}
+	}
+
+	for _, c := range children {
+		c.Parent = parent
+	}
+
+	parent.Nodes = slices.Insert(parent.Nodes, idx, children...)
+}
+
+// wordValues returns the `w:val` attributes of the elements with the given names in tree.
+func wordValues(tree *xmltree.Node, names ...string) []string {
+	var values []string
+
+	for _, a := range wordAttrs(tree, nsWord, func(elem xml.StartElement, a xml.Attr) bool {
+		return slices.Contains(names, elem.Name.Local) && a.Name.Local == "val"
+	}) {
+		values = append(values, a.Value)
+	}
+
+	return values
+}
+
+// wordAttrs returns the attributes of the namespace in tree that match. The attributes can be changed in place.
+func wordAttrs(tree *xmltree.Node, space string, match func(elem xml.StartElement, a xml.Attr) bool) []*xml.Attr {
+	var attrs []*xml.Attr
+
+	_ = xmltree.Walk(tree, func(node *xmltree.Node, depth uint) error {
+		if elem, ok := node.Token.(xml.StartElement); ok {
+			for i, a := range elem.Attr {
+				if a.Name.Space == space && match(elem, a) {
+					attrs = append(attrs, &elem.Attr[i])
+				}
+			}
+		}
+
+		return nil
+	})
+
+	return attrs
+}
+
+// attrOrEmpty returns the `w:` attribute of the node or an empty string.
+func attrOrEmpty(node *xmltree.Node, local string) string {
+	if node == nil {
+		return ""
+	}
+
+	value, _ := getAttr(node, nsWord, local)
+
+	return value
+}
+
+// setAttr sets the value of the attribute of an element node, if it has the attribute.
+func setAttr(node *xmltree.Node, space, local, value string) {
+	if elem, ok := node.Token.(xml.StartElement); ok {
+		for i, a := range elem.Attr {
+			if a.Name.Space == space && a.Name.Local == local {
+				elem.Attr[i].Value = value
+			}
+		}
+	}
+}
+
+// removeAttr removes the attributes with the local name of an element node, whatever their namespace is.
+func removeAttr(node *xmltree.Node, local string) {
+	elem, ok := node.Token.(xml.StartElement)
+	if !ok {
+		return
+	}
+
+	attrs := []xml.Attr{}
+
+	for _, a := range elem.Attr {
+		if a.Name.Local != local || a.Name.Space == "xmlns" {
+			attrs = append(attrs, a)
+		}
+	}
+
+	elem.Attr = attrs
+	node.Token = elem
+}
+
+// removeChild removes the child elements of node with the given name.
+func removeChild(node *xmltree.Node, space, local string) {
+	nodes := []*xmltree.Node{}
+
+	for _, n := range node.Nodes {
+		if elem, ok := n.Token.(xml.StartElement); !ok || elem.Name.Space != space || elem.Name.Local != local {
+			nodes = append(nodes, n)
+		}
+	}
+
+	node.Nodes = nodes
+}
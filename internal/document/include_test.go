@@ -0,0 +1,209 @@
+package document
+
+import (
+	"archive/zip"
+	"bytes"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestIncludeODT(t *testing.T) {
+	runGolden(t, "Include1.odt", "Include1.yaml")
+}
+
+func TestIncludeOOXML(t *testing.T) {
+	runGolden(t, "Include1.docx", "Include1.yaml")
+}
+
+func TestIncludeMedia(t *testing.T) {
+	tmpl, err := NewFromFile("../../testdata/Include1.odt")
+	require.Nil(t, err)
+
+	out := bytes.NewBuffer([]byte(""))
+	tpd, err := tmpl.Write(&Model{Data: map[string]any{"name": "Alice", "items": []any{"Apple"}}}, out)
+	require.Nil(t, err)
+
+	// Automatic styles of the includes are renamed and merged
+	require.Contains(t, tpd.XMLResult, `style-name="inc1-P1"`)
+	require.Contains(t, tpd.XMLResult, `name="inc2-fr1"`)
+	require.Contains(t, tpd.XMLResult, `href="Pictures/inc2-logo.png"`)
+
+	// The media is part of the package and the manifest
+	rdr, err := zip.NewReader(bytes.NewReader(out.Bytes()), int64(out.Len()))
+	require.Nil(t, err)
+
+	fd, err := rdr.Open("Pictures/inc2-logo.png")
+	require.Nil(t, err)
+	fd.Close()
+
+	fd, err = rdr.Open("META-INF/manifest.xml")
+	require.Nil(t, err)
+
+	manifest, err := ioutil.ReadAll(fd)
+	require.Nil(t, err)
+	require.Contains(t, string(manifest), `full-path="Pictures/inc2-logo.png"`)
+}
+
+func TestIncludeDocxResources(t *testing.T) {
+	dir := includeLibrary(t, `<w:pPr><w:pStyle w:val="IncHeading"/><w:numPr><w:ilvl w:val="0"/><w:numId w:val="1"/></w:numPr></w:pPr>`+
+		`<w:hyperlink r:id="rIdLink"><w:r><w:t>Link</w:t></w:r></w:hyperlink>`+
+		`<w:r><w:drawing><a:blip xmlns:a="http://schemas.openxmlformats.org/drawingml/2006/main" r:embed="rIdImg"/></w:drawing></w:r>`)
+
+	tmpl, err := NewFromFile(filepath.Join(dir, "Include1.docx"))
+	require.Nil(t, err)
+
+	out := bytes.NewBuffer([]byte(""))
+	_, err = tmpl.Write(&Model{Data: map[string]any{"name": "Alice", "items": []any{"Apple", "Pear"}}}, out)
+	require.Nil(t, err)
+
+	parts := readPackage(t, out.Bytes())
+
+	// Styles of the include are added with the styles they are based on, the styles of the template are kept
+	require.Contains(t, parts["word/styles.xml"], `w:styleId="IncHeading"`)
+	require.Contains(t, parts["word/styles.xml"], `w:styleId="IncBase"`)
+	require.Equal(t, 1, strings.Count(parts["word/styles.xml"], `w:styleId="Normal"`))
+
+	// Numberings are renumbered after the ones of the template
+	require.Contains(t, parts["word/numbering.xml"], `<w:abstractNum w:abstractNumId="1"`)
+	require.Contains(t, parts["word/numbering.xml"], `<w:num w:numId="2"><w:abstractNumId w:val="1"`)
+	require.Equal(t, 2, strings.Count(parts["word/document.xml"], `<w:numId w:val="2">`))
+
+	// Relationships and images are renamed
+	require.Contains(t, parts["word/document.xml"], `r:embed="inc1-rIdImg"`)
+	require.Contains(t, parts["word/document.xml"], `r:id="inc1-rIdLink"`)
+	require.Contains(t, parts["word/_rels/document.xml.rels"], `Id="inc1-rIdImg" Type="`+
+		`http://schemas.openxmlformats.org/officeDocument/2006/relationships/image" Target="media/inc1-logo.png"`)
+	require.Contains(t, parts["word/_rels/document.xml.rels"], `Target="https://example.com/?a=1&amp;b=2" TargetMode="External"`)
+	require.Equal(t, "PNG", parts["word/media/inc1-logo.png"])
+	require.Contains(t, parts["[Content_Types].xml"], `<Default Extension="png" ContentType="image/png"/>`)
+}
+
+func TestIncludeDocxUnsupportedPart(t *testing.T) {
+	dir := includeLibrary(t, `<w:pPr/><w:r><w:object><w:control r:id="rIdChart"/></w:object></w:r>`)
+
+	tmpl, err := NewFromFile(filepath.Join(dir, "Include1.docx"))
+	require.Nil(t, err)
+
+	_, err = tmpl.Write(&Model{Data: map[string]any{"name": "Alice", "items": []any{"Apple"}}}, bytes.NewBuffer([]byte("")))
+	require.ErrorIs(t, err, ErrInclude)
+	require.Contains(t, err.Error(), "word/charts/chart1.xml is referenced as "+
+		"http://schemas.openxmlformats.org/officeDocument/2006/relationships/chart, only images and external targets are supported")
+}
+
+// includeLibrary returns a directory with Include1.docx and its includes. The paragraph properties of
+// library/Item.docx are replaced by the given XML, which can use the styles, numberings and relationships
+// that are added to the include.
+func includeLibrary(t *testing.T, paragraph string) string {
+	t.Helper()
+
+	dir := t.TempDir()
+	require.Nil(t, os.MkdirAll(filepath.Join(dir, "library", "legal"), 0o700))
+
+	for _, name := range []string{"Include1.docx", "library/legal/Footer.docx", "library/legal/Signature.docx"} {
+		data, err := ioutil.ReadFile(filepath.Join("../../testdata", name))
+		require.Nil(t, err)
+		require.Nil(t, ioutil.WriteFile(filepath.Join(dir, name), data, 0o600))
+	}
+
+	rdr, err := zip.OpenReader("../../testdata/library/Item.docx")
+	require.Nil(t, err)
+	defer rdr.Close()
+
+	edits := map[string][2]string{
+		"word/document.xml": {`<w:pPr><w:pStyle w:val="Normal"/></w:pPr>`, paragraph},
+		"word/styles.xml": {"</w:styles>", `<w:style w:type="paragraph" w:styleId="IncHeading"><w:name w:val="Inc Heading"/>` +
+			`<w:basedOn w:val="IncBase"/></w:style><w:style w:type="paragraph" w:styleId="IncBase"><w:name w:val="Inc Base"/>` +
+			`<w:basedOn w:val="Normal"/></w:style></w:styles>`},
+		"word/_rels/document.xml.rels": {"</Relationships>", `<Relationship Id="rIdImg" ` +
+			`Type="http://schemas.openxmlformats.org/officeDocument/2006/relationships/image" Target="media/logo.png"/>` +
+			`<Relationship Id="rIdLink" Type="http://schemas.openxmlformats.org/officeDocument/2006/relationships/hyperlink" ` +
+			`Target="https://example.com/?a=1&amp;b=2" TargetMode="External"/>` +
+			`<Relationship Id="rIdChart" Type="http://schemas.openxmlformats.org/officeDocument/2006/relationships/chart" ` +
+			`Target="charts/chart1.xml"/></Relationships>`},
+	}
+
+	var buf bytes.Buffer
+
+	w := zip.NewWriter(&buf)
+
+	for _, f := range rdr.File {
+		fd, err := f.Open()
+		require.Nil(t, err)
+
+		data, err := io.ReadAll(fd)
+		require.Nil(t, err)
+		fd.Close()
+
+		if edit, ok := edits[f.Name]; ok {
+			require.Contains(t, string(data), edit[0], f.Name)
+			data = []byte(strings.Replace(string(data), edit[0], edit[1], 1))
+		}
+
+		fw, err := w.Create(f.Name)
+		require.Nil(t, err)
+
+		_, err = fw.Write(data)
+		require.Nil(t, err)
+	}
+
+	fw, err := w.Create("word/media/logo.png")
+	require.Nil(t, err)
+
+	_, err = fw.Write([]byte("PNG"))
+	require.Nil(t, err)
+
+	require.Nil(t, w.Close())
+	require.Nil(t, ioutil.WriteFile(filepath.Join(dir, "library", "Item.docx"), buf.Bytes(), 0o600))
+
+	return dir
+}
+
+// readPackage returns the content of the files of a zip package by name.
+func readPackage(t *testing.T, data []byte) map[string]string {
+	t.Helper()
+
+	rdr, err := zip.NewReader(bytes.NewReader(data), int64(len(data)))
+	require.Nil(t, err)
+
+	parts := map[string]string{}
+
+	for _, f := range rdr.File {
+		fd, err := f.Open()
+		require.Nil(t, err)
+
+		content, err := io.ReadAll(fd)
+		require.Nil(t, err)
+		fd.Close()
+
+		parts[f.Name] = string(content)
+	}
+
+	return parts
+}
+
+func TestResolveInclude(t *testing.T) {
+	p := &PackagedDocument{library: "../../testdata"}
+
+	file, err := p.resolveInclude("library/legal/Footer", []string{".odt", ".ott"})
+	require.Nil(t, err)
+	require.Equal(t, "../../testdata/library/legal/Footer.odt", file)
+
+	file, err = p.resolveInclude("library/Item.docx", []string{".docx"})
+	require.Nil(t, err)
+	require.Equal(t, "../../testdata/library/Item.docx", file)
+
+	_, err = p.resolveInclude("library/Missing", []string{".odt"})
+	require.ErrorIs(t, err, ErrInclude)
+
+	_, err = p.resolveInclude("../testdata/Include1", []string{".odt"})
+	require.ErrorIs(t, err, ErrInclude)
+
+	_, err = p.resolveInclude("/etc/passwd", []string{".odt"})
+	require.ErrorIs(t, err, ErrInclude)
+}
@@ -1,20 +1,39 @@
 package document
 
 import (
+	"archive/zip"
+	"encoding/xml"
 	"errors"
 	"fmt"
+	"io"
 	"io/fs"
+	"os"
 	"path/filepath"
 
+	"github.com/microfast-ch/rea/internal/convert"
+	"github.com/microfast-ch/rea/internal/engine"
 	"github.com/microfast-ch/rea/internal/odf"
 	"github.com/microfast-ch/rea/internal/ooxml"
+	"github.com/microfast-ch/rea/internal/schema"
 )
 
 var errUnsupportedFile = errors.New("unsupported file")
 
 // PackageDocument represents a templateable document.
 type PackagedDocument struct {
-	doc Format
+	doc           Format
+	library       string               // Directory from which includes are resolved
+	stripMacros   bool                 // Remove the VBA project of macro-enabled documents
+	odfOutput     ODFOutput            // Representation of written ODF documents
+	outputFormat  string               // Format of the written document as file extension, native if empty
+	converter     convert.Converter    // Converter to the output format
+	thumbnail     []byte               // PNG image that replaces the thumbnail of the template, removed if nil
+	deterministic bool                 // Write byte-identical packages for identical input
+	charPolicy    engine.CharPolicy    // Handling of printed characters that are not allowed in XML
+	undefinedMode engine.UndefinedMode // Handling of undefined variables and printed nil values
+	path          string               // Path of the template file, empty if not loaded from a file
+	schema        *schema.Schema       // Schema of the model data, nil if there is none
+	schemaLoaded  bool                 // The schema was set or loaded with the template
 }
 
 // Format needs to be implemented by templateable documents.
@@ -25,17 +44,104 @@ type Format interface {
 }
 
 // NewFromFile returns a new packaged document instance for the given file path.
-// TODO: make this factory function smarter and not only check
-// the file extension, but also the MIME type of the file.
+// The format is detected by the content of the file, as described for New.
 func NewFromFile(path string) (*PackagedDocument, error) {
-	switch ext := filepath.Ext(path); ext {
-	case ".odt", ".ott":
-		doc, err := odf.NewFromFile(path)
-		return &PackagedDocument{doc: doc}, err
-	case ".docx":
-		doc, err := ooxml.NewFromFile(path)
-		return &PackagedDocument{doc: doc}, err
-	default:
-		return nil, fmt.Errorf("%w : %s", errUnsupportedFile, ext)
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("error opening file %s: %w", path, err)
+	}
+
+	info, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return nil, fmt.Errorf("error reading file info of %s: %w", path, err)
+	}
+
+	kind, err := detectFormat(f, info.Size())
+	f.Close()
+
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+
+	var doc Format
+
+	switch kind {
+	case formatODF:
+		doc, err = odf.NewFromFile(path)
+	case formatFlatODF:
+		doc, err = odf.NewFlatFromFile(path)
+	case formatOOXML:
+		doc, err = ooxml.NewFromFile(path)
+	}
+
+	return &PackagedDocument{doc: doc, library: filepath.Dir(path), path: path}, err
+}
+
+// New returns a new packaged document instance for the given document with the given size.
+// The format is detected by the content: the `mimetype` file of an ODF package, the
+// `[Content_Types].xml` of an OOXML package or the `office:document` root element of a
+// flat ODF document. Includes are resolved from the template library set with
+// SetTemplateLibrary, templates with includes fail to render without one.
+func New(doc io.ReaderAt, size int64) (*PackagedDocument, error) {
+	kind, err := detectFormat(doc, size)
+	if err != nil {
+		return nil, err
+	}
+
+	var format Format
+
+	switch kind {
+	case formatODF:
+		format, err = odf.New(doc, size)
+	case formatFlatODF:
+		format, err = odf.NewFlat(doc, size)
+	case formatOOXML:
+		format, err = ooxml.New(doc, size)
+	}
+
+	return &PackagedDocument{doc: format}, err
+}
+
+// formatKind is the detected kind of a document.
+type formatKind int
+
+const (
+	formatODF     formatKind = iota + 1 // ODF package, like `.odt`
+	formatFlatODF                       // Flat XML ODF document, like `.fodt`
+	formatOOXML                         // OOXML package, like `.docx`
+)
+
+// detectFormat returns the kind of the document by its content.
+func detectFormat(doc io.ReaderAt, size int64) (formatKind, error) {
+	if rdr, err := zip.NewReader(doc, size); err == nil {
+		for _, f := range rdr.File {
+			switch f.Name {
+			case "mimetype":
+				return formatODF, nil
+			case "[Content_Types].xml":
+				return formatOOXML, nil
+			}
+		}
+
+		return 0, fmt.Errorf("%w: zip archive is neither an ODF nor an OOXML package", errUnsupportedFile)
+	}
+
+	// Flat documents are XML files, only the root element is read
+	d := xml.NewDecoder(io.NewSectionReader(doc, 0, size))
+
+	for {
+		tok, err := d.Token()
+		if err != nil {
+			return 0, fmt.Errorf("%w: neither a zip archive nor an XML document", errUnsupportedFile)
+		}
+
+		if elem, ok := tok.(xml.StartElement); ok {
+			if elem.Name.Space == nsOffice && elem.Name.Local == "document" {
+				return formatFlatODF, nil
+			}
+
+			return 0, fmt.Errorf("%w: XML document with root element %s", errUnsupportedFile, elem.Name.Local)
+		}
 	}
 }
@@ -0,0 +1,62 @@
+package document
+
+import (
+	"bytes"
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+
+	"github.com/microfast-ch/rea/internal/odf"
+	"github.com/microfast-ch/rea/internal/ooxml"
+	"github.com/stretchr/testify/require"
+)
+
+func TestNew(t *testing.T) {
+	tests := map[string]string{
+		"Basic1.ott":         odf.MIMETypeTextTemplate,
+		"Conditional1.fodt":  odf.MIMETypeText,
+		"Spreadsheet1.ots":   odf.MIMETypeSpreadsheetTemplate,
+		"Basic1.docx":        ooxml.MainDocumentContentType,
+		"Spreadsheet1.xlsx":  ooxml.SpreadsheetContentType,
+		"Presentation1.pptx": ooxml.PresentationContentType,
+	}
+
+	for file, mimetype := range tests {
+		data, err := ioutil.ReadFile(filepath.Join("../../testdata", file))
+		require.Nil(t, err)
+
+		doc, err := New(bytes.NewReader(data), int64(len(data)))
+		require.Nil(t, err, file)
+		require.Equal(t, mimetype, doc.doc.MIMEType(), file)
+	}
+
+	// Test unsupported data
+	for _, data := range []string{"", "plain text", `<?xml version="1.0"?><html/>`} {
+		_, err := New(bytes.NewReader([]byte(data)), int64(len(data)))
+		require.ErrorIs(t, err, errUnsupportedFile, data)
+	}
+}
+
+func TestNewFromFileExtension(t *testing.T) {
+	// The format doesn't depend on the extension
+	dir := t.TempDir()
+
+	for _, file := range []string{"Basic1.ott", "Conditional1.fodt", "Basic1.docx"} {
+		data, err := ioutil.ReadFile(filepath.Join("../../testdata", file))
+		require.Nil(t, err)
+
+		path := filepath.Join(dir, "upload.bin")
+		require.Nil(t, ioutil.WriteFile(path, data, 0600))
+
+		doc, err := NewFromFile(path)
+		require.Nil(t, err, file)
+		require.Equal(t, dir, doc.library)
+
+		out := bytes.NewBuffer([]byte(""))
+		_, err = doc.Write(&Model{Data: map[string]any{"items": []any{}}}, out)
+		require.Nil(t, err, file)
+	}
+
+	_, err := NewFromFile(filepath.Join(dir, "missing.odt"))
+	require.Error(t, err)
+}
@@ -0,0 +1,215 @@
+package document
+
+import (
+	"bytes"
+	"encoding/xml"
+	"fmt"
+	"io/ioutil"
+	"regexp"
+	"strings"
+
+	"github.com/djboris9/xmltree"
+	"github.com/microfast-ch/rea/internal/odf"
+	"github.com/microfast-ch/rea/internal/ooxml"
+	"github.com/microfast-ch/rea/internal/utils"
+)
+
+// metadataField maps a key of the model metadata to an element of the document properties.
+type metadataField struct {
+	key  string
+	name xml.Name
+}
+
+// odfMetadata are the elements of `office:meta` that are set from the model metadata.
+var odfMetadata = []metadataField{
+	{"title", xml.Name{Space: nsDC, Local: "title"}},
+	{"subject", xml.Name{Space: nsDC, Local: "subject"}},
+	{"author", xml.Name{Space: nsMeta, Local: "initial-creator"}},
+	{"author", xml.Name{Space: nsDC, Local: "creator"}},
+}
+
+// ooxmlMetadata are the core properties that are set from the model metadata.
+var ooxmlMetadata = []metadataField{
+	{"title", xml.Name{Space: nsDC, Local: "title"}},
+	{"subject", xml.Name{Space: nsDC, Local: "subject"}},
+	{"author", xml.Name{Space: nsDC, Local: "creator"}},
+}
+
+const coreRelType = "/metadata/core-properties"
+
+// hasMetadata reports if the metadata sets any of the fields.
+func hasMetadata(metadata map[string]string, fields []metadataField) bool {
+	for _, f := range fields {
+		if _, ok := metadata[f.key]; ok {
+			return true
+		}
+	}
+
+	return false
+}
+
+// getODFMetadata returns the meta.xml of the package with the metadata of the model set,
+// or nil if the model sets no metadata.
+func getODFMetadata(tmpl *odf.Odf, metadata map[string]string) ([]byte, error) {
+	if !hasMetadata(metadata, odfMetadata) {
+		return nil, nil
+	}
+
+	fd, err := tmpl.Open("meta.xml")
+	if err != nil {
+		return nil, fmt.Errorf("loading meta.xml from template: %w", err)
+	}
+	defer fd.Close()
+
+	data, err := ioutil.ReadAll(fd)
+	if err != nil {
+		return nil, fmt.Errorf("reading meta.xml from template: %w", err)
+	}
+
+	tree, err := xmltree.Parse(data)
+	if err != nil {
+		return nil, fmt.Errorf("parsing meta.xml as tree: %w", err)
+	}
+
+	return setODFMetadata(tree, utils.FindChild(tree, nsOffice, "document-meta"), metadata)
+}
+
+// setFlatMetadata returns the flat document with the metadata of the model set.
+func setFlatMetadata(content string, metadata map[string]string) ([]byte, error) {
+	if !hasMetadata(metadata, odfMetadata) {
+		return []byte(content), nil
+	}
+
+	tree, err := xmltree.Parse([]byte(content))
+	if err != nil {
+		return nil, fmt.Errorf("parsing flat document as tree: %w", err)
+	}
+
+	return setODFMetadata(tree, utils.FindChild(tree, nsOffice, "document"), metadata)
+}
+
+// setODFMetadata sets the metadata in the `office:meta` element of root and returns the encoded tree.
+func setODFMetadata(tree, root *xmltree.Node, metadata map[string]string) ([]byte, error) {
+	if root == nil {
+		return nil, utils.FormatError(ErrMimetype, "document has no root element for metadata")
+	}
+
+	meta := utils.FindChild(root, nsOffice, "meta")
+	if meta == nil {
+		// The metadata is the first section of a document
+		meta = newTextElement(root, xml.Name{Space: nsOffice, Local: "meta"}, "")
+		root.Nodes = append([]*xmltree.Node{meta}, root.Nodes...)
+	}
+
+	for _, f := range odfMetadata {
+		value, ok := metadata[f.key]
+		if !ok {
+			continue
+		}
+
+		if elem := utils.FindChild(meta, f.name.Space, f.name.Local); elem != nil {
+			elem.Nodes = nil
+			elem.Append(xml.CharData(value))
+			elem.Append(xml.EndElement{Name: f.name})
+
+			continue
+		}
+
+		appendChild(meta, newTextElement(meta, f.name, value))
+	}
+
+	var buf bytes.Buffer
+
+	err := encodeTree(&buf, tree)
+	if err != nil {
+		return nil, err
+	}
+
+	return buf.Bytes(), nil
+}
+
+// setOOXMLMetadata adds the override of the core properties with the metadata of the model set.
+// The core properties are changed textually, so everything else stays as written by the office suite.
+// Packages without core properties are left as they are.
+func setOOXMLMetadata(tmpl *ooxml.OOXML, metadata map[string]string, ov ooxml.Overrides) error {
+	if !hasMetadata(metadata, ooxmlMetadata) {
+		return nil
+	}
+
+	rels, err := loadRelationships(tmpl, "")
+	if err != nil {
+		return err
+	}
+
+	for _, rel := range rels {
+		if !strings.HasSuffix(rel.Type, coreRelType) {
+			continue
+		}
+
+		data, err := tmpl.ReadPart(rel.Target)
+		if err != nil {
+			return err
+		}
+
+		content := string(data)
+
+		for _, f := range ooxmlMetadata {
+			if value, ok := metadata[f.key]; ok {
+				content, err = setElementText(content, f.name, value)
+				if err != nil {
+					return fmt.Errorf("setting %s in %s: %w", f.name.Local, rel.Target, err)
+				}
+			}
+		}
+
+		ov[rel.Target] = ooxml.Override{Data: []byte(content)}
+	}
+
+	return nil
+}
+
+// setElementText sets the text of the element, which is a child of the root element. A missing
+// element is added at the end of the root element, with a namespace declaration if required.
+func setElementText(content string, name xml.Name, text string) (string, error) {
+	var escaped bytes.Buffer
+
+	_ = xml.EscapeText(&escaped, []byte(text))
+
+	elem := fmt.Sprintf(`<%s xmlns="%s">%s</%s>`, name.Local, name.Space, escaped.String(), name.Local)
+
+	if m := namespacePrefix(name.Space).FindStringSubmatch(content); m != nil {
+		qname := m[1] + ":" + name.Local
+		elem = fmt.Sprintf(`<%s>%s</%s>`, qname, escaped.String(), qname)
+
+		quoted := regexp.QuoteMeta(qname)
+		existing := regexp.MustCompile(`<` + quoted + `(?:\s[^>]*)?(?:/>|>[^<]*</` + quoted + `>)`)
+		if loc := existing.FindStringIndex(content); loc != nil {
+			return content[:loc[0]] + elem + content[loc[1]:], nil
+		}
+	}
+
+	idx := strings.LastIndex(content, "</")
+	if idx < 0 {
+		return "", utils.FormatError(ErrOverride, "root element has no end tag")
+	}
+
+	return content[:idx] + elem + content[idx:], nil
+}
+
+// namespacePrefix returns an expression that matches the declaration of the namespace with a prefix.
+func namespacePrefix(space string) *regexp.Regexp {
+	return regexp.MustCompile(`xmlns:(\w+)="` + regexp.QuoteMeta(space) + `"`)
+}
+
+// newTextElement returns an element with the text as content.
+func newTextElement(parent *xmltree.Node, name xml.Name, text string) *xmltree.Node {
+	elem := &xmltree.Node{Token: xml.StartElement{Name: name}, Parent: parent}
+
+	if text != "" {
+		elem.Append(xml.CharData(text))
+	}
+
+	elem.Append(xml.EndElement{Name: name})
+
+	return elem
+}
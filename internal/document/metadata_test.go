@@ -0,0 +1,76 @@
+package document
+
+import (
+	"bytes"
+	"encoding/xml"
+	"io/ioutil"
+	"testing"
+
+	"github.com/microfast-ch/rea/internal/odf"
+	"github.com/microfast-ch/rea/internal/ooxml"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMetadata(t *testing.T) {
+	metadata := map[string]string{"title": "Offer <42>", "author": "Jane Doe"}
+
+	// ODF packages have the metadata in meta.xml
+	tmpl, err := NewFromFile("../../testdata/Basic1.ott")
+	require.Nil(t, err)
+
+	out := bytes.NewBuffer([]byte(""))
+	_, err = tmpl.Write(&Model{Metadata: metadata}, out)
+	require.Nil(t, err)
+
+	doc, err := odf.New(bytes.NewReader(out.Bytes()), int64(out.Len()))
+	require.Nil(t, err)
+
+	fd, err := doc.Open("meta.xml")
+	require.Nil(t, err)
+
+	meta, err := ioutil.ReadAll(fd)
+	require.Nil(t, err)
+	fd.Close()
+	require.Contains(t, string(meta), "Offer &lt;42&gt;")
+	require.Contains(t, string(meta), "Jane Doe")
+
+	// Flat documents have it in the document itself
+	tmpl, err = NewFromFile("../../testdata/Conditional1.fodt")
+	require.Nil(t, err)
+
+	out.Reset()
+	_, err = tmpl.Write(&Model{Data: map[string]any{"items": []any{}}, Metadata: metadata}, out)
+	require.Nil(t, err)
+	require.Contains(t, out.String(), "Offer &lt;42&gt;")
+
+	// OOXML packages have it in the core properties
+	tmpl, err = NewFromFile("../../testdata/Basic1.dotx")
+	require.Nil(t, err)
+
+	out.Reset()
+	_, err = tmpl.Write(&Model{Metadata: metadata}, out)
+	require.Nil(t, err)
+
+	pkg, err := ooxml.New(bytes.NewReader(out.Bytes()), int64(out.Len()))
+	require.Nil(t, err)
+
+	fd, err = pkg.Open("docProps/core.xml")
+	require.Nil(t, err)
+
+	core, err := ioutil.ReadAll(fd)
+	require.Nil(t, err)
+	fd.Close()
+	require.Contains(t, string(core), "<dc:title>Offer &lt;42&gt;</dc:title><dc:subject /><dc:creator>Jane Doe</dc:creator>")
+}
+
+func TestSetElementText(t *testing.T) {
+	title := xml.Name{Space: nsDC, Local: "title"}
+
+	res, err := setElementText(`<cp xmlns:dc="`+nsDC+`"><dc:title>Old</dc:title></cp>`, title, "New")
+	require.Nil(t, err)
+	require.Equal(t, `<cp xmlns:dc="`+nsDC+`"><dc:title>New</dc:title></cp>`, res)
+
+	res, err = setElementText(`<cp></cp>`, title, "New")
+	require.Nil(t, err)
+	require.Equal(t, `<cp><title xmlns="`+nsDC+`">New</title></cp>`, res)
+}
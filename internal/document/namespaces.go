@@ -0,0 +1,17 @@
+package document
+
+// XML namespaces of the elements and attributes that are processed.
+const (
+	nsOffice  = "urn:oasis:names:tc:opendocument:xmlns:office:1.0"
+	nsStyle   = "urn:oasis:names:tc:opendocument:xmlns:style:1.0"
+	nsTable   = "urn:oasis:names:tc:opendocument:xmlns:table:1.0"
+	nsMeta    = "urn:oasis:names:tc:opendocument:xmlns:meta:1.0"
+	nsDC      = "http://purl.org/dc/elements/1.1/"
+	nsCalcext = "urn:org:documentfoundation:names:experimental:calc:xmlns:calcext:1.0"
+	nsXlink   = "http://www.w3.org/1999/xlink"
+	nsWord    = "http://schemas.openxmlformats.org/wordprocessingml/2006/main"
+	nsRels    = "http://schemas.openxmlformats.org/officeDocument/2006/relationships"
+	nsSheet   = "http://schemas.openxmlformats.org/spreadsheetml/2006/main"
+	nsPres    = "http://schemas.openxmlformats.org/presentationml/2006/main"
+	nsPkgRels = "http://schemas.openxmlformats.org/package/2006/relationships"
+)
@@ -1,49 +1,125 @@
 package document
 
 import (
+	"encoding/xml"
 	"fmt"
 	"io"
 	"io/ioutil"
+	"strings"
 
 	"github.com/djboris9/xmltree"
+	"github.com/microfast-ch/rea/internal/engine"
 	"github.com/microfast-ch/rea/internal/odf"
 	"github.com/microfast-ch/rea/internal/utils"
 )
 
 // processOdf processes the ODF specific entities for current PackagedDocument.
-// The PackagedDocument must be of type *odf.Odf.
+// The PackagedDocument must be of type *odf.Odf or *odf.Flat.
 func (p *PackagedDocument) processOdf(model *Model, out io.Writer) (*ProcessingData, error) {
-	tmpl, ok := p.doc.(*odf.Odf)
-	if !ok {
+	switch p.doc.(type) {
+	case *odf.Odf, *odf.Flat:
+	default:
 		return nil, fmt.Errorf("%w: processOdf called on non ODF document of type %T", ErrUnknownType, p.doc)
 	}
 
 	templateData := &ProcessingData{
-		TemplateMimeType: tmpl.MIMEType(),
+		TemplateMimeType: p.doc.MIMEType(),
 	}
 
-	xmlTree, err := getODFContent(tmpl)
+	xmlTree, err := getODFContent(p.doc)
 	if err != nil {
 		return templateData, err
 	}
 
-	err = runEngine(xmlTree, templateData, model, tmpl.InitScript())
+	// Load the includes and merge their styles before the template is processed
+	includes, err := p.loadOdfIncludes(xmlTree)
 	if err != nil {
 		return templateData, err
 	}
 
-	// Write file, overriding mimetype and content.xml
-	// TODO: Override/Delete thumbnail and remove it from the manifest.xml
+	err = includes.merge(xmlTree)
+	if err != nil {
+		return templateData, err
+	}
+
+	cfg := engineConfig{
+		initScript: p.doc.InitScript(),
+		includes:   includes.fragments,
+		charPolicy: p.charPolicy,
+		undefined:  p.undefinedMode,
+	}
+
+	// Numbers and dates in spreadsheets are written as typed cells, so formulas can use them
+	if odf.DocumentMIMEType(p.doc.MIMEType()) == odf.MIMETypeSpreadsheet {
+		cfg.valueTyper = odfValueTyper{}
+	}
+
+	err = runEngine(xmlTree, templateData, model, cfg)
+	if err != nil {
+		return templateData, err
+	}
+
+	// Write file, overriding mimetype and content.xml, which is the whole flat document
 	ov := odf.Overrides{
 		"mimetype": odf.Override{
-			Data: []byte("application/vnd.oasis.opendocument.text"),
+			Data: []byte(odf.DocumentMIMEType(p.doc.MIMEType())),
 		},
 		"content.xml": odf.Override{
 			Data: []byte(templateData.XMLResult),
 		},
 	}
 
-	err = tmpl.Write(out, ov)
+	// Add the media of the includes, the manifest entries are added when the package is written
+	for name, media := range includes.media {
+		ov[name] = media
+	}
+
+	switch tmpl := p.doc.(type) {
+	case *odf.Odf:
+		var meta []byte
+
+		meta, err = getODFMetadata(tmpl, model.Metadata)
+		if err != nil {
+			return templateData, err
+		}
+
+		if meta != nil {
+			ov["meta.xml"] = odf.Override{Data: meta}
+		}
+
+		// The thumbnail shows the template, so it is replaced or removed
+		err = tmpl.SetThumbnail(ov, p.thumbnail)
+		if err != nil {
+			return templateData, fmt.Errorf("setting thumbnail: %w", err)
+		}
+
+		if p.odfRepresentation() == ODFOutputFlat {
+			err = tmpl.WriteFlat(out, ov)
+		} else {
+			err = tmpl.Write(out, ov)
+		}
+	case *odf.Flat:
+		var content []byte
+
+		content, err = setFlatMetadata(templateData.XMLResult, model.Metadata)
+		if err != nil {
+			return templateData, err
+		}
+
+		ov["content.xml"] = odf.Override{Data: content}
+
+		err = tmpl.SetThumbnail(ov, p.thumbnail)
+		if err != nil {
+			return templateData, fmt.Errorf("setting thumbnail: %w", err)
+		}
+
+		if p.odfRepresentation() == ODFOutputPackage {
+			err = tmpl.WritePackage(out, ov)
+		} else {
+			err = tmpl.Write(out, ov)
+		}
+	}
+
 	if err != nil {
 		return templateData, fmt.Errorf("writing rendered template: %w", err)
 	}
@@ -51,11 +127,13 @@ func (p *PackagedDocument) processOdf(model *Model, out io.Writer) (*ProcessingD
 	return templateData, nil
 }
 
-// getODFContent returns the ODF specific content.xml as XMLTree.
-func getODFContent(tmpl *odf.Odf) (*xmltree.Node, error) {
-	// Check for text or text-template mimetype
-	if tmpl.MIMEType() != "application/vnd.oasis.opendocument.text-template" &&
-		tmpl.MIMEType() != "application/vnd.oasis.opendocument.text" {
+// getODFContent returns the ODF specific content.xml as XMLTree. For flat documents,
+// it is the whole document.
+func getODFContent(tmpl Format) (*xmltree.Node, error) {
+	// Check for text, spreadsheet or presentation mimetype
+	switch odf.DocumentMIMEType(tmpl.MIMEType()) {
+	case odf.MIMETypeText, odf.MIMETypeSpreadsheet, odf.MIMETypePresentation:
+	default:
 		return nil, utils.FormatError(ErrMimetype, fmt.Sprintf("Unsupported mimetype: %s", tmpl.MIMEType()))
 	}
 
@@ -76,3 +154,49 @@ func getODFContent(tmpl *odf.Odf) (*xmltree.Node, error) {
 
 	return tree, nil
 }
+
+// odfRoot returns the root element of the content, which is `office:document` for flat documents.
+func odfRoot(content *xmltree.Node) *xmltree.Node {
+	if root := utils.FindChild(content, nsOffice, "document-content"); root != nil {
+		return root
+	}
+
+	return utils.FindChild(content, nsOffice, "document")
+}
+
+// odfValueTyper sets the value type and value of table cells that contain a printed number or date.
+type odfValueTyper struct{}
+
+func (odfValueTyper) TypeValue(elem xml.StartElement, v engine.TypedValue) (xml.StartElement, bool) {
+	if elem.Name.Space != nsTable || elem.Name.Local != "table-cell" {
+		return elem, false
+	}
+
+	valueAttr := "value"
+	if v.Type == engine.ValueTypeDate {
+		valueAttr = "date-value"
+	}
+
+	// The previous value and its type are replaced, LibreOffice keeps the type also as calcext:value-type
+	attrs := make([]xml.Attr, 0, len(elem.Attr)+2)
+
+	for _, a := range elem.Attr {
+		switch {
+		case a.Name.Space == nsOffice && (a.Name.Local == "value-type" || strings.HasSuffix(a.Name.Local, "value")):
+			continue
+		case a.Name.Space == nsCalcext && a.Name.Local == "value-type":
+			a.Value = v.Type
+		}
+
+		attrs = append(attrs, a)
+	}
+
+	attrs = append(attrs,
+		xml.Attr{Name: xml.Name{Space: nsOffice, Local: "value-type"}, Value: v.Type},
+		xml.Attr{Name: xml.Name{Space: nsOffice, Local: valueAttr}, Value: v.Value},
+	)
+
+	elem.Attr = attrs
+
+	return elem, true
+}
@@ -29,8 +29,81 @@ func TestTemplateODT(t *testing.T) {
 	content, err := ioutil.ReadAll(contentFD)
 	require.Nil(t, err)
 
-	require.Greater(t, len(content), 10000)
+	require.Greater(t, len(content), 8000)
+	require.Contains(t, string(content), "<office:document-content ")
 	contentFD.Close()
+
+	// The thumbnail of the template is removed
+	_, err = doc.Open(odf.ThumbnailPath)
+	require.Error(t, err)
+}
+
+func TestTemplateODS(t *testing.T) {
+	tmpl, err := NewFromFile("../../testdata/Spreadsheet1.ots")
+	require.Nil(t, err)
+
+	out := bytes.NewBuffer([]byte(""))
+	_, err = tmpl.Write(&Model{Data: map[string]any{"items": []any{}}}, out)
+	require.Nil(t, err)
+
+	doc, err := odf.New(bytes.NewReader(out.Bytes()), int64(out.Len()))
+	require.Nil(t, err)
+	require.Equal(t, odf.MIMETypeSpreadsheet, doc.MIMEType()) // `spreadsheet-template` is now `spreadsheet`
+}
+
+func TestTemplateFODT(t *testing.T) {
+	tmpl, err := NewFromFile("../../testdata/Conditional1.fodt")
+	require.Nil(t, err)
+
+	model := &Model{Data: map[string]any{"items": []any{"Apple"}}}
+
+	// Flat templates are written flat by default
+	out := bytes.NewBuffer([]byte(""))
+	_, err = tmpl.Write(model, out)
+	require.Nil(t, err)
+
+	flat, err := odf.NewFlat(bytes.NewReader(out.Bytes()), int64(out.Len()))
+	require.Nil(t, err)
+	require.Equal(t, odf.MIMETypeText, flat.MIMEType())
+	require.Contains(t, out.String(), "Apple")
+
+	// Converted to a package, the content is split into the parts
+	tmpl.SetODFOutput(ODFOutputPackage)
+
+	out.Reset()
+	_, err = tmpl.Write(model, out)
+	require.Nil(t, err)
+
+	doc, err := odf.New(bytes.NewReader(out.Bytes()), int64(out.Len()))
+	require.Nil(t, err)
+	require.Equal(t, odf.MIMETypeText, doc.MIMEType())
+
+	for name, want := range map[string]string{"content.xml": "Apple", "styles.xml": "master-styles", "meta.xml": "creation-date"} {
+		fd, err := doc.Open(name)
+		require.Nil(t, err)
+
+		data, err := ioutil.ReadAll(fd)
+		require.Nil(t, err)
+		require.Contains(t, string(data), want, name)
+		fd.Close()
+	}
 }
 
-// TODO TestTemplateOOXML
+func TestTemplateODTFlatOutput(t *testing.T) {
+	tmpl, err := NewFromFile("../../testdata/Conditional1.odt")
+	require.Nil(t, err)
+
+	tmpl.SetODFOutput(ODFOutputFlat)
+
+	out := bytes.NewBuffer([]byte(""))
+	_, err = tmpl.Write(&Model{Data: map[string]any{"items": []any{"Apple"}}}, out)
+	require.Nil(t, err)
+
+	flat, err := odf.NewFlat(bytes.NewReader(out.Bytes()), int64(out.Len()))
+	require.Nil(t, err)
+	require.Equal(t, odf.MIMETypeText, flat.MIMEType())
+
+	for _, want := range []string{"Apple", "master-styles", "creation-date", "config-item"} {
+		require.Contains(t, out.String(), want)
+	}
+}
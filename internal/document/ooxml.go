@@ -0,0 +1,123 @@
+package document
+
+import (
+	"fmt"
+	"io"
+
+	"github.com/djboris9/xmltree"
+	"github.com/microfast-ch/rea/internal/ooxml"
+)
+
+// processOoxml processes the OOXML specific entities for current PackagedDocument.
+// The PackagedDocument must be of type *ooxml.OOXML.
+func (p *PackagedDocument) processOoxml(model *Model, out io.Writer) (*ProcessingData, error) {
+	tmpl, ok := p.doc.(*ooxml.OOXML)
+	if !ok {
+		return nil, fmt.Errorf("%w: processOoxml called on non OOXML document of type %T", ErrUnknownType, p.doc)
+	}
+
+	switch tmpl.MIMEType() {
+	case ooxml.SpreadsheetContentType:
+		return p.processXlsx(tmpl, model, out)
+	case ooxml.PresentationContentType:
+		return p.processPptx(tmpl, model, out)
+	}
+
+	templateData := &ProcessingData{
+		TemplateMimeType: tmpl.MIMEType(),
+	}
+
+	xmlTree, err := getOOXMLContent(tmpl)
+	if err != nil {
+		return templateData, err
+	}
+
+	includes, err := p.loadOoxmlIncludes(tmpl, xmlTree)
+	if err != nil {
+		return templateData, err
+	}
+
+	err = runEngine(xmlTree, templateData, model, engineConfig{
+		initScript: tmpl.InitScript(),
+		includes:   includes.fragments,
+		charPolicy: p.charPolicy,
+		undefined:  p.undefinedMode,
+	})
+	if err != nil {
+		return templateData, err
+	}
+
+	// Templates become documents and macros are stripped on request
+	ov := ooxml.Overrides{}
+
+	contentType := ooxml.DocumentContentType(tmpl.MIMEType(), p.stripMacros)
+	if contentType != tmpl.MIMEType() {
+		ov, err = tmpl.Retype(contentType, p.stripMacros && ooxml.IsMacroEnabled(tmpl.MIMEType()))
+		if err != nil {
+			return templateData, fmt.Errorf("changing content type to %s: %w", contentType, err)
+		}
+	}
+
+	// Write file, overriding the main document and adding the resources of the includes
+	ov[tmpl.MainPart()] = ooxml.Override{
+		Data: []byte(templateData.XMLResult),
+	}
+
+	err = includes.merge(tmpl, ov)
+	if err != nil {
+		return templateData, err
+	}
+
+	err = setOOXMLMetadata(tmpl, model.Metadata, ov)
+	if err != nil {
+		return templateData, err
+	}
+
+	// The thumbnail shows the template, so it is replaced or removed
+	err = tmpl.SetThumbnail(ov, p.thumbnail)
+	if err != nil {
+		return templateData, fmt.Errorf("setting thumbnail: %w", err)
+	}
+
+	err = tmpl.Write(out, ov)
+	if err != nil {
+		return templateData, fmt.Errorf("writing rendered template: %w", err)
+	}
+
+	return templateData, nil
+}
+
+// getOOXMLContent returns the main part of the document, e.g. `word/document.xml`, as XMLTree.
+func getOOXMLContent(tmpl *ooxml.OOXML) (*xmltree.Node, error) {
+	return getOOXMLPart(tmpl, tmpl.MainPart())
+}
+
+// getOOXMLPart returns the part with the given name as XMLTree.
+func getOOXMLPart(tmpl *ooxml.OOXML, name string) (*xmltree.Node, error) {
+	content, err := tmpl.ReadPart(name)
+	if err != nil {
+		return nil, err
+	}
+
+	tree, err := xmltree.Parse(content)
+	if err != nil {
+		return nil, fmt.Errorf("parsing %s as tree: %w", name, err)
+	}
+
+	return tree, nil
+}
+
+// loadRelationships returns the relationships of the part by id.
+func loadRelationships(tmpl *ooxml.OOXML, part string) (map[string]ooxml.Relationship, error) {
+	list, err := tmpl.ReadRelationships(part)
+	if err != nil {
+		return nil, err
+	}
+
+	rels := make(map[string]ooxml.Relationship, len(list))
+	for _, rel := range list {
+		rels[rel.ID] = rel
+	}
+
+	return rels, nil
+}
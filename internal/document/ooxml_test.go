@@ -0,0 +1,104 @@
+package document
+
+import (
+	"archive/zip"
+	"bytes"
+	"io"
+	"io/ioutil"
+	"strings"
+	"testing"
+
+	"github.com/microfast-ch/rea/internal/ooxml"
+	"github.com/stretchr/testify/require"
+)
+
+func TestTemplateOOXML(t *testing.T) {
+	tmpl, err := NewFromFile("../../testdata/Basic1.docx")
+	require.Nil(t, err)
+
+	out := bytes.NewBuffer([]byte(""))
+	_, err = tmpl.Write(&Model{}, out)
+	require.Nil(t, err)
+
+	// Readout the main part
+	doc, err := ooxml.New(bytes.NewReader(out.Bytes()), int64(out.Len()))
+	require.Nil(t, err)
+	require.Equal(t, ooxml.MainDocumentContentType, doc.MIMEType())
+
+	contentFD, err := doc.Open(doc.MainPart())
+	require.Nil(t, err)
+
+	content, err := ioutil.ReadAll(contentFD)
+	require.Nil(t, err)
+	contentFD.Close()
+
+	// The loop is executed and its blocks are removed
+	require.Greater(t, len(content), 10000)
+	require.Contains(t, string(content), "<w:document ")
+	require.NotContains(t, string(content), "[[")
+
+	// The thumbnail of the template is removed
+	_, err = doc.Open(ooxml.ThumbnailPart)
+	require.Error(t, err)
+}
+
+func TestTemplateDOTX(t *testing.T) {
+	for file, want := range map[string]string{
+		"Basic1.dotx": ooxml.MainDocumentContentType,  // `template` is now `document`
+		"Macro1.dotm": ooxml.MacroDocumentContentType, // Macros are kept by default
+	} {
+		tmpl, err := NewFromFile("../../testdata/" + file)
+		require.Nil(t, err)
+
+		out := bytes.NewBuffer([]byte(""))
+		_, err = tmpl.Write(&Model{}, out)
+		require.Nil(t, err)
+
+		doc, err := ooxml.New(bytes.NewReader(out.Bytes()), int64(out.Len()))
+		require.Nil(t, err)
+		require.Equal(t, want, doc.MIMEType(), file)
+	}
+}
+
+func TestTemplateOOXMLMainPart(t *testing.T) {
+	// The main part is found by its content type, so it doesn't need to be `word/document.xml`
+	rdr, err := zip.OpenReader("../../testdata/Basic1.docx")
+	require.Nil(t, err)
+	defer rdr.Close()
+
+	var buf bytes.Buffer
+
+	w := zip.NewWriter(&buf)
+
+	for _, f := range rdr.File {
+		fd, err := f.Open()
+		require.Nil(t, err)
+
+		data, err := io.ReadAll(fd)
+		require.Nil(t, err)
+		fd.Close()
+
+		name := strings.Replace(f.Name, "document.xml", "main.xml", 1)
+		data = bytes.ReplaceAll(data, []byte("word/document.xml"), []byte("word/main.xml"))
+
+		fw, err := w.Create(name)
+		require.Nil(t, err)
+
+		_, err = fw.Write(data)
+		require.Nil(t, err)
+	}
+
+	require.Nil(t, w.Close())
+
+	tmpl, err := New(bytes.NewReader(buf.Bytes()), int64(buf.Len()))
+	require.Nil(t, err)
+
+	out := bytes.NewBuffer([]byte(""))
+	_, err = tmpl.Write(&Model{}, out)
+	require.Nil(t, err)
+
+	parts := readPackage(t, out.Bytes())
+	require.NotContains(t, parts, "word/document.xml")
+	require.Contains(t, parts["word/main.xml"], "<w:document ")
+	require.NotContains(t, parts["word/main.xml"], "[[")
+}
@@ -0,0 +1,406 @@
+package document
+
+import (
+	"bytes"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"path"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/microfast-ch/rea/internal/ooxml"
+	"github.com/microfast-ch/rea/internal/utils"
+)
+
+var ErrPresentation = errors.New("presentationErr")
+
+const (
+	slideContentType = "application/vnd.openxmlformats-officedocument.presentationml.slide+xml"
+	slideRelType     = "http://schemas.openxmlformats.org/officeDocument/2006/relationships/slide"
+)
+
+// pptxSlide is a slide of a presentation.
+type pptxSlide struct {
+	relID string // Relationship of the presentation to the slide
+	part  string // Part inside the package, e.g. `ppt/slides/slide1.xml`
+}
+
+// pptxPresentation holds the slides of a presentation and the state to add new slides.
+type pptxPresentation struct {
+	part      string // Part of the presentation, e.g. `ppt/presentation.xml`
+	slides    []pptxSlide
+	relIDs    map[string]bool // Relationship ids of the presentation in use
+	lastSlide int             // Highest number of the slide parts
+	lastID    int             // Highest slide id of the slide list
+}
+
+// pptxAddedSlide is a slide that was added by repeating a slide.
+type pptxAddedSlide struct {
+	after pptxSlide // Slide that was repeated, the added slides follow it
+	relID string
+	part  string
+}
+
+// processPptx processes the slides of an OOXML presentation. Every slide that contains code
+// blocks is run through the engine, the result data is the one of the first such slide.
+// Slides that are repeated with `-- @repeat slide` are added as new slide parts, slides that
+// are repeated over an empty list are removed.
+func (p *PackagedDocument) processPptx(tmpl *ooxml.OOXML, model *Model, out io.Writer) (*ProcessingData, error) {
+	templateData := &ProcessingData{
+		TemplateMimeType: tmpl.MIMEType(),
+	}
+
+	pres, err := loadPresentation(tmpl)
+	if err != nil {
+		return templateData, err
+	}
+
+	ov := ooxml.Overrides{}
+	added := []pptxAddedSlide{}
+	removed := []pptxSlide{}
+
+	for _, slide := range pres.slides {
+		slideData := templateData
+		if len(ov) > 0 {
+			slideData = &ProcessingData{TemplateMimeType: tmpl.MIMEType()}
+		}
+
+		rendered, err := p.renderSlide(tmpl, slide, slideData, model)
+		if err != nil {
+			return templateData, fmt.Errorf("slide %s: %w", slide.part, err)
+		}
+
+		if rendered == nil {
+			continue
+		}
+
+		if len(rendered) == 0 {
+			removed = append(removed, slide)
+			continue
+		}
+
+		ov[slide.part] = ooxml.Override{Data: rendered[0]}
+
+		for _, data := range rendered[1:] {
+			added = append(added, pres.addSlide(tmpl, slide, data, ov))
+		}
+	}
+
+	if len(added) > 0 || len(removed) > 0 {
+		err = pres.register(tmpl, added, removed, ov)
+		if err != nil {
+			return templateData, err
+		}
+	}
+
+	err = setOOXMLMetadata(tmpl, model.Metadata, ov)
+	if err != nil {
+		return templateData, err
+	}
+
+	// The thumbnail shows the template, so it is replaced or removed
+	err = tmpl.SetThumbnail(ov, p.thumbnail)
+	if err != nil {
+		return templateData, fmt.Errorf("setting thumbnail: %w", err)
+	}
+
+	err = tmpl.Write(out, ov)
+	if err != nil {
+		return templateData, fmt.Errorf("writing rendered template: %w", err)
+	}
+
+	return templateData, nil
+}
+
+// loadPresentation reads the slides of the presentation in the order of the slide list.
+func loadPresentation(tmpl *ooxml.OOXML) (*pptxPresentation, error) {
+	pres := &pptxPresentation{
+		part:   tmpl.MainPart(),
+		relIDs: map[string]bool{},
+	}
+
+	tree, err := getOOXMLPart(tmpl, pres.part)
+	if err != nil {
+		return nil, err
+	}
+
+	rels, err := loadRelationships(tmpl, pres.part)
+	if err != nil {
+		return nil, err
+	}
+
+	for id, rel := range rels {
+		pres.relIDs[id] = true
+
+		if m := slidePartNumber.FindStringSubmatch(rel.Target); m != nil {
+			if n, _ := strconv.Atoi(m[1]); n > pres.lastSlide {
+				pres.lastSlide = n
+			}
+		}
+	}
+
+	root := utils.FindChild(tree, nsPres, "presentation")
+	if root == nil {
+		return nil, utils.FormatError(ErrPresentation, fmt.Sprintf("%s has no presentation element", pres.part))
+	}
+
+	for _, s := range utils.ElementChildren(utils.FindChild(root, nsPres, "sldIdLst")) {
+		id, _ := getAttr(s, "", "id")
+		if n, _ := strconv.Atoi(id); n > pres.lastID {
+			pres.lastID = n
+		}
+
+		relID, _ := getAttr(s, nsRels, "id")
+
+		rel, ok := rels[relID]
+		if !ok {
+			return nil, utils.FormatError(ErrPresentation, fmt.Sprintf("slide %s has no relationship %q", id, relID))
+		}
+
+		pres.slides = append(pres.slides, pptxSlide{relID: relID, part: rel.Target})
+	}
+
+	return pres, nil
+}
+
+var slidePartNumber = regexp.MustCompile(`slides/slide(\d+)\.xml$`)
+
+// renderSlide runs the slide through the engine if it contains code blocks. It returns a rendered
+// slide for each repetition of the slide, none if it was repeated over an empty list, or nil if
+// the slide has no code blocks.
+func (p *PackagedDocument) renderSlide(tmpl *ooxml.OOXML, slide pptxSlide, templateData *ProcessingData, model *Model) ([][]byte, error) {
+	xmlTree, err := getOOXMLPart(tmpl, slide.part)
+	if err != nil {
+		return nil, err
+	}
+
+	if !hasBlockTokens(utils.NodeText(xmlTree)) {
+		return nil, nil
+	}
+
+	// A repeated slide results in several slide elements, the first one is the result of the engine
+	var slides [][]xml.Token
+
+	err = runEngine(xmlTree, templateData, model, engineConfig{
+		initScript: tmpl.InitScript(),
+		rewrite: func(tokens []xml.Token) ([]xml.Token, error) {
+			// A slide that is repeated over an empty list has no root element
+			slides = splitRoots(tokens)
+			if len(slides) == 0 {
+				return nil, nil
+			}
+
+			return slides[0], nil
+		},
+		charPolicy: p.charPolicy,
+		undefined:  p.undefinedMode,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	if len(slides) == 0 {
+		return [][]byte{}, nil
+	}
+
+	rendered := [][]byte{[]byte(templateData.XMLResult)}
+
+	for _, s := range slides[1:] {
+		var buf bytes.Buffer
+
+		err = encodeTokens(&buf, s)
+		if err != nil {
+			return nil, err
+		}
+
+		rendered = append(rendered, buf.Bytes())
+	}
+
+	return rendered, nil
+}
+
+// splitRoots splits the tokens into documents with one root element each.
+// The tokens before the first root element, like the XML declaration, are part of every document.
+func splitRoots(tokens []xml.Token) [][]xml.Token {
+	prolog := []xml.Token{}
+	docs := [][]xml.Token{}
+
+	for i := 0; i < len(tokens); i++ {
+		if _, ok := tokens[i].(xml.StartElement); !ok {
+			if len(docs) == 0 {
+				prolog = append(prolog, tokens[i])
+			}
+
+			continue
+		}
+
+		end := matchingEnd(tokens, i)
+		doc := append(append([]xml.Token{}, prolog...), tokens[i:end+1]...)
+		docs = append(docs, doc)
+		i = end
+	}
+
+	return docs
+}
+
+// addSlide adds the rendered data as new slide part, with the relationships of the repeated slide.
+// The notes belong to the repeated slide and are not shared.
+func (pres *pptxPresentation) addSlide(tmpl *ooxml.OOXML, after pptxSlide, data []byte, ov ooxml.Overrides) pptxAddedSlide {
+	pres.lastSlide++
+	a := pptxAddedSlide{
+		after: after,
+		relID: pres.newRelID(),
+		part:  path.Join(path.Dir(after.part), fmt.Sprintf("slide%d.xml", pres.lastSlide)),
+	}
+
+	ov[a.part] = ooxml.Override{Data: data}
+
+	// A slide without relationships is valid
+	if rels, err := tmpl.ReadPart(ooxml.RelsPart(after.part)); err == nil {
+		ov[ooxml.RelsPart(a.part)] = ooxml.Override{Data: notesSlideRel.ReplaceAll(rels, nil)}
+	}
+
+	return a
+}
+
+var notesSlideRel = regexp.MustCompile(`<(?:\w+:)?Relationship\b[^>]*Type="[^"]*/notesSlide"[^>]*/>`)
+
+// newRelID returns an unused relationship id of the presentation.
+func (pres *pptxPresentation) newRelID() string {
+	for i := len(pres.relIDs) + 1; ; i++ {
+		id := "rId" + strconv.Itoa(i)
+		if !pres.relIDs[id] {
+			pres.relIDs[id] = true
+			return id
+		}
+	}
+}
+
+// register adds the added slides to the slide list, the relationships and the content types and
+// removes the removed slides from the slide list. The parts of removed slides and their notes are
+// deleted, so their relationships and content types are removed on writing.
+// The parts are changed textually, so everything else stays as written by the office suite.
+func (pres *pptxPresentation) register(tmpl *ooxml.OOXML, added []pptxAddedSlide, removed []pptxSlide, ov ooxml.Overrides) error {
+	presentation, err := tmpl.ReadPart(pres.part)
+	if err != nil {
+		return err
+	}
+
+	rels, err := tmpl.ReadPart(ooxml.RelsPart(pres.part))
+	if err != nil {
+		return err
+	}
+
+	contentTypes, err := tmpl.ReadPart("[Content_Types].xml")
+	if err != nil {
+		return err
+	}
+
+	content := string(presentation)
+	newRels := strings.Builder{}
+	newTypes := strings.Builder{}
+
+	// Added slides are inserted after the previous added slide of the same repeated slide
+	last := map[string]string{}
+
+	for _, a := range added {
+		prev, ok := last[a.after.relID]
+		if !ok {
+			prev = a.after.relID
+		}
+
+		last[a.after.relID] = a.relID
+
+		entry := regexp.MustCompile(`<(\w+:)?sldId\b[^>]*\b(\w+):id="` + regexp.QuoteMeta(prev) + `"[^>]*/>`)
+
+		loc := entry.FindStringSubmatchIndex(content)
+		if loc == nil {
+			return utils.FormatError(ErrPresentation, fmt.Sprintf("slide list has no entry for %q", prev))
+		}
+
+		prefix := ""
+		if loc[2] >= 0 {
+			prefix = content[loc[2]:loc[3]]
+		}
+
+		pres.lastID++
+		relPrefix := content[loc[4]:loc[5]]
+
+		content = content[:loc[1]] +
+			fmt.Sprintf(`<%ssldId id="%d" %s:id="%s"/>`, prefix, pres.lastID, relPrefix, a.relID) +
+			content[loc[1]:]
+
+		// Targets are relative to the presentation, if the slide is below it
+		target := "/" + a.part
+		if dir := path.Dir(pres.part) + "/"; strings.HasPrefix(a.part, dir) {
+			target = strings.TrimPrefix(a.part, dir)
+		}
+
+		fmt.Fprintf(&newRels, `<Relationship Id="%s" Type="%s" Target="%s"/>`, a.relID, slideRelType, target)
+		fmt.Fprintf(&newTypes, `<Override PartName="/%s" ContentType="%s"/>`, a.part, slideContentType)
+	}
+
+	for _, slide := range removed {
+		entry := regexp.MustCompile(`<(\w+:)?sldId\b[^>]*\b\w+:id="` + regexp.QuoteMeta(slide.relID) + `"[^>]*/>`)
+		content = entry.ReplaceAllString(content, "")
+
+		err = deleteSlide(tmpl, slide, ov)
+		if err != nil {
+			return err
+		}
+	}
+
+	ov[pres.part] = ooxml.Override{Data: []byte(content)}
+
+	relsData, err := insertBefore(string(rels), "</Relationships>", newRels.String())
+	if err != nil {
+		return err
+	}
+
+	ov[ooxml.RelsPart(pres.part)] = ooxml.Override{Data: []byte(relsData)}
+
+	typesData, err := insertBefore(string(contentTypes), "</Types>", newTypes.String())
+	if err != nil {
+		return err
+	}
+
+	ov["[Content_Types].xml"] = ooxml.Override{Data: []byte(typesData)}
+
+	return nil
+}
+
+// deleteSlide deletes the part of the slide and the notes of the slide.
+func deleteSlide(tmpl *ooxml.OOXML, slide pptxSlide, ov ooxml.Overrides) error {
+	ov[slide.part] = ooxml.Override{Delete: true}
+
+	// A slide without relationships is valid
+	if _, err := tmpl.ReadPart(ooxml.RelsPart(slide.part)); err != nil {
+		return nil
+	}
+
+	rels, err := loadRelationships(tmpl, slide.part)
+	if err != nil {
+		return err
+	}
+
+	for _, rel := range rels {
+		if strings.HasSuffix(rel.Type, "/notesSlide") {
+			ov[rel.Target] = ooxml.Override{Delete: true}
+		}
+	}
+
+	return nil
+}
+
+// insertBefore inserts text before the last occurrence of the end tag.
+func insertBefore(content, endTag, text string) (string, error) {
+	idx := strings.LastIndex(content, endTag)
+	if idx < 0 {
+		return "", utils.FormatError(ErrPresentation, fmt.Sprintf("missing %s", endTag))
+	}
+
+	return content[:idx] + text + content[idx:], nil
+}
@@ -0,0 +1,88 @@
+package document
+
+import (
+	"bytes"
+	"io/ioutil"
+	"testing"
+
+	"github.com/microfast-ch/rea/internal/ooxml"
+	"github.com/stretchr/testify/require"
+)
+
+func TestTemplatePPTX(t *testing.T) {
+	tmpl, err := NewFromFile("../../testdata/Presentation1.pptx")
+	require.Nil(t, err)
+
+	model := &Model{Data: map[string]any{"products": []any{
+		map[string]any{"name": "Apple", "price": 1.5},
+		map[string]any{"name": "Banana", "price": 2},
+		map[string]any{"name": "Cherry", "price": 3},
+	}}}
+
+	out := bytes.NewBuffer([]byte(""))
+	_, err = tmpl.Write(model, out)
+	require.Nil(t, err)
+
+	doc, err := ooxml.New(bytes.NewReader(out.Bytes()), int64(out.Len()))
+	require.Nil(t, err)
+	require.Equal(t, ooxml.PresentationContentType, doc.MIMEType())
+
+	read := func(name string) string {
+		fd, err := doc.Open(name)
+		require.Nil(t, err)
+
+		defer fd.Close()
+
+		data, err := ioutil.ReadAll(fd)
+		require.Nil(t, err)
+
+		return string(data)
+	}
+
+	// The repeated slides follow the template slide in the slide list
+	require.Contains(t, read("ppt/presentation.xml"), `<p:sldIdLst><p:sldId id="256" r:id="rId2"/><p:sldId id="257" r:id="rId3"/>`+
+		`<p:sldId id="259" r:id="rId6"/><p:sldId id="260" r:id="rId7"/><p:sldId id="258" r:id="rId4"/></p:sldIdLst>`)
+
+	rels := read("ppt/_rels/presentation.xml.rels")
+	require.Contains(t, rels, `<Relationship Id="rId6" Type="`+slideRelType+`" Target="slides/slide4.xml"/>`)
+	require.Contains(t, rels, `<Relationship Id="rId7" Type="`+slideRelType+`" Target="slides/slide5.xml"/>`)
+
+	contentTypes := read("[Content_Types].xml")
+	require.Contains(t, contentTypes, `<Override PartName="/ppt/slides/slide4.xml" ContentType="`+slideContentType+`"/>`)
+	require.Contains(t, contentTypes, `<Override PartName="/ppt/slides/slide5.xml" ContentType="`+slideContentType+`"/>`)
+
+	// Each repetition has its own content, the notes stay with the template slide
+	require.Contains(t, read("ppt/slides/slide4.xml"), "Premium")
+	require.Contains(t, read("ppt/slides/slide5.xml"), "Cherry")
+	require.Contains(t, read("ppt/slides/_rels/slide2.xml.rels"), "notesSlide")
+	require.NotContains(t, read("ppt/slides/_rels/slide5.xml.rels"), "notesSlide")
+	require.Contains(t, read("ppt/slides/_rels/slide5.xml.rels"), "slideLayout1.xml")
+
+	// Slides without code blocks are not touched
+	require.Contains(t, read("ppt/slides/slide3.xml"), "<a:t>Thanks</a:t>")
+}
+
+func TestTemplatePPTXEmptyRepeat(t *testing.T) {
+	tmpl, err := NewFromFile("../../testdata/Presentation1.pptx")
+	require.Nil(t, err)
+
+	model := &Model{Data: map[string]any{"products": []any{}}}
+
+	out := bytes.NewBuffer([]byte(""))
+	_, err = tmpl.Write(model, out)
+	require.Nil(t, err)
+
+	parts := readPackage(t, out.Bytes())
+
+	// The slide repeated over an empty list is removed with its notes
+	require.NotContains(t, parts, "ppt/slides/slide2.xml")
+	require.NotContains(t, parts, "ppt/slides/_rels/slide2.xml.rels")
+	require.NotContains(t, parts, "ppt/notesSlides/notesSlide1.xml")
+	require.Contains(t, parts["ppt/presentation.xml"], `<p:sldIdLst><p:sldId id="256" r:id="rId2"/><p:sldId id="258" r:id="rId4"/></p:sldIdLst>`)
+	require.NotContains(t, parts["ppt/_rels/presentation.xml.rels"], "slides/slide2.xml")
+	require.NotContains(t, parts["[Content_Types].xml"], "/ppt/slides/slide2.xml")
+	require.NotContains(t, parts["[Content_Types].xml"], "/ppt/notesSlides/notesSlide1.xml")
+
+	// The other slides are kept
+	require.Contains(t, parts["ppt/slides/slide3.xml"], "<a:t>Thanks</a:t>")
+}
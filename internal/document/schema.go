@@ -0,0 +1,326 @@
+package document
+
+import (
+	"bytes"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+
+	"github.com/djboris9/xmltree"
+	"github.com/microfast-ch/rea/internal/engine"
+	"github.com/microfast-ch/rea/internal/odf"
+	"github.com/microfast-ch/rea/internal/ooxml"
+	"github.com/microfast-ch/rea/internal/schema"
+	"github.com/microfast-ch/rea/internal/utils"
+)
+
+// SchemaProperty is the name of the custom document property that holds an embedded model schema.
+const SchemaProperty = "rea:schema"
+
+const customRelType = "/custom-properties"
+
+// SetSchema sets the JSON Schema that the data of models is validated against before templating.
+// By default the schema is loaded from the file next to the template, like `letter.schema.json` for
+// `letter.odt`, or from the custom document property `rea:schema`. Without a schema models aren't validated.
+func (p *PackagedDocument) SetSchema(s *schema.Schema) {
+	p.schema = s
+	p.schemaLoaded = true
+}
+
+// Schema returns the schema that the data of models is validated against, or nil if there is none.
+// The schema is loaded once, on the first call.
+func (p *PackagedDocument) Schema() (*schema.Schema, error) {
+	if p.schemaLoaded {
+		return p.schema, nil
+	}
+
+	s, err := p.loadSchema()
+	if err != nil {
+		return nil, err
+	}
+
+	p.schema = s
+	p.schemaLoaded = true
+
+	return s, nil
+}
+
+// loadSchema reads the schema from the file next to the template or from the document property.
+func (p *PackagedDocument) loadSchema() (*schema.Schema, error) {
+	if p.path != "" {
+		file := strings.TrimSuffix(p.path, filepath.Ext(p.path)) + ".schema.json"
+
+		data, err := ioutil.ReadFile(file)
+		if err == nil {
+			s, err := schema.Parse(data)
+			if err != nil {
+				return nil, fmt.Errorf("schema %s: %w", file, err)
+			}
+
+			return s, nil
+		} else if !errors.Is(err, fs.ErrNotExist) {
+			return nil, fmt.Errorf("reading schema %s: %w", file, err)
+		}
+	}
+
+	data, err := p.embeddedSchema()
+	if err != nil || data == "" {
+		return nil, err
+	}
+
+	s, err := schema.Parse([]byte(data))
+	if err != nil {
+		return nil, fmt.Errorf("schema of document property %s: %w", SchemaProperty, err)
+	}
+
+	return s, nil
+}
+
+// validateModel validates the data of the model against the schema of the template, if there is one.
+func (p *PackagedDocument) validateModel(model *Model) error {
+	s, err := p.Schema()
+	if err != nil || s == nil {
+		return err
+	}
+
+	return s.Validate(model.Data)
+}
+
+// InferSchema returns a starting schema with the model values that are referenced by the template.
+func (p *PackagedDocument) InferSchema() (*schema.Schema, error) {
+	progs, err := p.LuaPrograms()
+	if err != nil {
+		return nil, err
+	}
+
+	return schema.Infer(progs...), nil
+}
+
+// LuaPrograms returns the lua programs of the template, one for each part that is templated.
+// The programs are not executed.
+func (p *PackagedDocument) LuaPrograms() ([]string, error) {
+	_, trees, err := p.luaTrees()
+	if err != nil {
+		return nil, err
+	}
+
+	progs := make([]string, 0, len(trees))
+	for _, lt := range trees {
+		progs = append(progs, lt.LuaProg)
+	}
+
+	return progs, nil
+}
+
+// luaTrees returns the names of the templated parts and their lua trees.
+func (p *PackagedDocument) luaTrees() ([]string, []*engine.LuaTree, error) {
+	var parts []string
+	var trees []*xmltree.Node
+	var includes map[string]*xmltree.Node
+
+	switch tmpl := p.doc.(type) {
+	case *odf.Odf, *odf.Flat:
+		tree, err := getODFContent(p.doc)
+		if err != nil {
+			return nil, nil, err
+		}
+
+		inc, err := p.loadOdfIncludes(tree)
+		if err != nil {
+			return nil, nil, err
+		}
+
+		parts, trees, includes = []string{"content.xml"}, []*xmltree.Node{tree}, inc.fragments
+	case *ooxml.OOXML:
+		var err error
+
+		parts, trees, includes, err = p.ooxmlTrees(tmpl)
+		if err != nil {
+			return nil, nil, err
+		}
+	default:
+		return nil, nil, ErrUnknownType
+	}
+
+	luaTrees := make([]*engine.LuaTree, 0, len(trees))
+
+	for _, tree := range trees {
+		lt, err := engine.NewLuaTreeWithIncludes(tree, includes)
+		if err != nil {
+			return nil, nil, fmt.Errorf("creating lua tree from xml tree: %w", err)
+		}
+
+		luaTrees = append(luaTrees, lt)
+	}
+
+	return parts, luaTrees, nil
+}
+
+// ooxmlTrees returns the names and trees of the templated parts of the OOXML document and the fragments they include.
+func (p *PackagedDocument) ooxmlTrees(tmpl *ooxml.OOXML) ([]string, []*xmltree.Node, map[string]*xmltree.Node, error) {
+	var parts []string
+	var trees []*xmltree.Node
+
+	switch tmpl.MIMEType() {
+	case ooxml.SpreadsheetContentType:
+		wb, err := loadWorkbook(tmpl)
+		if err != nil {
+			return nil, nil, nil, err
+		}
+
+		sharedStrings, err := loadSharedStrings(tmpl, wb.sharedStrings)
+		if err != nil {
+			return nil, nil, nil, err
+		}
+
+		for _, sheet := range wb.sheets {
+			tree, err := getOOXMLPart(tmpl, sheet.part)
+			if err != nil {
+				return nil, nil, nil, err
+			}
+
+			if _, err := inlineSharedStrings(tree, sharedStrings); err != nil {
+				return nil, nil, nil, err
+			}
+
+			parts = append(parts, sheet.part)
+			trees = append(trees, tree)
+		}
+
+		return parts, trees, nil, nil
+	case ooxml.PresentationContentType:
+		pres, err := loadPresentation(tmpl)
+		if err != nil {
+			return nil, nil, nil, err
+		}
+
+		for _, slide := range pres.slides {
+			tree, err := getOOXMLPart(tmpl, slide.part)
+			if err != nil {
+				return nil, nil, nil, err
+			}
+
+			parts = append(parts, slide.part)
+			trees = append(trees, tree)
+		}
+
+		return parts, trees, nil, nil
+	}
+
+	tree, err := getOOXMLContent(tmpl)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+
+	includes, err := p.loadOoxmlIncludes(tmpl, tree)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+
+	return []string{tmpl.MainPart()}, []*xmltree.Node{tree}, includes.fragments, nil
+}
+
+// embeddedSchema returns the value of the custom document property with the schema, or an empty string.
+func (p *PackagedDocument) embeddedSchema() (string, error) {
+	switch tmpl := p.doc.(type) {
+	case *odf.Odf:
+		return odfSchemaProperty(tmpl, "meta.xml")
+	case *odf.Flat:
+		return odfSchemaProperty(tmpl, odf.FlatContent)
+	case *ooxml.OOXML:
+		rels, err := loadRelationships(tmpl, "")
+		if err != nil {
+			return "", err
+		}
+
+		for _, rel := range rels {
+			if !strings.HasSuffix(rel.Type, customRelType) {
+				continue
+			}
+
+			data, err := tmpl.ReadPart(rel.Target)
+			if err != nil {
+				return "", err
+			}
+
+			// <property name="rea:schema" ...><vt:lpwstr>...</vt:lpwstr></property>
+			return elementText(data, func(elem xml.StartElement) bool {
+				name, _ := utils.AttrValue(elem, "", "name")
+				return elem.Name.Local == "property" && name == SchemaProperty
+			})
+		}
+	}
+
+	return "", nil
+}
+
+// odfSchemaProperty returns the value of the `meta:user-defined` element with the schema in the file.
+func odfSchemaProperty(tmpl Format, name string) (string, error) {
+	fd, err := tmpl.Open(name)
+	if errors.Is(err, fs.ErrNotExist) {
+		return "", nil
+	} else if err != nil {
+		return "", fmt.Errorf("loading %s from template: %w", name, err)
+	}
+	defer fd.Close()
+
+	data, err := ioutil.ReadAll(fd)
+	if err != nil {
+		return "", fmt.Errorf("reading %s from template: %w", name, err)
+	}
+
+	return elementText(data, func(elem xml.StartElement) bool {
+		if elem.Name.Space != nsMeta || elem.Name.Local != "user-defined" {
+			return false
+		}
+
+		for _, attr := range elem.Attr {
+			if attr.Name.Space == nsMeta && attr.Name.Local == "name" {
+				return attr.Value == SchemaProperty
+			}
+		}
+
+		return false
+	})
+}
+
+// elementText returns the text of the first element that matches, including the text of its children.
+func elementText(data []byte, match func(xml.StartElement) bool) (string, error) {
+	d := xml.NewDecoder(bytes.NewReader(data))
+
+	var text strings.Builder
+
+	depth := 0
+
+	for {
+		tok, err := d.Token()
+		if errors.Is(err, io.EOF) {
+			return "", nil
+		} else if err != nil {
+			return "", fmt.Errorf("parsing document properties: %w", err)
+		}
+
+		switch v := tok.(type) {
+		case xml.StartElement:
+			if depth > 0 || match(v) {
+				depth++
+			}
+		case xml.EndElement:
+			if depth > 0 {
+				depth--
+				if depth == 0 {
+					return text.String(), nil
+				}
+			}
+		case xml.CharData:
+			if depth > 0 {
+				text.Write(v)
+			}
+		}
+	}
+}
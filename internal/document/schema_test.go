@@ -0,0 +1,187 @@
+package document
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/xml"
+	"io"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/microfast-ch/rea/internal/schema"
+	"github.com/stretchr/testify/require"
+)
+
+const itemsSchema = `{"type": "object", "properties": {"items": {"type": "array", "items": {"type": "string"}, "minItems": 3}}}`
+
+func TestSchemaFile(t *testing.T) {
+	dir := t.TempDir()
+
+	data, err := ioutil.ReadFile("../../testdata/Conditional1.odt")
+	require.Nil(t, err)
+	require.Nil(t, ioutil.WriteFile(filepath.Join(dir, "order.odt"), data, 0o600))
+
+	model := &Model{Data: map[string]any{"items": []any{"Apple", 2}, "show": true, "hidden": false}}
+
+	// Without schema the model isn't validated
+	tmpl, err := NewFromFile(filepath.Join(dir, "order.odt"))
+	require.Nil(t, err)
+
+	_, err = tmpl.Write(model, bytes.NewBuffer([]byte("")))
+	require.Nil(t, err)
+
+	// The schema next to the template reports all violations, it is loaded once with the template
+	require.Nil(t, ioutil.WriteFile(filepath.Join(dir, "order.schema.json"), []byte(itemsSchema), 0o600))
+
+	_, err = tmpl.Write(model, bytes.NewBuffer([]byte("")))
+	require.Nil(t, err)
+
+	tmpl, err = NewFromFile(filepath.Join(dir, "order.odt"))
+	require.Nil(t, err)
+
+	_, err = tmpl.Write(model, bytes.NewBuffer([]byte("")))
+	require.ErrorIs(t, err, schema.ErrValidation)
+	require.Contains(t, err.Error(), "items: must have at least 3 items; items[2]: expected string, got integer")
+
+	// A schema that is set explicitly takes precedence
+	s, err := schema.Parse([]byte(`{"type": "object"}`))
+	require.Nil(t, err)
+
+	tmpl.SetSchema(s)
+
+	_, err = tmpl.Write(model, bytes.NewBuffer([]byte("")))
+	require.Nil(t, err)
+
+	// Invalid schemas are reported
+	require.Nil(t, ioutil.WriteFile(filepath.Join(dir, "order.schema.json"), []byte(`{"type": "list"}`), 0o600))
+
+	tmpl, err = NewFromFile(filepath.Join(dir, "order.odt"))
+	require.Nil(t, err)
+
+	_, err = tmpl.Write(model, bytes.NewBuffer([]byte("")))
+	require.ErrorIs(t, err, schema.ErrSchema)
+}
+
+func TestSchemaProperty(t *testing.T) {
+	flat, err := ioutil.ReadFile("../../testdata/Conditional1.fodt")
+	require.Nil(t, err)
+
+	flat = bytes.Replace(flat, []byte("<office:meta>"),
+		[]byte(`<office:meta><meta:user-defined meta:name="rea:schema">`+strings.ReplaceAll(itemsSchema, `"`, "&quot;")+
+			`</meta:user-defined>`), 1)
+
+	docx := withCustomProperty(t, "../../testdata/Conditional1.docx", SchemaProperty, itemsSchema)
+
+	for name, data := range map[string][]byte{"Conditional1.fodt": flat, "Conditional1.docx": docx} {
+		tmpl, err := New(bytes.NewReader(data), int64(len(data)))
+		require.Nil(t, err, name)
+
+		s, err := tmpl.Schema()
+		require.Nil(t, err, name)
+		require.NotNil(t, s, name)
+
+		_, err = tmpl.Write(&Model{Data: map[string]any{"items": []any{"Apple"}}}, bytes.NewBuffer([]byte("")))
+		require.ErrorIs(t, err, schema.ErrValidation, name)
+		require.Contains(t, err.Error(), "items: must have at least 3 items", name)
+	}
+
+	// Documents without the property have no schema
+	tmpl, err := NewFromFile("../../testdata/Conditional1.docx")
+	require.Nil(t, err)
+
+	s, err := tmpl.Schema()
+	require.Nil(t, err)
+	require.Nil(t, s)
+}
+
+func TestInferSchema(t *testing.T) {
+	tests := []struct {
+		file  string
+		paths []string
+	}{
+		{"../../examples/letter.odt", []string{"firstname", "addr.street", "order.items[]", "epay"}},
+		{"../../testdata/Include1.odt", []string{"name", "items[]"}},
+		{"../../testdata/Include1.docx", []string{"name", "items[]"}},
+		{"../../testdata/Spreadsheet1.xlsx", []string{"items[].name", "items[].price", "items[].date"}},
+		{"../../testdata/Presentation1.pptx", []string{"products[].name", "products[].price"}},
+	}
+
+	for _, tc := range tests {
+		tmpl, err := NewFromFile(tc.file)
+		require.Nil(t, err, tc.file)
+
+		s, err := tmpl.InferSchema()
+		require.Nil(t, err, tc.file)
+
+		for _, path := range tc.paths {
+			require.True(t, hasSchemaPath(s, path), "%s: %s", tc.file, path)
+		}
+	}
+}
+
+// hasSchemaPath reports if the schema has the path, like `order.items[].name`.
+func hasSchemaPath(s *schema.Schema, path string) bool {
+	for _, name := range strings.Split(path, ".") {
+		items := strings.HasSuffix(name, "[]")
+
+		s = s.Properties[strings.TrimSuffix(name, "[]")]
+		if s == nil {
+			return false
+		}
+
+		if items {
+			if s.Items == nil {
+				return false
+			}
+
+			s = s.Items
+		}
+	}
+
+	return true
+}
+
+// withCustomProperty returns the OOXML package with its custom document properties replaced by the property.
+func withCustomProperty(t *testing.T, file, name, value string) []byte {
+	t.Helper()
+
+	rdr, err := zip.OpenReader(file)
+	require.Nil(t, err)
+	defer rdr.Close()
+
+	var escaped bytes.Buffer
+	require.Nil(t, xml.EscapeText(&escaped, []byte(value)))
+
+	var buf bytes.Buffer
+
+	w := zip.NewWriter(&buf)
+
+	for _, f := range rdr.File {
+		fd, err := f.Open()
+		require.Nil(t, err)
+
+		data, err := io.ReadAll(fd)
+		require.Nil(t, err)
+		fd.Close()
+
+		if f.Name == "docProps/custom.xml" {
+			data = []byte(`<?xml version="1.0" encoding="UTF-8" standalone="yes"?>` +
+				`<Properties xmlns="http://schemas.openxmlformats.org/officeDocument/2006/custom-properties" ` +
+				`xmlns:vt="http://schemas.openxmlformats.org/officeDocument/2006/docPropsVTypes">` +
+				`<property fmtid="{D5CDD505-2E9C-101B-9397-08002B2CF9AE}" pid="2" name="` + name + `">` +
+				`<vt:lpwstr>` + escaped.String() + `</vt:lpwstr></property></Properties>`)
+		}
+
+		fw, err := w.Create(f.Name)
+		require.Nil(t, err)
+
+		_, err = fw.Write(data)
+		require.Nil(t, err)
+	}
+
+	require.Nil(t, w.Close())
+
+	return buf.Bytes()
+}
@@ -1,6 +1,7 @@
 package document
 
 import (
+	"encoding/xml"
 	"errors"
 	"fmt"
 	"io"
@@ -9,6 +10,8 @@ import (
 	"github.com/djboris9/xmltree"
 	"github.com/microfast-ch/rea/internal/engine"
 	"github.com/microfast-ch/rea/internal/odf"
+	"github.com/microfast-ch/rea/internal/ooxml"
+	"github.com/microfast-ch/rea/internal/utils"
 )
 
 // TODO: Better error description and use it in Go style.
@@ -20,16 +23,83 @@ var ErrArchive = errors.New("archiveErr")
 // Model defines the data that is passed to the engine for templating.
 // Passed data must be a primitive or a map.
 type Model struct {
-	Data     map[string]any
-	Metadata map[string]string
+	Data     map[string]any    `json:"data"`
+	Metadata map[string]string `json:"metadata"`
+}
+
+// SetStripMacros sets if the VBA project of macro-enabled Word documents and templates is removed.
+// The result is a document without macros then, otherwise macro-enabled inputs stay macro-enabled.
+func (p *PackagedDocument) SetStripMacros(strip bool) {
+	p.stripMacros = strip
+}
+
+// SetThumbnail sets the PNG image that replaces the thumbnail of the template in the written document.
+// By default the thumbnail is removed, as it shows the template and not the generated document.
+func (p *PackagedDocument) SetThumbnail(image []byte) {
+	p.thumbnail = image
+}
+
+// SetDeterministic sets if written packages are byte-identical for identical input, e.g. for
+// content-addressed storage. The entries of the packages get a fixed modification time then.
+// Documents that are converted to another format depend on the converter.
+func (p *PackagedDocument) SetDeterministic(deterministic bool) {
+	p.deterministic = deterministic
+}
+
+// SetCharPolicy sets how printed characters are handled, that are not allowed in XML 1.0, like
+// control characters or invalid UTF-8. By default they are replaced with U+FFFD.
+func (p *PackagedDocument) SetCharPolicy(policy engine.CharPolicy) {
+	p.charPolicy = policy
+}
+
+// SetUndefinedMode sets how undefined variables and printed nil values are handled. In strict mode
+// they fail the templating, in lenient mode nil values are printed as empty strings. By default `nil` is printed.
+func (p *PackagedDocument) SetUndefinedMode(mode engine.UndefinedMode) {
+	p.undefinedMode = mode
+}
+
+// ODFOutput selects the representation in which ODF documents are written.
+type ODFOutput int
+
+const (
+	ODFOutputTemplate ODFOutput = iota // Same representation as the template
+	ODFOutputPackage                   // Zipped package, like `.odt`
+	ODFOutputFlat                      // Flat XML document, like `.fodt`
+)
+
+// SetODFOutput sets if ODF documents are written as package or as flat XML document,
+// regardless of the representation of the template. It has no effect on OOXML documents.
+func (p *PackagedDocument) SetODFOutput(output ODFOutput) {
+	p.odfOutput = output
 }
 
 // Write runs the packaged document through the templating engine using the given model and
-// writes a new packaged document on the writer.
+// writes a new packaged document on the writer. It is converted, if another output format is set.
+// The data of the model is validated against the schema of the template first, if there is one.
 func (p *PackagedDocument) Write(model *Model, out io.Writer) (*ProcessingData, error) {
+	err := p.validateModel(model)
+	if err != nil {
+		return nil, err
+	}
+
+	if p.outputFormat != "" && p.outputFormat != p.nativeFormat() {
+		return p.writeConverted(model, out)
+	}
+
+	return p.write(model, out)
+}
+
+// write runs the packaged document through the templating engine and writes it in its native format.
+func (p *PackagedDocument) write(model *Model, out io.Writer) (*ProcessingData, error) {
+	if d, ok := p.doc.(interface{ SetDeterministic(bool) }); ok {
+		d.SetDeterministic(p.deterministic)
+	}
+
 	switch p.doc.(type) {
-	case *odf.Odf:
+	case *odf.Odf, *odf.Flat:
 		return p.processOdf(model, out)
+	case *ooxml.OOXML:
+		return p.processOoxml(model, out)
 	default:
 		return nil, ErrUnknownType
 	}
@@ -48,20 +118,30 @@ type ProcessingData struct {
 	XMLResult    string
 }
 
+// engineConfig holds the format specific configuration of the engine.
+type engineConfig struct {
+	initScript string                                 // Lua script that is executed before the template
+	includes   map[string]*xmltree.Node               // Fragments that can be included by name
+	valueTyper engine.ValueTyper                      // Optional typer for printed numbers and dates
+	rewrite    func([]xml.Token) ([]xml.Token, error) // Optional rewrite of the resulting tokens
+	charPolicy engine.CharPolicy                      // Handling of printed characters that are not allowed in XML
+	undefined  engine.UndefinedMode                   // Handling of undefined variables and printed nil values
+}
+
 // runLuaEngine takes a XML tree and runs the engine on it. `templateData` is updated
 // with execution informations that can be used for post processing or error analysis.
-func runEngine(xmlTree *xmltree.Node, templateData *ProcessingData, model *Model, initScript string) error {
+func runEngine(xmlTree *xmltree.Node, templateData *ProcessingData, model *Model, cfg engineConfig) error {
 	// Convert xmlTree to luaTree
 	templateData.TemplateXMLTree = xmlTree
 
-	luaTree, err := engine.NewLuaTree(xmlTree)
+	luaTree, err := engine.NewLuaTreeWithIncludes(xmlTree, cfg.includes)
 	if err != nil {
 		return fmt.Errorf("creating lua tree from xml tree: %w", err)
 	}
 
 	// Register informations for further processing or debugging
 	templateData.TemplateLuaProg = luaTree.LuaProg
-	templateData.TemplateInitScript = initScript
+	templateData.TemplateInitScript = cfg.initScript
 	templateData.TemplateLuaNodeList = luaTree.NodeList
 
 	// Prepare data for passing to the engine
@@ -73,7 +153,14 @@ func runEngine(xmlTree *xmltree.Node, templateData *ProcessingData, model *Model
 	// Execute the engine
 	luaEngine := engine.NewLuaEngine(luaTree, engineData)
 
-	err = luaEngine.Exec(initScript)
+	if cfg.valueTyper != nil {
+		luaEngine.SetValueTyper(cfg.valueTyper)
+	}
+
+	luaEngine.SetCharPolicy(cfg.charPolicy)
+	luaEngine.SetUndefinedMode(cfg.undefined)
+
+	err = luaEngine.Exec(cfg.initScript)
 	if err != nil {
 		return fmt.Errorf("executing lua engine: %w", err)
 	}
@@ -84,7 +171,12 @@ func runEngine(xmlTree *xmltree.Node, templateData *ProcessingData, model *Model
 	// We serialize the resulting data and return it
 	var buf strings.Builder
 
-	err = luaEngine.WriteXML(&buf)
+	if cfg.rewrite != nil {
+		err = writeRewritten(&buf, luaEngine.GetNodePath(), cfg.rewrite)
+	} else {
+		err = luaEngine.WriteXML(&buf)
+	}
+
 	if err != nil {
 		return fmt.Errorf("writing executed template: %w", err)
 	}
@@ -94,3 +186,55 @@ func runEngine(xmlTree *xmltree.Node, templateData *ProcessingData, model *Model
 
 	return nil
 }
+
+// writeRewritten writes the tokens of the nodes after passing them to rewrite. The tokens are
+// copies, so the rewrite can change them without affecting the template.
+func writeRewritten(w io.Writer, nodes []*xmltree.Node, rewrite func([]xml.Token) ([]xml.Token, error)) error {
+	tokens := make([]xml.Token, len(nodes))
+	for i := range nodes {
+		tokens[i] = xml.CopyToken(nodes[i].Token)
+	}
+
+	tokens, err := rewrite(tokens)
+	if err != nil {
+		return err
+	}
+
+	return encodeTokens(w, tokens)
+}
+
+// encodeTokens writes the tokens as XML.
+func encodeTokens(w io.Writer, tokens []xml.Token) error {
+	enc := utils.NewXMLEncoder(w)
+	for i := range tokens {
+		if err := enc.EncodeToken(tokens[i]); err != nil {
+			return fmt.Errorf("encoding token %d: %w", i, err)
+		}
+	}
+
+	err := enc.Flush()
+	if err != nil {
+		return fmt.Errorf("flushing encoder: %w", err)
+	}
+
+	return nil
+}
+
+// encodeTree writes the tree as XML.
+func encodeTree(w io.Writer, tree *xmltree.Node) error {
+	enc := utils.NewXMLEncoder(w)
+
+	err := xmltree.Walk(tree, func(node *xmltree.Node, depth uint) error {
+		return enc.EncodeToken(node.Token)
+	})
+	if err != nil {
+		return fmt.Errorf("encoding tree: %w", err)
+	}
+
+	err = enc.Flush()
+	if err != nil {
+		return fmt.Errorf("flushing encoder: %w", err)
+	}
+
+	return nil
+}
@@ -0,0 +1,91 @@
+package document
+
+import (
+	"archive/zip"
+	"bytes"
+	"testing"
+
+	"github.com/microfast-ch/rea/internal/engine"
+	"github.com/microfast-ch/rea/internal/utils"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDeterministic(t *testing.T) {
+	model := &Model{
+		Data:     map[string]any{"items": []any{"Apple", "Banana"}},
+		Metadata: map[string]string{"title": "Fruits"},
+	}
+
+	for _, file := range []string{"Conditional1.odt", "Conditional1.docx", "Conditional1.fodt", "Include1.odt"} {
+		tmpl, err := NewFromFile("../../testdata/" + file)
+		require.Nil(t, err)
+
+		tmpl.SetDeterministic(true)
+		tmpl.SetODFOutput(ODFOutputPackage)
+
+		first := bytes.NewBuffer([]byte(""))
+		_, err = tmpl.Write(model, first)
+		require.Nil(t, err, file)
+
+		second := bytes.NewBuffer([]byte(""))
+		_, err = tmpl.Write(model, second)
+		require.Nil(t, err, file)
+
+		require.Equal(t, first.Bytes(), second.Bytes(), file)
+
+		rdr, err := zip.NewReader(bytes.NewReader(first.Bytes()), int64(first.Len()))
+		require.Nil(t, err)
+
+		for _, f := range rdr.File {
+			require.True(t, f.Modified.Equal(utils.ZipModTime), f.Name)
+		}
+	}
+}
+
+func TestCharPolicy(t *testing.T) {
+	model := &Model{
+		Data:     map[string]any{"items": []any{"Apple", "Ban\x0bana"}},
+		Metadata: map[string]string{"title": "Fruits"},
+	}
+
+	for _, file := range []string{"Conditional1.odt", "Conditional1.docx"} {
+		tmpl, err := NewFromFile("../../testdata/" + file)
+		require.Nil(t, err)
+
+		// By default the document is written with the character replaced
+		_, err = tmpl.Write(model, bytes.NewBuffer([]byte("")))
+		require.Nil(t, err, file)
+
+		tmpl.SetCharPolicy(engine.CharPolicyError)
+
+		_, err = tmpl.Write(model, bytes.NewBuffer([]byte("")))
+		require.ErrorIs(t, err, engine.ErrInvalidChar, file)
+		require.Contains(t, err.Error(), "model value items[2]", file)
+	}
+}
+
+func TestUndefinedMode(t *testing.T) {
+	model := &Model{
+		Data:     map[string]any{"items": []any{"Apple", "Banana"}, "show": true, "hidden": false},
+		Metadata: map[string]string{"title": "Fruits"},
+	}
+
+	for _, file := range []string{"Conditional1.odt", "Conditional1.docx"} {
+		tmpl, err := NewFromFile("../../testdata/" + file)
+		require.Nil(t, err)
+
+		tmpl.SetUndefinedMode(engine.UndefinedStrict)
+
+		_, err = tmpl.Write(model, bytes.NewBuffer([]byte("")))
+		require.Nil(t, err, file)
+
+		_, err = tmpl.Write(&Model{Data: map[string]any{"show": true}}, bytes.NewBuffer([]byte("")))
+		require.ErrorIs(t, err, engine.ErrUndefined, file)
+		require.Contains(t, err.Error(), `undefined variable "items"`, file)
+
+		tmpl.SetUndefinedMode(engine.UndefinedLenient)
+
+		_, err = tmpl.Write(&Model{Data: model.Data}, bytes.NewBuffer([]byte("")))
+		require.Nil(t, err, file)
+	}
+}
@@ -0,0 +1,93 @@
+package document
+
+import (
+	"sort"
+
+	"github.com/microfast-ch/rea/internal/engine"
+)
+
+// Variables lists the model values that are read by a template and the loop variables that are bound to them.
+type Variables struct {
+	Variables []Variable        `json:"variables"`
+	Bindings  []VariableBinding `json:"bindings"`
+}
+
+// Variable is a model value that is read by a template, like `order.items[].title`.
+type Variable struct {
+	Path string        `json:"path"`
+	Uses []VariableUse `json:"uses"`
+}
+
+// VariableUse is the location in the template where a model value is read.
+type VariableUse struct {
+	Part    string `json:"part"`              // Part of the document, like `content.xml`
+	Element string `json:"element"`           // Local names of the enclosing elements, like `document-content/body/text/p`
+	Line    int    `json:"line"`              // Line of the lua program, as reported by template errors
+	Code    string `json:"code"`              // Code of the line
+	Binding string `json:"binding,omitempty"` // Loop variable through which the value is read, if any
+}
+
+// VariableBinding is a loop variable that is bound to the items of a model value, like `item` in
+// `for item in each(order.items)`.
+type VariableBinding struct {
+	Name    string `json:"name"`
+	Path    string `json:"path"`
+	Part    string `json:"part"`
+	Element string `json:"element"`
+	Line    int    `json:"line"`
+	Code    string `json:"code"`
+}
+
+// Variables returns the model values that are read by the template, sorted by their path. Values of the
+// model that are read through loop variables are reported with the path of the iterated value, like
+// `order.items[].title`. The template is analysed statically and not executed.
+func (p *PackagedDocument) Variables() (*Variables, error) {
+	parts, trees, err := p.luaTrees()
+	if err != nil {
+		return nil, err
+	}
+
+	vars := &Variables{Variables: []Variable{}, Bindings: []VariableBinding{}}
+	byPath := map[string]int{} // Index of the variables by path
+
+	for i, lt := range trees {
+		elements := lt.ElementPaths()
+		uses, bindings := engine.ReadVariables(lt.LuaProg)
+
+		for _, use := range uses {
+			path := engine.FormatVarPath(use.Path)
+
+			idx, ok := byPath[path]
+			if !ok {
+				idx = len(vars.Variables)
+				byPath[path] = idx
+				vars.Variables = append(vars.Variables, Variable{Path: path})
+			}
+
+			vars.Variables[idx].Uses = append(vars.Variables[idx].Uses, VariableUse{
+				Part:    parts[i],
+				Element: elements[use.Line-1],
+				Line:    use.Line,
+				Code:    lt.CodeLine(use.Line),
+				Binding: use.Binding,
+			})
+		}
+
+		for _, binding := range bindings {
+			vars.Bindings = append(vars.Bindings, VariableBinding{
+				Name:    binding.Name,
+				Path:    engine.FormatVarPath(binding.Path),
+				Part:    parts[i],
+				Element: elements[binding.Line-1],
+				Line:    binding.Line,
+				Code:    lt.CodeLine(binding.Line),
+			})
+		}
+	}
+
+	sort.SliceStable(vars.Variables, func(i, j int) bool {
+		return vars.Variables[i].Path < vars.Variables[j].Path
+	})
+
+	return vars, nil
+}
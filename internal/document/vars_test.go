@@ -0,0 +1,50 @@
+package document
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestVariables(t *testing.T) {
+	tmpl, err := NewFromFile("../../examples/letter.odt")
+	require.Nil(t, err)
+
+	vars, err := tmpl.Variables()
+	require.Nil(t, err)
+
+	paths := make([]string, 0, len(vars.Variables))
+	for _, v := range vars.Variables {
+		paths = append(paths, v.Path)
+	}
+
+	require.Equal(t, []string{
+		"addr.country", "addr.postal", "addr.street", "epay", "firstname", "lastname",
+		"order.amount", "order.items", "order.items[]", "order.title",
+	}, paths)
+
+	firstname := vars.Variables[4]
+	require.Len(t, firstname.Uses, 2)
+	require.Equal(t, VariableUse{
+		Part:    "content.xml",
+		Element: "document-content/body/text/frame/text-box/p",
+		Line:    181,
+		Code:    "Print( firstname )",
+	}, firstname.Uses[0])
+
+	require.Len(t, vars.Bindings, 1)
+	require.Equal(t, "v", vars.Bindings[0].Name)
+	require.Equal(t, "order.items[]", vars.Bindings[0].Path)
+	require.Equal(t, "for i, v in ipairs(order.items) do", vars.Bindings[0].Code)
+	require.Equal(t, "v", vars.Variables[8].Uses[0].Binding)
+
+	// Spreadsheets report the templated sheets
+	tmpl, err = NewFromFile("../../testdata/Spreadsheet1.xlsx")
+	require.Nil(t, err)
+
+	vars, err = tmpl.Variables()
+	require.Nil(t, err)
+	require.Equal(t, "items[].name", vars.Variables[2].Path)
+	require.Equal(t, "xl/worksheets/sheet1.xml", vars.Variables[2].Uses[0].Part)
+	require.Equal(t, "item", vars.Variables[2].Uses[0].Binding)
+}
@@ -0,0 +1,836 @@
+package document
+
+import (
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/djboris9/xmltree"
+	"github.com/microfast-ch/rea/internal/engine"
+	"github.com/microfast-ch/rea/internal/ooxml"
+	"github.com/microfast-ch/rea/internal/utils"
+)
+
+var ErrWorkbook = errors.New("workbookErr")
+
+// xlsxSheet is a worksheet of a workbook.
+type xlsxSheet struct {
+	name string // Name of the sheet as shown in the workbook
+	part string // Part inside the package, e.g. `xl/worksheets/sheet1.xml`
+}
+
+// xlsxWorkbook holds the sheets and related parts of a workbook.
+type xlsxWorkbook struct {
+	part          string // Part of the workbook, e.g. `xl/workbook.xml`
+	relsPart      string // Part of the workbook relationships
+	sheets        []xlsxSheet
+	sharedStrings string // Part of the shared strings, empty if there are none
+	calcChain     string // Part of the calculation chain, empty if there is none
+	date1904      bool   // Dates are counted from 1904-01-01 instead of 1899-12-30
+}
+
+// processXlsx processes the sheets of an OOXML spreadsheet. Every sheet that contains
+// code blocks is run through the engine, the result data is the one of the first such sheet.
+func (p *PackagedDocument) processXlsx(tmpl *ooxml.OOXML, model *Model, out io.Writer) (*ProcessingData, error) {
+	templateData := &ProcessingData{
+		TemplateMimeType: tmpl.MIMEType(),
+	}
+
+	wb, err := loadWorkbook(tmpl)
+	if err != nil {
+		return templateData, err
+	}
+
+	sharedStrings, err := loadSharedStrings(tmpl, wb.sharedStrings)
+	if err != nil {
+		return templateData, err
+	}
+
+	ov := ooxml.Overrides{}
+	rows := map[string]rowMap{} // Row maps of the rendered sheets by sheet name
+
+	for _, sheet := range wb.sheets {
+		sheetData := templateData
+		if len(ov) > 0 {
+			sheetData = &ProcessingData{TemplateMimeType: tmpl.MIMEType()}
+		}
+
+		rendered, err := p.renderSheet(tmpl, sheet, rows, sharedStrings, wb.date1904, sheetData, model)
+		if err != nil {
+			return templateData, fmt.Errorf("sheet %q: %w", sheet.name, err)
+		}
+
+		if rendered == nil {
+			continue
+		}
+
+		rows[sheet.name] = rendered
+		ov[sheet.part] = ooxml.Override{Data: []byte(sheetData.XMLResult)}
+	}
+
+	if len(ov) > 0 {
+		err = updateWorkbook(tmpl, wb, rows, ov)
+		if err != nil {
+			return templateData, err
+		}
+	}
+
+	err = setOOXMLMetadata(tmpl, model.Metadata, ov)
+	if err != nil {
+		return templateData, err
+	}
+
+	// The thumbnail shows the template, so it is replaced or removed
+	err = tmpl.SetThumbnail(ov, p.thumbnail)
+	if err != nil {
+		return templateData, fmt.Errorf("setting thumbnail: %w", err)
+	}
+
+	err = tmpl.Write(out, ov)
+	if err != nil {
+		return templateData, fmt.Errorf("writing rendered template: %w", err)
+	}
+
+	return templateData, nil
+}
+
+// loadWorkbook reads the sheets and the related parts of the workbook from the relationships.
+func loadWorkbook(tmpl *ooxml.OOXML) (*xlsxWorkbook, error) {
+	wb := &xlsxWorkbook{
+		part: tmpl.MainPart(),
+	}
+	wb.relsPart = ooxml.RelsPart(wb.part)
+
+	tree, err := getOOXMLPart(tmpl, wb.part)
+	if err != nil {
+		return nil, err
+	}
+
+	rels, err := loadRelationships(tmpl, wb.part)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, rel := range rels {
+		switch {
+		case strings.HasSuffix(rel.Type, "/sharedStrings"):
+			wb.sharedStrings = rel.Target
+		case strings.HasSuffix(rel.Type, "/calcChain"):
+			wb.calcChain = rel.Target
+		}
+	}
+
+	root := utils.FindChild(tree, nsSheet, "workbook")
+	if root == nil {
+		return nil, utils.FormatError(ErrWorkbook, fmt.Sprintf("%s has no workbook element", wb.part))
+	}
+
+	if pr := utils.FindChild(root, nsSheet, "workbookPr"); pr != nil {
+		v, _ := getAttr(pr, "", "date1904")
+		wb.date1904 = v == "1" || v == "true"
+	}
+
+	for _, s := range utils.ElementChildren(utils.FindChild(root, nsSheet, "sheets")) {
+		name, _ := getAttr(s, "", "name")
+		id, _ := getAttr(s, nsRels, "id")
+
+		rel, ok := rels[id]
+		if !ok {
+			return nil, utils.FormatError(ErrWorkbook, fmt.Sprintf("sheet %q has no relationship %q", name, id))
+		}
+
+		wb.sheets = append(wb.sheets, xlsxSheet{name: name, part: rel.Target})
+	}
+
+	return wb, nil
+}
+
+// loadSharedStrings returns the string items of the shared strings part, which are referenced by index.
+func loadSharedStrings(tmpl *ooxml.OOXML, part string) ([]*xmltree.Node, error) {
+	if part == "" {
+		return nil, nil
+	}
+
+	tree, err := getOOXMLPart(tmpl, part)
+	if err != nil {
+		return nil, err
+	}
+
+	return utils.ElementChildren(utils.FindChild(tree, nsSheet, "sst")), nil
+}
+
+// renderSheet runs the sheet through the engine if it contains code blocks and returns the
+// map of the template rows to the rendered rows. It returns nil if the sheet has no code blocks.
+// References to other sheets are updated with the row maps of the sheets rendered before.
+func (p *PackagedDocument) renderSheet(tmpl *ooxml.OOXML, sheet xlsxSheet, rows map[string]rowMap, sharedStrings []*xmltree.Node, date1904 bool,
+	templateData *ProcessingData, model *Model,
+) (rowMap, error) {
+	xmlTree, err := getOOXMLPart(tmpl, sheet.part)
+	if err != nil {
+		return nil, err
+	}
+
+	// Code blocks are usually stored as shared strings, so they are moved into the cells
+	inlined, err := inlineSharedStrings(xmlTree, sharedStrings)
+	if err != nil {
+		return nil, err
+	}
+
+	if inlined == 0 && !hasBlockTokens(utils.NodeText(xmlTree)) {
+		return nil, nil
+	}
+
+	rw := &sheetRewriter{
+		templateRows: map[int]bool{},
+		date1904:     date1904,
+		sheet:        sheet.name,
+		workbook:     rows,
+		rows:         rowMap{},
+	}
+
+	_ = xmltree.Walk(xmlTree, func(node *xmltree.Node, depth uint) error {
+		if elem, ok := node.Token.(xml.StartElement); ok && elem.Name.Space == nsSheet && elem.Name.Local == "row" {
+			if n, ok := rowNumber(elem); ok {
+				rw.templateRows[n] = true
+			}
+		}
+
+		return nil
+	})
+
+	err = runEngine(xmlTree, templateData, model, engineConfig{
+		initScript: tmpl.InitScript(),
+		valueTyper: xlsxValueTyper{},
+		rewrite:    rw.rewrite,
+		charPolicy: p.charPolicy,
+		undefined:  p.undefinedMode,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return rw.rows, nil
+}
+
+// inlineSharedStrings replaces the references to shared strings that contain code blocks
+// with inline strings. It returns the number of replaced references.
+func inlineSharedStrings(tree *xmltree.Node, sharedStrings []*xmltree.Node) (int, error) {
+	cells := []*xmltree.Node{}
+
+	_ = xmltree.Walk(tree, func(node *xmltree.Node, depth uint) error {
+		if elem, ok := node.Token.(xml.StartElement); ok && elem.Name.Space == nsSheet && elem.Name.Local == "c" {
+			if t, _ := getAttr(node, "", "t"); t == "s" {
+				cells = append(cells, node)
+			}
+		}
+
+		return nil
+	})
+
+	inlined := 0
+
+	for _, c := range cells {
+		v := utils.FindChild(c, nsSheet, "v")
+		if v == nil {
+			continue
+		}
+
+		idx, err := strconv.Atoi(strings.TrimSpace(utils.NodeText(v)))
+		if err != nil || idx < 0 || idx >= len(sharedStrings) {
+			return inlined, utils.FormatError(ErrWorkbook, fmt.Sprintf("invalid shared string reference %q", utils.NodeText(v)))
+		}
+
+		si := sharedStrings[idx]
+		if !hasBlockTokens(utils.NodeText(si)) {
+			continue
+		}
+
+		// The inline string has the same content as the shared string item
+		is := &xmltree.Node{
+			Token:  xml.StartElement{Name: xml.Name{Space: nsSheet, Local: "is"}},
+			Parent: c,
+		}
+
+		for _, n := range bodyFragment(si, func(xml.StartElement) bool { return false }).Nodes {
+			is.Nodes = append(is.Nodes, engine.CopyNode(n, is))
+		}
+
+		is.Nodes = append(is.Nodes, &xmltree.Node{
+			Token:  xml.EndElement{Name: xml.Name{Space: nsSheet, Local: "is"}},
+			Parent: is,
+		})
+
+		for i := range c.Nodes {
+			if c.Nodes[i] == v {
+				c.Nodes[i] = is
+			}
+		}
+
+		elem := c.Token.(xml.StartElement)
+		elem.Attr = withAttr(elem.Attr, "t", "inlineStr")
+		c.Token = elem
+		inlined++
+	}
+
+	return inlined, nil
+}
+
+// updateWorkbook updates the defined names of the workbook to the rendered rows and
+// removes the calculation chain, as the cells of the formulas have moved.
+func updateWorkbook(tmpl *ooxml.OOXML, wb *xlsxWorkbook, rows map[string]rowMap, ov ooxml.Overrides) error {
+	// The parts are changed textually, so everything else stays as written by the office suite
+	workbook, err := tmpl.ReadPart(wb.part)
+	if err != nil {
+		return err
+	}
+
+	content := definedName.ReplaceAllStringFunc(string(workbook), func(s string) string {
+		m := definedName.FindStringSubmatch(s)
+		formula := rewriteRefs(xmlTextUnescaper.Replace(m[2]), rows, "", 0, 0)
+
+		return m[1] + xmlTextEscaper.Replace(formula) + m[3]
+	})
+
+	// Formulas are recalculated on load, as their cached values are outdated
+	if loc := calcPr.FindStringSubmatchIndex(content); loc != nil && !strings.Contains(content[loc[0]:loc[1]], "fullCalcOnLoad") {
+		content = content[:loc[3]] + ` fullCalcOnLoad="1"` + content[loc[3]:]
+	}
+
+	ov[wb.part] = ooxml.Override{Data: []byte(content)}
+
+	if wb.calcChain == "" {
+		return nil
+	}
+
+	ov[wb.calcChain] = ooxml.Override{Delete: true}
+
+	rels, err := tmpl.ReadPart(wb.relsPart)
+	if err != nil {
+		return err
+	}
+
+	ov[wb.relsPart] = ooxml.Override{Data: calcChainRel.ReplaceAll(rels, nil)}
+
+	contentTypes, err := tmpl.ReadPart("[Content_Types].xml")
+	if err != nil {
+		return err
+	}
+
+	override := regexp.MustCompile(`<(?:\w+:)?Override\b[^>]*PartName="` + regexp.QuoteMeta("/"+wb.calcChain) + `"[^>]*/>`)
+	ov["[Content_Types].xml"] = ooxml.Override{Data: override.ReplaceAll(contentTypes, nil)}
+
+	return nil
+}
+
+var (
+	definedName  = regexp.MustCompile(`(<(?:\w+:)?definedName\b[^>]*>)([^<]*)(</(?:\w+:)?definedName>)`)
+	calcPr       = regexp.MustCompile(`<((?:\w+:)?calcPr)\b[^>]*>`)
+	calcChainRel = regexp.MustCompile(`<(?:\w+:)?Relationship\b[^>]*Type="[^"]*/calcChain"[^>]*/>`)
+
+	xmlTextUnescaper = strings.NewReplacer("&lt;", "<", "&gt;", ">", "&quot;", `"`, "&apos;", "'", "&amp;", "&")
+	xmlTextEscaper   = strings.NewReplacer("&", "&amp;", "<", "&lt;", ">", "&gt;")
+)
+
+// xlsxValueTyper turns cells that contain a printed number or date into numeric cells.
+// Dates are marked with the type `d` until the sheetRewriter converts them to serial numbers.
+type xlsxValueTyper struct{}
+
+func (xlsxValueTyper) TypeValue(elem xml.StartElement, v engine.TypedValue) (xml.StartElement, bool) {
+	if elem.Name.Space != nsSheet || elem.Name.Local != "c" {
+		return elem, false
+	}
+
+	t := "n"
+	if v.Type == engine.ValueTypeDate {
+		t = "d"
+	}
+
+	elem.Attr = withAttr(elem.Attr, "t", t)
+
+	return elem, true
+}
+
+// sheetRewriter renumbers the rows and cells of a rendered sheet, so repeated rows get
+// their own numbers, and updates the references of formulas, merged cells and the dimension.
+type sheetRewriter struct {
+	templateRows map[int]bool // Numbers of the rows in the template
+	date1904     bool
+	sheet        string            // Name of the sheet
+	workbook     map[string]rowMap // Row maps of the other rendered sheets by sheet name
+	rows         rowMap
+	rendered     []rowPos // Rendered rows in document order
+}
+
+// rowPos is a rendered row with the number of its template row, which is 0 if it had none.
+type rowPos struct {
+	orig, row int
+}
+
+func (r *sheetRewriter) rewrite(tokens []xml.Token) ([]xml.Token, error) {
+	tokens, err := r.renumber(tokens)
+	if err != nil {
+		return nil, err
+	}
+
+	return r.updateRefs(tokens), nil
+}
+
+// renumber sets the numbers of rows and cells and converts typed cells to numeric cells.
+// Rows that are repeated push the following rows down, rows that were removed pull them up.
+func (r *sheetRewriter) renumber(tokens []xml.Token) ([]xml.Token, error) {
+	rendered := map[int]bool{}
+
+	for _, tok := range tokens {
+		if elem, ok := tok.(xml.StartElement); ok && isSheetElement(elem, "row") {
+			if n, ok := rowNumber(elem); ok {
+				rendered[n] = true
+			}
+		}
+	}
+
+	removed := []int{}
+
+	for n := range r.templateRows {
+		if !rendered[n] {
+			removed = append(removed, n)
+		}
+	}
+
+	sort.Ints(removed)
+
+	out := make([]xml.Token, 0, len(tokens))
+	shift, last := 0, 0
+	cell := -1 // Index of the current cell in out
+
+	for i := 0; i < len(tokens); i++ {
+		elem, ok := tokens[i].(xml.StartElement)
+		if !ok {
+			if end, ok := tokens[i].(xml.EndElement); ok && end.Name.Space == nsSheet && end.Name.Local == "c" {
+				cell = -1
+			}
+
+			out = append(out, tokens[i])
+
+			continue
+		}
+
+		switch {
+		case isSheetElement(elem, "row"):
+			orig, ok := rowNumber(elem)
+			n := last + 1
+
+			if ok {
+				n = orig + shift - sort.SearchInts(removed, orig)
+				if n <= last {
+					shift += last + 1 - n
+					n = last + 1
+				}
+
+				r.rows[orig] = append(r.rows[orig], n)
+			}
+
+			last = n
+			r.rendered = append(r.rendered, rowPos{orig: orig, row: n})
+			elem.Attr = withAttr(elem.Attr, "r", strconv.Itoa(n))
+		case isSheetElement(elem, "c"):
+			if ref, ok := utils.AttrValue(elem, "", "r"); ok {
+				elem.Attr = withAttr(elem.Attr, "r", strings.TrimRight(ref, "0123456789")+strconv.Itoa(last))
+			}
+
+			cell = len(out)
+		case isSheetElement(elem, "is") && cell >= 0:
+			c := out[cell].(xml.StartElement)
+			if t, _ := utils.AttrValue(c, "", "t"); t != "n" && t != "d" {
+				break
+			}
+
+			// The inline string of a typed cell is replaced by its value
+			end := matchingEnd(tokens, i)
+
+			v, err := r.cellValue(c, charData(tokens[i:end]))
+			if err != nil {
+				return nil, err
+			}
+
+			c.Attr = withAttr(c.Attr, "t", "n")
+			out[cell] = c
+			vName := xml.Name{Space: nsSheet, Local: "v"}
+			out = append(out, xml.StartElement{Name: vName}, xml.CharData(v), xml.EndElement{Name: vName})
+			i = end
+
+			continue
+		}
+
+		out = append(out, elem)
+	}
+
+	return out, nil
+}
+
+// cellValue returns the value of a typed cell, which is the number or the serial number of the date.
+func (r *sheetRewriter) cellValue(c xml.StartElement, text string) (string, error) {
+	text = strings.TrimSpace(text)
+	if t, _ := utils.AttrValue(c, "", "t"); t == "n" {
+		return text, nil
+	}
+
+	var date time.Time
+
+	var err error
+
+	for _, layout := range []string{"2006-01-02 15:04:05", "2006-01-02"} {
+		if date, err = time.Parse(layout, text); err == nil {
+			break
+		}
+	}
+
+	if err != nil {
+		return "", utils.FormatError(ErrWorkbook, fmt.Sprintf("invalid date %q: %s", text, err))
+	}
+
+	epoch := time.Date(1899, 12, 30, 0, 0, 0, 0, time.UTC)
+	if r.date1904 {
+		epoch = time.Date(1904, 1, 1, 0, 0, 0, 0, time.UTC)
+	}
+
+	days := float64(date.Sub(epoch)/time.Second) / (24 * 60 * 60)
+
+	return strconv.FormatFloat(days, 'f', -1, 64), nil
+}
+
+// updateRefs updates the references of formulas, merged cells and the dimension to the rendered rows.
+// Merged cells in a single row are merged in each repetition of the row.
+func (r *sheetRewriter) updateRefs(tokens []xml.Token) []xml.Token {
+	rows := map[string]rowMap{}
+	for name, m := range r.workbook {
+		rows[name] = m
+	}
+
+	rows[r.sheet] = r.rows
+
+	out := make([]xml.Token, 0, len(tokens))
+	pos := rowPos{}
+	rowIdx := 0
+	inFormula := false
+	mergeCells, merges := -1, 0
+
+	for i := 0; i < len(tokens); i++ {
+		switch tok := tokens[i].(type) {
+		case xml.StartElement:
+			switch {
+			case isSheetElement(tok, "row"):
+				pos = r.rendered[rowIdx]
+				rowIdx++
+			case isSheetElement(tok, "dimension"):
+				if ref, ok := utils.AttrValue(tok, "", "ref"); ok {
+					tok.Attr = withAttr(tok.Attr, "ref", rewriteRefs(ref, rows, r.sheet, 0, 0))
+				}
+			case isSheetElement(tok, "f"):
+				inFormula = true
+
+				if ref, ok := utils.AttrValue(tok, "", "ref"); ok {
+					tok.Attr = withAttr(tok.Attr, "ref", rewriteRefs(ref, rows, r.sheet, pos.orig, pos.row))
+				}
+			case isSheetElement(tok, "mergeCells"):
+				mergeCells = len(out)
+			case isSheetElement(tok, "mergeCell"):
+				ref, _ := utils.AttrValue(tok, "", "ref")
+				end := matchingEnd(tokens, i)
+
+				for _, m := range r.mergeRefs(ref) {
+					elem := xml.CopyToken(tok).(xml.StartElement)
+					elem.Attr = withAttr(elem.Attr, "ref", m)
+					out = append(out, elem)
+					out = append(out, tokens[i+1:end+1]...)
+					merges++
+				}
+
+				i = end
+
+				continue
+			}
+
+			out = append(out, tok)
+		case xml.EndElement:
+			if tok.Name.Space == nsSheet && tok.Name.Local == "f" {
+				inFormula = false
+			}
+
+			out = append(out, tok)
+		case xml.CharData:
+			if inFormula {
+				tok = xml.CharData(rewriteRefs(string(tok), rows, r.sheet, pos.orig, pos.row))
+			}
+
+			out = append(out, tok)
+		default:
+			out = append(out, tok)
+		}
+	}
+
+	if mergeCells >= 0 {
+		elem := out[mergeCells].(xml.StartElement)
+		if _, ok := utils.AttrValue(elem, "", "count"); ok {
+			elem.Attr = withAttr(elem.Attr, "count", strconv.Itoa(merges))
+			out[mergeCells] = elem
+		}
+	}
+
+	return out
+}
+
+// mergeRefs returns the references of the merged cells for the template reference.
+func (r *sheetRewriter) mergeRefs(ref string) []string {
+	m := cellRef.FindStringSubmatch(ref)
+	if m == nil || m[5] == "" || m[3] != m[5] {
+		return []string{rewriteRefs(ref, map[string]rowMap{r.sheet: r.rows}, r.sheet, 0, 0)}
+	}
+
+	// Merges in a single row follow the row: they are repeated or removed with it
+	row, _ := strconv.Atoi(m[3])
+	if !r.templateRows[row] {
+		return []string{rewriteRefs(ref, map[string]rowMap{r.sheet: r.rows}, r.sheet, 0, 0)}
+	}
+
+	refs := []string{}
+
+	for _, n := range r.rows[row] {
+		refs = append(refs, fmt.Sprintf("%s%d:%s%d", m[2], n, m[4], n))
+	}
+
+	return refs
+}
+
+// rowMap maps the row numbers of a template sheet to the numbers of the rendered rows.
+// Repeated rows have several numbers, rows that were not rendered have none.
+type rowMap map[int][]int
+
+// row returns the rendered number of the template row. Repeated rows return their first
+// number, other rows keep their distance to the previous rendered row.
+func (m rowMap) row(orig int) int {
+	if rows := m[orig]; len(rows) > 0 {
+		return rows[0]
+	}
+
+	prev := 0
+
+	for o, rows := range m {
+		if o < orig && o > prev && len(rows) > 0 {
+			prev = o
+		}
+	}
+
+	if prev == 0 {
+		return orig
+	}
+
+	return m[prev][len(m[prev])-1] + orig - prev
+}
+
+// span returns the rendered rows that the template rows from first to last span.
+// Ranges that reach beyond the rendered rows keep their distance at these ends.
+func (m rowMap) span(first, last int) (int, int) {
+	start, end := 0, 0
+	minOrig, maxOrig := 0, 0
+
+	for o, rows := range m {
+		if len(rows) == 0 {
+			continue
+		}
+
+		if minOrig == 0 || o < minOrig {
+			minOrig = o
+		}
+
+		if o > maxOrig {
+			maxOrig = o
+		}
+
+		if o < first || o > last {
+			continue
+		}
+
+		if start == 0 || rows[0] < start {
+			start = rows[0]
+		}
+
+		if n := rows[len(rows)-1]; n > end {
+			end = n
+		}
+	}
+
+	if start == 0 {
+		return m.row(first), m.row(last)
+	}
+
+	if first < minOrig {
+		start = m.row(first)
+	}
+
+	if last > maxOrig {
+		end = m.row(last)
+	}
+
+	return start, end
+}
+
+// cellRef matches A1 references with an optional sheet name and range end, like `'Sheet 1'!$A$1:$B$2`.
+var cellRef = regexp.MustCompile(`((?:'(?:[^']|'')+'|[A-Za-z_][\w.]*)!)?(\$?[A-Z]{1,3}\$?)(\d+)(?::(\$?[A-Z]{1,3}\$?)(\d+))?`)
+
+// rewriteRefs updates the A1 references in the formula to the rendered rows. rows holds the row
+// maps by sheet name and sheet is the name of the sheet of the formula, which references without
+// sheet name refer to. References to sheets without row map are kept. A reference to the row of
+// the formula itself, given by origRow, is updated to newRow, so formulas in repeated rows refer
+// to their own row.
+func rewriteRefs(formula string, rows map[string]rowMap, sheet string, origRow, newRow int) string {
+	// Text in string literals is not a reference
+	parts := strings.Split(formula, `"`)
+
+	for i := 0; i < len(parts); i += 2 {
+		parts[i] = rewriteUnquotedRefs(parts[i], rows, sheet, origRow, newRow)
+	}
+
+	return strings.Join(parts, `"`)
+}
+
+func rewriteUnquotedRefs(s string, rows map[string]rowMap, sheet string, origRow, newRow int) string {
+	var b strings.Builder
+
+	prev := 0
+
+	for _, m := range cellRef.FindAllStringSubmatchIndex(s, -1) {
+		start, end := m[0], m[1]
+
+		// Names and functions like LOG10() look like references
+		if (start > 0 && isNameChar(s[start-1])) || (end < len(s) && (isNameChar(s[end]) || s[end] == '(')) {
+			continue
+		}
+
+		refSheet := sheet
+		if m[2] >= 0 {
+			refSheet = strings.ReplaceAll(strings.Trim(s[m[2]:m[3]-1], "'"), "''", "'")
+		}
+
+		refRows := rows[refSheet]
+		if refRows == nil {
+			continue
+		}
+
+		first, _ := strconv.Atoi(s[m[6]:m[7]])
+		ownRow := refSheet == sheet && origRow > 0 && first == origRow
+
+		b.WriteString(s[prev:m[6]])
+
+		if m[8] < 0 {
+			n := refRows.row(first)
+			if ownRow {
+				n = newRow
+			}
+
+			b.WriteString(strconv.Itoa(n))
+		} else {
+			last, _ := strconv.Atoi(s[m[10]:m[11]])
+
+			from, to := refRows.span(first, last)
+			if ownRow && last == origRow {
+				from, to = newRow, newRow
+			}
+
+			b.WriteString(strconv.Itoa(from))
+			b.WriteString(s[m[7]:m[10]])
+			b.WriteString(strconv.Itoa(to))
+		}
+
+		prev = end
+	}
+
+	b.WriteString(s[prev:])
+
+	return b.String()
+}
+
+func isNameChar(c byte) bool {
+	return c == '_' || c == '.' || c == '$' || c >= 0x80 ||
+		('0' <= c && c <= '9') || ('a' <= c && c <= 'z') || ('A' <= c && c <= 'Z')
+}
+
+// hasBlockTokens reports if the text contains the start of a code or print block.
+func hasBlockTokens(text string) bool {
+	return strings.Contains(text, string(engine.BlockTokenStartCode)) ||
+		strings.Contains(text, string(engine.BlockTokenStartPrint))
+}
+
+func isSheetElement(elem xml.StartElement, local string) bool {
+	return elem.Name.Space == nsSheet && elem.Name.Local == local
+}
+
+// rowNumber returns the number of a row element, which is optional.
+func rowNumber(elem xml.StartElement) (int, bool) {
+	v, ok := utils.AttrValue(elem, "", "r")
+	if !ok {
+		return 0, false
+	}
+
+	n, err := strconv.Atoi(v)
+
+	return n, err == nil
+}
+
+// withAttr returns a copy of the attributes with the attribute without namespace set to value.
+func withAttr(attrs []xml.Attr, local, value string) []xml.Attr {
+	res := make([]xml.Attr, 0, len(attrs)+1)
+	found := false
+
+	for _, a := range attrs {
+		if a.Name.Space == "" && a.Name.Local == local {
+			a.Value = value
+			found = true
+		}
+
+		res = append(res, a)
+	}
+
+	if !found {
+		res = append(res, xml.Attr{Name: xml.Name{Local: local}, Value: value})
+	}
+
+	return res
+}
+
+// matchingEnd returns the index of the EndElement for the StartElement at index start.
+func matchingEnd(tokens []xml.Token, start int) int {
+	depth := 0
+
+	for i := start; i < len(tokens); i++ {
+		switch tokens[i].(type) {
+		case xml.StartElement:
+			depth++
+		case xml.EndElement:
+			depth--
+			if depth == 0 {
+				return i
+			}
+		}
+	}
+
+	return len(tokens) - 1
+}
+
+// charData returns the concatenated char data of the tokens.
+func charData(tokens []xml.Token) string {
+	var b strings.Builder
+
+	for _, tok := range tokens {
+		if d, ok := tok.(xml.CharData); ok {
+			b.Write(d)
+		}
+	}
+
+	return b.String()
+}
@@ -0,0 +1,98 @@
+package document
+
+import (
+	"bytes"
+	"io/ioutil"
+	"testing"
+
+	"github.com/microfast-ch/rea/internal/ooxml"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRewriteRefs(t *testing.T) {
+	// Row 2 is removed, row 3 is repeated three times and row 5 moves up
+	rows := map[string]rowMap{"My Sheet": {1: {1}, 3: {2, 3, 4}, 5: {6}}}
+
+	tests := []struct {
+		formula, want string
+		origRow       int
+	}{
+		{"SUM(B3:B4)", "SUM(B2:B4)", 0},
+		{"$C$5*2", "$C$6*2", 0},
+		{"B3*C3", "B3*C3", 3},               // Own row of the repeated formula
+		{"SUM(A3:C3)", "SUM(A3:C3)", 3},     // Range in the own row
+		{"SUM(B1:B100)", "SUM(B1:B101)", 0}, // Rows beyond keep their distance
+		{`IF(A5="B3",LOG10(B5))`, `IF(A6="B3",LOG10(B6))`, 0},
+		{"'My Sheet'!$B$3:$B$3", "'My Sheet'!$B$2:$B$4", 0},
+		{"Other!B3+Sheet_1.B3", "Other!B3+Sheet_1.B3", 0}, // Unknown sheet and names
+	}
+
+	for _, tc := range tests {
+		require.Equal(t, tc.want, rewriteRefs(tc.formula, rows, "My Sheet", tc.origRow, 3), tc.formula)
+	}
+}
+
+func TestUpdateRefsSheets(t *testing.T) {
+	// Row 3 of Sheet1 is repeated twice, row 3 of the sheet Other moved to row 5
+	rw := &sheetRewriter{
+		templateRows: map[int]bool{3: true, 5: true},
+		sheet:        "Sheet1",
+		workbook:     map[string]rowMap{"Other": {1: {1}, 3: {5}}},
+		rows:         rowMap{},
+	}
+
+	tokens := semanticTokens(t, []byte(`<worksheet xmlns="`+nsSheet+`"><sheetData>`+
+		`<row r="3"><c r="A3"/></row><row r="3"><c r="A3"/></row>`+
+		`<row r="5"><c r="B5"><f>Sheet1!A5+Other!B3+SUM(Sheet1!A3:A3)+Missing!C3</f></c></row>`+
+		`</sheetData></worksheet>`))
+
+	tokens, err := rw.rewrite(tokens)
+	require.Nil(t, err)
+	require.Equal(t, "Sheet1!A6+Other!B5+SUM(Sheet1!A3:A4)+Missing!C3", charData(tokens))
+}
+
+func TestTemplateXLSX(t *testing.T) {
+	tmpl, err := NewFromFile("../../testdata/Spreadsheet1.xlsx")
+	require.Nil(t, err)
+
+	model := &Model{Data: map[string]any{"items": []any{
+		map[string]any{"name": "Apple", "price": 1.5},
+		map[string]any{"name": "Banana", "price": 2},
+		map[string]any{"name": "Cherry", "price": 3},
+	}}}
+
+	out := bytes.NewBuffer([]byte(""))
+	_, err = tmpl.Write(model, out)
+	require.Nil(t, err)
+
+	doc, err := ooxml.New(bytes.NewReader(out.Bytes()), int64(out.Len()))
+	require.Nil(t, err)
+	require.Equal(t, ooxml.SpreadsheetContentType, doc.MIMEType())
+
+	read := func(name string) string {
+		fd, err := doc.Open(name)
+		require.Nil(t, err)
+
+		defer fd.Close()
+
+		data, err := ioutil.ReadAll(fd)
+		require.Nil(t, err)
+
+		return string(data)
+	}
+
+	// Defined names span the repeated rows and formulas are recalculated
+	workbook := read("xl/workbook.xml")
+	require.Contains(t, workbook, `<definedName name="Amounts">Items!$B$3:$B$5</definedName>`)
+	require.Contains(t, workbook, `<definedName name="Total">'Items'!$B$6</definedName>`)
+	require.Contains(t, workbook, `<calcPr fullCalcOnLoad="1" calcId="191029"/>`)
+
+	// The calculation chain refers to the moved cells and is removed
+	_, err = doc.Open("xl/calcChain.xml")
+	require.NotNil(t, err)
+	require.NotContains(t, read("[Content_Types].xml"), "calcChain")
+	require.NotContains(t, read("xl/_rels/workbook.xml.rels"), "calcChain")
+
+	// Sheets without code blocks are not touched
+	require.Contains(t, read("xl/worksheets/sheet2.xml"), "<f>Items!B6</f>")
+}
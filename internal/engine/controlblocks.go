@@ -0,0 +1,201 @@
+package engine
+
+import (
+	"encoding/xml"
+	"regexp"
+	"strings"
+
+	"github.com/djboris9/xmltree"
+	"golang.org/x/exp/slices"
+)
+
+// printCall matches code that emits content to the document by itself.
+var printCall = regexp.MustCompile(`\bPrint\s*\(`)
+
+// controlScanner determines the elements of a XML tree that contain only control blocks.
+// Such elements hold at least one code block and besides that only whitespace or
+// wrapper elements without any text. Print blocks and other elements, like images,
+// tabs or line breaks, count as content. See SetWrapperNodes and SetIgnoredNodes.
+type controlScanner struct {
+	state        luatreeFSMState
+	wrapperNodes []string
+	ignoredNodes []string
+	controlOnly  map[*xmltree.Node]bool
+}
+
+// findControlOnlyNodes returns the set of elements in the template and the included
+// fragments that contain only control blocks.
+func (e *LuaEngine) findControlOnlyNodes() map[*xmltree.Node]bool {
+	controlOnly := map[*xmltree.Node]bool{}
+
+	for _, root := range e.lt.roots() {
+		s := &controlScanner{
+			state:        luatreeFSMStateChar,
+			wrapperNodes: e.wrapperNodes,
+			ignoredNodes: e.ignoredNodes,
+			controlOnly:  controlOnly,
+		}
+
+		s.scan(root)
+	}
+
+	return controlOnly
+}
+
+// scan walks the given node in document order and reports if the node contains
+// code and if it renders content to the document.
+func (s *controlScanner) scan(node *xmltree.Node) (hasCode, hasContent bool) {
+	if chars, ok := node.Token.(xml.CharData); ok {
+		return s.scanCharData(string(chars))
+	}
+
+	for i := range node.Nodes {
+		code, content := s.scan(node.Nodes[i])
+		hasCode = hasCode || code
+		hasContent = hasContent || content
+	}
+
+	elem, ok := node.Token.(xml.StartElement)
+	if !ok {
+		return hasCode, hasContent
+	}
+
+	if hasCode && !hasContent {
+		s.controlOnly[node] = true
+	}
+
+	switch {
+	case slices.Contains(s.ignoredNodes, elem.Name.Local):
+		return hasCode, false
+	case slices.Contains(s.wrapperNodes, elem.Name.Local):
+		return hasCode, hasContent
+	default:
+		return hasCode, true
+	}
+}
+
+func (s *controlScanner) scanCharData(d string) (hasCode, hasContent bool) {
+	for _, tok := range codeBlockTokenizer(d) {
+		switch tok {
+		case "":
+			continue
+		case string(BlockTokenStartCode):
+			s.state = luatreeFSMStateCode
+			hasCode = true
+		case string(BlockTokenEndCode):
+			s.state = luatreeFSMStateChar
+			hasCode = true
+		case string(BlockTokenStartPrint):
+			s.state = luatreeFSMStatePrint
+			hasContent = true
+		case string(BlockTokenEndPrint):
+			s.state = luatreeFSMStateChar
+			hasContent = true
+		default:
+			switch s.state {
+			case luatreeFSMStateCode:
+				hasCode = true
+				hasContent = hasContent || printCall.MatchString(tok)
+			case luatreeFSMStatePrint:
+				hasContent = true
+			case luatreeFSMStateChar:
+				hasContent = hasContent || strings.TrimSpace(tok) != ""
+			}
+		}
+	}
+
+	return hasCode, hasContent
+}
+
+// isRemoved reports if the given node is part of an element that contains only
+// control blocks and is removable. The outermost of such elements decides.
+func (e *LuaEngine) isRemoved(node *xmltree.Node) bool {
+	return e.removedRoot(node) != nil
+}
+
+// removedRoot returns the removed element that contains the given node or nil.
+func (e *LuaEngine) removedRoot(node *xmltree.Node) *xmltree.Node {
+	if e.controlOnly == nil {
+		e.controlOnly = e.findControlOnlyNodes()
+	}
+
+	var root *xmltree.Node
+
+	for n := node; n != nil; n = n.Parent {
+		if e.controlOnly[n] {
+			root = n
+		}
+	}
+
+	if root == nil {
+		return nil
+	}
+
+	if elem, ok := root.Token.(xml.StartElement); ok && slices.Contains(e.removableNodes, elem.Name.Local) {
+		return root
+	}
+
+	return nil
+}
+
+// addRequiredChildren adds an empty child to the elements of the node path that lost all
+// of their required children, e.g. a table cell whose paragraphs were all removed.
+// See SetRequiredChildren.
+func (e *LuaEngine) addRequiredChildren() {
+	if len(e.requiredChildren) == 0 {
+		return
+	}
+
+	type openElement struct {
+		node  *xmltree.Node
+		local string
+		found bool // A required child was rendered
+	}
+
+	stack := []openElement{}
+	nodes := make([]*xmltree.Node, 0, len(e.nodePath))
+
+	for _, n := range e.nodePath {
+		switch tok := n.Token.(type) {
+		case xml.StartElement:
+			if len(stack) > 0 && e.requiredChildren[stack[len(stack)-1].local] == tok.Name.Local {
+				stack[len(stack)-1].found = true
+			}
+
+			stack = append(stack, openElement{node: n, local: tok.Name.Local})
+		case xml.EndElement:
+			if len(stack) == 0 {
+				break
+			}
+
+			open := stack[len(stack)-1]
+			stack = stack[:len(stack)-1]
+
+			if child, ok := e.requiredChildren[open.local]; ok && !open.found {
+				nodes = append(nodes, emptyChild(open.node, child)...)
+			}
+		}
+
+		nodes = append(nodes, n)
+	}
+
+	e.nodePath = nodes
+}
+
+// emptyChild returns the start and end node of an empty child element of parent with the
+// given local name. The name is taken from such a child in the template, if there is one.
+func emptyChild(parent *xmltree.Node, local string) []*xmltree.Node {
+	name := xml.Name{Space: parent.Token.(xml.StartElement).Name.Space, Local: local}
+
+	for _, c := range parent.Nodes {
+		if elem, ok := c.Token.(xml.StartElement); ok && elem.Name.Local == local {
+			name = elem.Name
+			break
+		}
+	}
+
+	start := &xmltree.Node{Token: xml.StartElement{Name: name}, Parent: parent}
+	end := &xmltree.Node{Token: xml.EndElement{Name: name}, Parent: start}
+
+	return []*xmltree.Node{start, end}
+}
@@ -0,0 +1,155 @@
+package engine
+
+import (
+	"encoding/xml"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestRemoveControlOnlyParagraphs(t *testing.T) {
+	testdata := xml.Header + `
+<body>[[ SetRemovableNodes({"p", "li", "tr"}) SetWrapperNodes({"p", "span"}) ]]
+  <p>[[ if false then ]]</p>
+  <p>Hello</p>
+  <p>[[ end ]]</p>
+  <p>World</p>
+  <p>[[ x = 1 ]]<span> </span></p>
+  <p>[[ Print("printed") ]]</p>
+</body>`
+
+	wantXML := xml.Header + `
+<body>
+  
+  <p>World</p>
+  
+  <p>printed</p>
+</body>`
+
+	e, err := prepareLua(t, testdata)
+	if err != nil {
+		t.Error(err)
+	}
+
+	if diff := cmp.Diff(wantXML, serializeNodePath(t, e.nodePath)); diff != "" {
+		t.Errorf("nodePath as XML mismatch (-want +got):\n%s", diff)
+		t.Log(e.lt.LuaProg)
+	}
+}
+
+func TestRemoveControlOnlyRows(t *testing.T) {
+	testdata := xml.Header + `
+<body>[[ SetRemovableNodes({"p", "li", "tr"}) SetWrapperNodes({"p", "span", "tr", "td"}) ]]
+<table>
+<tr><td><p><span>[[ for i=1,2 do ]]</span></p></td></tr>
+<tr><td><p>[# i #]</p></td><td><p>[[ if i == 1 then ]]</p><p>first</p><p>[[ end ]]</p></td></tr>
+<tr><td><p>[[ end ]]</p></td><td><p></p></td></tr>
+</table>
+</body>`
+
+	wantXML := xml.Header + `
+<body>
+<table>
+
+<tr><td><p>1</p></td><td><p>first</p></td></tr>
+
+<tr><td><p>2</p></td><td></td></tr>
+
+</table>
+</body>`
+
+	e, err := prepareLua(t, testdata)
+	if err != nil {
+		t.Error(err)
+	}
+
+	if diff := cmp.Diff(wantXML, serializeNodePath(t, e.nodePath)); diff != "" {
+		t.Errorf("nodePath as XML mismatch (-want +got):\n%s", diff)
+		t.Log(e.lt.LuaProg)
+	}
+}
+
+func TestRemoveControlOnlyDisabled(t *testing.T) {
+	// Without removable nodes, the elements are kept
+	testdata := xml.Header + `
+<body>
+  <p>[[ if true then ]]</p>
+  <p>Hello</p>
+  <p>[[ end ]]</p>
+</body>`
+
+	wantXML := xml.Header + `
+<body>
+  <p></p>
+  <p>Hello</p>
+  <p></p>
+</body>`
+
+	e, err := prepareLua(t, testdata)
+	if err != nil {
+		t.Error(err)
+	}
+
+	if diff := cmp.Diff(wantXML, serializeNodePath(t, e.nodePath)); diff != "" {
+		t.Errorf("nodePath as XML mismatch (-want +got):\n%s", diff)
+		t.Log(e.lt.LuaProg)
+	}
+}
+
+func TestRemoveControlOnlyKeepsElements(t *testing.T) {
+	// Images, frames and tabs are content, even without text
+	testdata := xml.Header + `
+<body>[[ SetRemovableNodes({"p"}) SetWrapperNodes({"p", "span"}) SetIgnoredNodes({"s", "bookmark"}) ]]
+  <p>[[ if true then ]]<frame><image href="logo.png"/></frame>[[ end ]]</p>
+  <p>[[ for i=1,2 do -- @repeat p ]]<tab/>[[ end ]]</p>
+  <p>[[ if true then ]]<span><s/></span><bookmark/>[[ end ]]</p>
+  <p>Text</p>
+</body>`
+
+	wantXML := xml.Header + `
+<body>
+  <p><frame><image href="logo.png"></image></frame></p>
+  <p><tab></tab></p><p><tab></tab></p>
+  
+  <p>Text</p>
+</body>`
+
+	e, err := prepareLua(t, testdata)
+	if err != nil {
+		t.Error(err)
+	}
+
+	if diff := cmp.Diff(wantXML, serializeNodePath(t, e.nodePath)); diff != "" {
+		t.Errorf("nodePath as XML mismatch (-want +got):\n%s", diff)
+		t.Log(e.lt.LuaProg)
+	}
+}
+
+func TestRequiredChildren(t *testing.T) {
+	// The cell keeps an empty paragraph, the row is removed as a whole
+	testdata := xml.Header + `
+<body>[[ SetRemovableNodes({"p", "tr"}) SetWrapperNodes({"p", "tr", "td"}) SetRequiredChildren({td = "p"}) ]]
+<table>
+<tr><td><p>[[ if false then ]]</p><p>Hidden</p><p>[[ end ]]</p></td><td><p>Text</p></td></tr>
+<tr><td><p>[[ x = 1 ]]</p></td></tr>
+</table>
+</body>`
+
+	wantXML := xml.Header + `
+<body>
+<table>
+<tr><td><p></p></td><td><p>Text</p></td></tr>
+
+</table>
+</body>`
+
+	e, err := prepareLua(t, testdata)
+	if err != nil {
+		t.Error(err)
+	}
+
+	if diff := cmp.Diff(wantXML, serializeNodePath(t, e.nodePath)); diff != "" {
+		t.Errorf("nodePath as XML mismatch (-want +got):\n%s", diff)
+		t.Log(e.lt.LuaProg)
+	}
+}
@@ -10,6 +10,8 @@ import (
 	"github.com/Shopify/go-lua"
 	"github.com/djboris9/xmltree"
 	"github.com/microfast-ch/rea/internal/safelua"
+	"github.com/microfast-ch/rea/internal/stdlib"
+	"github.com/microfast-ch/rea/internal/utils"
 	"golang.org/x/exp/slices"
 
 	goluagoUtil "github.com/Shopify/goluago/util"
@@ -31,6 +33,36 @@ type LuaEngine struct {
 
 	// List of xml node names that act as the origin of iterations
 	iterationNodes []string
+
+	// Aliases for the names of elements that can be used with `Repeat` and the
+	// nodes of the repeat annotations that were reached during the execution
+	repeatAliases map[string][]string
+	repeated      map[int]bool
+
+	// List of xml node names that are removed if they contain only control blocks, the
+	// names of the nodes that don't decide that by themselves and the elements found
+	removableNodes []string
+	wrapperNodes   []string
+	ignoredNodes   []string
+	controlOnly    map[*xmltree.Node]bool
+
+	// Local names of the child elements that an element must keep, e.g. a paragraph of a cell
+	requiredChildren map[string]string
+
+	// Sets the type of elements that hold a printed number or date
+	valueTyper  ValueTyper
+	typedValues map[*xmltree.Node]TypedValue
+
+	// Handling of printed characters that are not allowed in XML and the model paths
+	// of the strings with such characters, which are collected on the first one printed
+	data          *TemplateData
+	charPolicy    CharPolicy
+	invalidValues map[string]string
+
+	// Handling of undefined variables and the error raised by a Go function during exec
+	undefinedMode   UndefinedMode
+	raised          error
+	templateGlobals map[string]bool // Globals that are assigned by the template, read on the first undefined variable
 }
 
 // Passed data must be a primitive or a map.
@@ -48,38 +80,57 @@ func NewLuaEngine(lt *LuaTree, data *TemplateData) *LuaEngine {
 		lt:           lt,
 		luaState:     l,
 		reachcounter: newReachCounter(),
+		data:         data,
 	}
 
 	// Map our Go functions to lua
-	l.Register("SetToken", e.handleIterations(e.iSetToken))
-	l.Register("StartNode", e.handleIterations(e.iStartNode))
-	l.Register("EndNode", e.handleIterations(e.iEndNode))
-	l.Register("CharData", e.handleIterations(e.iCharData))
-	l.Register("Print", e.handleIterations(e.iPrint))
-	l.Register("SetIterationNodes", e.iSetIterationNodes)
+	for _, f := range e.functions() {
+		l.Register(f.Name, f.Function)
+	}
 
 	// Inject data into the lua stack
 	if data != nil {
 		if data.Data != nil {
 			for k, v := range data.Data {
-				goluagoUtil.DeepPush(l, v)
+				pushData(l, v)
 				l.SetGlobal(k)
 			}
 		}
 
 		if data.Metadata != nil {
-			goluagoUtil.DeepPush(l, data)
+			goluagoUtil.DeepPush(l, data.Metadata)
 			l.SetGlobal("metadata")
 		}
 	}
 
-	// Restricted base library
+	// Restricted base library and rea specific functions
 	safelua.Add(l)
+	stdlib.Add(l)
+	e.setGlobalsMetaTable(l)
 
 	// Return engine
 	return e
 }
 
+// functions returns the Go functions of the engine that are registered as lua globals.
+func (e *LuaEngine) functions() []lua.RegistryFunction {
+	return []lua.RegistryFunction{
+		{Name: "SetToken", Function: e.skipRemoved(e.handleIterations(e.iSetToken))},
+		{Name: "StartNode", Function: e.skipRemoved(e.handleIterations(e.iStartNode))},
+		{Name: "EndNode", Function: e.skipRemoved(e.handleIterations(e.iEndNode))},
+		{Name: "CharData", Function: e.skipRemoved(e.handleIterations(e.iCharData))},
+		{Name: "Print", Function: e.handleIterations(e.iPrint)},
+		{Name: "SetIterationNodes", Function: e.iSetIterationNodes},
+		{Name: "SetRemovableNodes", Function: e.iSetRemovableNodes},
+		{Name: "SetWrapperNodes", Function: e.iSetWrapperNodes},
+		{Name: "SetIgnoredNodes", Function: e.iSetIgnoredNodes},
+		{Name: "SetRequiredChildren", Function: e.iSetRequiredChildren},
+		{Name: "Include", Function: e.iInclude},
+		{Name: "Repeat", Function: e.iRepeat},
+		{Name: "SetRepeatAliases", Function: e.iSetRepeatAliases},
+	}
+}
+
 // This function is serialized on exec.
 func (e *LuaEngine) Exec(initFunc string) error {
 	e.execLock.Lock() // TODO: We might convert it to sync.Once
@@ -88,29 +139,70 @@ func (e *LuaEngine) Exec(initFunc string) error {
 	// Initialize empty state
 	e.nodePath = []*xmltree.Node{}
 	e.nodePathStr = []string{}
+	e.typedValues = map[*xmltree.Node]TypedValue{}
+	e.repeated = map[int]bool{}
+	e.controlOnly = nil
+	e.raised = nil
 
 	// Execute initialization function
 	err := lua.DoString(e.luaState, initFunc)
 	if err != nil {
 		// We got an error, but the detailed error message is on the stack. Wrap it.
-		return fmt.Errorf("executing init lua prog got %w with :%s", err, lua.CheckString(e.luaState, -1))
+		return e.execError("init lua prog", err)
 	}
 
 	// Execute lua program
 	err = lua.DoString(e.luaState, e.lt.LuaProg)
 	if err != nil {
 		// We got an error, but the detailed error message is on the stack. Wrap it.
-		return fmt.Errorf("executing lua prog got %w with :%s", err, lua.CheckString(e.luaState, -1))
+		return e.execError("lua prog", err)
+	}
+
+	e.closeParents()
+	e.removeEmptyRepeats()
+	e.addRequiredChildren()
+
+	if e.valueTyper != nil {
+		e.applyValueTypes()
 	}
 
 	return err
 }
 
+// closeParents closes the elements that are still open after the execution,
+// e.g. because the end of an element was removed with its parent.
+func (e *LuaEngine) closeParents() {
+	for i := len(e.parentStack) - 1; i >= 0; i-- {
+		elem := e.parentStack[i].Token.(xml.StartElement).End()
+
+		e.nodePath = append(e.nodePath, &xmltree.Node{
+			Token:  elem,
+			Parent: e.parentStack[i],
+		})
+		e.nodePathStr = append(e.nodePathStr, fmt.Sprintf("EndNode(%s) - balanced", elem.Name.Local))
+	}
+
+	e.parentStack = e.parentStack[:0]
+}
+
+// execError wraps the error of an executed lua program. Errors raised by Go functions, like
+// runtime errors, have no message on the stack.
+func (e *LuaEngine) execError(prog string, err error) error {
+	if e.raised != nil {
+		return fmt.Errorf("executing %s: %w", prog, e.raised)
+	}
+
+	if msg, ok := e.luaState.ToString(-1); ok {
+		return fmt.Errorf("executing %s got %w with :%s", prog, err, msg)
+	}
+
+	return fmt.Errorf("executing %s: %w", prog, err)
+}
+
 // Can only be called after Exec() has been run.
 func (e *LuaEngine) WriteXML(w io.Writer) error {
-	enc := xml.NewEncoder(w)
+	enc := utils.NewXMLEncoder(w)
 	for i := range e.nodePath {
-		// if err := EncodeToken(enc, w, e.nodePath[i].Token); err != nil { // Is a custom encoder needed
 		if err := enc.EncodeToken(e.nodePath[i].Token); err != nil {
 			return fmt.Errorf("encoding token %d: %w", i, err)
 		}
@@ -163,7 +255,9 @@ func (e *LuaEngine) iEndNode(state *lua.State) int {
 	e.nodePathStr = append(e.nodePathStr, fmt.Sprintf("EndNode(%d)", nodeID))
 
 	// Remove one level from the parent stack
-	e.parentStack = e.parentStack[:len(e.parentStack)-1]
+	if len(e.parentStack) > 0 {
+		e.parentStack = e.parentStack[:len(e.parentStack)-1]
+	}
 
 	return 0
 }
@@ -203,7 +297,24 @@ func (e *LuaEngine) iPrint(state *lua.State) int {
 	n := state.Top()
 	state.Global("tostring")
 
+	// Values are separated by tabs, nil values that are skipped in lenient mode have no separator
+	printed := 0
+
 	for i := 1; i <= n; i++ {
+		if state.IsNil(i) {
+			if e.undefinedMode == UndefinedStrict {
+				e.raise(state, utils.FormatError(ErrUndefined, "printed value is nil at "+e.location(state)))
+			} else if e.undefinedMode == UndefinedLenient {
+				continue
+			}
+		}
+
+		if printed > 0 {
+			sc.WriteString("\t")
+		}
+
+		printed++
+
 		state.PushValue(-1) // function to be called
 		state.PushValue(i)  // value to print
 		state.Call(1, 1)
@@ -214,29 +325,86 @@ func (e *LuaEngine) iPrint(state *lua.State) int {
 			panic("unreachable")
 		}
 
-		if i > 1 {
-			sc.WriteString("\t")
+		// Invalid characters of model values are reported with their path
+		if e.charPolicy == CharPolicyError {
+			if err := e.checkPrinted(s); err != nil {
+				e.raise(state, fmt.Errorf("%w at %s", err, e.location(state)))
+			}
 		}
 
 		sc.WriteString(s)
 		state.Pop(1) // pop result
 	}
 
+	text, err := e.charPolicy.sanitize(sc.String())
+	if err != nil {
+		e.raise(state, fmt.Errorf("%w at %s", err, e.location(state)))
+	}
+
 	e.nodePathStr = append(e.nodePathStr, "Print(???)")
 
 	// Create new node and add it to the nodePath
-	chrNode := xml.CharData(sc.String())
-	node := &xmltree.Node{
-		Token:  chrNode,
-		Parent: e.parentStack[len(e.parentStack)-1],
+	// Prints outside of the root element have no parent
+	chrNode := xml.CharData(text)
+	node := &xmltree.Node{Token: chrNode}
+
+	if len(e.parentStack) > 0 {
+		node.Parent = e.parentStack[len(e.parentStack)-1]
 	}
 	e.nodePath = append(e.nodePath, node)
 
+	// Remember numbers and dates, which might become typed values
+	if v, ok := toTypedValue(state, 1); ok && n == 1 {
+		e.typedValues[node] = v
+	}
+
 	return 0
 }
 
 func (e *LuaEngine) iSetIterationNodes(state *lua.State) int {
-	// Extract string array from first argument, which is a table
+	// Set new iteration nodes
+	e.SetIterationNodes(checkStringList(state, "SetIterationNodes"))
+
+	return 0
+}
+
+func (e *LuaEngine) iSetRemovableNodes(state *lua.State) int {
+	e.SetRemovableNodes(checkStringList(state, "SetRemovableNodes"))
+
+	return 0
+}
+
+func (e *LuaEngine) iSetWrapperNodes(state *lua.State) int {
+	e.SetWrapperNodes(checkStringList(state, "SetWrapperNodes"))
+
+	return 0
+}
+
+func (e *LuaEngine) iSetIgnoredNodes(state *lua.State) int {
+	e.SetIgnoredNodes(checkStringList(state, "SetIgnoredNodes"))
+
+	return 0
+}
+
+func (e *LuaEngine) iSetRequiredChildren(state *lua.State) int {
+	lua.CheckType(state, 1, lua.TypeTable)
+
+	children := map[string]string{}
+
+	state.PushNil()
+
+	for state.Next(1) {
+		children[lua.CheckString(state, -2)] = lua.CheckString(state, -1)
+		state.Pop(1)
+	}
+
+	e.SetRequiredChildren(children)
+
+	return 0
+}
+
+// checkStringList extracts a string array from the last argument, which is a table.
+func checkStringList(state *lua.State, funcName string) []string {
 	idx := state.AbsIndex(-1)
 	args := make([]string, lua.LengthEx(state, idx))
 
@@ -245,7 +413,7 @@ func (e *LuaEngine) iSetIterationNodes(state *lua.State) int {
 	for state.Next(idx) {
 		k, ok := state.ToInteger(-2)
 		if !ok {
-			lua.Errorf(state, "SetIterationNodes cannot process numeric index, got: %s", state.TypeOf(-2))
+			lua.Errorf(state, "%s cannot process numeric index, got: %s", funcName, state.TypeOf(-2))
 			panic("unreachable")
 		}
 
@@ -253,10 +421,7 @@ func (e *LuaEngine) iSetIterationNodes(state *lua.State) int {
 		state.Pop(1)
 	}
 
-	// Set new iteration nodes
-	e.SetIterationNodes(args)
-
-	return 0
+	return args
 }
 
 // SetIterationNodes updates the list of node names that act as an iteration origin.
@@ -264,6 +429,47 @@ func (e *LuaEngine) SetIterationNodes(nodes []string) {
 	e.iterationNodes = nodes
 }
 
+// SetRemovableNodes updates the list of node names that are removed from the
+// document if they contain only control blocks, e.g. a paragraph with only `[[ end ]]`.
+func (e *LuaEngine) SetRemovableNodes(nodes []string) {
+	e.removableNodes = nodes
+}
+
+// SetWrapperNodes updates the list of node names that only structure their children, like
+// spans, runs and table cells. They don't count as content by themselves, their children decide
+// if they contain only control blocks.
+func (e *LuaEngine) SetWrapperNodes(nodes []string) {
+	e.wrapperNodes = nodes
+	e.controlOnly = nil
+}
+
+// SetIgnoredNodes updates the list of node names that don't count as content, like
+// formatting properties, bookmarks and spaces.
+func (e *LuaEngine) SetIgnoredNodes(nodes []string) {
+	e.ignoredNodes = nodes
+	e.controlOnly = nil
+}
+
+// SetRequiredChildren sets the local names of the child elements that an element must keep,
+// e.g. `{tc = "p"}` for table cells that need a paragraph. If all of them are removed, an empty
+// one is added.
+func (e *LuaEngine) SetRequiredChildren(children map[string]string) {
+	e.requiredChildren = children
+}
+
+// skipRemoved is a middleware that skips the processing of nodes which are
+// part of a removed element. See SetRemovableNodes.
+func (e *LuaEngine) skipRemoved(next lua.Function) lua.Function {
+	return func(state *lua.State) int {
+		nodeID := lua.CheckInteger(state, -1)
+		if e.isRemoved(e.lt.NodeList[nodeID]) {
+			return 0
+		}
+
+		return next(state)
+	}
+}
+
 // handleIterations is a middleware for handling iterations. It detects if we
 // need to reconstruct XML parents from the iteration origin up to the current node.
 // This function needs to be called before processing elements that have an impact
@@ -281,9 +487,9 @@ func (e *LuaEngine) handleIterations(next lua.Function) lua.Function {
 		lastNode := e.nodePath[len(e.nodePath)-1]
 		var iterOrigin *xmltree.Node
 
-		for parent := lastNode.Parent; parent.Token != nil; parent = parent.Parent {
-			elem := parent.Token.(xml.StartElement)
-			if slices.Contains(e.iterationNodes, elem.Name.Local) {
+		for parent := lastNode.Parent; parent != nil && parent.Token != nil; parent = parent.Parent {
+			elem, ok := parent.Token.(xml.StartElement)
+			if ok && slices.Contains(e.iterationNodes, elem.Name.Local) {
 				iterOrigin = parent
 			}
 		}
@@ -293,18 +499,23 @@ func (e *LuaEngine) handleIterations(next lua.Function) lua.Function {
 			return next(state)
 		}
 
-		// Rebalance tree up to the iterTarget. The next function will rebalance
-		// it again down to the new node.
-		e.fillTree(iterOrigin)
-
-		// Clean counter and add current node to it, as it will be rendered
-		e.reachcounter.Clean()
-		e.countCall(state)
+		e.restartIteration(state, iterOrigin)
 
 		return next(state)
 	}
 }
 
+// restartIteration starts a new iteration of the given iteration origin.
+func (e *LuaEngine) restartIteration(state *lua.State, iterOrigin *xmltree.Node) {
+	// Rebalance tree up to the iterTarget. The next function will rebalance
+	// it again down to the new node.
+	e.fillTree(iterOrigin)
+
+	// Clean counter and add current node to it, as it will be rendered
+	e.reachcounter.Clean()
+	e.countCall(state)
+}
+
 // countCall records the execution of the current line and returns true if this
 // function was already called at least one time at the current source line.
 // TODO: If the function is defined two times at the same line, it currently
@@ -332,28 +543,41 @@ func (e *LuaEngine) countCall(state *lua.State) bool {
 // nolint:funlen
 func (e *LuaEngine) fillTree(newNode *xmltree.Node) {
 	// We are the root or are somehow detached. No balancing possible.
-	if newNode.Parent == nil || len(e.parentStack) == 0 {
+	if newNode.Parent == nil {
 		return
 	}
 
-	previousNode := e.nodePath[len(e.nodePath)-1]
-	lastStack := e.parentStack[len(e.parentStack)-1]
-
-	// Check if we have the same parent as the previous node.
-	// EndElements are children of the StartElement/parent.
-	// This means we are still on the same depth and can safely return here.
-	if newNode.Parent == previousNode.Parent {
-		return
+	// The stack is empty between repeated root elements
+	var lastStack *xmltree.Node
+	if len(e.parentStack) > 0 {
+		lastStack = e.parentStack[len(e.parentStack)-1]
 	}
 
-	// Is the previous node is our parent, we don't have to rebalance here
-	if newNode.Parent == previousNode {
-		return
-	}
+	// A start element that is still open is reached again, e.g. by a loop that ends in it.
+	// It needs to be closed before it is opened again, even if the stack matches.
+	// The first node needs its parents, if they were skipped, e.g. by a condition.
+	_, isStart := newNode.Token.(xml.StartElement)
+	if len(e.nodePath) > 0 && (!isStart || !slices.Contains(e.parentStack, newNode)) {
+		previousNode := e.nodePath[len(e.nodePath)-1]
+
+		// Check if we have the same parent as the previous node.
+		// EndElements are children of the StartElement/parent.
+		// This means we are still on the same depth and can safely return here.
+		// After an EndElement its parent is closed, so a new child needs to reopen it.
+		_, previousIsEnd := previousNode.Token.(xml.EndElement)
+		if newNode.Parent == previousNode.Parent && !previousIsEnd {
+			return
+		}
 
-	// Does our stack match? This happens when we are the next node after an EndNode
-	if newNode.Parent == lastStack {
-		return
+		// Is the previous node is our parent, we don't have to rebalance here
+		if newNode.Parent == previousNode {
+			return
+		}
+
+		// Does our stack match? This happens when we are the next node after an EndNode
+		if newNode.Parent == lastStack {
+			return
+		}
 	}
 
 	// Okk, now we have to work. The stack doesn't match the stack the newNode
@@ -423,7 +647,7 @@ func getCommonPaths(node *xmltree.Node, stack []*xmltree.Node) (leftTree []*xmlt
 
 	// get commonParent and build leftTree
 nodeLoop:
-	for parent := node.Parent; parent != nil; parent = parent.Parent {
+	for parent := node.Parent; parent != nil && parent.Token != nil; parent = parent.Parent {
 		for i := range stack {
 			if parent == stack[i] {
 				commonParent = parent
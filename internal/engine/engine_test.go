@@ -2,6 +2,9 @@ package engine
 
 import (
 	"encoding/xml"
+	"errors"
+	"io"
+	"runtime"
 	"strings"
 	"testing"
 
@@ -765,3 +768,203 @@ func TestIterationNodes(t *testing.T) {
 		t.Log(e.lt.LuaProg)
 	}
 }
+
+func TestRenderEachLoop(t *testing.T) {
+	testdata := xml.Header + `
+<p>[[ for v, loop in each({"a", "b", "c"}) do ]][# v #][[ if not loop.last then ]], [[ end ]][[ end ]]</p>`
+
+	wantXML := xml.Header + `
+<p>a, b, c</p>`
+
+	e, err := prepareLua(t, testdata)
+	if err != nil {
+		t.Error(err)
+	}
+
+	if diff := cmp.Diff(wantXML, serializeNodePath(t, e.nodePath)); diff != "" {
+		t.Errorf("nodePath as XML mismatch (-want +got):\n%s", diff)
+		t.Log(e.lt.LuaProg)
+	}
+}
+
+func TestMetadata(t *testing.T) {
+	tree, err := xmltree.Parse([]byte(`<p>[# metadata.author #]</p>`))
+	if err != nil {
+		t.Fatalf("parsing tree: %v", err)
+	}
+
+	lt, err := NewLuaTree(tree)
+	if err != nil {
+		t.Fatalf("creating lua tree: %v", err)
+	}
+
+	e := NewLuaEngine(lt, &TemplateData{Metadata: map[string]string{"author": "Sue"}})
+
+	err = e.Exec("")
+	if err != nil {
+		t.Fatalf("executing lua engine: %s", err)
+	}
+
+	if diff := cmp.Diff(`<p>Sue</p>`, serializeNodePath(t, e.nodePath)); diff != "" {
+		t.Errorf("nodePath as XML mismatch (-want +got):\n%s", diff)
+	}
+}
+
+// Blocks that span elements in unusual ways are rendered as balanced XML.
+func TestRenderBalanced(t *testing.T) {
+	for doc, want := range map[string]string{
+		// The loop ends in the element it starts again
+		`<p>[[ for i=1,2 do ]]</p><p>[[ end ]]x</p>`: `<p></p><p>x</p>`,
+		// The start of the parent is skipped
+		`<body>[[ if A then ]]<p>[[ end ]]x</p></body>`: `<body><p>x</p></body>`,
+		// The end of the open element is removed with its parent
+		`<body>[[ for i=1,2 do ]]<p>[# i #][[ if A then ]]</p><p>[[ end ]]<p>[[ end ]]</p></p></body>`: `<body><p>1</p><p>2</p></body>`,
+		// Prints outside of the root element have no parent
+		`[[ for i=1,2 do ]][# i #][[ end ]]<p>x</p>`: `12<p>x</p>`,
+	} {
+		tree, err := xmltree.Parse([]byte(doc))
+		if err != nil {
+			t.Fatalf("parsing tree: %v", err)
+		}
+
+		lt, err := NewLuaTree(tree)
+		if err != nil {
+			t.Fatalf("creating lua tree: %v", err)
+		}
+
+		e := NewLuaEngine(lt, nil)
+
+		err = e.Exec(`SetRemovableNodes({"p"})`)
+		if err != nil {
+			t.Fatalf("executing lua engine: %s", err)
+		}
+
+		var buf strings.Builder
+		if err := e.WriteXML(&buf); err != nil {
+			t.Fatalf("writing xml: %s", err)
+		}
+
+		if diff := cmp.Diff(want, buf.String()); diff != "" {
+			t.Errorf("%s mismatch (-want +got):\n%s", doc, diff)
+		}
+	}
+}
+
+// fuzzElements and fuzzBlocks are the parts of the documents generated by fuzzDocument.
+var (
+	fuzzElements = []string{"p", "span", "tr", "li"}
+	fuzzBlocks   = []string{"[[ if A then ]]", "[[ else ]]", "[[ end ]]", "[[ for i=1,2 do ]]", "[# i #]", "[# A #]",
+		"[[ ", " ]]", "[# ", " #]", "[[ for i, v in ipairs({}) do ]]"}
+)
+
+// fuzzDocument generates a XML document with embedded blocks from the fuzzed data. Each byte
+// opens or closes an element or adds text or a block. Loops are limited, as they multiply the output.
+func fuzzDocument(data []byte) string {
+	var b strings.Builder
+
+	stack := []string{}
+	loops := 0
+
+	for _, c := range data {
+		arg := int(c / 4)
+
+		switch c % 4 {
+		case 0:
+			if len(stack) < 8 {
+				stack = append(stack, fuzzElements[arg%len(fuzzElements)])
+				b.WriteString("<" + stack[len(stack)-1] + ">")
+			}
+		case 1:
+			if len(stack) > 0 {
+				b.WriteString("</" + stack[len(stack)-1] + ">")
+				stack = stack[:len(stack)-1]
+			}
+		case 2:
+			block := fuzzBlocks[arg%len(fuzzBlocks)]
+			if strings.Contains(block, "for") {
+				if loops >= 3 {
+					continue
+				}
+
+				loops++
+			}
+
+			b.WriteString(block)
+		default:
+			b.WriteString("x")
+		}
+	}
+
+	for i := len(stack) - 1; i >= 0; i-- {
+		b.WriteString("</" + stack[i] + ">")
+	}
+
+	return b.String()
+}
+
+// FuzzRender renders generated documents. The output needs to be balanced and documents
+// without blocks need to be rendered as they are.
+func FuzzRender(f *testing.F) {
+	for _, seed := range []string{
+		"\x00\x02\x03\x0a\x01",                     // <p>[[ if A then ]]x[[ end ]]</p>
+		"\x00\x0e\x01\x00\x12\x01\x00\x0a\x01",     // <p>[[ for i=1,2 do ]]</p><p>[# i #]</p><p>[[ end ]]</p>
+		"\x00\x08\x0e\x00\x12\x03\x01\x0a\x01\x01", // <p><tr>[[ for i=1,2 do ]]<p>[# i #]x</p>[[ end ]]</tr></p>
+		"\x12\x00\x03\x01",                         // [# i #]<p>x</p>
+		"\x00\x04\x03\x01\x03\x01",                 // <p><span>x</span>x</p>
+	} {
+		f.Add([]byte(seed))
+	}
+
+	f.Fuzz(func(t *testing.T, data []byte) {
+		doc := fuzzDocument(data)
+
+		tree, err := xmltree.Parse([]byte(doc))
+		if err != nil {
+			t.Fatalf("parsing generated document %q: %v", doc, err)
+		}
+
+		// Unbalanced blocks are rejected
+		lt, err := NewLuaTree(tree)
+		if err != nil {
+			return
+		}
+
+		e := NewLuaEngine(lt, nil)
+
+		// Lua errors are fine, but panics of the engine are recovered as runtime errors
+		err = e.Exec(`SetIterationNodes({"tr"}) SetRemovableNodes({"p", "li", "tr"})`)
+
+		var runtimeErr runtime.Error
+		if errors.As(err, &runtimeErr) {
+			t.Fatalf("executing %q: %v", doc, err)
+		}
+
+		if err != nil {
+			return
+		}
+
+		var buf strings.Builder
+
+		err = e.WriteXML(&buf)
+		if err != nil {
+			t.Fatalf("writing rendered %q: %v", doc, err)
+		}
+
+		// The output can be decoded by a strict decoder
+		d := xml.NewDecoder(strings.NewReader(buf.String()))
+		for {
+			_, err := d.Token()
+			if err == io.EOF {
+				break
+			}
+
+			if err != nil {
+				t.Fatalf("decoding rendered %q: %v\n%s", doc, err, buf.String())
+			}
+		}
+
+		if !strings.Contains(doc, "[") && !strings.Contains(doc, "]") && buf.String() != doc {
+			t.Errorf("document without blocks %q rendered as %q", doc, buf.String())
+		}
+	})
+}
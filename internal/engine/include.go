@@ -0,0 +1,161 @@
+package engine
+
+import (
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/Shopify/go-lua"
+	"github.com/djboris9/xmltree"
+	"github.com/microfast-ch/rea/internal/utils"
+)
+
+var ErrInclude = errors.New("includeErr")
+
+// maxIncludeDepth limits nested includes, which also stops cyclic includes.
+const maxIncludeDepth = 10
+
+var (
+	// includeRef matches the include macro `include "name"` and the function call `Include("name")`.
+	includeRef = regexp.MustCompile(`(?:\binclude\s+|\bInclude\s*\(\s*)"([^"]+)"`)
+
+	// includeStmt matches a code block that consists only of an include.
+	includeStmt = regexp.MustCompile(`^\s*Include\s*\(\s*"([^"]+)"\s*\)\s*(--[^\n]*)?$`)
+)
+
+// FindIncludes returns the names of all includes referenced in the code blocks of the
+// given tree, in the order of their first occurrence. Only string literals can be found.
+func FindIncludes(tree *xmltree.Node) []string {
+	var sc strings.Builder
+
+	state := luatreeFSMStateChar
+
+	_ = xmltree.Walk(tree, func(node *xmltree.Node, depth uint) error {
+		chars, ok := node.Token.(xml.CharData)
+		if !ok {
+			return nil
+		}
+
+		for _, tok := range codeBlockTokenizer(string(chars)) {
+			switch tok {
+			case string(BlockTokenStartCode):
+				state = luatreeFSMStateCode
+			case string(BlockTokenEndCode):
+				state = luatreeFSMStateChar
+				sc.WriteString("\n")
+			case string(BlockTokenStartPrint):
+				state = luatreeFSMStatePrint
+			case string(BlockTokenEndPrint):
+				state = luatreeFSMStateChar
+			default:
+				if state == luatreeFSMStateCode {
+					sc.WriteString(tok)
+				}
+			}
+		}
+
+		return nil
+	})
+
+	names := []string{}
+	seen := map[string]bool{}
+
+	for _, m := range includeRef.FindAllStringSubmatch(sc.String(), -1) {
+		if !seen[m[1]] {
+			seen[m[1]] = true
+			names = append(names, m[1])
+		}
+	}
+
+	return names
+}
+
+// includeFragment copies the fragment with the given name into the lua tree, anchored at the
+// given parent, and returns the lua program for it. The program is a block of its own.
+func (t *LuaTree) includeFragment(name string, parent *xmltree.Node, depth int) (string, error) {
+	fragment, ok := t.includes[name]
+	if !ok {
+		return "", utils.FormatError(ErrInclude, fmt.Sprintf("include %q is not available", name))
+	}
+
+	if depth >= maxIncludeDepth {
+		return "", utils.FormatError(ErrInclude, fmt.Sprintf("include %q exceeds the maximum depth of %d, is it cyclic?", name, maxIncludeDepth))
+	}
+
+	if parent == nil {
+		return "", utils.FormatError(ErrInclude, fmt.Sprintf("include %q is outside of the document root", name))
+	}
+
+	var sc strings.Builder
+
+	fmt.Fprintf(&sc, "do -- Include %q\n", name)
+
+	fsm := newFSM(&sc, t.RegisterNode)
+	fsm.includeDepth = depth + 1
+	fsm.include = t.includeFragment
+
+	for i := range fragment.Nodes {
+		node := CopyNode(fragment.Nodes[i], parent)
+		t.included = append(t.included, node)
+
+		err := xmltree.Walk(node, func(node *xmltree.Node, depth uint) error {
+			nodeID := t.RegisterNode(node)
+
+			err := fsm.Next(nodeID, node, depth)
+			if err != nil {
+				return fmt.Errorf("executing FSM for node %d: %w", nodeID, err)
+			}
+
+			return nil
+		})
+		if err != nil {
+			return "", fmt.Errorf("converting include %q to LuaTree: %w", name, err)
+		}
+	}
+
+	err := fsm.End()
+	if err != nil {
+		return "", fmt.Errorf("converting include %q to LuaTree: %w", name, err)
+	}
+
+	fmt.Fprintf(&sc, "end -- Include %q", name)
+
+	return sc.String(), nil
+}
+
+// includeParent returns the parent for the fragment of the include in the given code block,
+// which ends in node. The elements that hold nothing but the include, like the paragraph of
+// `[[ include "legal/footer" ]]`, are replaced by the fragment, so their parent is returned.
+func includeParent(node *xmltree.Node, code string) *xmltree.Node {
+	block := strings.Join(strings.Fields(string(BlockTokenStartCode)+code+string(BlockTokenEndCode)), "")
+
+	parent := node.Parent
+	for parent != nil && parent.Parent != nil && strings.Join(strings.Fields(utils.NodeText(parent)), "") == block {
+		parent = parent.Parent
+	}
+
+	return parent
+}
+
+// iInclude reports the usage of `Include` that couldn't be resolved when the template was loaded.
+func (e *LuaEngine) iInclude(state *lua.State) int {
+	lua.Errorf(state, "Include: only string literals are supported and the include must be the only statement of its code block")
+	panic("unreachable")
+}
+
+// CopyNode deep copies the node, anchoring the copy at the given parent.
+func CopyNode(n, parent *xmltree.Node) *xmltree.Node {
+	c := &xmltree.Node{
+		Token:  xml.CopyToken(n.Token),
+		Parent: parent,
+		Nodes:  make([]*xmltree.Node, len(n.Nodes)),
+	}
+
+	for i := range n.Nodes {
+		c.Nodes[i] = CopyNode(n.Nodes[i], c)
+	}
+
+	return c
+}
@@ -0,0 +1,113 @@
+package engine
+
+import (
+	"encoding/xml"
+	"testing"
+
+	"github.com/djboris9/xmltree"
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestFindIncludes(t *testing.T) {
+	tree, err := xmltree.Parse([]byte(`<body><p>[[ include "legal/footer" ]]</p><p>[[ Include(</p><p>"sig") ]]</p>` +
+		`<p>[[ include "legal/footer" ]]</p><p>Please include "your invoice number" when paying.</p>` +
+		`<p>[# "Include(\"printed\")" #]</p></body>`))
+	if err != nil {
+		t.Fatalf("parsing tree: %v", err)
+	}
+
+	if diff := cmp.Diff([]string{"legal/footer", "sig"}, FindIncludes(tree)); diff != "" {
+		t.Errorf("FindIncludes() mismatch (-want +got):\n%s", diff)
+	}
+}
+
+func TestRenderInclude(t *testing.T) {
+	testdata := xml.Header + `
+<body>[[ name = "Alice" ]]
+  <p>Before</p>[[ for i=1,2 do ]][[ include "greeting" ]][[ end ]][[ i = 3 ]][[ Include("greeting") ]]
+  <p>After</p>
+</body>`
+
+	fragment, err := xmltree.Parse([]byte(`<p>Hello [# name #] [# i #]</p><p>[[ if i == 2 then ]]Bye[[ end ]]</p>`))
+	if err != nil {
+		t.Fatalf("parsing fragment: %v", err)
+	}
+
+	wantXML := xml.Header + `
+<body>
+  <p>Before</p><p>Hello Alice 1</p><p></p><p>Hello Alice 2</p><p>Bye</p><p>Hello Alice 3</p><p></p>
+  <p>After</p>
+</body>`
+
+	tree, err := xmltree.Parse([]byte(testdata))
+	if err != nil {
+		t.Fatalf("parsing tree: %v", err)
+	}
+
+	lt, err := NewLuaTreeWithIncludes(tree, map[string]*xmltree.Node{"greeting": fragment})
+	if err != nil {
+		t.Fatalf("creating lua tree: %v", err)
+	}
+
+	e := NewLuaEngine(lt, nil)
+	if err := e.Exec(""); err != nil {
+		t.Fatalf("executing lua engine: %s", err)
+	}
+
+	if diff := cmp.Diff(wantXML, serializeNodePath(t, e.nodePath)); diff != "" {
+		t.Errorf("nodePath as XML mismatch (-want +got):\n%s", diff)
+		t.Log(e.lt.LuaProg)
+	}
+}
+
+func TestRenderIncludeParagraph(t *testing.T) {
+	// The paragraph that holds only the include is replaced by the fragment
+	testdata := xml.Header + `<body><p>Before</p><p><span>[[ include "greeting" ]]</span></p><p>After</p></body>`
+
+	fragment, err := xmltree.Parse([]byte(`<p>Hello</p><p>World</p>`))
+	if err != nil {
+		t.Fatalf("parsing fragment: %v", err)
+	}
+
+	tree, err := xmltree.Parse([]byte(testdata))
+	if err != nil {
+		t.Fatalf("parsing tree: %v", err)
+	}
+
+	lt, err := NewLuaTreeWithIncludes(tree, map[string]*xmltree.Node{"greeting": fragment})
+	if err != nil {
+		t.Fatalf("creating lua tree: %v", err)
+	}
+
+	e := NewLuaEngine(lt, nil)
+	if err := e.Exec(`SetRemovableNodes({"p"}) SetWrapperNodes({"p", "span"})`); err != nil {
+		t.Fatalf("executing lua engine: %s", err)
+	}
+
+	wantXML := xml.Header + `<body><p>Before</p><p>Hello</p><p>World</p><p>After</p></body>`
+	if diff := cmp.Diff(wantXML, serializeNodePath(t, e.nodePath)); diff != "" {
+		t.Errorf("nodePath as XML mismatch (-want +got):\n%s", diff)
+		t.Log(e.lt.LuaProg)
+	}
+}
+
+func TestRenderIncludeMissing(t *testing.T) {
+	tree, err := xmltree.Parse([]byte(`<body>[[ include "missing" ]]</body>`))
+	if err != nil {
+		t.Fatalf("parsing tree: %v", err)
+	}
+
+	if _, err := NewLuaTree(tree); err == nil {
+		t.Errorf("including a missing fragment should fail")
+	}
+
+	// Cyclic includes are stopped by the depth limit
+	fragment, err := xmltree.Parse([]byte(`<p>[[ include "self" ]]</p>`))
+	if err != nil {
+		t.Fatalf("parsing fragment: %v", err)
+	}
+
+	if _, err := NewLuaTreeWithIncludes(tree, map[string]*xmltree.Node{"missing": fragment, "self": fragment}); err == nil {
+		t.Errorf("cyclic includes should fail")
+	}
+}
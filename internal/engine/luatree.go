@@ -20,6 +20,10 @@ type LuaTree struct {
 	nodeListMx sync.Mutex
 
 	LuaProg string // Lua program representing only the XML tree
+
+	// Fragments that can be included by name and the copies of the included nodes
+	includes map[string]*xmltree.Node
+	included []*xmltree.Node
 }
 
 // RegisterNode adds a xmltree node to the node registry of the lua tree,
@@ -35,13 +39,24 @@ func (t *LuaTree) RegisterNode(node *xmltree.Node) uint32 {
 
 // NewLuaTree converts an XML tree to a lua tree.
 func NewLuaTree(tree *xmltree.Node) (*LuaTree, error) {
-	lt := &LuaTree{}
+	return NewLuaTreeWithIncludes(tree, nil)
+}
+
+// NewLuaTreeWithIncludes converts an XML tree to a lua tree. Code blocks consisting
+// of an include, like `[[ include "legal/footer" ]]`, are replaced by the fragment
+// with this name. A fragment is a node without token, whose children are inserted.
+// The fragments are part of the same lua program, so they share the scope of the template.
+func NewLuaTreeWithIncludes(tree *xmltree.Node, includes map[string]*xmltree.Node) (*LuaTree, error) {
+	lt := &LuaTree{
+		includes: includes,
+	}
 
 	// Temporary lua script holder
 	var sc strings.Builder
 
 	// Initialize FSM
 	fsm := newFSM(&sc, lt.RegisterNode)
+	fsm.include = lt.includeFragment
 
 	err := xmltree.Walk(tree, func(node *xmltree.Node, depth uint) error {
 		// We register a node id for each node to keep track of it
@@ -56,6 +71,10 @@ func NewLuaTree(tree *xmltree.Node) (*LuaTree, error) {
 		return nil
 	})
 
+	if err == nil {
+		err = fsm.End()
+	}
+
 	if err != nil {
 		return nil, fmt.Errorf("converting XML Tree to LuaTree: %w", err)
 	}
@@ -65,6 +84,15 @@ func NewLuaTree(tree *xmltree.Node) (*LuaTree, error) {
 	return lt, nil
 }
 
+// roots returns the root of the template and the roots of the included nodes.
+func (t *LuaTree) roots() []*xmltree.Node {
+	if len(t.NodeList) == 0 {
+		return t.included
+	}
+
+	return append([]*xmltree.Node{t.NodeList[0]}, t.included...)
+}
+
 type luatreeFSMState int
 
 const (
@@ -82,6 +110,14 @@ type luatreeFSM struct {
 	sc         io.Writer
 	curIndent  string
 	registerer nodeRegisterer
+
+	// Content of the current code block, which is written out at its end
+	code     strings.Builder
+	codeNode uint32
+
+	// Resolves includes to lua programs and the current depth of includes
+	include      func(name string, parent *xmltree.Node, depth int) (string, error)
+	includeDepth int
 }
 
 func newFSM(buf io.Writer, registerer nodeRegisterer) *luatreeFSM {
@@ -142,6 +178,19 @@ func (fsm *luatreeFSM) Next(nodeID uint32, node *xmltree.Node, depth uint) error
 	return nil
 }
 
+// End checks that the last code or print block was closed, as the elements of the
+// document after an unterminated block would be lost.
+func (fsm *luatreeFSM) End() error {
+	switch fsm.state {
+	case luatreeFSMStateCode:
+		return utils.FormatError(ErrLuaTree, fmt.Sprintf("code block at node %d is not terminated", fsm.codeNode))
+	case luatreeFSMStatePrint:
+		return utils.FormatError(ErrLuaTree, "print block is not terminated")
+	}
+
+	return nil
+}
+
 func (fsm *luatreeFSM) processStartElement(nodeID uint32, node *xmltree.Node) error {
 	element, ok := node.Token.(xml.StartElement)
 	if !ok {
@@ -182,8 +231,11 @@ func (fsm *luatreeFSM) processEndElement(nodeID uint32, node *xmltree.Node) erro
 
 func (fsm *luatreeFSM) processChar(nodeID uint32, node *xmltree.Node, curToken string, singleToken bool) error {
 	switch fsm.state {
-	case luatreeFSMStateCode, luatreeFSMStatePrint:
-		// If we are in a code or print context, we print the parts directly
+	case luatreeFSMStateCode:
+		// Code is collected until the end of the block, so annotations can be processed
+		fsm.code.WriteString(curToken)
+	case luatreeFSMStatePrint:
+		// If we are in a print context, we print the parts directly
 		// as the envelope is handled in the according start and end blocks
 		fmt.Fprintf(fsm.sc, "%s", curToken)
 	case luatreeFSMStateChar:
@@ -212,6 +264,8 @@ func (fsm *luatreeFSM) processStartCode(nodeID uint32, node *xmltree.Node) error
 		return utils.FormatError(ErrLuaTree, "start code block reached from inside a print or code block")
 	case luatreeFSMStateChar:
 		fmt.Fprintf(fsm.sc, "%s", fsm.curIndent)
+		fsm.code.Reset()
+		fsm.codeNode = nodeID
 		fsm.state = luatreeFSMStateCode
 	default:
 		return utils.FormatError(ErrLuaTree, "invalid state")
@@ -225,7 +279,21 @@ func (fsm *luatreeFSM) processEndCode(nodeID uint32, node *xmltree.Node) error {
 	case luatreeFSMStateChar, luatreeFSMStatePrint:
 		return utils.FormatError(ErrLuaTree, "end code block reached outside a code block")
 	case luatreeFSMStateCode:
-		fmt.Fprintf(fsm.sc, " -- CodeBlock\n")
+		code, err := expandMacros(fsm.code.String())
+		if err != nil {
+			return fmt.Errorf("expanding code block at node %d: %w", fsm.codeNode, err)
+		}
+
+		if m := includeStmt.FindStringSubmatch(code); m != nil && fsm.include != nil {
+			prog, err := fsm.include(m[1], includeParent(node, fsm.code.String()), fsm.includeDepth)
+			if err != nil {
+				return fmt.Errorf("including at node %d: %w", fsm.codeNode, err)
+			}
+
+			fmt.Fprintf(fsm.sc, "%s -- CodeBlock\n", prog)
+		} else {
+			fmt.Fprintf(fsm.sc, "%s -- CodeBlock\n", rewriteRepeatAnnotation(code, fsm.codeNode))
+		}
 		fsm.printInhibition()
 		fsm.state = luatreeFSMStateChar
 	default:
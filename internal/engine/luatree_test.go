@@ -2,6 +2,7 @@ package engine
 
 import (
 	"encoding/xml"
+	"errors"
 	"testing"
 
 	"github.com/djboris9/xmltree"
@@ -49,7 +50,7 @@ func TestNewLuaTree(t *testing.T) {
   SetToken(27) -- Type: xml.Comment
   SetToken(28) --  "\n  "
   StartNode(29) --  p2
-    if (A)  -- CodeBlock
+    if (A) then  -- CodeBlock
    CharData(31) --  "Hallo "
    Print( A ) -- PrintBlock
    EndNode(32) --  p2
@@ -75,3 +76,40 @@ func TestNewLuaTree(t *testing.T) {
 		t.Errorf("generated LuaProg mismatch (-want +got):\n%s", diff)
 	}
 }
+
+func FuzzNewLuaTree(f *testing.F) {
+	for _, seed := range []string{
+		`<p1><p2 no="5">[[ if (A) ]]Hallo [# A #]</p2><p2 no="6">[[ endif ]]</p2></p1>`,
+		`<body>[[ include "item" ]]<!-- [[ --><p>[# i #]</p></body>`,
+		xml.Header + `<p>[[ for i=1,2 do -- @repeat p ]]x[[ end ]]</p>`,
+		`[# 1 #]<p>]]</p>`,
+	} {
+		f.Add(seed)
+	}
+
+	f.Fuzz(func(t *testing.T, data string) {
+		tree, err := xmltree.Parse([]byte(data))
+		if err != nil {
+			return
+		}
+
+		// Errors are fine, as long as the builder doesn't panic
+		lt, err := NewLuaTreeWithIncludes(tree, map[string]*xmltree.Node{"item": tree})
+		if err == nil && lt.LuaProg == "" && len(tree.Nodes) > 0 {
+			t.Errorf("empty lua program for %q", data)
+		}
+	})
+}
+
+func TestNewLuaTreeUnterminated(t *testing.T) {
+	for _, doc := range []string{`<p>[[ if A then </p>`, `<p>[# A</p>`} {
+		tree, err := xmltree.Parse([]byte(doc))
+		if err != nil {
+			t.Fatalf("parsing tree: %v", err)
+		}
+
+		if _, err := NewLuaTree(tree); !errors.Is(err, ErrLuaTree) {
+			t.Errorf("NewLuaTree(%q) = %v, want %v", doc, err, ErrLuaTree)
+		}
+	}
+}
@@ -0,0 +1,99 @@
+package engine
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/microfast-ch/rea/internal/utils"
+)
+
+var ErrMacro = errors.New("macroErr")
+
+var (
+	// `each <name> in <expr>` or `each <name>, <name> in <expr>`
+	eachMacro = regexp.MustCompile(`^each\s+([A-Za-z_]\w*)(?:\s*,\s*([A-Za-z_]\w*))?\s+in\s+(\S.*)$`)
+
+	// `if <expr>` and `elseif <expr>` without the trailing `then`
+	ifMacro = regexp.MustCompile(`^(if|elseif)([\s(]|$)`)
+
+	// The `then` keyword, which marks an if statement as plain lua
+	thenKeyword = regexp.MustCompile(`(^|[^\w])then($|[^\w])`)
+
+	// `include "name"`
+	includeMacro = regexp.MustCompile(`^include\s+("[^"]*")$`)
+)
+
+// expandMacros expands the simplified syntax of a code block to lua code.
+// Code blocks that are plain lua are returned as they are. The following macros are supported:
+//
+//	each item in order.items       -> for item, loop in each(order.items) do
+//	each item, meta in order.items -> for item, meta in each(order.items) do
+//	if epay                        -> if epay then
+//	elseif invoice                 -> elseif invoice then
+//	include "legal/footer"         -> Include("legal/footer")
+//
+// A trailing comment, like a `-- @repeat` annotation, is kept.
+func expandMacros(code string) (string, error) {
+	stmt, comment := splitComment(code)
+	trimmed := strings.TrimSpace(stmt)
+
+	switch {
+	case trimmed == "each" || strings.HasPrefix(trimmed, "each ") || strings.HasPrefix(trimmed, "each\t"):
+		m := eachMacro.FindStringSubmatch(trimmed)
+		if m == nil {
+			return "", utils.FormatError(ErrMacro, fmt.Sprintf("invalid each macro %q, expected `each <name> in <expression>`", trimmed))
+		}
+
+		loopVar := m[2]
+		if loopVar == "" {
+			loopVar = "loop"
+		}
+
+		stmt = fmt.Sprintf(" for %s, %s in each(%s) do ", m[1], loopVar, strings.TrimSpace(m[3]))
+	case ifMacro.MatchString(trimmed) && !thenKeyword.MatchString(trimmed):
+		keyword := ifMacro.FindStringSubmatch(trimmed)[1]
+		if strings.TrimSpace(strings.TrimPrefix(trimmed, keyword)) == "" {
+			return "", utils.FormatError(ErrMacro, fmt.Sprintf("invalid %s macro %q, expected `%s <condition>`", keyword, trimmed, keyword))
+		}
+
+		stmt = fmt.Sprintf(" %s then ", trimmed)
+	case trimmed == "include" || strings.HasPrefix(trimmed, "include "):
+		m := includeMacro.FindStringSubmatch(trimmed)
+		if m == nil {
+			return "", utils.FormatError(ErrMacro, fmt.Sprintf("invalid include macro %q, expected `include \"<name>\"`", trimmed))
+		}
+
+		stmt = fmt.Sprintf(" Include(%s) ", m[1])
+	}
+
+	return stmt + comment, nil
+}
+
+// splitComment splits a lua code snippet into the code and a trailing comment,
+// starting at the first `--` that is not part of a string.
+func splitComment(code string) (stmt, comment string) {
+	var quote rune
+
+	escaped := false
+
+	for i, c := range code {
+		switch {
+		case escaped:
+			escaped = false
+		case quote != 0 && c == '\\':
+			escaped = true
+		case quote != 0:
+			if c == quote {
+				quote = 0
+			}
+		case c == '"' || c == '\'':
+			quote = c
+		case c == '-' && strings.HasPrefix(code[i:], "--"):
+			return code[:i], code[i:]
+		}
+	}
+
+	return code, ""
+}
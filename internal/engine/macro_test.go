@@ -0,0 +1,72 @@
+package engine
+
+import (
+	"encoding/xml"
+	"errors"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestExpandMacros(t *testing.T) {
+	tests := []struct {
+		code string
+		want string
+	}{
+		{" each item in order.items ", " for item, loop in each(order.items) do "},
+		{" each item, meta in order.items ", " for item, meta in each(order.items) do "},
+		{" each item in order.items -- @repeat section", " for item, loop in each(order.items) do -- @repeat section"},
+		{" if epay ", " if epay then "},
+		{" if (a or b) ", " if (a or b) then "},
+		{" if(a) ", " if(a) then "},
+		{" elseif x == \"--\" ", " elseif x == \"--\" then "},
+		{" else ", " else "},
+		{" end ", " end "},
+		{" if epay then ", " if epay then "},
+		{" if a then b = 1 end ", " if a then b = 1 end "},
+		{" for i=1,3 do ", " for i=1,3 do "},
+		{" each(items) ", " each(items) "},
+		{" ifx = 1 ", " ifx = 1 "},
+		{" x = 1 -- if y", " x = 1 -- if y"},
+		{` include "legal/footer" `, ` Include("legal/footer") `},
+		{` includes = 1 `, ` includes = 1 `},
+	}
+
+	for _, tc := range tests {
+		got, err := expandMacros(tc.code)
+		if err != nil {
+			t.Errorf("expandMacros(%q) returned error: %s", tc.code, err)
+		}
+
+		if diff := cmp.Diff(tc.want, got); diff != "" {
+			t.Errorf("expandMacros(%q) mismatch (-want +got):\n%s", tc.code, diff)
+		}
+	}
+}
+
+func TestExpandMacrosErrors(t *testing.T) {
+	for _, code := range []string{" each item ", " each in items ", " each ", " if ", " elseif ", " include footer "} {
+		_, err := expandMacros(code)
+		if !errors.Is(err, ErrMacro) {
+			t.Errorf("expandMacros(%q) should return ErrMacro, got: %v", code, err)
+		}
+	}
+}
+
+func TestRenderMacros(t *testing.T) {
+	testdata := xml.Header + `
+<ul>[[ items = {"a", "b"} ]][[ each v in items ]]<li>[[ if loop.first ]]First [[ elseif loop.last ]]Last [[ else ]]Other [[ end ]][# v #]</li>[[ end ]]</ul>`
+
+	wantXML := xml.Header + `
+<ul><li>First a</li><li>Last b</li></ul>`
+
+	e, err := prepareLua(t, testdata)
+	if err != nil {
+		t.Error(err)
+	}
+
+	if diff := cmp.Diff(wantXML, serializeNodePath(t, e.nodePath)); diff != "" {
+		t.Errorf("nodePath as XML mismatch (-want +got):\n%s", diff)
+		t.Log(e.lt.LuaProg)
+	}
+}
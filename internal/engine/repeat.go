@@ -0,0 +1,165 @@
+package engine
+
+import (
+	"encoding/xml"
+	"fmt"
+	"regexp"
+	"strconv"
+
+	"github.com/Shopify/go-lua"
+	"github.com/djboris9/xmltree"
+	"golang.org/x/exp/slices"
+)
+
+// repeatAnnotation matches a `-- @repeat <name>` comment inside a code block.
+// The comment runs until the end of the line, as every other lua comment.
+var repeatAnnotation = regexp.MustCompile(`--\s*@repeat\s+([A-Za-z_][\w-]*)[^\n]*`)
+
+// rewriteRepeatAnnotation replaces a `-- @repeat <name>` annotation in the code block
+// with a call to `Repeat`, anchored at the node that contains the code block.
+// Example: `for i=1,3 do -- @repeat section` becomes `for i=1,3 do Repeat("section", 12)`.
+func rewriteRepeatAnnotation(code string, nodeID uint32) string {
+	return repeatAnnotation.ReplaceAllString(code, fmt.Sprintf(`Repeat("${1}", %d)`, nodeID))
+}
+
+// iRepeat implements the lua function `Repeat(name, nodeID)`. It is placed at the
+// head of a loop body and makes the innermost element with the given name, that
+// encloses the node with nodeID, the unit that is repeated on each iteration.
+// On the first iteration nothing is done, as the unit is rendered normally.
+func (e *LuaEngine) iRepeat(state *lua.State) int {
+	name := lua.CheckString(state, 1)
+	nodeID := lua.CheckInteger(state, 2)
+	lua.ArgumentCheck(state, nodeID >= 0 && nodeID < len(e.lt.NodeList), 2, "invalid node id")
+
+	e.repeated[nodeID] = true
+	anchor := e.lt.NodeList[nodeID]
+
+	origin := e.findRepeatOrigin(anchor, name)
+	if origin == nil {
+		lua.Errorf(state, "Repeat: no element '%s' encloses the loop", name)
+		panic("unreachable")
+	}
+
+	// On the first iteration we don't need to repeat anything
+	if !e.countCall(state) {
+		return 0
+	}
+
+	e.restartIteration(state, origin)
+
+	// Reopen the elements down to the loop head, so following prints are
+	// placed at the same position as in the first iteration. Removed elements stay closed.
+	if root := e.removedRoot(anchor); root != nil {
+		anchor = root
+	}
+
+	e.fillTree(anchor)
+
+	return 0
+}
+
+// repeatCall matches the calls of `Repeat` that replaced the annotations of the lua program.
+var repeatCall = regexp.MustCompile(`Repeat\("([A-Za-z_][\w-]*)", (\d+)\)`)
+
+// removeEmptyRepeats removes the units of loops that were not repeated at all, as the loop had
+// no iteration. Without an iteration the unit would be rendered once, with only its static content.
+func (e *LuaEngine) removeEmptyRepeats() {
+	for _, m := range repeatCall.FindAllStringSubmatch(e.lt.LuaProg, -1) {
+		nodeID, err := strconv.Atoi(m[2])
+		if err != nil || nodeID >= len(e.lt.NodeList) || e.repeated[nodeID] {
+			continue
+		}
+
+		origin := e.findRepeatOrigin(e.lt.NodeList[nodeID], m[1])
+		if origin != nil {
+			e.removeElement(origin)
+		}
+	}
+}
+
+// removeElement removes each rendering of the element from the node path, up to its balanced end.
+func (e *LuaEngine) removeElement(elem *xmltree.Node) {
+	nodes := e.nodePath[:0]
+
+	for i := 0; i < len(e.nodePath); i++ {
+		if e.nodePath[i] != elem {
+			nodes = append(nodes, e.nodePath[i])
+			continue
+		}
+
+		for depth := 0; i < len(e.nodePath); i++ {
+			switch e.nodePath[i].Token.(type) {
+			case xml.StartElement:
+				depth++
+			case xml.EndElement:
+				depth--
+			}
+
+			if depth == 0 {
+				break
+			}
+		}
+	}
+
+	e.nodePath = nodes
+}
+
+// findRepeatOrigin returns the innermost parent of node that matches the given
+// repeat name or one of its aliases. It returns nil if no such parent exists.
+func (e *LuaEngine) findRepeatOrigin(node *xmltree.Node, name string) *xmltree.Node {
+	names, ok := e.repeatAliases[name]
+	if !ok {
+		names = []string{name}
+	}
+
+	for parent := node.Parent; parent != nil; parent = parent.Parent {
+		elem, ok := parent.Token.(xml.StartElement)
+		if ok && slices.Contains(names, elem.Name.Local) {
+			return parent
+		}
+	}
+
+	return nil
+}
+
+func (e *LuaEngine) iSetRepeatAliases(state *lua.State) int {
+	lua.CheckType(state, 1, lua.TypeTable)
+
+	aliases := map[string][]string{}
+
+	state.PushNil()
+
+	for state.Next(1) {
+		alias := lua.CheckString(state, -2)
+
+		switch state.TypeOf(-1) {
+		case lua.TypeString:
+			aliases[alias] = []string{lua.CheckString(state, -1)}
+		case lua.TypeTable:
+			names := make([]string, lua.LengthEx(state, -1))
+			for i := range names {
+				state.RawGetInt(-1, i+1)
+				names[i] = lua.CheckString(state, -1)
+				state.Pop(1)
+			}
+
+			aliases[alias] = names
+		default:
+			lua.Errorf(state, "SetRepeatAliases expects a string or table for alias %s, got: %s", alias, state.TypeOf(-1))
+			panic("unreachable")
+		}
+
+		state.Pop(1)
+	}
+
+	e.SetRepeatAliases(aliases)
+
+	return 0
+}
+
+// SetRepeatAliases sets the aliases that can be used as name of a repeat unit.
+// An alias maps to the local element names that it stands for, e.g. `row` to `table-row`.
+// Names that are no alias are matched directly against the local element name.
+func (e *LuaEngine) SetRepeatAliases(aliases map[string][]string) {
+	e.repeatAliases = aliases
+}
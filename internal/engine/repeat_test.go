@@ -0,0 +1,153 @@
+package engine
+
+import (
+	"encoding/xml"
+	"strings"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestRewriteRepeatAnnotation(t *testing.T) {
+	got := rewriteRepeatAnnotation(" for i=1,3 do -- @repeat section ", 12)
+	want := ` for i=1,3 do Repeat("section", 12)`
+
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Errorf("rewriteRepeatAnnotation() mismatch (-want +got):\n%s", diff)
+	}
+
+	// Code without annotation stays untouched
+	got = rewriteRepeatAnnotation(" for i=1,3 do -- just a comment", 12)
+	want = " for i=1,3 do -- just a comment"
+
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Errorf("rewriteRepeatAnnotation() mismatch (-want +got):\n%s", diff)
+	}
+}
+
+func TestRepeatEmpty(t *testing.T) {
+	// A unit without iterations is removed with its static content
+	testdata := xml.Header + `<body><p>Pre</p><section><p>[[ for i=1,0 do -- @repeat section ]]Item [# i #]</p>` +
+		`<p>Text</p><p>[[ end ]]</p></section><p>Post</p></body>`
+
+	e, err := prepareLua(t, testdata)
+	if err != nil {
+		t.Error(err)
+	}
+
+	want := xml.Header + `<body><p>Pre</p><p>Post</p></body>`
+	if diff := cmp.Diff(want, serializeNodePath(t, e.nodePath)); diff != "" {
+		t.Errorf("nodePath as XML mismatch (-want +got):\n%s", diff)
+	}
+}
+
+func TestRepeatSection(t *testing.T) {
+	testdata := xml.Header + `
+<body>
+  <p>Pre</p>
+  <section>
+    <p>[[ for i=1,2 do -- @repeat section ]]Item [# i #]</p>
+    <p>Text</p>
+    <p>[[ end ]]</p>
+  </section>
+  <p>Post</p>
+</body>`
+
+	wantXML := xml.Header + `
+<body>
+  <p>Pre</p>
+  <section>
+    <p>Item 1</p>
+    <p>Text</p>
+    <p></p></section><section><p>Item 2</p>
+    <p>Text</p>
+    <p></p>
+  </section>
+  <p>Post</p>
+</body>`
+
+	e, err := prepareLua(t, testdata)
+	if err != nil {
+		t.Error(err)
+	}
+
+	if diff := cmp.Diff(wantXML, serializeNodePath(t, e.nodePath)); diff != "" {
+		t.Errorf("nodePath as XML mismatch (-want +got):\n%s", diff)
+		t.Log(e.lt.LuaProg)
+	}
+}
+
+func TestRepeatAlias(t *testing.T) {
+	testdata := xml.Header + `
+<body>[[ SetRepeatAliases({unit = {"frame"}}) ]]
+  <frame><box>
+    <p>[[ for i=1,2 do -- @repeat unit ]][# i #]</p>
+    <p>[[ end ]]</p>
+  </box></frame>
+</body>`
+
+	wantXML := xml.Header + `
+<body>
+  <frame><box>
+    <p>1</p>
+    <p></p></box></frame><frame><box><p>2</p>
+    <p></p>
+  </box></frame>
+</body>`
+
+	e, err := prepareLua(t, testdata)
+	if err != nil {
+		t.Error(err)
+	}
+
+	if diff := cmp.Diff(wantXML, serializeNodePath(t, e.nodePath)); diff != "" {
+		t.Errorf("nodePath as XML mismatch (-want +got):\n%s", diff)
+		t.Log(e.lt.LuaProg)
+	}
+}
+
+func TestRepeatRemovedHead(t *testing.T) {
+	testdata := xml.Header + `
+<deck>[[ SetRemovableNodes({"frame"}) SetWrapperNodes({"p"}) ]]<page><frame><p>[[ for i=1,2 do -- @repeat page ]]</p></frame><frame><p>[# i #]</p></frame><frame><p>[[ end ]]</p></frame></page></deck>`
+
+	wantXML := xml.Header + `
+<deck><page><frame><p>1</p></frame></page><page><frame><p>2</p></frame></page></deck>`
+
+	e, err := prepareLua(t, testdata)
+	if err != nil {
+		t.Error(err)
+	}
+
+	if diff := cmp.Diff(wantXML, serializeNodePath(t, e.nodePath)); diff != "" {
+		t.Errorf("nodePath as XML mismatch (-want +got):\n%s", diff)
+		t.Log(e.lt.LuaProg)
+	}
+}
+
+func TestRepeatRoot(t *testing.T) {
+	testdata := xml.Header + `
+<sld>[[ SetRemovableNodes({"sp"}) SetWrapperNodes({"p"}) ]]<tree><sp><p>[[ for i=1,2 do -- @repeat sld ]]</p></sp><sp><p>[# i #]</p></sp><sp><p>[[ end ]]</p></sp></tree></sld>`
+
+	wantXML := xml.Header + `
+<sld><tree><sp><p>1</p></sp></tree></sld><sld><tree><sp><p>2</p></sp></tree></sld>`
+
+	e, err := prepareLua(t, testdata)
+	if err != nil {
+		t.Error(err)
+	}
+
+	if diff := cmp.Diff(wantXML, serializeNodePath(t, e.nodePath)); diff != "" {
+		t.Errorf("nodePath as XML mismatch (-want +got):\n%s", diff)
+		t.Log(e.lt.LuaProg)
+	}
+}
+
+func TestRepeatMissingElement(t *testing.T) {
+	// The origin is validated on the first iteration already
+	testdata := xml.Header + `<body><p>[[ for i=1,1 do -- @repeat q ]][# i #][[ end ]]</p></body>`
+
+	err := newTestEngine(t, testdata, nil).Exec("")
+	if err == nil || !strings.Contains(err.Error(), `Repeat: no element 'q' encloses the loop`) {
+		t.Errorf("expected missing element error, got: %v", err)
+	}
+}
@@ -0,0 +1,194 @@
+package engine
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/microfast-ch/rea/internal/utils"
+)
+
+var ErrInvalidChar = errors.New("invalidCharErr")
+
+// CharPolicy defines how printed characters are handled, that are not allowed in XML 1.0.
+// These are control characters like \x0b and invalid UTF-8, e.g. from legacy systems.
+type CharPolicy int
+
+const (
+	// CharPolicyReplace replaces invalid characters with U+FFFD.
+	CharPolicyReplace CharPolicy = iota
+	// CharPolicyStrip removes invalid characters.
+	CharPolicyStrip
+	// CharPolicyError fails the execution on printing an invalid character, with the model path of the printed value.
+	CharPolicyError
+)
+
+var charPolicyNames = map[string]CharPolicy{
+	"replace": CharPolicyReplace,
+	"strip":   CharPolicyStrip,
+	"error":   CharPolicyError,
+}
+
+// Identifier matches model keys that can be written with the dot notation.
+var Identifier = regexp.MustCompile(`^[A-Za-z_]\w*$`)
+
+// ParseCharPolicy returns the policy with the given name, which is one of replace, strip or error.
+func ParseCharPolicy(name string) (CharPolicy, error) {
+	p, ok := charPolicyNames[name]
+	if !ok {
+		return CharPolicyReplace, utils.FormatError(ErrInvalidChar,
+			fmt.Sprintf("unknown policy %q, expected replace, strip or error", name))
+	}
+
+	return p, nil
+}
+
+// SetCharPolicy sets how characters that are not allowed in XML are printed. Defaults to CharPolicyReplace.
+func (e *LuaEngine) SetCharPolicy(p CharPolicy) {
+	e.charPolicy = p
+}
+
+// sanitize returns the string with the invalid characters handled by the policy.
+func (p CharPolicy) sanitize(s string) (string, error) {
+	if i := invalidChar(s); i < 0 {
+		return s, nil
+	} else if p == CharPolicyError {
+		return s, utils.FormatError(ErrInvalidChar, fmt.Sprintf("%s at byte %d", describeChar(s[i:]), i))
+	}
+
+	var sb strings.Builder
+
+	for i := 0; i < len(s); {
+		r, size := utf8.DecodeRuneInString(s[i:])
+		if (r == utf8.RuneError && size == 1) || !utils.IsXMLChar(r) {
+			if p == CharPolicyReplace {
+				sb.WriteRune(utf8.RuneError)
+			}
+		} else {
+			sb.WriteString(s[i : i+size])
+		}
+
+		i += size
+	}
+
+	return sb.String(), nil
+}
+
+// invalidChar returns the byte index of the first character that is not allowed in XML, or -1.
+func invalidChar(s string) int {
+	for i := 0; i < len(s); {
+		r, size := utf8.DecodeRuneInString(s[i:])
+		if (r == utf8.RuneError && size == 1) || !utils.IsXMLChar(r) {
+			return i
+		}
+
+		i += size
+	}
+
+	return -1
+}
+
+// describeChar describes the character at the start of s for error messages.
+func describeChar(s string) string {
+	r, size := utf8.DecodeRuneInString(s)
+	if r == utf8.RuneError && size == 1 {
+		return fmt.Sprintf("invalid UTF-8 byte 0x%02x", s[0])
+	}
+
+	return fmt.Sprintf("invalid XML character %U", r)
+}
+
+// checkPrinted returns an error with the model path of the printed string, if it is a model value that
+// contains a character which is not allowed in XML. Other strings are checked when the print is written.
+func (e *LuaEngine) checkPrinted(s string) error {
+	i := invalidChar(s)
+	if i < 0 {
+		return nil
+	}
+
+	if e.invalidValues == nil {
+		e.invalidValues = invalidValues(e.data)
+	}
+
+	path, ok := e.invalidValues[s]
+	if !ok {
+		return nil
+	}
+
+	return utils.FormatError(ErrInvalidChar, fmt.Sprintf("%s at byte %d of model value %s", describeChar(s[i:]), i, path))
+}
+
+// invalidValues returns the model paths of the strings that contain a character which is not allowed
+// in XML, by value. Values that occur several times have the first path in the order of the keys.
+func invalidValues(data *TemplateData) map[string]string {
+	found := map[string]string{}
+
+	if data == nil {
+		return found
+	}
+
+	for _, k := range sortedKeys(data.Data) {
+		collectInvalid(data.Data[k], ChildPath("", k), found)
+	}
+
+	collectInvalid(data.Metadata, "metadata", found)
+
+	return found
+}
+
+// collectInvalid walks the model value and adds the paths of the invalid strings to found.
+// Lists are indexed from 1, as in lua.
+func collectInvalid(v any, path string, found map[string]string) {
+	switch v := v.(type) {
+	case string:
+		if _, ok := found[v]; !ok && invalidChar(v) >= 0 {
+			found[v] = path
+		}
+	case map[string]any:
+		for _, k := range sortedKeys(v) {
+			collectInvalid(v[k], ChildPath(path, k), found)
+		}
+	case map[string]string:
+		for _, k := range sortedKeys(v) {
+			collectInvalid(v[k], ChildPath(path, k), found)
+		}
+	case []any:
+		for i, item := range v {
+			collectInvalid(item, fmt.Sprintf("%s[%d]", path, i+1), found)
+		}
+	case []string:
+		for i, item := range v {
+			collectInvalid(item, fmt.Sprintf("%s[%d]", path, i+1), found)
+		}
+	}
+}
+
+// sortedKeys returns the keys of the map in ascending order.
+func sortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+
+	sort.Strings(keys)
+
+	return keys
+}
+
+// ChildPath returns the path of the key in the table at path, like a template would access it.
+// The empty path stands for the globals.
+func ChildPath(path, key string) string {
+	switch {
+	case path == "" && Identifier.MatchString(key):
+		return key
+	case path == "":
+		return fmt.Sprintf("[%q]", key)
+	case Identifier.MatchString(key):
+		return path + "." + key
+	default:
+		return fmt.Sprintf("%s[%q]", path, key)
+	}
+}
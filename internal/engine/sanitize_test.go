@@ -0,0 +1,196 @@
+package engine
+
+import (
+	"encoding/xml"
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/djboris9/xmltree"
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestCharPolicy(t *testing.T) {
+	testdata := xml.Header + `<p>[[ for _, item in ipairs(order.items) do ]]<item>[# item.name #]</item>[[ end ]]` +
+		`<author>[# metadata.author #]</author></p>`
+
+	model := &TemplateData{
+		Data: map[string]any{
+			"order": map[string]any{
+				"items": []any{
+					map[string]any{"name": "Pen"},
+					map[string]any{"name": "Ink\x0b\xffPot"},
+				},
+			},
+		},
+		Metadata: map[string]string{"author": "Bob\x01"},
+	}
+
+	tests := []struct {
+		policy  CharPolicy
+		wantXML string
+	}{
+		{
+			policy: CharPolicyReplace,
+			wantXML: xml.Header + "<p><item>Pen</item><item>Ink��Pot</item>" +
+				"<author>Bob�</author></p>",
+		},
+		{
+			policy:  CharPolicyStrip,
+			wantXML: xml.Header + "<p><item>Pen</item><item>InkPot</item><author>Bob</author></p>",
+		},
+	}
+
+	for _, tc := range tests {
+		e := newTestEngine(t, testdata, model)
+		e.SetCharPolicy(tc.policy)
+
+		if err := e.Exec(""); err != nil {
+			t.Fatalf("executing lua engine with policy %d: %s", tc.policy, err)
+		}
+
+		if diff := cmp.Diff(tc.wantXML, serializeNodePath(t, e.nodePath)); diff != "" {
+			t.Errorf("nodePath with policy %d as XML mismatch (-want +got):\n%s", tc.policy, diff)
+		}
+	}
+
+	// The error names the first offending model value
+	e := newTestEngine(t, testdata, model)
+	e.SetCharPolicy(CharPolicyError)
+
+	err := e.Exec("")
+	if !errors.Is(err, ErrInvalidChar) {
+		t.Fatalf("expected ErrInvalidChar, got %v", err)
+	}
+
+	if !strings.Contains(err.Error(), "invalid XML character U+000B at byte 3 of model value order.items[2].name") {
+		t.Errorf("error doesn't point at the model value: %v", err)
+	}
+
+	model.Data = map[string]any{"order": map[string]any{"items": []any{}}}
+	e = newTestEngine(t, testdata, model)
+	e.SetCharPolicy(CharPolicyError)
+
+	err = e.Exec("")
+	if err == nil || !strings.Contains(err.Error(), "of model value metadata.author") {
+		t.Errorf("error doesn't point at the metadata value: %v", err)
+	}
+}
+
+func TestCharPolicyPrint(t *testing.T) {
+	// Strings that are built by the template have no model path
+	e := newTestEngine(t, xml.Header+`<p>[# "a\255" #]</p>`, nil)
+	e.SetCharPolicy(CharPolicyError)
+
+	err := e.Exec("")
+	if !errors.Is(err, ErrInvalidChar) {
+		t.Fatalf("expected ErrInvalidChar, got %v", err)
+	}
+
+	if !strings.Contains(err.Error(), "invalid UTF-8 byte 0xff at byte 1 at line 4") {
+		t.Errorf("error doesn't point at the print: %v", err)
+	}
+}
+
+func TestCharPolicyUnprinted(t *testing.T) {
+	// Only printed values are checked
+	model := &TemplateData{Data: map[string]any{"name": "Pen", "note": "\x0b", "tags": []string{"\x01"}}}
+
+	e := newTestEngine(t, xml.Header+`<p>[# name #]</p>`, model)
+	e.SetCharPolicy(CharPolicyError)
+
+	if err := e.Exec(""); err != nil {
+		t.Errorf("expected no error for unprinted invalid values, got %v", err)
+	}
+
+	e = newTestEngine(t, xml.Header+`<p>[# name, tags[1] #]</p>`, model)
+	e.SetCharPolicy(CharPolicyError)
+
+	err := e.Exec("")
+	if err == nil || !strings.Contains(err.Error(), "of model value tags[1] at line") {
+		t.Errorf("error doesn't point at the printed value: %v", err)
+	}
+}
+
+func TestInvalidValuesPaths(t *testing.T) {
+	tests := []struct {
+		data map[string]any
+		want string
+	}{
+		{map[string]any{"name": "\x00"}, "name"},
+		{map[string]any{"list": []any{"ok", []any{"\x1f"}}}, "list[2][1]"},
+		{map[string]any{"a": map[string]any{"first name": "\ufffe"}}, `a["first name"]`},
+		{map[string]any{"tags": []string{"ok", "\x1f"}}, "tags[2]"},
+		{map[string]any{"labels": map[string]string{"de": "\x1f"}}, "labels.de"},
+		{map[string]any{"b": "\x1f", "a": []any{"\x1f"}}, "a[1]"},
+		{map[string]any{"n": 1, "ok": "Grüße\t\n"}, ""},
+	}
+
+	for _, tc := range tests {
+		found := invalidValues(&TemplateData{Data: tc.data})
+
+		if tc.want == "" {
+			if len(found) > 0 {
+				t.Errorf("expected no invalid values for %v, got %v", tc.data, found)
+			}
+
+			continue
+		}
+
+		if len(found) != 1 {
+			t.Errorf("expected one invalid value for %v, got %v", tc.data, found)
+		}
+
+		for _, path := range found {
+			if path != tc.want {
+				t.Errorf("expected path %s, got %s", tc.want, path)
+			}
+		}
+	}
+}
+
+func TestParseCharPolicy(t *testing.T) {
+	for name, want := range map[string]CharPolicy{"replace": CharPolicyReplace, "strip": CharPolicyStrip, "error": CharPolicyError} {
+		got, err := ParseCharPolicy(name)
+		if err != nil || got != want {
+			t.Errorf("ParseCharPolicy(%q) = %d, %v, want %d", name, got, err, want)
+		}
+	}
+
+	if _, err := ParseCharPolicy("escape"); !errors.Is(err, ErrInvalidChar) {
+		t.Errorf("expected ErrInvalidChar for unknown policy, got %v", err)
+	}
+}
+
+func newTestEngine(t *testing.T, template string, data *TemplateData) *LuaEngine {
+	t.Helper()
+
+	tree, err := xmltree.Parse([]byte(template))
+	if err != nil {
+		t.Fatalf("parsing tree: %v", err)
+	}
+
+	lt, err := NewLuaTree(tree)
+	if err != nil {
+		t.Fatalf("creating lua tree: %v", err)
+	}
+
+	return NewLuaEngine(lt, data)
+}
+
+func TestChildPath(t *testing.T) {
+	tests := []struct {
+		path, key, want string
+	}{
+		{"", "order", "order"},
+		{"", "first name", `["first name"]`},
+		{"order", "items", "order.items"},
+		{"order", "2nd", `order["2nd"]`},
+	}
+
+	for _, tc := range tests {
+		if got := ChildPath(tc.path, tc.key); got != tc.want {
+			t.Errorf("ChildPath(%q, %q) = %q, want %q", tc.path, tc.key, got, tc.want)
+		}
+	}
+}
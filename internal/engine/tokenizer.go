@@ -28,7 +28,6 @@ func isToken(s string) bool {
 
 // codeBlockTokenizer splits the string d into strings with the tokens inside.
 // Tokens are expected to be 2 chars long. The resulting slice contains at least one element.
-// TODO: Add fuzzer.
 func codeBlockTokenizer(d string) []string {
 	ret := []string{}
 	lastToken := 0
@@ -1,6 +1,7 @@
 package engine
 
 import (
+	"strings"
 	"testing"
 
 	"github.com/google/go-cmp/cmp"
@@ -40,3 +41,28 @@ func TestCodeBlockTokenizer(t *testing.T) {
 		t.Errorf("codeBlockTokenizer() mismatch (-want +got):\n%s", diff)
 	}
 }
+
+func FuzzCodeBlockTokenizer(f *testing.F) {
+	for _, seed := range []string{"", "hello", "abcd[[ efg ]]hi[# jk #]lmn", "[[[#]]#]", "[[]", "#]]"} {
+		f.Add(seed)
+	}
+
+	f.Fuzz(func(t *testing.T, d string) {
+		got := codeBlockTokenizer(d)
+		if len(got) == 0 {
+			t.Fatalf("codeBlockTokenizer(%q) returned no elements", d)
+		}
+
+		// The tokens are split without losing or changing any data
+		if joined := strings.Join(got, ""); joined != d {
+			t.Errorf("codeBlockTokenizer(%q) joined to %q", d, joined)
+		}
+
+		// Tokens and char data alternate, beginning and ending with char data
+		for i := range got {
+			if isToken(got[i]) != (i%2 == 1) {
+				t.Errorf("codeBlockTokenizer(%q) has %q at unexpected index %d", d, got[i], i)
+			}
+		}
+	})
+}
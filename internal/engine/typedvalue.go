@@ -0,0 +1,152 @@
+package engine
+
+import (
+	"encoding/xml"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/Shopify/go-lua"
+	"github.com/djboris9/xmltree"
+	"github.com/microfast-ch/rea/internal/stdlib"
+
+	goluagoUtil "github.com/Shopify/goluago/util"
+)
+
+// Types of printed values that can be set on the enclosing element.
+const (
+	ValueTypeFloat = "float"
+	ValueTypeDate  = "date"
+)
+
+// TypedValue is a number or date that was printed to the document. The value
+// is formatted as in ODF: floats in decimal notation, dates as ISO 8601.
+type TypedValue struct {
+	Type  string
+	Value string
+}
+
+// ValueTyper is implemented by formats that support typed values, like cells of spreadsheets.
+// TypeValue returns the element with the value set, if the element can hold a typed value.
+type ValueTyper interface {
+	TypeValue(elem xml.StartElement, value TypedValue) (xml.StartElement, bool)
+}
+
+// SetValueTyper sets the typer for printed numbers and dates. If an element of the
+// resulting document contains nothing but such a value, the element is passed to the typer.
+func (e *LuaEngine) SetValueTyper(typer ValueTyper) {
+	e.valueTyper = typer
+}
+
+// toTypedValue returns the typed value of the lua value at the given index, if it is a number or date.
+func toTypedValue(state *lua.State, index int) (TypedValue, bool) {
+	if state.TypeOf(index) == lua.TypeNumber {
+		f, _ := state.ToNumber(index)
+		return TypedValue{Type: ValueTypeFloat, Value: strconv.FormatFloat(f, 'f', -1, 64)}, true
+	}
+
+	if t, ok := stdlib.ToDate(state, index); ok {
+		layout := "2006-01-02T15:04:05"
+		if t.Hour() == 0 && t.Minute() == 0 && t.Second() == 0 {
+			layout = "2006-01-02"
+		}
+
+		return TypedValue{Type: ValueTypeDate, Value: t.Format(layout)}, true
+	}
+
+	return TypedValue{}, false
+}
+
+// valueFrame collects the text and the typed prints inside an element of the nodePath.
+type valueFrame struct {
+	start int // Index of the StartElement in the nodePath
+	text  strings.Builder
+	typed []*xmltree.Node
+}
+
+// applyValueTypes passes the elements of the nodePath, that contain exactly one typed
+// print and no other text, to the value typer. The innermost element that is typed wins.
+func (e *LuaEngine) applyValueTypes() {
+	stack := []*valueFrame{{start: -1}}
+
+	for i, node := range e.nodePath {
+		top := stack[len(stack)-1]
+
+		switch tok := node.Token.(type) {
+		case xml.StartElement:
+			stack = append(stack, &valueFrame{start: i})
+		case xml.CharData:
+			top.text.Write(tok)
+
+			if _, ok := e.typedValues[node]; ok {
+				top.typed = append(top.typed, node)
+			}
+		case xml.EndElement:
+			if len(stack) == 1 {
+				continue
+			}
+
+			stack = stack[:len(stack)-1]
+			parent := stack[len(stack)-1]
+			parent.text.WriteString(top.text.String())
+
+			if !e.typeElement(top) {
+				parent.typed = append(parent.typed, top.typed...)
+			}
+		}
+	}
+}
+
+// typeElement types the element of the frame and reports if it was typed.
+func (e *LuaEngine) typeElement(f *valueFrame) bool {
+	if len(f.typed) != 1 {
+		return false
+	}
+
+	printed := string(f.typed[0].Token.(xml.CharData))
+	if strings.TrimSpace(f.text.String()) != strings.TrimSpace(printed) {
+		return false
+	}
+
+	node := e.nodePath[f.start]
+
+	elem, ok := e.valueTyper.TypeValue(node.Token.(xml.StartElement), e.typedValues[f.typed[0]])
+	if !ok {
+		return false
+	}
+
+	// The node of the template is shared by all iterations, so it is replaced by a copy
+	e.nodePath[f.start] = &xmltree.Node{
+		Token:  elem,
+		Parent: node.Parent,
+		Nodes:  node.Nodes,
+	}
+
+	return true
+}
+
+// pushData pushes a value of the model on the stack. Dates are pushed as date
+// tables, all other values are handled by DeepPush.
+func pushData(l *lua.State, v any) {
+	switch v := v.(type) {
+	case time.Time:
+		stdlib.PushDate(l, v)
+	case map[string]any:
+		l.CreateTable(0, len(v))
+
+		for key, value := range v {
+			l.PushString(key)
+			pushData(l, value)
+			l.RawSet(-3)
+		}
+	case []any:
+		l.CreateTable(len(v), 0)
+
+		for i, value := range v {
+			pushData(l, value)
+			l.RawSetInt(-2, i+1)
+		}
+	default:
+		goluagoUtil.DeepPush(l, v)
+	}
+}
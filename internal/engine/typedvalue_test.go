@@ -0,0 +1,71 @@
+package engine
+
+import (
+	"encoding/xml"
+	"testing"
+	"time"
+
+	"github.com/djboris9/xmltree"
+	"github.com/google/go-cmp/cmp"
+)
+
+// cellTyper types `cell` elements by setting the attributes `type` and `value`.
+type cellTyper struct{}
+
+func (cellTyper) TypeValue(elem xml.StartElement, value TypedValue) (xml.StartElement, bool) {
+	if elem.Name.Local != "cell" {
+		return elem, false
+	}
+
+	elem.Attr = []xml.Attr{
+		{Name: xml.Name{Local: "type"}, Value: value.Type},
+		{Name: xml.Name{Local: "value"}, Value: value.Value},
+	}
+
+	return elem, true
+}
+
+func TestTypedValues(t *testing.T) {
+	testdata := xml.Header + `
+<table>[[ for i=1,2 do ]]<row><cell type="string"><p>[# i * 1.5 #]</p></cell><cell><p>No [# i #]</p></cell><cell>[# "3" #]</cell>` +
+		`<cell>[# day #]</cell><cell>[# date(2022, 1, 31, 8, 0, 0) #]</cell></row>[[ end ]]</table>`
+
+	wantXML := xml.Header + `
+<table><row><cell type="float" value="1.5"><p>1.5</p></cell><cell><p>No 1</p></cell><cell>3</cell>` +
+		`<cell type="date" value="2022-01-31">2022-01-31</cell><cell type="date" value="2022-01-31T08:00:00">2022-01-31 08:00:00</cell></row>` +
+		`<row><cell type="float" value="3"><p>3</p></cell><cell><p>No 2</p></cell><cell>3</cell>` +
+		`<cell type="date" value="2022-01-31">2022-01-31</cell><cell type="date" value="2022-01-31T08:00:00">2022-01-31 08:00:00</cell></row></table>`
+
+	tree, err := xmltree.Parse([]byte(testdata))
+	if err != nil {
+		t.Fatalf("parsing tree: %v", err)
+	}
+
+	lt, err := NewLuaTree(tree)
+	if err != nil {
+		t.Fatalf("creating lua tree: %v", err)
+	}
+
+	e := NewLuaEngine(lt, &TemplateData{Data: map[string]any{
+		"day": time.Date(2022, 1, 31, 0, 0, 0, 0, time.UTC),
+	}})
+	e.SetValueTyper(cellTyper{})
+
+	if err := e.Exec(`SetIterationNodes({"row"})`); err != nil {
+		t.Fatalf("executing lua engine: %s", err)
+	}
+
+	if diff := cmp.Diff(wantXML, serializeNodePath(t, e.nodePath)); diff != "" {
+		t.Errorf("nodePath as XML mismatch (-want +got):\n%s", diff)
+		t.Log(e.lt.LuaProg)
+	}
+
+	// The template nodes stay untouched
+	for _, node := range lt.NodeList {
+		if elem, ok := node.Token.(xml.StartElement); ok && elem.Name.Local == "cell" && len(elem.Attr) > 0 {
+			if diff := cmp.Diff(`string`, elem.Attr[0].Value); diff != "" {
+				t.Errorf("template node was modified (-want +got):\n%s", diff)
+			}
+		}
+	}
+}
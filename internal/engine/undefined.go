@@ -0,0 +1,108 @@
+package engine
+
+import (
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/Shopify/go-lua"
+	"github.com/microfast-ch/rea/internal/utils"
+)
+
+var ErrUndefined = errors.New("undefinedErr")
+
+// UndefinedMode defines how undefined variables and printed nil values are handled.
+type UndefinedMode int
+
+const (
+	// UndefinedNil prints nil values as `nil`, undefined variables are nil.
+	UndefinedNil UndefinedMode = iota
+	// UndefinedStrict fails on undefined variables and printed nil values, e.g. because of a typo.
+	// Globals that are assigned by the template, like `total = (total or 0) + x`, are nil until assigned.
+	UndefinedStrict
+	// UndefinedLenient prints nil values as empty strings.
+	UndefinedLenient
+)
+
+// SetUndefinedMode sets how undefined variables and printed nil values are handled. Defaults to UndefinedNil.
+func (e *LuaEngine) SetUndefinedMode(mode UndefinedMode) {
+	e.undefinedMode = mode
+}
+
+// setGlobalsMetaTable sets a metatable on the globals, so reading undefined variables
+// can fail in strict mode.
+func (e *LuaEngine) setGlobalsMetaTable(l *lua.State) {
+	l.PushGlobalTable()
+	l.CreateTable(0, 1)
+	l.PushGoFunction(e.iIndexGlobal)
+	l.SetField(-2, "__index")
+	l.SetMetaTable(-2)
+	l.Pop(1)
+}
+
+// iIndexGlobal is called for variables that are neither in the model nor defined by the template.
+// Globals that the template assigns later are read before their assignment and are nil.
+func (e *LuaEngine) iIndexGlobal(state *lua.State) int {
+	if e.undefinedMode == UndefinedStrict {
+		if e.templateGlobals == nil {
+			e.templateGlobals = readVariables(e.lt.LuaProg).globals
+		}
+
+		name, _ := state.ToString(2)
+		if e.templateGlobals[name] {
+			state.PushNil()
+			return 1
+		}
+
+		e.raise(state, utils.FormatError(ErrUndefined, fmt.Sprintf("undefined variable %q at %s", name, e.location(state))))
+	}
+
+	state.PushNil()
+
+	return 1
+}
+
+// raise raises a lua error. Exec returns the error itself, so it can be checked with errors.Is.
+func (e *LuaEngine) raise(state *lua.State, err error) {
+	e.raised = err
+	lua.Errorf(state, "%s", err.Error())
+	panic("unreachable")
+}
+
+// location describes the position in the template of the lua code that called the
+// running Go function: the line of the lua program, its code and the enclosing elements.
+func (e *LuaEngine) location(state *lua.State) string {
+	frame, ok := lua.Stack(state, 1)
+	if !ok {
+		return "unknown location"
+	}
+
+	d, ok := lua.Info(state, "Sl", frame)
+	if !ok || d.CurrentLine <= 0 {
+		return "unknown location"
+	}
+
+	if d.Source != e.lt.LuaProg {
+		return fmt.Sprintf("line %d of init script", d.CurrentLine)
+	}
+
+	loc := fmt.Sprintf("line %d", d.CurrentLine)
+
+	if d.CurrentLine <= strings.Count(e.lt.LuaProg, "\n")+1 {
+		loc += fmt.Sprintf(" %q", e.lt.CodeLine(d.CurrentLine))
+	}
+
+	if len(e.parentStack) > 0 {
+		names := make([]string, len(e.parentStack))
+		for i, node := range e.parentStack {
+			if elem, ok := node.Token.(xml.StartElement); ok {
+				names[i] = elem.Name.Local
+			}
+		}
+
+		loc += " in " + strings.Join(names, "/")
+	}
+
+	return loc
+}
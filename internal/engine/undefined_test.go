@@ -0,0 +1,119 @@
+package engine
+
+import (
+	"encoding/xml"
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestUndefinedMode(t *testing.T) {
+	testdata := xml.Header + `<p><name>[# customer.firstnmae #]</name>[[ local x = 1 ]]<x>[# x #] [# customer.lastname #]</x></p>`
+	model := &TemplateData{Data: map[string]any{
+		"customer": map[string]any{"firstname": "Ada", "lastname": "Lovelace"},
+	}}
+
+	tests := []struct {
+		mode    UndefinedMode
+		wantXML string
+	}{
+		{UndefinedNil, xml.Header + "<p><name>nil</name><x>1 Lovelace</x></p>"},
+		{UndefinedLenient, xml.Header + "<p><name></name><x>1 Lovelace</x></p>"},
+	}
+
+	for _, tc := range tests {
+		e := newTestEngine(t, testdata, model)
+		e.SetUndefinedMode(tc.mode)
+
+		if err := e.Exec(""); err != nil {
+			t.Fatalf("executing lua engine with mode %d: %s", tc.mode, err)
+		}
+
+		if diff := cmp.Diff(tc.wantXML, serializeNodePath(t, e.nodePath)); diff != "" {
+			t.Errorf("nodePath with mode %d as XML mismatch (-want +got):\n%s", tc.mode, diff)
+		}
+	}
+
+	e := newTestEngine(t, testdata, model)
+	e.SetUndefinedMode(UndefinedStrict)
+
+	err := e.Exec("")
+	if !errors.Is(err, ErrUndefined) {
+		t.Fatalf("expected ErrUndefined, got %v", err)
+	}
+
+	if !strings.Contains(err.Error(), `printed value is nil at line 5 "Print( customer.firstnmae )" in p/name`) {
+		t.Errorf("error doesn't point at the print: %v", err)
+	}
+}
+
+func TestUndefinedLenientSeparator(t *testing.T) {
+	// Skipped nil values have no separator
+	tests := []struct {
+		mode    UndefinedMode
+		wantXML string
+	}{
+		{UndefinedNil, xml.Header + "<p>nil&#x9;a&#x9;nil&#x9;b</p>"},
+		{UndefinedLenient, xml.Header + "<p>a&#x9;b</p>"},
+	}
+
+	for _, tc := range tests {
+		e := newTestEngine(t, xml.Header+`<p>[# nil, "a", nil, "b" #]</p>`, nil)
+		e.SetUndefinedMode(tc.mode)
+
+		if err := e.Exec(""); err != nil {
+			t.Fatalf("executing lua engine with mode %d: %s", tc.mode, err)
+		}
+
+		if diff := cmp.Diff(tc.wantXML, serializeNodePath(t, e.nodePath)); diff != "" {
+			t.Errorf("nodePath with mode %d as XML mismatch (-want +got):\n%s", tc.mode, diff)
+		}
+	}
+}
+
+func TestUndefinedStrictGlobals(t *testing.T) {
+	tests := []struct {
+		template string
+		wantErr  string
+	}{
+		{`<p>[# custmer.name #]</p>`, `undefined variable "custmer" at line 4 "Print( custmer.name )" in p`},
+		{`<p>[[ if epay then ]]<b>pay</b>[[ end ]]</p>`, `undefined variable "epay" at line 4 "if epay then" in p`},
+		{`<p>[[ total = 3 ]][# total, metadata.author, date(2022, 1, 31) #]</p>`, ""},
+		// Globals of the template are nil before they are assigned, other names are still reported
+		{`<p>[[ for i=1,3 do total = (total or 0) + i end ]][# total #]</p>`, ""},
+		{`<p>[[ total = (totl or 0) + 1 ]][# total #]</p>`, `undefined variable "totl" at line 4`},
+	}
+
+	for _, tc := range tests {
+		e := newTestEngine(t, xml.Header+tc.template, &TemplateData{
+			Data:     map[string]any{"customer": map[string]any{"name": "Ada"}},
+			Metadata: map[string]string{"author": "Bob"},
+		})
+		e.SetUndefinedMode(UndefinedStrict)
+
+		err := e.Exec(`SetIterationNodes({"p"})`)
+
+		if tc.wantErr == "" {
+			if err != nil {
+				t.Errorf("executing %s: %v", tc.template, err)
+			}
+
+			continue
+		}
+
+		if !errors.Is(err, ErrUndefined) || !strings.Contains(err.Error(), tc.wantErr) {
+			t.Errorf("expected error %q for %s, got %v", tc.wantErr, tc.template, err)
+		}
+	}
+
+	// Undefined variables of the init script have no template location
+	e := newTestEngine(t, xml.Header+`<p/>`, nil)
+	e.SetUndefinedMode(UndefinedStrict)
+
+	err := e.Exec(`SetIterationNodes(nodes)`)
+	if !errors.Is(err, ErrUndefined) || !strings.Contains(err.Error(), `undefined variable "nodes" at line 1 of init script`) {
+		t.Errorf("expected undefined error in init script, got %v", err)
+	}
+}
@@ -0,0 +1,613 @@
+package engine
+
+import (
+	"encoding/xml"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/Shopify/go-lua"
+	"github.com/microfast-ch/rea/internal/safelua"
+	"github.com/microfast-ch/rea/internal/stdlib"
+)
+
+// ItemsSegment is the path segment for the items of an array, like in `order.items[].title`.
+const ItemsSegment = "[]"
+
+// VarUse is a model value that is read by a lua program.
+type VarUse struct {
+	Path    []string // Segments of the path, like `order`, `items`, ItemsSegment, `title`
+	Line    int      // Line of the lua program
+	Binding string   // Loop variable through which the value is read, if any
+}
+
+// VarBinding is a loop variable that is bound to the items of a model value.
+type VarBinding struct {
+	Name string
+	Path []string
+	Line int
+}
+
+// builtins are the globals of the engine, which are not part of the model.
+var builtins = builtinNames()
+
+// builtinNames returns the names of the functions that NewLuaEngine registers.
+func builtinNames() map[string]bool {
+	names := map[string]bool{}
+
+	for _, functions := range [][]lua.RegistryFunction{(&LuaEngine{}).functions(), safelua.Functions, stdlib.Functions} {
+		for _, f := range functions {
+			names[f.Name] = true
+		}
+	}
+
+	return names
+}
+
+var luaKeywords = map[string]bool{
+	"and": true, "break": true, "do": true, "else": true, "elseif": true, "end": true, "false": true,
+	"for": true, "function": true, "goto": true, "if": true, "in": true, "local": true, "nil": true,
+	"not": true, "or": true, "repeat": true, "return": true, "then": true, "true": true, "until": true,
+	"while": true,
+}
+
+// nodeCall matches the lines of a lua tree that start or end an element.
+var nodeCall = regexp.MustCompile(`^\s*(StartNode|EndNode)\((\d+)\)`)
+
+// FormatVarPath returns the path as written in templates, like `order.items[].title`.
+func FormatVarPath(path []string) string {
+	formatted := ""
+
+	for _, segment := range path {
+		if segment == ItemsSegment {
+			formatted += segment
+		} else {
+			formatted = ChildPath(formatted, segment)
+		}
+	}
+
+	return formatted
+}
+
+// CodeLine returns the code of the line of the lua program, without the comments of the lua tree.
+func (t *LuaTree) CodeLine(line int) string {
+	lines := strings.Split(t.LuaProg, "\n")
+	if line < 1 || line > len(lines) {
+		return ""
+	}
+
+	code := strings.TrimSuffix(strings.TrimSuffix(lines[line-1], " -- PrintBlock"), " -- CodeBlock")
+
+	return strings.TrimSpace(code)
+}
+
+// ElementPaths returns the local names of the elements that enclose each line of the lua program,
+// like `document-content/body/text/p`. The path of a line is at the index of the line minus one.
+func (t *LuaTree) ElementPaths() []string {
+	lines := strings.Split(t.LuaProg, "\n")
+	paths := make([]string, len(lines))
+
+	var stack []string
+
+	for i, line := range lines {
+		paths[i] = strings.Join(stack, "/")
+
+		m := nodeCall.FindStringSubmatch(line)
+		if m == nil {
+			continue
+		}
+
+		id, err := strconv.Atoi(m[2])
+		if err != nil || id >= len(t.NodeList) {
+			continue
+		}
+
+		switch {
+		case m[1] == "StartNode":
+			if elem, ok := t.NodeList[id].Token.(xml.StartElement); ok {
+				stack = append(stack, elem.Name.Local)
+			}
+		case len(stack) > 0:
+			stack = stack[:len(stack)-1]
+		}
+	}
+
+	return paths
+}
+
+// ReadVariables returns the model values that are read by the lua program and the loop variables that
+// are bound to them. Loops over model values with each and ipairs bind the value variable to the items
+// of the iterated value. Local names are scoped to their block, so a name that is read after the block
+// is a model value again. The analysis is static, values that are assigned to other variables are not followed.
+func ReadVariables(prog string) ([]VarUse, []VarBinding) {
+	r := readVariables(prog)
+
+	return r.uses, r.bindings
+}
+
+// readVariables reads the lua program, the reader holds the uses, bindings and the globals of the program.
+func readVariables(prog string) *varReader {
+	toks := luaTokens(prog)
+
+	r := &varReader{
+		toks:    toks,
+		globals: map[string]bool{},
+		scopes:  []map[string][]string{{}},
+	}
+
+	braces := 0 // Depth of table constructors, in which `name =` is a field
+
+	for i := 0; i < len(toks); i++ {
+		tok := toks[i].text
+
+		for len(r.closeAt) > 0 && r.closeAt[len(r.closeAt)-1] < i {
+			r.close()
+			r.closeAt = r.closeAt[:len(r.closeAt)-1]
+		}
+
+		switch {
+		case tok == "{":
+			braces++
+		case tok == "}":
+			braces--
+		case braces > 0 && isLuaName(tok) && r.next(i) == "=":
+			continue
+		case tok == "local":
+			i = r.declareNames(i + 1)
+		case tok == "function":
+			// Global functions, like `function total(items)`, belong to the template
+			if isLuaName(r.next(i)) && r.next(i+1) == "(" {
+				r.globals[toks[i+1].text] = true
+			}
+
+			r.open()
+			i = r.declareParams(i + 1)
+		case tok == "for":
+			// The loop variables are local to the block of the loop
+			r.open()
+			r.loop = true
+			i = r.declareLoop(i + 1)
+		case tok == "do":
+			if r.loop {
+				r.loop = false
+			} else {
+				r.open()
+			}
+		case tok == "then" || tok == "repeat":
+			r.open()
+		case tok == "else":
+			r.close()
+			r.open()
+		case tok == "elseif" || tok == "end":
+			r.close()
+		case tok == "until":
+			// The locals of the repeat block are visible in the until expression
+			r.closeAt = append(r.closeAt, r.expressionEnd(i+1))
+		case isLuaName(tok) && !luaKeywords[tok] && (i == 0 || toks[i-1].text != "." && toks[i-1].text != ":"):
+			start := i
+
+			var path []string
+
+			path, i = r.readPath(i)
+
+			// Assigned globals belong to the template
+			if len(path) == 1 && r.next(i) == "=" {
+				if _, ok := r.lookup(path[0]); !ok {
+					r.globals[path[0]] = true
+				}
+
+				continue
+			}
+
+			if path, binding, ok := r.resolve(path); ok {
+				r.uses = append(r.uses, VarUse{Path: path, Line: toks[start].line, Binding: binding})
+			}
+		}
+	}
+
+	return r
+}
+
+// varReader holds the state of ReadVariables.
+type varReader struct {
+	toks     []luaToken
+	globals  map[string]bool       // Globals that are assigned or declared as function by the program
+	scopes   []map[string][]string // Local names of the open blocks, with the bound path for loop variables
+	loop     bool                  // The next `do` is the one of a for loop, whose block is open already
+	closeAt  []int                 // Last tokens of until expressions, after which the repeat block is closed
+	uses     []VarUse
+	bindings []VarBinding
+}
+
+// open opens the scope of a block.
+func (r *varReader) open() {
+	r.scopes = append(r.scopes, map[string][]string{})
+}
+
+// close closes the scope of the innermost block. The scope of the program itself stays open.
+func (r *varReader) close() {
+	if len(r.scopes) > 1 {
+		r.scopes = r.scopes[:len(r.scopes)-1]
+	}
+}
+
+// declare declares the local name in the innermost block. Loop variables are bound
+// to the items of a model value, other names are declared with a nil path.
+func (r *varReader) declare(name string, bound []string) {
+	r.scopes[len(r.scopes)-1][name] = bound
+}
+
+// lookup returns the bound path of the local name and if it is declared in an open block.
+func (r *varReader) lookup(name string) ([]string, bool) {
+	for i := len(r.scopes) - 1; i >= 0; i-- {
+		if bound, ok := r.scopes[i][name]; ok {
+			return bound, true
+		}
+	}
+
+	return nil, false
+}
+
+// next returns the token after i, or an empty string at the end.
+func (r *varReader) next(i int) string {
+	if i+1 < len(r.toks) {
+		return r.toks[i+1].text
+	}
+
+	return ""
+}
+
+// resolve returns the path of the model value, with loop variables replaced by the value they are
+// bound to. It returns false if the path is not a model value.
+func (r *varReader) resolve(path []string) ([]string, string, bool) {
+	if bound, ok := r.lookup(path[0]); ok {
+		if bound == nil {
+			return nil, "", false
+		}
+
+		return append(append([]string{}, bound...), path[1:]...), path[0], true
+	}
+
+	if r.globals[path[0]] || builtins[path[0]] {
+		return nil, "", false
+	}
+
+	return path, "", true
+}
+
+// readPath reads the path of names and indexes that starts at i. It returns the path and
+// the index of its last token. Calls of methods end the path.
+func (r *varReader) readPath(i int) ([]string, int) {
+	path := []string{r.toks[i].text}
+
+	for i+1 < len(r.toks) {
+		switch {
+		case r.next(i) == "." && isLuaName(r.next(i+1)):
+			path = append(path, r.toks[i+2].text)
+			i += 2
+		case r.next(i) == "[":
+			end := r.closingBracket(i + 1)
+			if end == i+3 && isStringLiteral(r.toks[i+2].text) {
+				key := r.toks[i+2].text
+				path = append(path, key[1:len(key)-1])
+			} else {
+				path = append(path, ItemsSegment)
+			}
+
+			i = end
+		default:
+			return path, i
+		}
+	}
+
+	return path, i
+}
+
+// declareNames declares the names of a local statement, like `local a, b = ...` or `local function f`.
+func (r *varReader) declareNames(i int) int {
+	if i < len(r.toks) && r.toks[i].text == "function" {
+		if i+1 < len(r.toks) {
+			r.declare(r.toks[i+1].text, nil)
+		}
+
+		r.open()
+
+		return r.declareParams(i + 1)
+	}
+
+	for ; i < len(r.toks) && isLuaName(r.toks[i].text); i++ {
+		r.declare(r.toks[i].text, nil)
+
+		if r.next(i) != "," {
+			return i
+		}
+
+		i++
+	}
+
+	return i - 1
+}
+
+// declareParams declares the parameters of a function in the open scope of its body,
+// starting after the `function` keyword.
+func (r *varReader) declareParams(i int) int {
+	for i < len(r.toks) && r.toks[i].text != "(" {
+		i++
+	}
+
+	for i++; i < len(r.toks) && r.toks[i].text != ")"; i++ {
+		if isLuaName(r.toks[i].text) {
+			r.declare(r.toks[i].text, nil)
+		}
+	}
+
+	return i
+}
+
+// declareLoop declares the variables of a for loop in the open scope of its block and binds the value
+// variable of loops over model values with each and ipairs. The iterated value is read by the program itself.
+func (r *varReader) declareLoop(i int) int {
+	var names []string
+
+	for ; i < len(r.toks) && isLuaName(r.toks[i].text); i += 2 {
+		names = append(names, r.toks[i].text)
+
+		if r.next(i) != "," {
+			i++
+			break
+		}
+	}
+
+	for _, name := range names {
+		r.declare(name, nil)
+	}
+
+	// Generic for: `in each(...)` or `in ipairs(...)` with a path as argument
+	if len(names) == 0 || i+3 >= len(r.toks) || r.toks[i].text != "in" || r.next(i+1) != "(" || !isLuaName(r.toks[i+3].text) {
+		return i - 1
+	}
+
+	path, end := r.readPath(i + 3)
+	if r.next(end) != ")" {
+		return i - 1
+	}
+
+	path, _, ok := r.resolve(path)
+	if !ok {
+		return i - 1
+	}
+
+	name := ""
+
+	switch {
+	case r.toks[i+1].text == "each":
+		name = names[0]
+	case r.toks[i+1].text == "ipairs" && len(names) > 1:
+		name = names[1]
+	default:
+		return i - 1
+	}
+
+	bound := append(append([]string{}, path...), ItemsSegment)
+	r.declare(name, bound)
+	r.bindings = append(r.bindings, VarBinding{Name: name, Path: bound, Line: r.toks[i].line})
+
+	return i - 1
+}
+
+// expressionEnd returns the index of the last token of the expression that starts at i.
+func (r *varReader) expressionEnd(i int) int {
+	depth := 0
+
+	for ; i < len(r.toks); i++ {
+		tok := r.toks[i].text
+
+		switch tok {
+		case "(", "[", "{", "function":
+			depth++
+			continue
+		case ")", "]", "}", "end":
+			depth--
+		default:
+			// Operators and keywords are followed by an operand
+			if !isOperand(tok) {
+				continue
+			}
+		}
+
+		if depth > 0 {
+			continue
+		}
+
+		// Binary operators, indexes and calls continue the expression
+		next := r.next(i)
+		if !luaOperators[next] && next != "." && next != ":" && next != "(" && next != "{" &&
+			!strings.HasPrefix(next, "[") && !isStringLiteral(next) {
+			return i
+		}
+	}
+
+	return len(r.toks) - 1
+}
+
+// closingBracket returns the index of the bracket that closes the bracket at i.
+func (r *varReader) closingBracket(i int) int {
+	depth := 0
+
+	for ; i < len(r.toks); i++ {
+		switch r.toks[i].text {
+		case "[":
+			depth++
+		case "]":
+			depth--
+			if depth == 0 {
+				return i
+			}
+		}
+	}
+
+	return len(r.toks) - 1
+}
+
+func isLuaName(tok string) bool {
+	return tok != "" && Identifier.MatchString(tok)
+}
+
+// luaOperators are the binary operators, operators of two characters like `//` are split by luaTokens.
+var luaOperators = map[string]bool{
+	"+": true, "-": true, "*": true, "/": true, "%": true, "^": true, "..": true, "==": true, "~=": true,
+	"<": true, "<=": true, ">": true, ">=": true, "&": true, "|": true, "~": true, "and": true, "or": true,
+}
+
+// isOperand reports if the token is a name, literal or vararg, which can end an expression.
+func isOperand(tok string) bool {
+	switch {
+	case tok == "true" || tok == "false" || tok == "nil" || tok == "...":
+		return true
+	case isLuaName(tok):
+		return !luaKeywords[tok]
+	default:
+		isNumber := tok != "" && (tok[0] >= '0' && tok[0] <= '9' || len(tok) > 1 && tok[0] == '.' && tok[1] >= '0' && tok[1] <= '9')
+
+		// Strings, with long brackets like `[[text]]`
+		return isNumber || tok[0] == '"' || tok[0] == '\'' || len(tok) > 1 && tok[0] == '['
+	}
+}
+
+func isStringLiteral(tok string) bool {
+	return len(tok) >= 2 && (tok[0] == '"' || tok[0] == '\'') && tok[len(tok)-1] == tok[0] && !strings.Contains(tok, `\`)
+}
+
+// luaToken is a name, literal or operator of a lua program.
+type luaToken struct {
+	text string
+	line int
+}
+
+// luaTokens splits a lua program into names, literals and operators. Comments are skipped.
+func luaTokens(prog string) []luaToken {
+	var toks []luaToken
+
+	// Lines are looked up by the offset of the tokens
+	var newlines []int
+
+	for i := range prog {
+		if prog[i] == '\n' {
+			newlines = append(newlines, i)
+		}
+	}
+
+	add := func(start, end int) {
+		toks = append(toks, luaToken{text: prog[start:end], line: sort.SearchInts(newlines, start) + 1})
+	}
+
+	for i := 0; i < len(prog); {
+		c := prog[i]
+
+		switch {
+		case c == ' ' || c == '\t' || c == '\n' || c == '\r':
+			i++
+		case strings.HasPrefix(prog[i:], "--"):
+			i = skipComment(prog, i+2)
+		case c == '"' || c == '\'':
+			end := quotedEnd(prog, i)
+			add(i, end)
+			i = end
+		case c == '[' && longBracketLevel(prog, i) >= 0:
+			end := longBracketEnd(prog, i)
+			add(i, end)
+			i = end
+		case c == '_' || c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z':
+			end := i + 1
+			for end < len(prog) && isNameChar(prog[end]) {
+				end++
+			}
+
+			add(i, end)
+			i = end
+		case c >= '0' && c <= '9' || c == '.' && i+1 < len(prog) && prog[i+1] >= '0' && prog[i+1] <= '9':
+			end := i + 1
+			for end < len(prog) && (isNameChar(prog[end]) || prog[end] == '.') {
+				end++
+			}
+
+			add(i, end)
+			i = end
+		default:
+			// Operators of two or three characters are kept together, so `==` is not an assignment
+			end := i + 1
+
+			for _, long := range []string{"...", "..", "==", "~=", "<=", ">=", "::"} {
+				if strings.HasPrefix(prog[i:], long) {
+					end = i + len(long)
+					break
+				}
+			}
+
+			add(i, end)
+			i = end
+		}
+	}
+
+	return toks
+}
+
+func isNameChar(c byte) bool {
+	return c == '_' || c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9'
+}
+
+// skipComment returns the index after the comment whose content starts at i.
+func skipComment(prog string, i int) int {
+	if i < len(prog) && prog[i] == '[' && longBracketLevel(prog, i) >= 0 {
+		return longBracketEnd(prog, i)
+	}
+
+	if end := strings.IndexByte(prog[i:], '\n'); end >= 0 {
+		return i + end + 1
+	}
+
+	return len(prog)
+}
+
+// quotedEnd returns the index after the quoted string that starts at i.
+func quotedEnd(prog string, i int) int {
+	quote := prog[i]
+
+	for i++; i < len(prog); i++ {
+		switch prog[i] {
+		case '\\':
+			i++
+		case quote, '\n':
+			return i + 1
+		}
+	}
+
+	return len(prog)
+}
+
+// longBracketLevel returns the level of the long bracket at i, like 1 for `[=[`, or -1 if there is none.
+func longBracketLevel(prog string, i int) int {
+	level := 0
+
+	for i++; i < len(prog) && prog[i] == '='; i++ {
+		level++
+	}
+
+	if i < len(prog) && prog[i] == '[' {
+		return level
+	}
+
+	return -1
+}
+
+// longBracketEnd returns the index after the long bracket string or comment that starts at i.
+func longBracketEnd(prog string, i int) int {
+	closing := "]" + strings.Repeat("=", longBracketLevel(prog, i)) + "]"
+
+	if end := strings.Index(prog[i:], closing); end >= 0 {
+		return i + end + len(closing)
+	}
+
+	return len(prog)
+}
@@ -0,0 +1,148 @@
+package engine
+
+import (
+	"encoding/xml"
+	"strings"
+	"testing"
+
+	"github.com/djboris9/xmltree"
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestReadVariables(t *testing.T) {
+	prog := `
+ StartNode(1) --  p
+ Print( customer.name ) -- PrintBlock
+ for item, loop in each(order.items) do  -- CodeBlock
+  Print( item.price, loop.index ) -- PrintBlock
+  for _, tag in ipairs(item.tags) do -- CodeBlock
+   Print( tag.label ) -- PrintBlock
+  end -- CodeBlock
+ end -- CodeBlock
+ local total = 0 -- CodeBlock
+ for i = 1, #order.lines do total = total + order.lines[i].amount end -- CodeBlock
+ summary = { title = customer["first name"], count = 3 } -- CodeBlock
+ if epay and summary.title ~= "x.y" then Print(date(2022, 1, 31), metadata.author) end -- CodeBlock
+ function fmt(value) return tostring(value) end -- CodeBlock
+ Print( fmt(vat.rate) ) -- comment.with.dots
+ --[[ ignored.block ]] Print( [[long.string]] )
+ EndNode(2) --  p
+`
+
+	uses, bindings := ReadVariables(prog)
+
+	wantUses := []VarUse{
+		{Path: []string{"customer", "name"}, Line: 3},
+		{Path: []string{"order", "items"}, Line: 4},
+		{Path: []string{"order", "items", "[]", "price"}, Line: 5, Binding: "item"},
+		{Path: []string{"order", "items", "[]", "tags"}, Line: 6, Binding: "item"},
+		{Path: []string{"order", "items", "[]", "tags", "[]", "label"}, Line: 7, Binding: "tag"},
+		{Path: []string{"order", "lines"}, Line: 11},
+		{Path: []string{"order", "lines", "[]", "amount"}, Line: 11},
+		{Path: []string{"customer", "first name"}, Line: 12},
+		{Path: []string{"epay"}, Line: 13},
+		{Path: []string{"metadata", "author"}, Line: 13},
+		{Path: []string{"vat", "rate"}, Line: 15},
+	}
+	if diff := cmp.Diff(wantUses, uses); diff != "" {
+		t.Errorf("ReadVariables() uses mismatch (-want +got):\n%s", diff)
+	}
+
+	wantBindings := []VarBinding{
+		{Name: "item", Path: []string{"order", "items", "[]"}, Line: 4},
+		{Name: "tag", Path: []string{"order", "items", "[]", "tags", "[]"}, Line: 6},
+	}
+	if diff := cmp.Diff(wantBindings, bindings); diff != "" {
+		t.Errorf("ReadVariables() bindings mismatch (-want +got):\n%s", diff)
+	}
+}
+
+func TestReadVariablesScopes(t *testing.T) {
+	// Local names end with their block, the same names after it are model values
+	prog := `
+for _, item in ipairs(items) do Print( item.name ) end Print( item )
+if x then local name = 1 Print( name ) end Print( name )
+if x then elseif y then local a = 1 else Print( a ) end
+while x do local b = 1 end Print( b )
+do local c = 1 end repeat local d = 1 until d == x Print( c, d )
+local function f(e) return e end Print( f(1), e )
+for i = 1, 2 do local g = i end Print( i, g )
+local h = 1 if x then Print( h ) end
+`
+
+	uses, _ := ReadVariables(prog)
+
+	wantUses := []VarUse{
+		{Path: []string{"items"}, Line: 2},
+		{Path: []string{"items", "[]", "name"}, Line: 2, Binding: "item"},
+		{Path: []string{"item"}, Line: 2},
+		{Path: []string{"x"}, Line: 3},
+		{Path: []string{"name"}, Line: 3},
+		{Path: []string{"x"}, Line: 4},
+		{Path: []string{"y"}, Line: 4},
+		{Path: []string{"a"}, Line: 4},
+		{Path: []string{"x"}, Line: 5},
+		{Path: []string{"b"}, Line: 5},
+		{Path: []string{"x"}, Line: 6},
+		{Path: []string{"c"}, Line: 6},
+		{Path: []string{"d"}, Line: 6},
+		{Path: []string{"e"}, Line: 7},
+		{Path: []string{"i"}, Line: 8},
+		{Path: []string{"g"}, Line: 8},
+		{Path: []string{"x"}, Line: 9},
+	}
+	if diff := cmp.Diff(wantUses, uses); diff != "" {
+		t.Errorf("ReadVariables() uses mismatch (-want +got):\n%s", diff)
+	}
+}
+
+func TestFormatVarPath(t *testing.T) {
+	tests := map[string][]string{
+		"firstname":               {"firstname"},
+		"order.items[].title":     {"order", "items", "[]", "title"},
+		`customer["first name"]`:  {"customer", "first name"},
+		`["2nd"].items[][]`:       {"2nd", "items", "[]", "[]"},
+		`metadata.author`:         {"metadata", "author"},
+		`order.lines[]["unit €"]`: {"order", "lines", "[]", "unit €"},
+	}
+
+	for want, path := range tests {
+		if got := FormatVarPath(path); got != want {
+			t.Errorf("FormatVarPath(%q) = %q, want %q", path, got, want)
+		}
+	}
+}
+
+func TestElementPaths(t *testing.T) {
+	testdata := xml.Header + `<doc><p>[# firstname #]</p><table><row>[[ for _, v in ipairs(items) do ]]` +
+		`<cell>[# v #]</cell>[[ end ]]</row></table></doc>`
+
+	tree, err := xmltree.Parse([]byte(testdata))
+	if err != nil {
+		t.Fatalf("parsing template: %s", err)
+	}
+
+	lt, err := NewLuaTree(tree)
+	if err != nil {
+		t.Fatalf("creating lua tree: %s", err)
+	}
+
+	paths := lt.ElementPaths()
+
+	got := map[string]string{}
+
+	for i := range paths {
+		if code := lt.CodeLine(i + 1); strings.HasPrefix(code, "Print(") || strings.HasPrefix(code, "for") {
+			got[code] = paths[i]
+		}
+	}
+
+	want := map[string]string{
+		"Print( firstname )":           "doc/p",
+		"for _, v in ipairs(items) do": "doc/table/row",
+		"Print( v )":                   "doc/table/row/cell",
+	}
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Errorf("ElementPaths() mismatch (-want +got):\n%s", diff)
+	}
+}
@@ -0,0 +1,700 @@
+package odf
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/base64"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"io/ioutil"
+	"mime"
+	"net/http"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/djboris9/xmltree"
+	"github.com/microfast-ch/rea/internal/utils"
+	"golang.org/x/exp/slices"
+)
+
+var ErrFlat = errors.New("flatErr")
+
+// XML namespaces of the elements that are converted between packages and flat documents.
+const (
+	nsOffice   = "urn:oasis:names:tc:opendocument:xmlns:office:1.0"
+	nsStyle    = "urn:oasis:names:tc:opendocument:xmlns:style:1.0"
+	nsDraw     = "urn:oasis:names:tc:opendocument:xmlns:drawing:1.0"
+	nsXlink    = "http://www.w3.org/1999/xlink"
+	nsManifest = "urn:oasis:names:tc:opendocument:xmlns:manifest:1.0"
+)
+
+// FlatContent is the name under which the flat document is opened and overridden,
+// as it holds the content like content.xml of a package.
+const FlatContent = "content.xml"
+
+// Flat is a flat XML OpenDocument, like `.fodt`. It is a single `office:document` element
+// that holds the parts of a package, media files are embedded as base64 data.
+type Flat struct {
+	data          []byte
+	mimetype      string
+	deterministic bool
+}
+
+// flatParts are the XML parts of a package with the sections of the flat document they hold.
+var flatParts = []struct {
+	name     string
+	root     string
+	sections []string
+}{
+	{"meta.xml", "document-meta", []string{"meta"}},
+	{"settings.xml", "document-settings", []string{"settings"}},
+	{"styles.xml", "document-styles", []string{"font-face-decls", "styles", "automatic-styles", "master-styles"}},
+	{"content.xml", "document-content", []string{"scripts", "font-face-decls", "automatic-styles", "body"}},
+}
+
+// flatSections are the sections of a flat document in the order of the schema.
+var flatSections = []string{
+	"meta", "settings", "scripts", "font-face-decls", "styles", "automatic-styles", "master-styles", "body",
+}
+
+// NewFlatFromFile returns a new flat ODF instance for the given document file path.
+// The root element is validated but no content or structure is processed.
+func NewFlatFromFile(path string) (*Flat, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("error opening file %s: %w", path, err)
+	}
+
+	flat := &Flat{data: data}
+	err = flat.ValidateAndSetMIMEType()
+
+	return flat, err
+}
+
+// NewFlat returns a flat ODF instance for the given document with the given size.
+// The root element is validated but no content or structure is processed.
+func NewFlat(doc io.ReaderAt, size int64) (*Flat, error) {
+	data, err := ioutil.ReadAll(io.NewSectionReader(doc, 0, size))
+	if err != nil {
+		return nil, fmt.Errorf("reading flat ODF: %w", err)
+	}
+
+	flat := &Flat{data: data}
+	err = flat.ValidateAndSetMIMEType()
+
+	return flat, err
+}
+
+func (f *Flat) MIMEType() string {
+	return f.mimetype
+}
+
+// Open opens the flat document, which is the only file of it, with the name FlatContent.
+func (f *Flat) Open(name string) (fs.File, error) {
+	if name != FlatContent {
+		return nil, fmt.Errorf("error opening %s: %w", name, fs.ErrNotExist)
+	}
+
+	return &flatFile{Reader: bytes.NewReader(f.data), size: int64(len(f.data))}, nil
+}
+
+func (f *Flat) Close() error {
+	return nil
+}
+
+func (f *Flat) InitScript() string {
+	return initScript(f.mimetype)
+}
+
+// SetDeterministic sets if packages written from the flat document are byte-identical for identical
+// input. The entries get a fixed modification time instead of the time of writing.
+func (f *Flat) SetDeterministic(deterministic bool) {
+	f.deterministic = deterministic
+}
+
+// ValidateAndSetMIMEType validates that the root element is an `office:document`
+// of an OpenDocument mimetype and sets the MIME type accordingly.
+func (f *Flat) ValidateAndSetMIMEType() error {
+	d := xml.NewDecoder(bytes.NewReader(f.data))
+
+	for {
+		tok, err := d.Token()
+		if err != nil {
+			return utils.FormatError(ErrMimetype, fmt.Sprintf("reading root element: %s", err))
+		}
+
+		elem, ok := tok.(xml.StartElement)
+		if !ok {
+			continue
+		}
+
+		if elem.Name.Space != nsOffice || elem.Name.Local != "document" {
+			return utils.FormatError(ErrMimetype, fmt.Sprintf("%s is not a flat OpenDocument", elem.Name.Local))
+		}
+
+		mimetype, _ := utils.AttrValue(elem, nsOffice, "mimetype")
+		if !strings.HasPrefix(mimetype, "application/vnd.oasis.opendocument.") {
+			return utils.FormatError(ErrMimetype, fmt.Sprintf("%s not an OpenDocument file", mimetype))
+		}
+
+		f.mimetype = mimetype
+
+		return nil
+	}
+}
+
+// Write writes the flat document to the given writer. The document is overridden by FlatContent
+// and the mimetype by `mimetype`. All other overrides are media files, that are embedded where
+// images reference them.
+func (f *Flat) Write(w io.Writer, ov Overrides) error {
+	tree, _, err := f.overridden(ov)
+	if err != nil {
+		return err
+	}
+
+	err = embedMedia(tree, func(name string) ([]byte, error) {
+		return overrideData(ov, name)
+	})
+	if err != nil {
+		return fmt.Errorf("embedding media: %w", err)
+	}
+
+	return writeTree(w, tree)
+}
+
+// WritePackage converts the flat document to an ODF package and writes it to the given writer.
+// The overrides are handled as by Write, but the media files are written as files of the package.
+// The automatic styles and font faces are written to both content.xml and styles.xml.
+func (f *Flat) WritePackage(w io.Writer, ov Overrides) error {
+	_, root, err := f.overridden(ov)
+	if err != nil {
+		return err
+	}
+
+	files := map[string][]byte{}
+
+	types := map[string]string{}
+
+	for name, o := range ov {
+		if name != FlatContent && name != "mimetype" && name != "META-INF/manifest.xml" && !o.Delete {
+			files[name], err = overrideData(ov, name)
+			if err != nil {
+				return err
+			}
+
+			types[name] = o.MediaType
+		}
+	}
+
+	err = extractMedia(root, files)
+	if err != nil {
+		return fmt.Errorf("extracting media: %w", err)
+	}
+
+	elem, _ := root.Token.(xml.StartElement)
+	mimetype, _ := utils.AttrValue(elem, nsOffice, "mimetype")
+	attrs := []xml.Attr{}
+
+	for _, a := range elem.Attr {
+		if a.Name.Space != nsOffice || a.Name.Local != "mimetype" {
+			attrs = append(attrs, a)
+		}
+	}
+
+	zipWriter := utils.NewZipWriter(w)
+	modTime := time.Now()
+
+	if f.deterministic {
+		modTime = utils.ZipModTime
+	}
+
+	err = writeZipFile(zipWriter, &zip.FileHeader{Name: "mimetype", Method: zip.Store, Modified: modTime}, []byte(mimetype))
+	if err != nil {
+		return err
+	}
+
+	entries := map[string]string{}
+
+	for _, p := range flatParts {
+		children := []*xmltree.Node{}
+
+		for _, s := range p.sections {
+			if n := utils.FindChild(root, nsOffice, s); n != nil {
+				children = append(children, n)
+			}
+		}
+
+		if len(children) == 0 && p.name != "content.xml" {
+			continue
+		}
+
+		var buf bytes.Buffer
+
+		err = writeTree(&buf, newDocument(xml.Name{Space: nsOffice, Local: p.root}, attrs, children))
+		if err != nil {
+			return err
+		}
+
+		err = writeZipFile(zipWriter, &zip.FileHeader{Name: p.name, Method: zip.Deflate, Modified: modTime}, buf.Bytes())
+		if err != nil {
+			return err
+		}
+
+		entries[p.name] = "text/xml"
+	}
+
+	names := make([]string, 0, len(files))
+	for name := range files {
+		names = append(names, name)
+	}
+
+	slices.Sort(names)
+
+	for _, name := range names {
+		err = writeZipFile(zipWriter, &zip.FileHeader{Name: name, Method: zip.Deflate, Modified: modTime}, files[name])
+		if err != nil {
+			return err
+		}
+
+		entries[name] = types[name]
+		if entries[name] == "" {
+			entries[name] = mediaType(name)
+		}
+	}
+
+	version, _ := utils.AttrValue(elem, nsOffice, "version")
+	manifest := flatManifest(mimetype, version, entries)
+
+	err = writeZipFile(zipWriter, &zip.FileHeader{Name: "META-INF/manifest.xml", Method: zip.Deflate, Modified: modTime}, manifest)
+	if err != nil {
+		return err
+	}
+
+	err = zipWriter.Close()
+	if err != nil {
+		return utils.FormatError(err, "error finishing archive")
+	}
+
+	return nil
+}
+
+// WriteFlat converts the package with the overrides to a flat document and writes it to the
+// given writer. Media files of images are embedded, other files are not part of a flat document.
+func (o *Odf) WriteFlat(w io.Writer, ov Overrides) error {
+	read := func(name string) ([]byte, error) {
+		if _, ok := ov[name]; ok {
+			return overrideData(ov, name)
+		}
+
+		fd, err := o.Open(name)
+		if err != nil {
+			return nil, err
+		}
+		defer fd.Close()
+
+		return ioutil.ReadAll(fd)
+	}
+
+	mimetype := o.MIMEType()
+	if data, err := overrideData(ov, "mimetype"); err == nil {
+		mimetype = string(data)
+	}
+
+	attrs := []xml.Attr{}
+	sections := map[string][]*xmltree.Node{}
+
+	for _, p := range flatParts {
+		data, err := read(p.name)
+		if err != nil {
+			// Only content.xml is required in a package
+			if p.name == "content.xml" {
+				return fmt.Errorf("reading content.xml: %w", err)
+			}
+
+			continue
+		}
+
+		tree, err := xmltree.Parse(data)
+		if err != nil {
+			return fmt.Errorf("parsing %s as tree: %w", p.name, err)
+		}
+
+		root := utils.FindChild(tree, nsOffice, p.root)
+		if root == nil {
+			return utils.FormatError(ErrFlat, fmt.Sprintf("%s has no %s element", p.name, p.root))
+		}
+
+		// The namespace declarations of all parts are combined
+		for _, a := range root.Token.(xml.StartElement).Attr {
+			if slices.IndexFunc(attrs, func(b xml.Attr) bool { return b.Name == a.Name }) < 0 {
+				attrs = append(attrs, a)
+			}
+		}
+
+		for _, s := range p.sections {
+			if n := utils.FindChild(root, nsOffice, s); n != nil {
+				sections[s] = append(sections[s], n)
+			}
+		}
+	}
+
+	attrs = append(attrs, xml.Attr{Name: xml.Name{Space: nsOffice, Local: "mimetype"}, Value: mimetype})
+	children := []*xmltree.Node{}
+
+	for _, s := range flatSections {
+		if n := mergeSections(sections[s]); n != nil {
+			children = append(children, n)
+		}
+	}
+
+	doc := newDocument(xml.Name{Space: nsOffice, Local: "document"}, attrs, children)
+
+	err := embedMedia(doc, read)
+	if err != nil {
+		return fmt.Errorf("embedding media: %w", err)
+	}
+
+	return writeTree(w, doc)
+}
+
+// overridden returns the document tree and its root element with the overrides applied.
+func (f *Flat) overridden(ov Overrides) (*xmltree.Node, *xmltree.Node, error) {
+	data := f.data
+	if d, err := overrideData(ov, FlatContent); err == nil {
+		data = d
+	}
+
+	tree, err := xmltree.Parse(data)
+	if err != nil {
+		return nil, nil, fmt.Errorf("parsing flat document as tree: %w", err)
+	}
+
+	root := utils.FindChild(tree, nsOffice, "document")
+	if root == nil {
+		return nil, nil, utils.FormatError(ErrFlat, "document has no office:document element")
+	}
+
+	if mimetype, err := overrideData(ov, "mimetype"); err == nil {
+		elem := root.Token.(xml.StartElement)
+		attrs := make([]xml.Attr, 0, len(elem.Attr))
+
+		for _, a := range elem.Attr {
+			if a.Name.Space != nsOffice || a.Name.Local != "mimetype" {
+				attrs = append(attrs, a)
+			}
+		}
+
+		elem.Attr = append(attrs, xml.Attr{Name: xml.Name{Space: nsOffice, Local: "mimetype"}, Value: string(mimetype)})
+		root.Token = elem
+	}
+
+	return tree, root, nil
+}
+
+// mergeSections combines the sections of several parts into the first one. Elements with
+// a style name that is already declared, like a font face in styles.xml and content.xml,
+// are only taken once.
+func mergeSections(nodes []*xmltree.Node) *xmltree.Node {
+	if len(nodes) < 2 {
+		if len(nodes) == 0 {
+			return nil
+		}
+
+		return nodes[0]
+	}
+
+	start := nodes[0].Token.(xml.StartElement)
+	merged := &xmltree.Node{Token: start}
+	declared := map[string]bool{}
+
+	for _, n := range nodes {
+		for _, c := range n.Nodes {
+			elem, ok := c.Token.(xml.StartElement)
+			if !ok {
+				continue
+			}
+
+			if name, _ := utils.AttrValue(elem, nsStyle, "name"); name != "" {
+				family, _ := utils.AttrValue(elem, nsStyle, "family")
+				key := elem.Name.Local + "\x00" + name + "\x00" + family
+				if declared[key] {
+					continue
+				}
+
+				declared[key] = true
+			}
+
+			c.Parent = merged
+			merged.Nodes = append(merged.Nodes, c)
+		}
+	}
+
+	merged.Append(xml.EndElement{Name: start.Name})
+
+	return merged
+}
+
+// embedMedia embeds the media files referenced by images as base64 data. References that can't
+// be read are kept, as they can point to files next to the document.
+func embedMedia(tree *xmltree.Node, read func(name string) ([]byte, error)) error {
+	return xmltree.Walk(tree, func(node *xmltree.Node, depth uint) error {
+		elem, ok := node.Token.(xml.StartElement)
+		if !ok || elem.Name.Space != nsDraw || elem.Name.Local != "image" {
+			return nil
+		}
+
+		href, _ := utils.AttrValue(elem, nsXlink, "href")
+		if href == "" || strings.Contains(href, ":") || strings.HasPrefix(href, "#") {
+			return nil
+		}
+
+		data, err := read(strings.TrimPrefix(href, "./"))
+		if err != nil {
+			return nil
+		}
+
+		attrs := make([]xml.Attr, 0, len(elem.Attr))
+
+		for _, a := range elem.Attr {
+			if a.Name.Space != nsXlink {
+				attrs = append(attrs, a)
+			}
+		}
+
+		elem.Attr = attrs
+		node.Token = elem
+
+		name := xml.Name{Space: nsOffice, Local: "binary-data"}
+		binary := &xmltree.Node{Token: xml.StartElement{Name: name}, Parent: node}
+		binary.Append(xml.CharData(base64.StdEncoding.EncodeToString(data)))
+		binary.Append(xml.EndElement{Name: name})
+
+		node.Nodes = append([]*xmltree.Node{binary}, node.Nodes...)
+
+		return nil
+	})
+}
+
+// extractMedia moves the base64 data of images to files, which are referenced by the images.
+func extractMedia(tree *xmltree.Node, files map[string][]byte) error {
+	return xmltree.Walk(tree, func(node *xmltree.Node, depth uint) error {
+		elem, ok := node.Token.(xml.StartElement)
+		if !ok || elem.Name.Space != nsDraw || elem.Name.Local != "image" {
+			return nil
+		}
+
+		idx := slices.IndexFunc(node.Nodes, func(c *xmltree.Node) bool {
+			e, ok := c.Token.(xml.StartElement)
+			return ok && e.Name.Space == nsOffice && e.Name.Local == "binary-data"
+		})
+		if idx < 0 {
+			return nil
+		}
+
+		encoded := strings.Map(func(r rune) rune {
+			if r == ' ' || r == '\t' || r == '\r' || r == '\n' {
+				return -1
+			}
+
+			return r
+		}, utils.NodeText(node.Nodes[idx]))
+
+		data, err := base64.StdEncoding.DecodeString(encoded)
+		if err != nil {
+			return utils.FormatError(ErrFlat, fmt.Sprintf("decoding image data: %s", err))
+		}
+
+		name := ""
+		for i := len(files) + 1; name == "" || files[name] != nil; i++ {
+			name = fmt.Sprintf("Pictures/image%d%s", i, mediaExtension(data))
+		}
+
+		files[name] = data
+
+		elem.Attr = append(elem.Attr,
+			xml.Attr{Name: xml.Name{Space: nsXlink, Local: "href"}, Value: name},
+			xml.Attr{Name: xml.Name{Space: nsXlink, Local: "type"}, Value: "simple"},
+			xml.Attr{Name: xml.Name{Space: nsXlink, Local: "show"}, Value: "embed"},
+			xml.Attr{Name: xml.Name{Space: nsXlink, Local: "actuate"}, Value: "onLoad"},
+		)
+		node.Token = elem
+		node.Nodes = slices.Delete(node.Nodes, idx, idx+1)
+
+		return nil
+	})
+}
+
+// mediaExtension returns the file extension for the detected type of the media data.
+func mediaExtension(data []byte) string {
+	switch http.DetectContentType(data) {
+	case "image/png":
+		return ".png"
+	case "image/jpeg":
+		return ".jpg"
+	case "image/gif":
+		return ".gif"
+	case "image/bmp":
+		return ".bmp"
+	case "image/webp":
+		return ".webp"
+	}
+
+	if bytes.Contains(data, []byte("<svg")) {
+		return ".svg"
+	}
+
+	return ""
+}
+
+// mediaType returns the media type of a file by its extension.
+func mediaType(name string) string {
+	// Parameters like the charset of `text/xml; charset=utf-8` are not part of manifest entries
+	if t := mime.TypeByExtension(path.Ext(name)); t != "" {
+		t, _, _ = strings.Cut(t, ";")
+		return t
+	}
+
+	return "application/octet-stream"
+}
+
+// flatManifest returns a manifest.xml for a package converted from a flat document.
+func flatManifest(mimetype, version string, entries map[string]string) []byte {
+	versionAttr := ""
+	if version != "" {
+		versionAttr = fmt.Sprintf(` manifest:version="%s"`, escapeAttr(version))
+	}
+
+	var buf bytes.Buffer
+
+	buf.WriteString(xml.Header)
+	fmt.Fprintf(&buf, `<manifest:manifest xmlns:manifest="%s"%s>`, nsManifest, versionAttr)
+	fmt.Fprintf(&buf, `<manifest:file-entry manifest:full-path="/"%s manifest:media-type="%s"/>`,
+		versionAttr, escapeAttr(mimetype))
+
+	paths := make([]string, 0, len(entries))
+	for p := range entries {
+		paths = append(paths, p)
+	}
+
+	slices.Sort(paths)
+
+	for _, p := range paths {
+		fmt.Fprintf(&buf, `<manifest:file-entry manifest:full-path="%s" manifest:media-type="%s"/>`,
+			escapeAttr(p), escapeAttr(entries[p]))
+	}
+
+	buf.WriteString(`</manifest:manifest>`)
+
+	return buf.Bytes()
+}
+
+// newDocument returns a document with the XML declaration and a root element with the children.
+func newDocument(name xml.Name, attrs []xml.Attr, children []*xmltree.Node) *xmltree.Node {
+	doc := &xmltree.Node{}
+	doc.Append(xml.ProcInst{Target: "xml", Inst: []byte(`version="1.0" encoding="UTF-8"`)})
+
+	root := doc.Append(xml.StartElement{Name: name, Attr: attrs})
+
+	for _, c := range children {
+		c.Parent = root
+		root.Nodes = append(root.Nodes, c)
+	}
+
+	root.Append(xml.EndElement{Name: name})
+
+	return doc
+}
+
+// writeTree writes the tree as XML.
+func writeTree(w io.Writer, tree *xmltree.Node) error {
+	enc := utils.NewXMLEncoder(w)
+
+	err := xmltree.Walk(tree, func(node *xmltree.Node, depth uint) error {
+		return enc.EncodeToken(node.Token)
+	})
+	if err != nil {
+		return fmt.Errorf("encoding xml tree: %w", err)
+	}
+
+	err = enc.Flush()
+	if err != nil {
+		return fmt.Errorf("flushing xml encoder: %w", err)
+	}
+
+	return nil
+}
+
+// writeZipFile writes the data as file with the given header to the archive.
+func writeZipFile(zipWriter *zip.Writer, header *zip.FileHeader, data []byte) error {
+	f, err := zipWriter.CreateHeader(header)
+	if err != nil {
+		return utils.FormatError(ErrArchive, fmt.Sprintf("unable to create file %s in archive: %q", header.Name, err))
+	}
+
+	_, err = f.Write(data)
+	if err != nil {
+		return utils.FormatError(ErrArchive, fmt.Sprintf("unable to write file %s to archive: %q", header.Name, err))
+	}
+
+	return nil
+}
+
+// overrideData returns the data of an override that is not deleted. The content of a reader
+// is kept as data of the override, as the reader can only be read once.
+func overrideData(ov Overrides, name string) ([]byte, error) {
+	o, ok := ov[name]
+	if !ok || o.Delete {
+		return nil, fmt.Errorf("override %s: %w", name, fs.ErrNotExist)
+	}
+
+	if o.Reader != nil {
+		data, err := ioutil.ReadAll(o.Reader)
+		if err != nil {
+			return nil, fmt.Errorf("reading override %s: %w", name, err)
+		}
+
+		ov[name] = Override{Data: data, MediaType: o.MediaType}
+
+		return data, nil
+	}
+
+	return o.Data, nil
+}
+
+// escapeAttr escapes the value for the use in an attribute.
+func escapeAttr(value string) string {
+	var buf bytes.Buffer
+
+	_ = xml.EscapeText(&buf, []byte(value))
+
+	return buf.String()
+}
+
+// flatFile is the flat document opened as file.
+type flatFile struct {
+	*bytes.Reader
+	size int64
+}
+
+func (f *flatFile) Stat() (fs.FileInfo, error) {
+	return flatFileInfo{size: f.size}, nil
+}
+
+func (f *flatFile) Close() error {
+	return nil
+}
+
+type flatFileInfo struct {
+	size int64
+}
+
+func (fi flatFileInfo) Name() string       { return FlatContent }
+func (fi flatFileInfo) Size() int64        { return fi.size }
+func (fi flatFileInfo) Mode() fs.FileMode  { return 0444 }
+func (fi flatFileInfo) ModTime() time.Time { return time.Time{} }
+func (fi flatFileInfo) IsDir() bool        { return false }
+func (fi flatFileInfo) Sys() any           { return nil }
@@ -0,0 +1,93 @@
+package odf
+
+import (
+	"bytes"
+	"encoding/base64"
+	"io/ioutil"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestNewFlat(t *testing.T) {
+	doc, err := NewFlatFromFile("../../testdata/Conditional1.fodt")
+	require.Nil(t, err)
+	require.Equal(t, MIMETypeText, doc.MIMEType())
+
+	fd, err := doc.Open(FlatContent)
+	require.Nil(t, err)
+
+	data, err := ioutil.ReadAll(fd)
+	require.Nil(t, err)
+	require.Contains(t, string(data), "office:body")
+
+	_, err = doc.Open("styles.xml")
+	require.Error(t, err)
+
+	// Test invalid files
+	for _, data := range []string{"", "<document/>", `<office:document xmlns:office="` + nsOffice + `" office:mimetype="text/plain"/>`} {
+		_, err = NewFlat(bytes.NewReader([]byte(data)), int64(len(data)))
+		require.Error(t, err, data)
+	}
+
+	_, err = NewFlatFromFile("../../testdata/Basic1.ott")
+	require.Error(t, err)
+}
+
+func TestFlatConversion(t *testing.T) {
+	image := []byte("\x89PNG\r\n\x1a\nimage")
+	content := `<?xml version="1.0" encoding="UTF-8"?>
+<office:document-content xmlns:office="urn:oasis:names:tc:opendocument:xmlns:office:1.0" xmlns:text="urn:oasis:names:tc:opendocument:xmlns:text:1.0" xmlns:draw="urn:oasis:names:tc:opendocument:xmlns:drawing:1.0" xmlns:xlink="http://www.w3.org/1999/xlink" office:version="1.3"><office:body><office:text><text:p>Hello<draw:frame><draw:image xlink:href="Pictures/a.png" xlink:type="simple"/></draw:frame></text:p></office:text></office:body></office:document-content>`
+
+	doc, err := NewFromFile("../../testdata/Basic1.ott")
+	require.Nil(t, err)
+
+	defer doc.Close()
+
+	// Package to flat document, the image is embedded
+	flatBuf := new(bytes.Buffer)
+	err = doc.WriteFlat(flatBuf, Overrides{
+		"content.xml":    Override{Data: []byte(content)},
+		"Pictures/a.png": Override{Data: image},
+	})
+	require.Nil(t, err)
+
+	flat, err := NewFlat(bytes.NewReader(flatBuf.Bytes()), int64(flatBuf.Len()))
+	require.Nil(t, err)
+	require.Equal(t, MIMETypeTextTemplate, flat.MIMEType())
+
+	for _, want := range []string{"Hello", "master-styles", "config-item", "binary-data", base64.StdEncoding.EncodeToString(image)} {
+		require.Contains(t, flatBuf.String(), want)
+	}
+
+	require.NotContains(t, flatBuf.String(), "Pictures/a.png")
+
+	// Flat document to package, the image is extracted
+	pkgBuf := new(bytes.Buffer)
+	err = flat.WritePackage(pkgBuf, Overrides{"mimetype": Override{Data: []byte(MIMETypeText)}})
+	require.Nil(t, err)
+
+	pkg, err := New(bytes.NewReader(pkgBuf.Bytes()), int64(pkgBuf.Len()))
+	require.Nil(t, err)
+	require.Equal(t, MIMETypeText, pkg.MIMEType())
+
+	files := map[string]string{}
+
+	for _, name := range []string{"content.xml", "styles.xml", "meta.xml", "settings.xml", "META-INF/manifest.xml", "Pictures/image1.png"} {
+		fd, err := pkg.Open(name)
+		require.Nil(t, err, name)
+
+		data, err := ioutil.ReadAll(fd)
+		require.Nil(t, err)
+		fd.Close()
+
+		files[name] = string(data)
+	}
+
+	require.Equal(t, string(image), files["Pictures/image1.png"])
+	require.Contains(t, files["content.xml"], "Pictures/image1.png")
+	require.NotContains(t, files["content.xml"], "binary-data")
+	require.Contains(t, files["styles.xml"], "master-styles")
+	require.Contains(t, files["META-INF/manifest.xml"], `manifest:full-path="Pictures/image1.png" manifest:media-type="image/png"`)
+	require.Contains(t, files["META-INF/manifest.xml"], `manifest:full-path="/" manifest:version="1.3" manifest:media-type="`+MIMETypeText+`"`)
+}
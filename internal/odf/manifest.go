@@ -6,6 +6,12 @@ import (
 	"errors"
 	"fmt"
 	"io"
+	"io/ioutil"
+	"regexp"
+	"strings"
+
+	"github.com/microfast-ch/rea/internal/utils"
+	"golang.org/x/exp/slices"
 )
 
 var ErrUpdateRootMediaType = errors.New("couldn't find and update root media-type")
@@ -51,7 +57,7 @@ func retypeManifest(b []byte, newType []byte) ([]byte, error) {
 
 	// Encode XML
 	buf := bytes.NewBuffer([]byte(""))
-	enc := xml.NewEncoder(buf)
+	enc := utils.NewXMLEncoder(buf)
 
 	for i := range nodes {
 		err := enc.EncodeToken(nodes[i])
@@ -90,3 +96,186 @@ func manifestAttrIsRoot(e xml.StartElement) bool {
 
 	return false
 }
+
+// AddManifestEntries adds file entries with the given paths and media types to a manifest.xml.
+// Entries for paths that are already part of the manifest are not added a second time.
+// The manifest is changed textually, so everything else stays as written by the office suite.
+func AddManifestEntries(b []byte, entries map[string]string) ([]byte, error) {
+	existing, err := manifestPaths(b)
+	if err != nil {
+		return nil, err
+	}
+
+	paths := make([]string, 0, len(entries))
+
+	for p := range entries {
+		if !slices.Contains(existing, p) {
+			paths = append(paths, p)
+		}
+	}
+
+	if len(paths) == 0 {
+		return b, nil
+	}
+
+	slices.Sort(paths)
+
+	m := manifestRoot.FindSubmatch(b)
+	if m == nil {
+		return nil, utils.FormatError(ErrOverride, "manifest.xml has no manifest element")
+	}
+
+	prefix := ""
+	if len(m[1]) > 0 {
+		prefix = string(m[1]) + ":"
+	}
+
+	var buf bytes.Buffer
+
+	for _, p := range paths {
+		fmt.Fprintf(&buf, `<%sfile-entry %sfull-path="%s" %smedia-type="%s"/>`,
+			prefix, prefix, escapeAttr(p), prefix, escapeAttr(entries[p]))
+	}
+
+	idx := bytes.LastIndex(b, []byte("</"))
+	if idx < 0 {
+		return nil, utils.FormatError(ErrOverride, "manifest.xml has no end tag")
+	}
+
+	return []byte(string(b[:idx]) + buf.String() + string(b[idx:])), nil
+}
+
+var manifestRoot = regexp.MustCompile(`<(?:(\w+):)?manifest[\s>]`)
+
+// RemoveManifestEntries removes the file entries with the given paths from a manifest.xml.
+// The manifest is changed textually, so everything else stays as written by the office suite.
+func RemoveManifestEntries(b []byte, paths []string) []byte {
+	for _, p := range paths {
+		entry := regexp.MustCompile(`<(?:\w+:)?file-entry\b[^>]*\bfull-path="` + regexp.QuoteMeta(escapeAttr(p)) +
+			`"(?:[^>]*/>|[^>]*>(?s:.*?)</(?:\w+:)?file-entry>)[ \t]*\n?`)
+		b = entry.ReplaceAll(b, nil)
+	}
+
+	return b
+}
+
+// updateManifest sets the manifest.xml override to a manifest that matches the written package.
+// Entries of files and directories that are not part of the package anymore are removed. Files of
+// overrides without entry are added with the media type of the override or the one of their extension.
+func (o *Odf) updateManifest(ov Overrides) error {
+	manifest, err := o.overriddenManifest(ov)
+	if err != nil {
+		return err
+	}
+
+	files := map[string]bool{}
+
+	for _, f := range o.zipFD.File {
+		if v, ok := ov[f.Name]; !ok || !v.Delete {
+			files[f.Name] = true
+		}
+	}
+
+	added := map[string]string{}
+
+	for name, v := range ov {
+		if v.Delete || name == "mimetype" || strings.HasPrefix(name, "META-INF/") || strings.HasSuffix(name, "/") {
+			continue
+		}
+
+		files[name] = true
+
+		added[name] = v.MediaType
+		if added[name] == "" {
+			added[name] = mediaType(name)
+		}
+	}
+
+	paths, err := manifestPaths(manifest)
+	if err != nil {
+		return err
+	}
+
+	stale := []string{}
+
+	for _, p := range paths {
+		if p != "/" && !manifestPathExists(p, files) {
+			stale = append(stale, p)
+		}
+	}
+
+	manifest = RemoveManifestEntries(manifest, stale)
+
+	// Entries that exist already are kept as they are
+	manifest, err = AddManifestEntries(manifest, added)
+	if err != nil {
+		return err
+	}
+
+	ov["META-INF/manifest.xml"] = Override{Data: manifest}
+
+	return nil
+}
+
+// manifestPathExists reports if the path of a manifest entry is part of the files. A directory
+// exists, if it contains a file or is an entry itself.
+func manifestPathExists(p string, files map[string]bool) bool {
+	if files[p] || !strings.HasSuffix(p, "/") {
+		return files[p]
+	}
+
+	for f := range files {
+		if strings.HasPrefix(f, p) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// manifestPaths returns the paths of the file entries of a manifest.xml.
+func manifestPaths(b []byte) ([]string, error) {
+	d := xml.NewDecoder(bytes.NewReader(b))
+	paths := []string{}
+
+	for {
+		tok, err := d.Token()
+		if err == io.EOF {
+			break
+		}
+
+		if err != nil {
+			return nil, fmt.Errorf("reading xml token: %w", err)
+		}
+
+		if e, ok := tok.(xml.StartElement); ok && e.Name.Local == "file-entry" {
+			for _, a := range e.Attr {
+				if a.Name.Local == "full-path" {
+					paths = append(paths, a.Value)
+				}
+			}
+		}
+	}
+
+	return paths, nil
+}
+
+// overriddenManifest returns the manifest.xml of the overrides or of the package.
+func (o *Odf) overriddenManifest(ov Overrides) ([]byte, error) {
+	if _, ok := ov["META-INF/manifest.xml"]; ok {
+		return overrideData(ov, "META-INF/manifest.xml")
+	}
+
+	fd, err := o.Open("META-INF/manifest.xml")
+	if err != nil {
+		return nil, fmt.Errorf("loading manifest.xml: %w", err)
+	}
+	defer fd.Close()
+
+	manifest, err := ioutil.ReadAll(fd)
+	if err != nil {
+		return nil, fmt.Errorf("reading manifest.xml: %w", err)
+	}
+
+	return manifest, nil
+}
@@ -1,6 +1,7 @@
 package odf
 
 import (
+	"strings"
 	"testing"
 
 	"github.com/stretchr/testify/require"
@@ -25,3 +26,29 @@ func TestParseManifest(t *testing.T) {
 
 	require.Contains(t, string(manifest), "deadbee")
 }
+
+func TestAddManifestEntries(t *testing.T) {
+	manifest, err := AddManifestEntries([]byte(testmanifest), map[string]string{
+		"Pictures/logo.png": "image/png",
+		"content.xml":       "text/xml",
+	})
+	require.Nil(t, err)
+
+	require.Contains(t, string(manifest), `full-path="Pictures/logo.png"`)
+	require.Equal(t, 1, strings.Count(string(manifest), `full-path="content.xml"`))
+
+	// The manifest stays valid
+	_, err = retypeManifest(manifest, []byte("deadbeef"))
+	require.Nil(t, err)
+}
+
+func TestRemoveManifestEntries(t *testing.T) {
+	manifest := RemoveManifestEntries([]byte(testmanifest), []string{"Thumbnails/thumbnail.png", "Thumbnails/"})
+
+	require.NotContains(t, string(manifest), "Thumbnails")
+	require.Contains(t, string(manifest), `full-path="content.xml"`)
+
+	// The manifest stays valid
+	_, err := retypeManifest(manifest, []byte("deadbeef"))
+	require.Nil(t, err)
+}
@@ -8,6 +8,7 @@ import (
 	"io/fs"
 	"io/ioutil"
 	"strings"
+	"time"
 
 	"github.com/microfast-ch/rea/internal/utils"
 	"golang.org/x/exp/slices"
@@ -19,9 +20,10 @@ var ErrOverride = errors.New("overrideErr")
 var ErrArchive = errors.New("archiveErr")
 
 type Odf struct {
-	zipFD       *zip.Reader
-	zipFDCloser io.Closer
-	mimetype    string
+	zipFD         *zip.Reader
+	zipFDCloser   io.Closer
+	mimetype      string
+	deterministic bool
 }
 
 // NewFromFile returns a new ODF instance for the given document file path.
@@ -128,34 +130,81 @@ func (o *Odf) ValidateAndSetMIMEType() error {
 	return nil
 }
 
+// Mimetypes of the supported OpenDocument types.
+const (
+	MIMETypeText                 = "application/vnd.oasis.opendocument.text"
+	MIMETypeTextTemplate         = "application/vnd.oasis.opendocument.text-template"
+	MIMETypeSpreadsheet          = "application/vnd.oasis.opendocument.spreadsheet"
+	MIMETypeSpreadsheetTemplate  = "application/vnd.oasis.opendocument.spreadsheet-template"
+	MIMETypePresentation         = "application/vnd.oasis.opendocument.presentation"
+	MIMETypePresentationTemplate = "application/vnd.oasis.opendocument.presentation-template"
+)
+
+// DocumentMIMEType returns the mimetype of a document that is created from a package
+// with the given mimetype. Templates map to their document type, e.g. `text-template` to `text`.
+func DocumentMIMEType(mimetype string) string {
+	return strings.TrimSuffix(mimetype, "-template")
+}
+
 func (o *Odf) InitScript() string {
-	// Configures iteration nodes for list and table rows
-	return "-- ODF Init Script\nSetIterationNodes({\"list-item\", \"table-row\"})"
+	return initScript(o.mimetype)
+}
+
+// initScript returns the initialization script of the engine for documents with the mimetype.
+func initScript(mimetype string) string {
+	switch DocumentMIMEType(mimetype) {
+	case MIMETypeSpreadsheet:
+		// Configures iteration nodes for table rows, the aliases for repeated units
+		// and the elements that are removed if they contain only control blocks
+		return `-- ODF Spreadsheet Init Script
+SetIterationNodes({"table-row"})
+SetRepeatAliases({row = "table-row", sheet = "table"})
+SetRemovableNodes({"table-row"})
+SetWrapperNodes({"span", "a", "p", "h", "table-row", "table-cell", "covered-table-cell"})
+SetIgnoredNodes({"s", "soft-page-break", "bookmark", "bookmark-start", "bookmark-end"})`
+	case MIMETypePresentation:
+		// Slides are repeated with `@repeat slide`, frames that contain only control blocks are removed
+		return `-- ODF Presentation Init Script
+SetIterationNodes({"list-item", "table-row"})
+SetRepeatAliases({slide = "page", row = "table-row", item = "list-item", paragraph = {"p", "h"}})
+SetRemovableNodes({"p", "h", "list-item", "table-row", "frame"})
+SetWrapperNodes({"span", "a", "p", "h", "list", "list-item", "text-box", "table-row", "table-cell", "covered-table-cell"})
+SetIgnoredNodes({"s", "soft-page-break", "bookmark", "bookmark-start", "bookmark-end"})`
+	default:
+		// Configures iteration nodes for list and table rows, the aliases for repeated units
+		// and the elements that are removed if they contain only control blocks
+		return `-- ODF Init Script
+SetIterationNodes({"list-item", "table-row"})
+SetRepeatAliases({row = "table-row", item = "list-item", paragraph = {"p", "h"}})
+SetRemovableNodes({"p", "h", "list-item", "table-row"})
+SetWrapperNodes({"span", "a", "p", "h", "list", "list-item", "text-box", "table-row", "table-cell", "covered-table-cell"})
+SetIgnoredNodes({"s", "soft-page-break", "bookmark", "bookmark-start", "bookmark-end"})`
+	}
 }
 
 // Writes an ODF package to the given writer. It will use the loaded ODF contents
-// as base and incorporate the overrides. It handles the mimetype and manifest.xml.
+// as base and incorporate the overrides. It handles the mimetype and manifest.xml,
+// which gets entries for added files and loses the ones of deleted files.
 func (o *Odf) Write(w io.Writer, ov Overrides) error {
 	if ov == nil {
 		ov = Overrides{}
 	}
 
-	zipWriter := zip.NewWriter(w)
-
-	writtenFiles, err := o.writeMimetype(ov, zipWriter)
-
+	err := o.updateManifest(ov)
 	if err != nil {
-		return fmt.Errorf("error writing MIMEtype from override: %w", err)
+		return fmt.Errorf("error updating manifest.xml: %w", err)
 	}
 
-	writtenFiles, err = o.writeOverrides(writtenFiles, ov, zipWriter)
+	zipWriter := utils.NewZipWriter(w)
+
+	err = o.writeMimetype(ov, zipWriter)
 	if err != nil {
-		return fmt.Errorf("error writing file overrides: %w", err)
+		return fmt.Errorf("error writing MIMEtype from override: %w", err)
 	}
 
-	err = o.writeUntouched(writtenFiles, zipWriter)
+	err = o.writeEntries(ov, zipWriter)
 	if err != nil {
-		return fmt.Errorf("error writing untouched files: %w", err)
+		return err
 	}
 
 	// Finish archive
@@ -167,124 +216,152 @@ func (o *Odf) Write(w io.Writer, ov Overrides) error {
 	return nil
 }
 
-func (o *Odf) writeMimetype(ov Overrides, zipWriter *zip.Writer) ([]string, error) {
+func (o *Odf) writeMimetype(ov Overrides, zipWriter *zip.Writer) error {
 	// Write mimetype file
 	var mimetype []byte
 
 	if mimeTypeOverride, ok := ov["mimetype"]; ok {
 		if mimeTypeOverride.Delete {
-			return nil, utils.FormatError(ErrMimetype, "unable to delete mimetype for final archive")
+			return utils.FormatError(ErrMimetype, "unable to delete mimetype for final archive")
+		}
+
+		data, err := overrideData(ov, "mimetype")
+		if err != nil {
+			return err
 		}
 
-		mimetype = mimeTypeOverride.Data
+		mimetype = data
 	} else {
 		mimetype = []byte(o.MIMEType())
 	}
 
 	f, err := zipWriter.CreateHeader(&zip.FileHeader{
-		Name:   "mimetype",
-		Method: zip.Store, // The first file in an ODF package needs to be the mimetype file and uncompressed
+		Name:     "mimetype",
+		Method:   zip.Store, // The first file in an ODF package needs to be the mimetype file and uncompressed
+		Modified: o.modTime(time.Now()),
 	})
 	if err != nil {
-		return nil, utils.FormatError(ErrMimetype, "unable to create mimetype file in archive")
+		return utils.FormatError(ErrMimetype, "unable to create mimetype file in archive")
 	}
 
 	_, err = f.Write(mimetype)
 	if err != nil {
-		return nil, utils.FormatError(ErrMimetype, "unable to create mimetype file in archive")
+		return utils.FormatError(ErrMimetype, "unable to create mimetype file in archive")
 	}
 
 	// Retype manifest.xml
-	var manifestBytes []byte
-	if v, ok := ov["META-INF/manifest.xml"]; ok && !v.Delete {
-		manifestBytes = v.Data
-	} else {
-		fd, err := o.Open("META-INF/manifest.xml")
-		if err != nil {
-			return nil, err
-		}
-		manifestBytes, err = ioutil.ReadAll(fd)
-		fd.Close()
-
-		if err != nil {
-			return nil, utils.FormatError(ErrMimetype, "unable to read manifest.xml")
-		}
+	manifestBytes, err := o.overriddenManifest(ov)
+	if err != nil {
+		return utils.FormatError(ErrMimetype, "unable to read manifest.xml")
 	}
 
 	manifest, err := retypeManifest(manifestBytes, mimetype)
 	if err != nil {
-		return nil, utils.FormatError(ErrMimetype, "unable to retype manifest.xml")
+		return utils.FormatError(ErrMimetype, "unable to retype manifest.xml")
 	}
 
 	ov["META-INF/manifest.xml"] = Override{
 		Data: manifest,
 	}
 
-	return []string{"mimetype"}, nil
+	return nil
 }
 
-func (o *Odf) writeOverrides(writtenFiles []string, ov Overrides, zipWriter *zip.Writer) ([]string, error) {
-	for fname, fdata := range ov {
-		writtenFiles = append(writtenFiles, fname)
+// writeEntries writes the files of the template in their order, with the overrides applied.
+// Files that are added by the overrides follow, sorted by name.
+func (o *Odf) writeEntries(ov Overrides, zipWriter *zip.Writer) error {
+	names := map[string]bool{"mimetype": true}
 
-		// Do not write mimetype a second time, if overridden
-		if fname == "mimetype" {
-			continue
-		}
+	for _, f := range o.zipFD.File {
+		names[f.Name] = true
 
-		// Skip writing file if we delete it from archive
-		if fdata.Delete {
+		v, ok := ov[f.Name]
+
+		switch {
+		case f.Name == "mimetype":
 			continue
+		case !ok:
+			err := o.writeUntouched(f, zipWriter)
+			if err != nil {
+				return fmt.Errorf("error writing untouched files: %w", err)
+			}
+		default:
+			err := o.writeOverride(f.Name, v, zipWriter)
+			if err != nil {
+				return fmt.Errorf("error writing file overrides: %w", err)
+			}
 		}
+	}
 
-		// Write file
-		f, err := zipWriter.Create(fname)
-		if err != nil {
-			return nil, utils.FormatError(ErrOverride,
-				fmt.Sprintf("unable to create file %s fom override in final archive: %q", fname, err))
+	added := []string{}
+
+	for name := range ov {
+		if !names[name] {
+			added = append(added, name)
 		}
+	}
 
-		_, err = f.Write(fdata.Data)
+	slices.Sort(added)
+
+	for _, name := range added {
+		err := o.writeOverride(name, ov[name], zipWriter)
 		if err != nil {
-			return nil, utils.FormatError(ErrOverride,
-				fmt.Sprintf("unable to write file %s fom override in final archive: %q", fname, err))
+			return fmt.Errorf("error writing file overrides: %w", err)
 		}
 	}
 
-	return writtenFiles, nil
+	return nil
 }
 
-// Write untouched files contained in the template package which were not processed by any override.
-func (o *Odf) writeUntouched(writtenFiles []string, zipWriter *zip.Writer) error {
-	for _, v := range o.zipFD.File {
-		// Skip already written files (or just skipped/deleted files)
-		if slices.Contains(writtenFiles, v.Name) {
-			continue
-		}
+// writeOverride writes the file of the override, unless it is deleted.
+func (o *Odf) writeOverride(fname string, fdata Override, zipWriter *zip.Writer) error {
+	// Skip writing file if we delete it from archive
+	if fdata.Delete {
+		return nil
+	}
 
-		// Write file from loaded ODF to new package
-		f, err := zipWriter.Create(v.Name)
-		if err != nil {
-			return utils.FormatError(ErrArchive, fmt.Sprintf("unable to recreate file %s from template: %q", v.Name, err))
-		}
+	f, err := zipWriter.CreateHeader(&zip.FileHeader{Name: fname, Method: zip.Deflate, Modified: o.modTime(time.Now())})
+	if err != nil {
+		return utils.FormatError(ErrOverride,
+			fmt.Sprintf("unable to create file %s fom override in final archive: %q", fname, err))
+	}
 
-		data, err := v.Open()
-		if err != nil {
-			return utils.FormatError(ErrArchive, fmt.Sprintf("unable to open file %s from template: %q", v.Name, err))
-		}
+	if fdata.Reader != nil {
+		_, err = io.Copy(f, fdata.Reader)
+	} else {
+		_, err = f.Write(fdata.Data)
+	}
 
-		dataBytes, err := ioutil.ReadAll(data)
-		data.Close()
+	if err != nil {
+		return utils.FormatError(ErrOverride,
+			fmt.Sprintf("unable to write file %s fom override in final archive: %q", fname, err))
+	}
 
-		if err != nil {
-			return utils.FormatError(ErrArchive, fmt.Sprintf("unable to read file %s from template: %q", v.Name, err))
-		}
+	return nil
+}
 
-		_, err = f.Write(dataBytes)
-		if err != nil {
-			return utils.FormatError(ErrArchive, fmt.Sprintf("unable to write file %s to archive: %q", v.Name, err))
-		}
+// Write an untouched file contained in the template package which was not processed by any override.
+// The compressed data is copied as it is, so it isn't decompressed and compressed again.
+func (o *Odf) writeUntouched(v *zip.File, zipWriter *zip.Writer) error {
+	err := utils.CopyZipFile(zipWriter, v, o.deterministic)
+	if err != nil {
+		return utils.FormatError(ErrArchive, fmt.Sprintf("unable to copy file %s from template: %q", v.Name, err))
 	}
 
 	return nil
 }
+
+// SetDeterministic sets if written packages are byte-identical for identical input. The entries
+// get a fixed modification time instead of the one of the template or the time of writing.
+func (o *Odf) SetDeterministic(deterministic bool) {
+	o.deterministic = deterministic
+}
+
+// modTime returns the modification time of an entry, which is fixed for deterministic packages.
+func (o *Odf) modTime(t time.Time) time.Time {
+	if o.deterministic {
+		return utils.ZipModTime
+	}
+
+	return t
+}
@@ -1,6 +1,7 @@
 package odf
 
 import (
+	"archive/zip"
 	"bytes"
 	"io/ioutil"
 	"testing"
@@ -94,4 +95,89 @@ func TestWrite(t *testing.T) {
 	extraData, err := ioutil.ReadAll(extra)
 	require.Nil(t, err)
 	require.Equal(t, []byte("my-extra-file"), extraData)
+
+}
+
+func TestWriteManifest(t *testing.T) {
+	doc, err := NewFromFile("../../testdata/Basic1.ott")
+	require.Nil(t, err)
+
+	ov := Overrides{
+		"content.xml":        Override{Delete: true},
+		"extra.txt":          Override{Data: []byte("my-extra-file")},
+		"Pictures/logo.data": Override{Data: []byte("logo"), MediaType: "image/png"},
+	}
+
+	buf := new(bytes.Buffer)
+	require.Nil(t, doc.Write(buf, ov))
+
+	// The manifest lists the added files, but not the deleted one
+	doc, err = New(bytes.NewReader(buf.Bytes()), int64(buf.Len()))
+	require.Nil(t, err)
+
+	manifestFD, err := doc.Open("META-INF/manifest.xml")
+	require.Nil(t, err)
+
+	manifest, err := ioutil.ReadAll(manifestFD)
+	require.Nil(t, err)
+	require.Regexp(t, `full-path="extra.txt" manifest:media-type="text/plain"`, string(manifest))
+	require.Regexp(t, `full-path="Pictures/logo.data" manifest:media-type="image/png"`, string(manifest))
+	require.Contains(t, string(manifest), `full-path="Configurations2/"`)
+	require.NotContains(t, string(manifest), `full-path="content.xml"`)
+}
+
+func TestDocumentMIMEType(t *testing.T) {
+	require.Equal(t, MIMETypeText, DocumentMIMEType(MIMETypeTextTemplate))
+	require.Equal(t, MIMETypeText, DocumentMIMEType(MIMETypeText))
+	require.Equal(t, MIMETypeSpreadsheet, DocumentMIMEType(MIMETypeSpreadsheetTemplate))
+	require.Equal(t, MIMETypePresentation, DocumentMIMEType(MIMETypePresentationTemplate))
+}
+
+func TestWriteStreamed(t *testing.T) {
+	doc, err := NewFromFile("../../testdata/Basic1.ott")
+	require.Nil(t, err)
+
+	buf := new(bytes.Buffer)
+	ov := Overrides{
+		"media/video.bin": Override{Reader: bytes.NewReader([]byte("my-streamed-file"))},
+	}
+	require.Nil(t, doc.Write(buf, ov))
+
+	out, err := zip.NewReader(bytes.NewReader(buf.Bytes()), int64(buf.Len()))
+	require.Nil(t, err)
+
+	// Untouched entries keep their compressed data
+	require.Equal(t, rawEntry(t, doc.zipFD, "styles.xml"), rawEntry(t, out, "styles.xml"))
+
+	// The streamed override is written to the package
+	doc, err = New(bytes.NewReader(buf.Bytes()), int64(buf.Len()))
+	require.Nil(t, err)
+
+	fd, err := doc.Open("media/video.bin")
+	require.Nil(t, err)
+
+	data, err := ioutil.ReadAll(fd)
+	require.Nil(t, err)
+	require.Equal(t, []byte("my-streamed-file"), data)
+}
+
+// rawEntry returns the compressed data of the entry.
+func rawEntry(t *testing.T, r *zip.Reader, name string) []byte {
+	t.Helper()
+
+	for _, f := range r.File {
+		if f.Name == name {
+			fd, err := f.OpenRaw()
+			require.Nil(t, err)
+
+			data, err := ioutil.ReadAll(fd)
+			require.Nil(t, err)
+
+			return data
+		}
+	}
+
+	require.Fail(t, "entry not found", name)
+
+	return nil
 }
@@ -1,10 +1,14 @@
 package odf
 
+import "io"
+
 // Overrides defines an override identified by the file path as map key.
 type Overrides map[string]Override
 
 // Override represents a content override for a file.
 type Override struct {
-	Data   []byte // File contents to write
-	Delete bool   // Do not write file with the given path to the package
+	Data      []byte    // File contents to write
+	Reader    io.Reader // File contents streamed to the package instead of Data, e.g. for large media
+	Delete    bool      // Do not write file with the given path to the package
+	MediaType string    // Media type of the manifest entry, inferred from the extension if empty
 }
@@ -0,0 +1,45 @@
+package odf
+
+import (
+	"bytes"
+
+	"github.com/microfast-ch/rea/internal/utils"
+)
+
+// ThumbnailPath is the path of the thumbnail image in an ODF package.
+const ThumbnailPath = "Thumbnails/thumbnail.png"
+
+var pngSignature = []byte("\x89PNG\r\n\x1a\n")
+
+// SetThumbnail adds the override that replaces the thumbnail of the package with the PNG image.
+// If the image is nil, the thumbnail is deleted. The manifest entry follows when the package is written.
+func (o *Odf) SetThumbnail(ov Overrides, image []byte) error {
+	if image == nil {
+		ov[ThumbnailPath] = Override{Delete: true}
+		return nil
+	}
+
+	if !bytes.HasPrefix(image, pngSignature) {
+		return utils.FormatError(ErrOverride, "thumbnail is not a PNG image")
+	}
+
+	ov[ThumbnailPath] = Override{Data: image, MediaType: "image/png"}
+
+	return nil
+}
+
+// SetThumbnail adds the override with the PNG image as thumbnail, which is used if the document is
+// written as package. Flat documents have no thumbnail, so nothing is removed if the image is nil.
+func (f *Flat) SetThumbnail(ov Overrides, image []byte) error {
+	if image == nil {
+		return nil
+	}
+
+	if !bytes.HasPrefix(image, pngSignature) {
+		return utils.FormatError(ErrOverride, "thumbnail is not a PNG image")
+	}
+
+	ov[ThumbnailPath] = Override{Data: image, MediaType: "image/png"}
+
+	return nil
+}
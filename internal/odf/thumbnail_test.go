@@ -0,0 +1,47 @@
+package odf
+
+import (
+	"bytes"
+	"io/ioutil"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestSetThumbnail(t *testing.T) {
+	doc, err := NewFromFile("../../testdata/Basic1.ott")
+	require.Nil(t, err)
+
+	// Removed thumbnails are also removed from the manifest
+	ov := Overrides{}
+	require.Nil(t, doc.SetThumbnail(ov, nil))
+	require.True(t, ov[ThumbnailPath].Delete)
+
+	buf := bytes.NewBuffer([]byte(""))
+	require.Nil(t, doc.Write(buf, ov))
+	require.NotContains(t, string(ov["META-INF/manifest.xml"].Data), "Thumbnails")
+
+	// A replacement is added to the manifest once
+	image := append(append([]byte{}, pngSignature...), "image"...)
+
+	ov = Overrides{}
+	require.Nil(t, doc.SetThumbnail(ov, image))
+	require.Equal(t, image, ov[ThumbnailPath].Data)
+
+	buf.Reset()
+	require.Nil(t, doc.Write(buf, ov))
+	require.Equal(t, 1, bytes.Count(ov["META-INF/manifest.xml"].Data, []byte(ThumbnailPath)))
+
+	out, err := New(bytes.NewReader(buf.Bytes()), int64(buf.Len()))
+	require.Nil(t, err)
+
+	fd, err := out.Open(ThumbnailPath)
+	require.Nil(t, err)
+
+	data, err := ioutil.ReadAll(fd)
+	require.Nil(t, err)
+	require.Equal(t, image, data)
+
+	// Only PNG images are valid thumbnails
+	require.ErrorIs(t, doc.SetThumbnail(Overrides{}, []byte("GIF89a")), ErrOverride)
+}
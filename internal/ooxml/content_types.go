@@ -6,11 +6,46 @@ import (
 	"errors"
 	"fmt"
 	"io"
+	"strings"
 )
 
 const MainDocumentContentType = "application/vnd.openxmlformats-officedocument.wordprocessingml.document.main+xml"
+const SpreadsheetContentType = "application/vnd.openxmlformats-officedocument.spreadsheetml.sheet.main+xml"
+const PresentationContentType = "application/vnd.openxmlformats-officedocument.presentationml.presentation.main+xml"
+const TemplateContentType = "application/vnd.openxmlformats-officedocument.wordprocessingml.template.main+xml"
+const MacroDocumentContentType = "application/vnd.ms-word.document.macroEnabled.main+xml"
+const MacroTemplateContentType = "application/vnd.ms-word.template.macroEnabledTemplate.main+xml"
 const OpenxmlNamespace = "http://schemas.openxmlformats.org/package/2006/content-types"
 
+// mainContentTypes are the content types of the supported main parts.
+var mainContentTypes = []string{
+	MainDocumentContentType, TemplateContentType, MacroDocumentContentType, MacroTemplateContentType,
+	SpreadsheetContentType, PresentationContentType,
+}
+
+// DocumentContentType returns the content type of the document that is created from
+// a main part of the given content type. Templates become documents and macro-enabled
+// documents become plain documents, if the macros are stripped.
+func DocumentContentType(contentType string, stripMacros bool) string {
+	switch {
+	case contentType == TemplateContentType:
+		return MainDocumentContentType
+	case IsMacroEnabled(contentType) && stripMacros:
+		return MainDocumentContentType
+	case contentType == MacroTemplateContentType:
+		return MacroDocumentContentType
+	default:
+		return contentType
+	}
+}
+
+// IsMacroEnabled reports if the content type is the one of a macro-enabled main part.
+func IsMacroEnabled(contentType string) bool {
+	return contentType == MacroDocumentContentType || contentType == MacroTemplateContentType
+}
+
+var utf8BOM = []byte("\xef\xbb\xbf")
+
 var ErrUnexpectedTokenType = errors.New("unexpected token type")
 var ErrContentTypeValidation = errors.New(" of ooxml in invalid")
 
@@ -82,3 +117,69 @@ func partNameIsDocument(e xml.StartElement) bool {
 
 	return hasDocPart && hasCorrectMimeType
 }
+
+// contentTypes holds the content types of a package as declared in [Content_Types].xml.
+type contentTypes struct {
+	defaults  map[string]string // Content type by file extension
+	overrides map[string]string // Content type by part name, e.g. `/word/document.xml`
+}
+
+// parseContentTypes parses a [Content_Types].xml.
+func parseContentTypes(b []byte) (*contentTypes, error) {
+	ct := &contentTypes{
+		defaults:  map[string]string{},
+		overrides: map[string]string{},
+	}
+
+	d := xml.NewDecoder(bytes.NewReader(bytes.TrimPrefix(b, utf8BOM)))
+	hasTypesElement := false
+
+	for {
+		tok, err := d.Token()
+		if err == io.EOF {
+			break
+		}
+
+		if err != nil {
+			return nil, fmt.Errorf("reading xml token: %w", err)
+		}
+
+		e, ok := tok.(xml.StartElement)
+		if !ok || e.Name.Space != OpenxmlNamespace {
+			continue
+		}
+
+		attrs := map[string]string{}
+		for _, a := range e.Attr {
+			attrs[a.Name.Local] = a.Value
+		}
+
+		switch e.Name.Local {
+		case "Types":
+			hasTypesElement = true
+		case "Default":
+			ct.defaults[strings.ToLower(attrs["Extension"])] = attrs["ContentType"]
+		case "Override":
+			ct.overrides[attrs["PartName"]] = attrs["ContentType"]
+		}
+	}
+
+	if !hasTypesElement {
+		return nil, fmt.Errorf("no types element found: %w", ErrContentTypeValidation)
+	}
+
+	return ct, nil
+}
+
+// mainPart returns the name and content type of the main part of the package.
+func (ct *contentTypes) mainPart() (string, string, bool) {
+	for _, contentType := range mainContentTypes {
+		for name, t := range ct.overrides {
+			if t == contentType {
+				return name, contentType, true
+			}
+		}
+	}
+
+	return "", "", false
+}
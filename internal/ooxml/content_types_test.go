@@ -26,3 +26,14 @@ func TestParseManifest(t *testing.T) {
 	err := validateManifest([]byte(testmanifest))
 	require.Nil(t, err)
 }
+
+func TestMainPart(t *testing.T) {
+	ct, err := parseContentTypes(append(utf8BOM, testmanifest...))
+	require.Nil(t, err)
+	require.Equal(t, "image/png", ct.defaults["png"])
+
+	name, contentType, ok := ct.mainPart()
+	require.True(t, ok)
+	require.Equal(t, "/word/document.xml", name)
+	require.Equal(t, MainDocumentContentType, contentType)
+}
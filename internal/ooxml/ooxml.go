@@ -7,6 +7,8 @@ import (
 	"io"
 	"io/fs"
 	"io/ioutil"
+	"strings"
+	"time"
 
 	"github.com/microfast-ch/rea/internal/utils"
 	"golang.org/x/exp/slices"
@@ -19,9 +21,11 @@ var ErrArchive = errors.New("archiveErr")
 
 // ODF defines an OpenDocument file that is concurrently accessible.
 type OOXML struct {
-	zipFD       *zip.Reader
-	zipFDCloser io.Closer
-	mimetype    string
+	zipFD         *zip.Reader
+	zipFDCloser   io.Closer
+	mimetype      string
+	mainPart      string
+	deterministic bool
 }
 
 // New returns an ooxml instance for the given document with the given size.
@@ -65,10 +69,44 @@ func (o *OOXML) MIMEType() string {
 	return o.mimetype
 }
 
+// MainPart returns the path of the main part inside the package, e.g. `word/document.xml`.
+func (o *OOXML) MainPart() string {
+	return o.mainPart
+}
+
 func (o *OOXML) InitScript() string {
-	// Configures iteration nodes for table rows
-	// TODO: Lists
-	return "-- OOXML Init Script\nSetIterationNodes({\"tr\"})"
+	switch o.mimetype {
+	case SpreadsheetContentType:
+		// Configures iteration nodes for sheet rows and removes rows that contain only control blocks
+		return `-- OOXML Spreadsheet Init Script
+SetIterationNodes({"row"})
+SetRemovableNodes({"row"})
+SetWrapperNodes({"row", "c", "is", "r", "t", "v"})
+SetIgnoredNodes({"rPr"})`
+	case PresentationContentType:
+		// Configures iteration nodes for table rows, the aliases for repeated units and the
+		// elements that are removed if they contain only control blocks. Text bodies keep
+		// a paragraph. Repeated slides are split into slide parts afterwards.
+		return `-- OOXML Presentation Init Script
+SetIterationNodes({"tr"})
+SetRepeatAliases({slide = "sld", row = "tr", paragraph = "p", shape = "sp"})
+SetRemovableNodes({"p", "tr", "sp"})
+SetWrapperNodes({"p", "r", "t", "txBody", "tr", "tc"})
+SetIgnoredNodes({"pPr", "rPr", "tcPr", "nvSpPr", "spPr", "style", "bodyPr", "lstStyle", "endParaRPr"})
+SetRequiredChildren({txBody = "p"})`
+	default:
+		// Configures iteration nodes for table rows, the aliases for repeated units
+		// and the elements that are removed if they contain only control blocks.
+		// Table cells keep a paragraph.
+		// TODO: Lists
+		return `-- OOXML Init Script
+SetIterationNodes({"tr"})
+SetRepeatAliases({row = "tr", paragraph = "p"})
+SetRemovableNodes({"p", "tr"})
+SetWrapperNodes({"p", "r", "t", "hyperlink", "smartTag", "sdt", "sdtContent", "tr", "tc"})
+SetIgnoredNodes({"pPr", "rPr", "tcPr", "trPr", "proofErr", "bookmarkStart", "bookmarkEnd", "lastRenderedPageBreak"})
+SetRequiredChildren({tc = "p"})`
+	}
 }
 
 // Opens the given file as fs.File.
@@ -93,25 +131,29 @@ func (o *OOXML) Close() error {
 }
 
 // Writes an OOXML package to the given writer. It will use the loaded OOXML contents
-// as base and incorporate the overrides.
+// as base and incorporate the overrides. The content types and relationships are updated
+// for added and deleted parts.
 func (o *OOXML) Write(w io.Writer, ov Overrides) error {
 	if ov == nil {
 		ov = Overrides{}
 	}
 
-	zipWriter := zip.NewWriter(w)
-
-	// Write files from overrides
-	writtenFiles := []string{}
+	// Relationships first, as deleted relationship parts don't need a content type
+	err := o.updateRelationships(ov)
+	if err != nil {
+		return fmt.Errorf("updating relationships: %w", err)
+	}
 
-	writtenFiles, err := o.writeOverrides(writtenFiles, ov, zipWriter)
+	err = o.updateContentTypes(ov)
 	if err != nil {
-		return err
+		return fmt.Errorf("updating [Content_Types].xml: %w", err)
 	}
 
-	err = o.writeUntouched(writtenFiles, zipWriter)
+	zipWriter := utils.NewZipWriter(w)
+
+	err = o.writeEntries(ov, zipWriter)
 	if err != nil {
-		return fmt.Errorf("error writing untouched files: %w", err)
+		return err
 	}
 
 	// Finish archive
@@ -123,64 +165,99 @@ func (o *OOXML) Write(w io.Writer, ov Overrides) error {
 	return nil
 }
 
-func (o *OOXML) writeUntouched(writtenFiles []string, zipWriter *zip.Writer) error {
-	// Write other files from loaded ODF that are not already defined in writtenFiles to skip
-	for _, v := range o.zipFD.File {
-		// Skip already written files (or just skipped/deleted files)
-		if slices.Contains(writtenFiles, v.Name) {
-			continue
-		}
+// writeEntries writes the parts of the template in their order, with the overrides applied.
+// Parts that are added by the overrides follow, sorted by name.
+func (o *OOXML) writeEntries(ov Overrides, zipWriter *zip.Writer) error {
+	names := map[string]bool{}
 
-		// Write file from loaded ODF to new package
-		f, err := zipWriter.Create(v.Name)
-		if err != nil {
-			return utils.FormatError(ErrArchive, fmt.Sprintf("unable to recreate file %s from template: %q", v.Name, err))
+	for _, f := range o.zipFD.File {
+		names[f.Name] = true
+
+		if v, ok := ov[f.Name]; ok {
+			err := o.writeOverride(f.Name, v, zipWriter)
+			if err != nil {
+				return err
+			}
+
+			continue
 		}
 
-		data, err := v.Open()
+		err := o.writeUntouched(f, zipWriter)
 		if err != nil {
-			return utils.FormatError(ErrArchive, fmt.Sprintf("unable to open file %s from template: %q", v.Name, err))
+			return fmt.Errorf("error writing untouched files: %w", err)
 		}
+	}
 
-		dataBytes, err := ioutil.ReadAll(data)
-		data.Close()
+	added := []string{}
 
-		if err != nil {
-			return utils.FormatError(ErrArchive, fmt.Sprintf("unable to read file %s from template: %q", v.Name, err))
+	for name := range ov {
+		if !names[name] {
+			added = append(added, name)
 		}
+	}
+
+	slices.Sort(added)
 
-		_, err = f.Write(dataBytes)
+	for _, name := range added {
+		err := o.writeOverride(name, ov[name], zipWriter)
 		if err != nil {
-			return utils.FormatError(ErrArchive, fmt.Sprintf("unable to write file %s to archive: %q", v.Name, err))
+			return err
 		}
 	}
 
 	return nil
 }
 
-func (o *OOXML) writeOverrides(writtenFiles []string, ov Overrides, zipWriter *zip.Writer) ([]string, error) {
-	for fname, fdata := range ov {
-		writtenFiles = append(writtenFiles, fname)
+// Write an untouched file from the loaded package which was not processed by any override.
+// The compressed data is copied as it is, so it isn't decompressed and compressed again.
+func (o *OOXML) writeUntouched(v *zip.File, zipWriter *zip.Writer) error {
+	err := utils.CopyZipFile(zipWriter, v, o.deterministic)
+	if err != nil {
+		return utils.FormatError(ErrArchive, fmt.Sprintf("unable to copy file %s from template: %q", v.Name, err))
+	}
 
-		// Skip writing file if we delete it from archive
-		if fdata.Delete {
-			continue
-		}
+	return nil
+}
 
-		// Write file
-		f, err := zipWriter.Create(fname)
-		if err != nil {
-			return nil, utils.FormatError(ErrOverride,
-				fmt.Sprintf("unable to create file %s fom override in final archive: %q", fname, err))
-		}
+// writeOverride writes the part of the override, unless it is deleted.
+func (o *OOXML) writeOverride(fname string, fdata Override, zipWriter *zip.Writer) error {
+	// Skip writing file if we delete it from archive
+	if fdata.Delete {
+		return nil
+	}
 
+	f, err := zipWriter.CreateHeader(&zip.FileHeader{Name: fname, Method: zip.Deflate, Modified: o.modTime(time.Now())})
+	if err != nil {
+		return utils.FormatError(ErrOverride,
+			fmt.Sprintf("unable to create file %s fom override in final archive: %q", fname, err))
+	}
+
+	if fdata.Reader != nil {
+		_, err = io.Copy(f, fdata.Reader)
+	} else {
 		_, err = f.Write(fdata.Data)
-		if err != nil {
-			return nil, utils.FormatError(ErrOverride, fmt.Sprintf("unable to write file %s fom override in final archive: %q", fname, err))
-		}
 	}
 
-	return writtenFiles, nil
+	if err != nil {
+		return utils.FormatError(ErrOverride, fmt.Sprintf("unable to write file %s fom override in final archive: %q", fname, err))
+	}
+
+	return nil
+}
+
+// SetDeterministic sets if written packages are byte-identical for identical input. The entries
+// get a fixed modification time instead of the one of the template or the time of writing.
+func (o *OOXML) SetDeterministic(deterministic bool) {
+	o.deterministic = deterministic
+}
+
+// modTime returns the modification time of an entry, which is fixed for deterministic packages.
+func (o *OOXML) modTime(t time.Time) time.Time {
+	if o.deterministic {
+		return utils.ZipModTime
+	}
+
+	return t
 }
 
 // http://officeopenxml.com/anatomyofOOXML.php
@@ -194,14 +271,30 @@ func (o *OOXML) ValidateAndSetMIMEType() error {
 	}
 	defer fd.Close()
 
-	// Main Document: Contains the body of the document.
-	fd, err = o.Open("word/document.xml")
+	contentTypesBytes, err := ioutil.ReadAll(fd)
+	if err != nil {
+		return fmt.Errorf("reading [Content_Types].xml: %w", err)
+	}
+
+	ct, err := parseContentTypes(contentTypesBytes)
+	if err != nil {
+		return fmt.Errorf("parsing [Content_Types].xml: %w", err)
+	}
+
+	// Main part: Contains the body of the document or the workbook of a spreadsheet.
+	name, contentType, ok := ct.mainPart()
+	if !ok {
+		return utils.FormatError(ErrMimetype, "no supported main part found in [Content_Types].xml")
+	}
+
+	fd, err = o.Open(strings.TrimPrefix(name, "/"))
 	if err != nil {
-		return fmt.Errorf("Main Document: %w", err)
+		return fmt.Errorf("Main part: %w", err)
 	}
 	defer fd.Close()
 
-	o.mimetype = MainDocumentContentType
+	o.mimetype = contentType
+	o.mainPart = strings.TrimPrefix(name, "/")
 
 	return nil
 }
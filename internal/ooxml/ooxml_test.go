@@ -1,6 +1,7 @@
 package ooxml
 
 import (
+	"archive/zip"
 	"bytes"
 	"io/ioutil"
 	"testing"
@@ -87,3 +88,52 @@ func TestWrite(t *testing.T) {
 	require.Nil(t, err)
 	require.Equal(t, []byte("my-extra-file"), updatedData)
 }
+
+func TestWriteStreamed(t *testing.T) {
+	doc, err := NewFromFile("../../testdata/Basic1.docx")
+	require.Nil(t, err)
+
+	buf := new(bytes.Buffer)
+	ov := Overrides{
+		"media/video.bin": Override{Reader: bytes.NewReader([]byte("my-streamed-file"))},
+	}
+	require.Nil(t, doc.Write(buf, ov))
+
+	out, err := zip.NewReader(bytes.NewReader(buf.Bytes()), int64(buf.Len()))
+	require.Nil(t, err)
+
+	// Untouched entries keep their compressed data
+	require.Equal(t, rawEntry(t, doc.zipFD, "word/styles.xml"), rawEntry(t, out, "word/styles.xml"))
+
+	// The streamed override is written to the package
+	doc, err = New(bytes.NewReader(buf.Bytes()), int64(buf.Len()))
+	require.Nil(t, err)
+
+	fd, err := doc.Open("media/video.bin")
+	require.Nil(t, err)
+
+	data, err := ioutil.ReadAll(fd)
+	require.Nil(t, err)
+	require.Equal(t, []byte("my-streamed-file"), data)
+}
+
+// rawEntry returns the compressed data of the entry.
+func rawEntry(t *testing.T, r *zip.Reader, name string) []byte {
+	t.Helper()
+
+	for _, f := range r.File {
+		if f.Name == name {
+			fd, err := f.OpenRaw()
+			require.Nil(t, err)
+
+			data, err := ioutil.ReadAll(fd)
+			require.Nil(t, err)
+
+			return data
+		}
+	}
+
+	require.Fail(t, "entry not found", name)
+
+	return nil
+}
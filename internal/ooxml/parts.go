@@ -0,0 +1,202 @@
+package ooxml
+
+import (
+	"fmt"
+	"mime"
+	"path"
+	"strings"
+
+	"golang.org/x/exp/slices"
+)
+
+// packageParts returns the parts that are written to the package with the overrides.
+func (o *OOXML) packageParts(ov Overrides) map[string]bool {
+	parts := map[string]bool{}
+
+	for _, f := range o.zipFD.File {
+		if v, ok := ov[f.Name]; !ok || !v.Delete {
+			parts[f.Name] = true
+		}
+	}
+
+	for name, v := range ov {
+		if !v.Delete {
+			parts[name] = true
+		}
+	}
+
+	return parts
+}
+
+// updateContentTypes sets the [Content_Types].xml override to content types that match the written
+// package. Overrides of parts that are not part of the package anymore are removed. Added parts get
+// an override with their media type, or a default for their extension if they have none.
+// The content types are changed textually, so everything else stays as written by the office suite.
+func (o *OOXML) updateContentTypes(ov Overrides) error {
+	data, err := o.overriddenPart(ov, "[Content_Types].xml")
+	if err != nil {
+		return err
+	}
+
+	ct, err := parseContentTypes(data)
+	if err != nil {
+		return fmt.Errorf("parsing [Content_Types].xml: %w", err)
+	}
+
+	parts := map[string]bool{}
+	for p := range o.packageParts(ov) {
+		parts["/"+strings.ToLower(p)] = true
+	}
+
+	changed := false
+
+	// Part names are case-insensitive
+	for name := range ct.overrides {
+		if !parts[strings.ToLower(name)] {
+			data = elementWithAttr("Override", "PartName", name).ReplaceAll(data, nil)
+			changed = true
+		}
+	}
+
+	var added strings.Builder
+
+	for _, name := range sortedNames(ov) {
+		v := ov[name]
+		if v.Delete || name == "[Content_Types].xml" || strings.HasSuffix(name, "/") {
+			continue
+		}
+
+		partName := "/" + name
+		ext := strings.ToLower(strings.TrimPrefix(path.Ext(name), "."))
+
+		current, ok := ct.overrides[partName]
+		if !ok {
+			current, ok = ct.defaults[ext]
+		}
+
+		switch {
+		case v.MediaType == "" && ok, v.MediaType != "" && v.MediaType == current:
+			continue
+		case v.MediaType == "" && ext != "":
+			fmt.Fprintf(&added, `<Default Extension="%s" ContentType="%s"/>`, ext, partMediaType(name))
+			ct.defaults[ext] = partMediaType(name)
+		case v.MediaType == "":
+			fmt.Fprintf(&added, `<Override PartName="%s" ContentType="%s"/>`, partName, partMediaType(name))
+		default:
+			data = elementWithAttr("Override", "PartName", partName).ReplaceAll(data, nil)
+			fmt.Fprintf(&added, `<Override PartName="%s" ContentType="%s"/>`, partName, v.MediaType)
+		}
+
+		changed = true
+	}
+
+	if !changed {
+		return nil
+	}
+
+	data, err = insertBefore(data, "</Types>", added.String())
+	if err != nil {
+		return err
+	}
+
+	ov["[Content_Types].xml"] = Override{Data: data}
+
+	return nil
+}
+
+// updateRelationships removes the relationships to deleted parts. The relationships of deleted parts
+// are deleted as well. The relationships are changed textually, so everything else stays as written
+// by the office suite.
+func (o *OOXML) updateRelationships(ov Overrides) error {
+	deleted := map[string]bool{}
+
+	for name, v := range ov {
+		if v.Delete {
+			deleted[name] = true
+		}
+	}
+
+	if len(deleted) == 0 {
+		return nil
+	}
+
+	parts := o.packageParts(ov)
+
+	for name := range deleted {
+		if rels := RelsPart(name); parts[rels] {
+			ov[rels] = Override{Delete: true}
+			delete(parts, rels)
+		}
+	}
+
+	for name := range parts {
+		source, ok := relsSource(name)
+		if !ok {
+			continue
+		}
+
+		data, err := o.overriddenPart(ov, name)
+		if err != nil {
+			return err
+		}
+
+		rels, err := parseRelationships(data, source)
+		if err != nil {
+			return fmt.Errorf("parsing %s: %w", name, err)
+		}
+
+		changed := false
+
+		for _, rel := range rels {
+			if deleted[rel.Target] {
+				data = elementWithAttr("Relationship", "Id", rel.ID).ReplaceAll(data, nil)
+				changed = true
+			}
+		}
+
+		if changed {
+			ov[name] = Override{Data: data}
+		}
+	}
+
+	return nil
+}
+
+// relsSource returns the part of which the relationships part holds the relationships.
+// The source of the package relationships is empty.
+func relsSource(name string) (string, bool) {
+	dir, base := path.Split(name)
+	if path.Base(dir) != "_rels" || !strings.HasSuffix(base, ".rels") {
+		return "", false
+	}
+
+	source := strings.TrimSuffix(base, ".rels")
+	if source == "" {
+		return "", true
+	}
+
+	return path.Join(path.Dir(path.Dir(dir)), source), true
+}
+
+// partMediaType returns the media type of the part by its extension.
+func partMediaType(name string) string {
+	// Parameters like the charset of `text/xml; charset=utf-8` are not part of content types
+	if t := mime.TypeByExtension(path.Ext(name)); t != "" {
+		t, _, _ = strings.Cut(t, ";")
+		return t
+	}
+
+	return "application/octet-stream"
+}
+
+// sortedNames returns the names of the overrides in order, so changes are reproducible.
+func sortedNames(ov Overrides) []string {
+	names := make([]string, 0, len(ov))
+	for name := range ov {
+		names = append(names, name)
+	}
+
+	slices.Sort(names)
+
+	return names
+}
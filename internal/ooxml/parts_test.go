@@ -0,0 +1,61 @@
+package ooxml
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestWritePackageParts(t *testing.T) {
+	doc, err := NewFromFile("../../testdata/Basic1.dotx")
+	require.Nil(t, err)
+
+	ov := Overrides{
+		"customXml/item1.xml": Override{Delete: true},
+		"docProps/custom.xml": Override{Delete: true},
+		"word/media/logo.png": Override{Data: []byte("logo")},
+		"word/data.xml":       Override{Data: []byte("<data/>"), MediaType: "application/vnd.example+xml"},
+	}
+
+	buf := bytes.NewBuffer([]byte(""))
+	require.Nil(t, doc.Write(buf, ov))
+
+	// Relationships to deleted parts and the relationships of deleted parts are removed
+	require.True(t, ov["customXml/_rels/item1.xml.rels"].Delete)
+	require.NotContains(t, string(ov["word/_rels/document.xml.rels"].Data), "item1.xml")
+	require.Contains(t, string(ov["word/_rels/document.xml.rels"].Data), "item2.xml")
+	require.NotContains(t, string(ov["_rels/.rels"].Data), "custom.xml")
+
+	// Content types follow the parts
+	contentTypes := string(ov["[Content_Types].xml"].Data)
+	require.NotContains(t, contentTypes, "/docProps/custom.xml")
+	require.Contains(t, contentTypes, `<Default Extension="png" ContentType="image/png"/>`)
+	require.Contains(t, contentTypes, `<Override PartName="/word/data.xml" ContentType="application/vnd.example+xml"/>`)
+	require.NotContains(t, contentTypes, "/word/media/logo.png")
+
+	out, err := New(bytes.NewReader(buf.Bytes()), int64(buf.Len()))
+	require.Nil(t, err)
+	require.Equal(t, TemplateContentType, out.MIMEType())
+
+	// Unchanged packages keep their parts
+	ov = Overrides{}
+	require.Nil(t, doc.Write(buf, ov))
+	require.Len(t, ov, 0)
+}
+
+func TestRelsSource(t *testing.T) {
+	for name, want := range map[string]string{
+		"_rels/.rels":                      "",
+		"word/_rels/document.xml.rels":     "word/document.xml",
+		"customXml/_rels/item1.xml.rels":   "customXml/item1.xml",
+		"ppt/slides/_rels/slide1.xml.rels": "ppt/slides/slide1.xml",
+	} {
+		source, ok := relsSource(name)
+		require.True(t, ok, name)
+		require.Equal(t, want, source, name)
+	}
+
+	_, ok := relsSource("word/document.xml")
+	require.False(t, ok)
+}
@@ -0,0 +1,106 @@
+package ooxml
+
+import (
+	"bytes"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"path"
+	"strings"
+)
+
+// Relationship is a relationship of a part to another part or to an external target.
+type Relationship struct {
+	ID       string
+	Type     string // Type of the relationship, e.g. `.../relationships/worksheet`
+	Target   string // Part name of the target without leading slash, external targets are kept as they are
+	External bool   // The target is outside of the package, like the URL of a hyperlink
+}
+
+// ReadRelationships returns the relationships of the part in the order of the relationships part.
+// Pass the empty part for the relationships of the package.
+func (o *OOXML) ReadRelationships(part string) ([]Relationship, error) {
+	data, err := o.ReadPart(RelsPart(part))
+	if err != nil {
+		return nil, err
+	}
+
+	return parseRelationships(data, part)
+}
+
+// parseRelationships returns the relationships of the relationships part of the part.
+func parseRelationships(data []byte, part string) ([]Relationship, error) {
+	d := xml.NewDecoder(bytes.NewReader(data))
+	rels := []Relationship{}
+
+	for {
+		tok, err := d.Token()
+		if err == io.EOF {
+			break
+		}
+
+		if err != nil {
+			return nil, fmt.Errorf("reading xml token: %w", err)
+		}
+
+		e, ok := tok.(xml.StartElement)
+		if !ok || e.Name.Local != "Relationship" {
+			continue
+		}
+
+		rel := Relationship{}
+
+		for _, a := range e.Attr {
+			switch a.Name.Local {
+			case "Id":
+				rel.ID = a.Value
+			case "Type":
+				rel.Type = a.Value
+			case "Target":
+				rel.Target = a.Value
+			case "TargetMode":
+				rel.External = a.Value == "External"
+			}
+		}
+
+		// Targets are relative to the part or absolute inside the package
+		switch {
+		case rel.External:
+		case strings.HasPrefix(rel.Target, "/"):
+			rel.Target = strings.TrimPrefix(rel.Target, "/")
+		default:
+			rel.Target = path.Join(path.Dir(part), rel.Target)
+		}
+
+		rels = append(rels, rel)
+	}
+
+	return rels, nil
+}
+
+// ReadPart returns the content of the part without byte order mark. Office writes one,
+// which would be decoded as char data before the XML declaration.
+func (o *OOXML) ReadPart(name string) ([]byte, error) {
+	fd, err := o.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	defer fd.Close()
+
+	data, err := ioutil.ReadAll(fd)
+	if err != nil {
+		return nil, fmt.Errorf("reading %s: %w", name, err)
+	}
+
+	return bytes.TrimPrefix(data, utf8BOM), nil
+}
+
+// RelsPart returns the relationships part of the given part, or of the package if part is empty.
+func RelsPart(part string) string {
+	if part == "" {
+		return "_rels/.rels"
+	}
+
+	return path.Join(path.Dir(part), "_rels", path.Base(part)+".rels")
+}
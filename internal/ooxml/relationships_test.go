@@ -0,0 +1,24 @@
+package ooxml
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseRelationships(t *testing.T) {
+	data := []byte(`<Relationships xmlns="http://schemas.openxmlformats.org/package/2006/relationships">` +
+		`<Relationship Id="rId1" Type="http://example.com/styles" Target="styles.xml"/>` +
+		`<Relationship Id="rId2" Type="http://example.com/image" Target="/word/media/logo.png"/>` +
+		`<Relationship Id="rId3" Type="http://example.com/hyperlink" Target="https://example.com/a b" TargetMode="External"/>` +
+		`</Relationships>`)
+
+	// Targets are resolved to part names, external targets are kept
+	rels, err := parseRelationships(data, "word/document.xml")
+	require.Nil(t, err)
+	require.Equal(t, []Relationship{
+		{ID: "rId1", Type: "http://example.com/styles", Target: "word/styles.xml"},
+		{ID: "rId2", Type: "http://example.com/image", Target: "word/media/logo.png"},
+		{ID: "rId3", Type: "http://example.com/hyperlink", Target: "https://example.com/a b", External: true},
+	}, rels)
+}
@@ -0,0 +1,105 @@
+package ooxml
+
+import (
+	"fmt"
+	"path"
+	"regexp"
+	"strings"
+
+	"github.com/microfast-ch/rea/internal/utils"
+)
+
+const vbaProjectContentType = "application/vnd.ms-office.vbaProject"
+
+// Retype returns the overrides that change the content type of the main part. If stripMacros
+// is set, the VBA project and the parts that belong to it are removed from the package.
+// The parts are changed textually, so everything else stays as written by the office suite.
+func (o *OOXML) Retype(contentType string, stripMacros bool) (Overrides, error) {
+	contentTypes, err := o.ReadPart("[Content_Types].xml")
+	if err != nil {
+		return nil, err
+	}
+
+	ov := Overrides{}
+
+	mainOverride := elementWithAttr("Override", "PartName", "/"+o.mainPart)
+	if !mainOverride.Match(contentTypes) {
+		return nil, utils.FormatError(ErrOverride, fmt.Sprintf("[Content_Types].xml has no override for %s", o.mainPart))
+	}
+
+	contentTypes = mainOverride.ReplaceAllFunc(contentTypes, func(elem []byte) []byte {
+		return contentTypeAttr.ReplaceAll(elem, []byte(`ContentType="`+contentType+`"`))
+	})
+
+	if stripMacros {
+		contentTypes, err = o.stripMacros(contentTypes, ov)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	ov["[Content_Types].xml"] = Override{Data: contentTypes}
+
+	return ov, nil
+}
+
+var contentTypeAttr = regexp.MustCompile(`\bContentType="[^"]*"`)
+
+// stripMacros adds the overrides that delete the VBA project of the main part and
+// returns the content types without the deleted parts.
+func (o *OOXML) stripMacros(contentTypes []byte, ov Overrides) ([]byte, error) {
+	mainRelsPart := RelsPart(o.mainPart)
+
+	rels, err := o.ReadRelationships(o.mainPart)
+	if err != nil {
+		return nil, err
+	}
+
+	mainRels, err := o.ReadPart(mainRelsPart)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, rel := range rels {
+		if !strings.HasSuffix(rel.Type, "/vbaProject") {
+			continue
+		}
+
+		mainRels = elementWithAttr("Relationship", "Id", rel.ID).ReplaceAll(mainRels, nil)
+
+		// The VBA project has related parts, like the data of the controls
+		parts := []string{rel.Target}
+
+		vbaRels, err := o.ReadRelationships(rel.Target)
+		if err == nil {
+			parts = append(parts, RelsPart(rel.Target))
+
+			for _, r := range vbaRels {
+				if !r.External {
+					parts = append(parts, r.Target, RelsPart(r.Target))
+				}
+			}
+		}
+
+		for _, part := range parts {
+			ov[part] = Override{Delete: true}
+			contentTypes = elementWithAttr("Override", "PartName", "/"+part).ReplaceAll(contentTypes, nil)
+		}
+	}
+
+	ov[mainRelsPart] = Override{Data: mainRels}
+
+	// The default for the extension of the VBA project is only removed, if no other part needs it
+	for _, f := range o.zipFD.File {
+		if _, deleted := ov[f.Name]; !deleted && strings.EqualFold(path.Ext(f.Name), ".bin") {
+			return contentTypes, nil
+		}
+	}
+
+	return elementWithAttr("Default", "ContentType", vbaProjectContentType).ReplaceAll(contentTypes, nil), nil
+}
+
+// elementWithAttr returns an expression that matches the empty element with the attribute value.
+func elementWithAttr(local, attr, value string) *regexp.Regexp {
+	return regexp.MustCompile(`<(?:\w+:)?` + local + `\b[^>]*\b` + attr + `="` + regexp.QuoteMeta(value) + `"[^>]*/>`)
+}
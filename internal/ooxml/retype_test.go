@@ -0,0 +1,69 @@
+package ooxml
+
+import (
+	"bytes"
+	"io/ioutil"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestDocumentContentType(t *testing.T) {
+	tests := []struct {
+		contentType string
+		strip       bool
+		want        string
+	}{
+		{MainDocumentContentType, false, MainDocumentContentType},
+		{TemplateContentType, false, MainDocumentContentType},
+		{MacroTemplateContentType, false, MacroDocumentContentType},
+		{MacroTemplateContentType, true, MainDocumentContentType},
+		{MacroDocumentContentType, false, MacroDocumentContentType},
+		{MacroDocumentContentType, true, MainDocumentContentType},
+		{SpreadsheetContentType, true, SpreadsheetContentType},
+	}
+
+	for _, tc := range tests {
+		require.Equal(t, tc.want, DocumentContentType(tc.contentType, tc.strip), tc.contentType)
+	}
+}
+
+func TestRetype(t *testing.T) {
+	doc, err := NewFromFile("../../testdata/Macro1.dotm")
+	require.Nil(t, err)
+	require.Equal(t, MacroTemplateContentType, doc.MIMEType())
+
+	// Keep the macros
+	ov, err := doc.Retype(MacroDocumentContentType, false)
+	require.Nil(t, err)
+	require.Len(t, ov, 1)
+	require.Contains(t, string(ov["[Content_Types].xml"].Data), `vbaProject`)
+
+	// Strip the macros
+	ov, err = doc.Retype(MainDocumentContentType, true)
+	require.Nil(t, err)
+
+	for _, part := range []string{"word/vbaProject.bin", "word/_rels/vbaProject.bin.rels", "word/vbaData.xml"} {
+		require.True(t, ov[part].Delete, part)
+	}
+
+	require.NotContains(t, string(ov["word/_rels/document.xml.rels"].Data), "vbaProject")
+
+	buf := bytes.NewBuffer([]byte(""))
+	require.Nil(t, doc.Write(buf, ov))
+	require.Nil(t, doc.Close())
+
+	out, err := New(bytes.NewReader(buf.Bytes()), int64(buf.Len()))
+	require.Nil(t, err)
+	require.Equal(t, MainDocumentContentType, out.MIMEType())
+
+	_, err = out.Open("word/vbaProject.bin")
+	require.Error(t, err)
+
+	fd, err := out.Open("[Content_Types].xml")
+	require.Nil(t, err)
+
+	contentTypes, err := ioutil.ReadAll(fd)
+	require.Nil(t, err)
+	require.NotContains(t, string(contentTypes), "vba")
+}
@@ -0,0 +1,112 @@
+package ooxml
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"strconv"
+	"strings"
+
+	"github.com/microfast-ch/rea/internal/utils"
+)
+
+const thumbnailRelType = "http://schemas.openxmlformats.org/package/2006/relationships/metadata/thumbnail"
+
+// ThumbnailPart is the part of a thumbnail image that replaces the one of the package.
+const ThumbnailPart = "docProps/thumbnail.png"
+
+var pngSignature = []byte("\x89PNG\r\n\x1a\n")
+
+// SetThumbnail adds the overrides that replace the thumbnail of the package with the PNG image.
+// If the image is nil, the thumbnail is deleted. Its relationship and content type follow when the
+// package is written, the relationship of a replacement is added to the package relationships.
+func (o *OOXML) SetThumbnail(ov Overrides, image []byte) error {
+	if image != nil && !bytes.HasPrefix(image, pngSignature) {
+		return utils.FormatError(ErrOverride, "thumbnail is not a PNG image")
+	}
+
+	rels, err := o.ReadRelationships("")
+	if err != nil {
+		return err
+	}
+
+	ids := map[string]bool{}
+	related := false
+
+	for _, rel := range rels {
+		ids[rel.ID] = true
+
+		if !strings.HasSuffix(rel.Type, "/metadata/thumbnail") {
+			continue
+		}
+
+		if image != nil && rel.Target == ThumbnailPart {
+			related = true
+			continue
+		}
+
+		ov[rel.Target] = Override{Delete: true}
+	}
+
+	if image == nil {
+		return nil
+	}
+
+	ov[ThumbnailPart] = Override{Data: image, MediaType: "image/png"}
+
+	if related {
+		return nil
+	}
+
+	relsPart := RelsPart("")
+
+	relsData, err := o.overriddenPart(ov, relsPart)
+	if err != nil {
+		return err
+	}
+
+	id := ""
+	for i := len(ids) + 1; id == "" || ids[id]; i++ {
+		id = "rId" + strconv.Itoa(i)
+	}
+
+	relsData, err = insertBefore(relsData, "</Relationships>",
+		fmt.Sprintf(`<Relationship Id="%s" Type="%s" Target="%s"/>`, id, thumbnailRelType, ThumbnailPart))
+	if err != nil {
+		return err
+	}
+
+	ov[relsPart] = Override{Data: relsData}
+
+	return nil
+}
+
+// overriddenPart returns the content of the part from the overrides or from the package.
+// The content of a reader is kept as data of the override, as the reader can only be read once.
+func (o *OOXML) overriddenPart(ov Overrides, name string) ([]byte, error) {
+	if v, ok := ov[name]; ok && !v.Delete {
+		if v.Reader != nil {
+			data, err := ioutil.ReadAll(v.Reader)
+			if err != nil {
+				return nil, fmt.Errorf("reading override %s: %w", name, err)
+			}
+
+			v = Override{Data: data, MediaType: v.MediaType}
+			ov[name] = v
+		}
+
+		return bytes.TrimPrefix(v.Data, utf8BOM), nil
+	}
+
+	return o.ReadPart(name)
+}
+
+// insertBefore inserts the text before the last occurrence of the end tag.
+func insertBefore(data []byte, endTag, text string) ([]byte, error) {
+	idx := bytes.LastIndex(data, []byte(endTag))
+	if idx < 0 {
+		return nil, utils.FormatError(ErrOverride, fmt.Sprintf("missing %s", endTag))
+	}
+
+	return []byte(string(data[:idx]) + text + string(data[idx:])), nil
+}
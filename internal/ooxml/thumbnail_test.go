@@ -0,0 +1,43 @@
+package ooxml
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestSetThumbnail(t *testing.T) {
+	doc, err := NewFromFile("../../testdata/Basic1.dotx")
+	require.Nil(t, err)
+
+	// Packages without thumbnail are not changed
+	ov := Overrides{}
+	require.Nil(t, doc.SetThumbnail(ov, nil))
+	require.Len(t, ov, 0)
+
+	// A replacement is added with its relationship and content type
+	image := append(append([]byte{}, pngSignature...), "image"...)
+	require.Nil(t, doc.SetThumbnail(ov, image))
+	require.Equal(t, image, ov[ThumbnailPart].Data)
+	require.Contains(t, string(ov["_rels/.rels"].Data), `<Relationship Id="rId5" Type="`+thumbnailRelType+`" Target="docProps/thumbnail.png"/>`)
+
+	buf := bytes.NewBuffer([]byte(""))
+	require.Nil(t, doc.Write(buf, ov))
+	require.Contains(t, string(ov["[Content_Types].xml"].Data), `<Override PartName="/docProps/thumbnail.png" ContentType="image/png"/>`)
+
+	// The thumbnail of a package is removed with its relationship and content type
+	out, err := New(bytes.NewReader(buf.Bytes()), int64(buf.Len()))
+	require.Nil(t, err)
+
+	ov = Overrides{}
+	require.Nil(t, out.SetThumbnail(ov, nil))
+	require.True(t, ov[ThumbnailPart].Delete)
+
+	buf.Reset()
+	require.Nil(t, out.Write(buf, ov))
+	require.NotContains(t, string(ov["_rels/.rels"].Data), "thumbnail")
+	require.NotContains(t, string(ov["[Content_Types].xml"].Data), "thumbnail")
+
+	require.ErrorIs(t, doc.SetThumbnail(Overrides{}, []byte("GIF89a")), ErrOverride)
+}
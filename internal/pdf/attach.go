@@ -0,0 +1,357 @@
+// Package pdf modifies PDF documents that were written by a converter.
+package pdf
+
+import (
+	"bytes"
+	"compress/zlib"
+	"errors"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf16"
+
+	"github.com/microfast-ch/rea/internal/utils"
+	"golang.org/x/exp/slices"
+)
+
+var ErrPDF = errors.New("pdfErr")
+
+// File is a file that is embedded into a PDF document.
+type File struct {
+	Name        string    // File name, e.g. `model.json`
+	MediaType   string    // Media type, e.g. `application/json`
+	Description string    // Description shown by PDF viewers
+	Data        []byte    // Content of the file
+	ModTime     time.Time // Modification time, left out if zero
+}
+
+var (
+	startXRef   = regexp.MustCompile(`startxref\s+(\d+)\s+%%EOF\s*$`)
+	trailerRoot = regexp.MustCompile(`/Root\s+(\d+)\s+(\d+)\s+R`)
+	trailerSize = regexp.MustCompile(`/Size\s+(\d+)`)
+	trailerInfo = regexp.MustCompile(`/Info\s+\d+\s+\d+\s+R`)
+	trailerID   = regexp.MustCompile(`/ID\s*\[[^\]]*\]`)
+	namesKey    = regexp.MustCompile(`/Names\b\s*(<<)?`)
+	afKey       = regexp.MustCompile(`/AF\b\s*(\[)?`)
+	pdfaPart    = regexp.MustCompile(`pdfaid:part(?:>|\s*=\s*["'])\s*(\d)`)
+)
+
+// Attach embeds the files into the PDF document and returns the new document. The files are added
+// with an incremental update, so the original document stays as it is. They are associated with the
+// document as its source, as PDF/A-3 requires it for embedded files. PDF/A-1 and PDF/A-2 documents
+// don't allow embedded files and are rejected. Only documents with a cross-reference table are
+// supported, as written by LibreOffice.
+func Attach(doc []byte, files []File) ([]byte, error) {
+	// The metadata stream of PDF/A documents is not compressed, so its identification can be read directly
+	if m := pdfaPart.FindSubmatch(doc); m != nil && string(m[1]) != "3" {
+		return nil, utils.FormatError(ErrPDF, fmt.Sprintf("PDF/A-%s documents don't allow embedded files", m[1]))
+	}
+
+	m := startXRef.FindSubmatch(doc)
+	if m == nil {
+		return nil, utils.FormatError(ErrPDF, "document has no startxref")
+	}
+
+	prev, _ := strconv.Atoi(string(m[1]))
+	if prev >= len(doc) || !bytes.HasPrefix(doc[prev:], []byte("xref")) {
+		return nil, utils.FormatError(ErrPDF, "cross-reference streams are not supported")
+	}
+
+	idx := bytes.Index(doc[prev:], []byte("trailer"))
+	if idx < 0 {
+		return nil, utils.FormatError(ErrPDF, "document has no trailer")
+	}
+
+	trailer := doc[prev+idx:]
+
+	root := trailerRoot.FindSubmatch(trailer)
+	size := trailerSize.FindSubmatch(trailer)
+
+	if root == nil || size == nil {
+		return nil, utils.FormatError(ErrPDF, "trailer has no root or size")
+	}
+
+	rootNum, _ := strconv.Atoi(string(root[1]))
+	rootGen, _ := strconv.Atoi(string(root[2]))
+	next, _ := strconv.Atoi(string(size[1]))
+
+	catalog, err := objectDict(doc, rootNum, rootGen)
+	if err != nil {
+		return nil, err
+	}
+
+	// Name trees are sorted by their keys
+	files = append([]File{}, files...)
+	slices.SortFunc(files, func(a, b File) bool { return a.Name < b.Name })
+
+	var buf bytes.Buffer
+
+	buf.Write(doc)
+
+	if !bytes.HasSuffix(doc, []byte("\n")) {
+		buf.WriteByte('\n')
+	}
+
+	size0 := next
+	offsets := map[int]int{}
+	names := []string{}
+	refs := []string{}
+
+	for _, f := range files {
+		stream, spec := next, next+1
+		next += 2
+
+		offsets[stream] = buf.Len()
+
+		err = writeEmbeddedFile(&buf, stream, f)
+		if err != nil {
+			return nil, err
+		}
+
+		offsets[spec] = buf.Len()
+		fmt.Fprintf(&buf, "%d 0 obj\n<< /Type /Filespec /F %s /UF %s /Desc %s /AFRelationship /Source", spec,
+			pdfString(f.Name), pdfString(f.Name), pdfString(f.Description))
+		fmt.Fprintf(&buf, " /EF << /F %d 0 R /UF %d 0 R >> >>\nendobj\n", stream, stream)
+
+		names = append(names, fmt.Sprintf("%s %d 0 R", pdfString(f.Name), spec))
+		refs = append(refs, fmt.Sprintf("%d 0 R", spec))
+	}
+
+	catalog, err = addToCatalog(catalog, names, refs)
+	if err != nil {
+		return nil, err
+	}
+
+	catalogOffset := buf.Len()
+	fmt.Fprintf(&buf, "%d %d obj\n%s\nendobj\n", rootNum, rootGen, catalog)
+
+	// The cross-reference section of the update has the catalog and the new objects
+	xref := buf.Len()
+	fmt.Fprintf(&buf, "xref\n%d 1\n%010d %05d n\r\n%d %d\n", rootNum, catalogOffset, rootGen, size0, next-size0)
+
+	for n := size0; n < next; n++ {
+		fmt.Fprintf(&buf, "%010d 00000 n\r\n", offsets[n])
+	}
+
+	fmt.Fprintf(&buf, "trailer\n<< /Size %d /Root %d %d R", next, rootNum, rootGen)
+
+	for _, key := range []*regexp.Regexp{trailerInfo, trailerID} {
+		if v := key.Find(trailer); v != nil {
+			fmt.Fprintf(&buf, " %s", v)
+		}
+	}
+
+	fmt.Fprintf(&buf, " /Prev %d >>\nstartxref\n%d\n%%%%EOF\n", prev, xref)
+
+	return buf.Bytes(), nil
+}
+
+// writeEmbeddedFile writes the compressed embedded file stream of the file as object.
+func writeEmbeddedFile(buf *bytes.Buffer, num int, f File) error {
+	var data bytes.Buffer
+
+	zw := zlib.NewWriter(&data)
+
+	_, err := zw.Write(f.Data)
+	if err != nil {
+		return fmt.Errorf("compressing %s: %w", f.Name, err)
+	}
+
+	err = zw.Close()
+	if err != nil {
+		return fmt.Errorf("compressing %s: %w", f.Name, err)
+	}
+
+	modDate := ""
+	if !f.ModTime.IsZero() {
+		modDate = " /ModDate " + pdfDate(f.ModTime)
+	}
+
+	fmt.Fprintf(buf, "%d 0 obj\n<< /Type /EmbeddedFile /Subtype %s /Filter /FlateDecode /Length %d", num, pdfName(f.MediaType), data.Len())
+	fmt.Fprintf(buf, " /Params << /Size %d%s >> >>\nstream\n", len(f.Data), modDate)
+	buf.Write(data.Bytes())
+	buf.WriteString("\nendstream\nendobj\n")
+
+	return nil
+}
+
+// addToCatalog adds the file specifications to the embedded files and associated files of the catalog.
+// Name trees and associated files that are indirect objects are not supported.
+func addToCatalog(catalog []byte, names, refs []string) ([]byte, error) {
+	embedded := "/EmbeddedFiles << /Names [" + strings.Join(names, " ") + "] >>"
+
+	switch m := namesKey.FindSubmatchIndex(catalog); {
+	case m == nil:
+		catalog = insertAt(catalog, len(catalog)-2, " /Names << "+embedded+" >> ")
+	case m[2] < 0:
+		return nil, utils.FormatError(ErrPDF, "catalog has an indirect name dictionary")
+	case bytes.Contains(catalog, []byte("/EmbeddedFiles")):
+		return nil, utils.FormatError(ErrPDF, "catalog has embedded files already")
+	default:
+		catalog = insertAt(catalog, m[3], " "+embedded+" ")
+	}
+
+	switch m := afKey.FindSubmatchIndex(catalog); {
+	case m == nil:
+		catalog = insertAt(catalog, len(catalog)-2, " /AF ["+strings.Join(refs, " ")+"] ")
+	case m[2] < 0:
+		return nil, utils.FormatError(ErrPDF, "catalog has indirect associated files")
+	default:
+		catalog = insertAt(catalog, m[3], strings.Join(refs, " ")+" ")
+	}
+
+	return catalog, nil
+}
+
+// objectDict returns the dictionary of the last definition of the object.
+func objectDict(doc []byte, num, gen int) ([]byte, error) {
+	def := regexp.MustCompile(fmt.Sprintf(`(?:^|[\s>])%d\s+%d\s+obj\s*<<`, num, gen))
+
+	locs := def.FindAllIndex(doc, -1)
+	if locs == nil {
+		return nil, utils.FormatError(ErrPDF, fmt.Sprintf("object %d %d is not defined or compressed", num, gen))
+	}
+
+	start := locs[len(locs)-1][1] - 2
+
+	end, err := dictEnd(doc, start)
+	if err != nil {
+		return nil, err
+	}
+
+	return append([]byte{}, doc[start:end]...), nil
+}
+
+// dictEnd returns the offset after the end of the dictionary that starts at start.
+func dictEnd(doc []byte, start int) (int, error) {
+	depth := 0
+
+	for i := start; i < len(doc)-1; i++ {
+		switch {
+		case doc[i] == '(':
+			i = stringEnd(doc, i)
+		case doc[i] == '%':
+			for i < len(doc) && doc[i] != '\n' && doc[i] != '\r' {
+				i++
+			}
+		case doc[i] == '<' && doc[i+1] == '<':
+			depth++
+			i++
+		case doc[i] == '<':
+			// Hexadecimal string
+			for i < len(doc) && doc[i] != '>' {
+				i++
+			}
+		case doc[i] == '>' && doc[i+1] == '>':
+			depth--
+			i++
+
+			if depth == 0 {
+				return i + 1, nil
+			}
+		}
+	}
+
+	return 0, utils.FormatError(ErrPDF, "unterminated dictionary")
+}
+
+// stringEnd returns the offset of the closing parenthesis of the literal string that starts at start.
+func stringEnd(doc []byte, start int) int {
+	depth := 0
+
+	for i := start; i < len(doc); i++ {
+		switch doc[i] {
+		case '\\':
+			i++
+		case '(':
+			depth++
+		case ')':
+			depth--
+
+			if depth == 0 {
+				return i
+			}
+		}
+	}
+
+	return len(doc)
+}
+
+// pdfString returns the text as literal string, or as UTF-16 hexadecimal string if it isn't ASCII.
+func pdfString(text string) string {
+	var buf bytes.Buffer
+
+	for _, r := range text {
+		if r > 0x7e {
+			buf.Reset()
+			buf.WriteString("<FEFF")
+
+			for _, c := range utf16.Encode([]rune(text)) {
+				fmt.Fprintf(&buf, "%04X", c)
+			}
+
+			buf.WriteString(">")
+
+			return buf.String()
+		}
+	}
+
+	buf.WriteByte('(')
+
+	for i := 0; i < len(text); i++ {
+		switch c := text[i]; {
+		case c == '(' || c == ')' || c == '\\':
+			buf.WriteByte('\\')
+			buf.WriteByte(c)
+		case c < 0x20:
+			fmt.Fprintf(&buf, "\\%03o", c)
+		default:
+			buf.WriteByte(c)
+		}
+	}
+
+	buf.WriteByte(')')
+
+	return buf.String()
+}
+
+// pdfName returns the value as name object, characters other than letters and digits are escaped.
+func pdfName(value string) string {
+	var buf bytes.Buffer
+
+	buf.WriteByte('/')
+
+	for i := 0; i < len(value); i++ {
+		c := value[i]
+		if c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' || c == '.' || c == '-' || c == '+' {
+			buf.WriteByte(c)
+		} else {
+			fmt.Fprintf(&buf, "#%02X", c)
+		}
+	}
+
+	return buf.String()
+}
+
+// pdfDate returns the time as date string.
+func pdfDate(t time.Time) string {
+	_, offset := t.Zone()
+	if offset == 0 {
+		return "(D:" + t.Format("20060102150405") + "Z)"
+	}
+
+	sign := '+'
+	if offset < 0 {
+		sign = '-'
+		offset = -offset
+	}
+
+	return fmt.Sprintf("(D:%s%c%02d'%02d')", t.Format("20060102150405"), sign, offset/3600, offset%3600/60)
+}
+
+// insertAt inserts the text into data at the offset.
+func insertAt(data []byte, offset int, text string) []byte {
+	return append(data[:offset:offset], append([]byte(text), data[offset:]...)...)
+}
@@ -0,0 +1,162 @@
+package pdf
+
+import (
+	"bytes"
+	"compress/zlib"
+	"fmt"
+	"io/ioutil"
+	"regexp"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+// newPDF returns a document with the objects and a cross-reference table, like LibreOffice writes it.
+func newPDF(objects ...string) []byte {
+	var buf bytes.Buffer
+
+	buf.WriteString("%PDF-1.7\n")
+
+	offsets := []int{}
+
+	for i, obj := range objects {
+		offsets = append(offsets, buf.Len())
+		fmt.Fprintf(&buf, "%d 0 obj\n%s\nendobj\n", i+1, obj)
+	}
+
+	xref := buf.Len()
+	fmt.Fprintf(&buf, "xref\n0 %d\n0000000000 65535 f\r\n", len(objects)+1)
+
+	for _, o := range offsets {
+		fmt.Fprintf(&buf, "%010d 00000 n\r\n", o)
+	}
+
+	fmt.Fprintf(&buf, "trailer\n<</Size %d/Root 1 0 R\n/Info 2 0 R\n/ID [ <ABCD> <ABCD> ]\n>>\nstartxref\n%d\n%%%%EOF\n", len(objects)+1, xref)
+
+	return buf.Bytes()
+}
+
+var xrefEntry = regexp.MustCompile(`(?m)^(\d+) (\d+)\n((?:\d{10} \d{5} n\r\n)+)`)
+
+// checkXRef verifies that the entries of the last cross-reference section point to their objects.
+func checkXRef(t *testing.T, doc []byte) {
+	t.Helper()
+
+	m := startXRef.FindSubmatch(doc)
+	require.NotNil(t, m)
+
+	xref, _ := strconv.Atoi(string(m[1]))
+	require.True(t, bytes.HasPrefix(doc[xref:], []byte("xref\n")))
+
+	for _, sub := range xrefEntry.FindAllSubmatch(doc[xref:], -1) {
+		first, _ := strconv.Atoi(string(sub[1]))
+
+		for i := 0; i < len(sub[3])/20; i++ {
+			offset, _ := strconv.Atoi(string(sub[3][i*20 : i*20+10]))
+			require.True(t, bytes.HasPrefix(doc[offset:], []byte(fmt.Sprintf("%d 0 obj", first+i))), "object %d", first+i)
+		}
+	}
+}
+
+func TestAttach(t *testing.T) {
+	doc := newPDF(
+		"<</Type/Catalog/Pages 3 0 R/Lang(de-CH)>>",
+		"<</Title(Letter \\(draft\\))/Producer<4C696272654F6666696365>>>",
+		"<</Type/Pages/Kids[]/Count 0>>",
+	)
+
+	modTime := time.Date(2022, 10, 1, 13, 30, 0, 0, time.FixedZone("CEST", 2*3600))
+	files := []File{
+		{Name: "model.json", MediaType: "application/json", Description: "Model", Data: []byte(`{"data":{}}`), ModTime: modTime},
+		{Name: "bundle.tar", MediaType: "application/x-tar", Description: "Job bundle", Data: []byte("tar")},
+	}
+
+	res, err := Attach(doc, files)
+	require.Nil(t, err)
+
+	// The original document is kept and the update refers to it
+	require.True(t, bytes.HasPrefix(res, doc))
+	require.Contains(t, string(res[len(doc):]), fmt.Sprintf("/Prev %d >>", bytes.Index(doc, []byte("xref"))))
+	require.Contains(t, string(res[len(doc):]), "/Size 8 /Root 1 0 R /Info 2 0 R /ID [ <ABCD> <ABCD> ]")
+	checkXRef(t, res)
+
+	// The files are sorted by name and associated with the catalog
+	catalog, err := objectDict(res, 1, 0)
+	require.Nil(t, err)
+	require.Equal(t, "<</Type/Catalog/Pages 3 0 R/Lang(de-CH) /Names << /EmbeddedFiles << /Names [(bundle.tar) 5 0 R (model.json) 7 0 R] >> >>  /AF [5 0 R 7 0 R] >>",
+		string(catalog))
+
+	spec, err := objectDict(res, 7, 0)
+	require.Nil(t, err)
+	require.Contains(t, string(spec), "/F (model.json) /UF (model.json) /Desc (Model) /AFRelationship /Source /EF << /F 6 0 R /UF 6 0 R >>")
+
+	stream, err := objectDict(res, 6, 0)
+	require.Nil(t, err)
+	require.Contains(t, string(stream), "/Subtype /application#2Fjson")
+	require.Contains(t, string(stream), "/ModDate (D:20221001133000+02'00')")
+
+	// The stream holds the compressed file
+	obj := bytes.Index(res, []byte("6 0 obj"))
+	start := obj + bytes.Index(res[obj:], []byte("stream\n")) + len("stream\n")
+	zr, err := zlib.NewReader(bytes.NewReader(res[start:]))
+	require.Nil(t, err)
+
+	data, err := ioutil.ReadAll(zr)
+	require.Nil(t, err)
+	require.Equal(t, `{"data":{}}`, string(data))
+}
+
+func TestAttachCatalog(t *testing.T) {
+	// Existing name and associated file entries are extended
+	res, err := Attach(newPDF("<</Type/Catalog/Names<</Dests 2 0 R>>/AF[2 0 R]>>", "<<>>"), []File{{Name: "a"}})
+	require.Nil(t, err)
+
+	catalog, err := objectDict(res, 1, 0)
+	require.Nil(t, err)
+	require.Equal(t, "<</Type/Catalog/Names<< /EmbeddedFiles << /Names [(a) 4 0 R] >> /Dests 2 0 R>>/AF[4 0 R 2 0 R]>>", string(catalog))
+	checkXRef(t, res)
+
+	// Indirect name dictionaries are not supported
+	_, err = Attach(newPDF("<</Type/Catalog/Names 2 0 R>>", "<<>>"), []File{{Name: "a"}})
+	require.ErrorIs(t, err, ErrPDF)
+
+	// Cross-reference streams are not supported
+	_, err = Attach([]byte("%PDF-1.7\n1 0 obj\n<</Type/XRef>>\nendobj\nstartxref\n9\n%%EOF\n"), []File{{Name: "a"}})
+	require.ErrorIs(t, err, ErrPDF)
+
+	_, err = Attach([]byte("no pdf"), []File{{Name: "a"}})
+	require.ErrorIs(t, err, ErrPDF)
+}
+
+func TestAttachPDFA(t *testing.T) {
+	// Embedded files are only allowed by PDF/A-3
+	for part, allowed := range map[string]bool{"1": false, "2": false, "3": true} {
+		doc := newPDF(
+			"<</Type/Catalog/Pages 3 0 R/Metadata 2 0 R>>",
+			"<</Type/Metadata/Subtype/XML/Length 100>>\nstream\n<rdf:Description rdf:about=\"\" "+
+				"xmlns:pdfaid=\"http://www.aiim.org/pdfa/ns/id/\">\n<pdfaid:part>"+part+"</pdfaid:part>\n"+
+				"<pdfaid:conformance>B</pdfaid:conformance>\n</rdf:Description>\nendstream",
+			"<</Type/Pages/Kids[]/Count 0>>",
+		)
+
+		_, err := Attach(doc, []File{{Name: "a"}})
+		if allowed {
+			require.Nil(t, err, part)
+		} else {
+			require.ErrorIs(t, err, ErrPDF, part)
+			require.Contains(t, err.Error(), "PDF/A-"+part, part)
+		}
+	}
+
+	// The part can be written as attribute as well
+	_, err := Attach(newPDF("<</Type/Catalog>>", `<x:xmpmeta><rdf:Description pdfaid:part="2"/></x:xmpmeta>`), []File{{Name: "a"}})
+	require.ErrorIs(t, err, ErrPDF)
+}
+
+func TestPDFString(t *testing.T) {
+	require.Equal(t, `(a\(b\)\\c\012)`, pdfString("a(b)\\c\n"))
+	require.Equal(t, `<FEFF00E4>`, pdfString("ä"))
+	require.Equal(t, `/application#2Fvnd.oasis.opendocument.text`, pdfName("application/vnd.oasis.opendocument.text"))
+}
@@ -9,13 +9,14 @@ import (
 	"github.com/Shopify/go-lua"
 )
 
-func Add(l *lua.State) {
-	l.Register("next", next)
-	l.Register("pairs", pairs("__pairs", false, next))
-	l.Register("ipairs", pairs("__ipairs", true, intPairs))
-	l.Register("tonumber", tonumber)
-
-	l.Register("getmetatable", func(l *lua.State) int {
+// Functions are the safe base library functions, which are registered as lua globals by Add.
+var Functions = []lua.RegistryFunction{
+	{Name: "next", Function: next},
+	{Name: "pairs", Function: pairs("__pairs", false, next)},
+	{Name: "ipairs", Function: pairs("__ipairs", true, intPairs)},
+	{Name: "tonumber", Function: tonumber},
+
+	{Name: "getmetatable", Function: func(l *lua.State) int {
 		lua.CheckAny(l, 1)
 		if !l.MetaTable(1) {
 			l.PushNil()
@@ -23,9 +24,9 @@ func Add(l *lua.State) {
 		}
 		lua.MetaField(l, 1, "__metatable")
 		return 1
-	})
+	}},
 
-	l.Register("setmetatable", func(l *lua.State) int {
+	{Name: "setmetatable", Function: func(l *lua.State) int {
 		t := l.TypeOf(2)
 		lua.CheckType(l, 1, lua.TypeTable)
 		lua.ArgumentCheck(l, t == lua.TypeNil || t == lua.TypeTable, 2, "nil or table expected")
@@ -35,19 +36,26 @@ func Add(l *lua.State) {
 		l.SetTop(2)
 		l.SetMetaTable(1)
 		return 1
-	})
+	}},
 
-	l.Register("tostring", func(l *lua.State) int {
+	{Name: "tostring", Function: func(l *lua.State) int {
 		lua.CheckAny(l, 1)
 		lua.ToStringMeta(l, 1)
 		return 1
-	})
+	}},
 
-	l.Register("type", func(l *lua.State) int {
+	{Name: "type", Function: func(l *lua.State) int {
 		lua.CheckAny(l, 1)
 		l.PushString(lua.TypeNameOf(l, 1))
 		return 1
-	})
+	}},
+}
+
+// Add registers the safe base library functions in the given lua state.
+func Add(l *lua.State) {
+	for _, f := range Functions {
+		l.Register(f.Name, f.Function)
+	}
 }
 
 func next(l *lua.State) int {
@@ -0,0 +1,68 @@
+package schema
+
+import "github.com/microfast-ch/rea/internal/engine"
+
+// Infer returns a starting schema for the model from the variables that are referenced by the lua
+// programs of a template. Variables that are iterated, like `order.items` in `each item in order.items`,
+// become arrays. The types of the other values are unknown, so they are left to be filled in. No value
+// is required, as values can be read only under a condition, like `if x then`.
+// The analysis is static and doesn't follow variables that are assigned from model values.
+func Infer(progs ...string) *Schema {
+	root := &Schema{Schema: Draft}
+
+	for _, prog := range progs {
+		uses, bindings := engine.ReadVariables(prog)
+
+		paths := make([][]string, 0, len(uses)+len(bindings))
+		for _, use := range uses {
+			paths = append(paths, use.Path)
+		}
+
+		// Loop variables are bound to the items of an array, even if no field of them is read
+		for _, binding := range bindings {
+			paths = append(paths, binding.Path)
+		}
+
+		for _, path := range paths {
+			// The metadata of the model is not part of its data
+			if path[0] != "metadata" {
+				root.addPath(path)
+			}
+		}
+	}
+
+	root.Type = Types{"object"}
+
+	return root
+}
+
+// addPath adds the properties and items of the path to the schema.
+func (s *Schema) addPath(path []string) {
+	if len(path) == 0 {
+		return
+	}
+
+	if path[0] == engine.ItemsSegment {
+		s.Type = Types{"array"}
+		if s.Items == nil {
+			s.Items = &Schema{}
+		}
+
+		s.Items.addPath(path[1:])
+
+		return
+	}
+
+	s.Type = Types{"object"}
+	if s.Properties == nil {
+		s.Properties = map[string]*Schema{}
+	}
+
+	prop, ok := s.Properties[path[0]]
+	if !ok {
+		prop = &Schema{}
+		s.Properties[path[0]] = prop
+	}
+
+	prop.addPath(path[1:])
+}
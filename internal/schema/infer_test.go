@@ -0,0 +1,44 @@
+package schema
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestInfer(t *testing.T) {
+	s := Infer(
+		"Print( customer.name ) for item in each(order.items) do Print( item.price ) end",
+		"Print( customer.email ) Print( order.items[1] ) Print( metadata.author )",
+		"if customer.vip then Print( \"VIP\" ) end for _, tag in ipairs(tags) do end",
+	)
+
+	// No value is required and loop variables make arrays, even if no field of the items is read
+	data, err := json.Marshal(s)
+	require.Nil(t, err)
+	require.JSONEq(t, `{
+		"$schema": "https://json-schema.org/draft/2020-12/schema",
+		"type": "object",
+		"properties": {
+			"customer": {
+				"type": "object",
+				"properties": {"email": {}, "name": {}, "vip": {}}
+			},
+			"order": {
+				"type": "object",
+				"properties": {
+					"items": {
+						"type": "array",
+						"items": {"type": "object", "properties": {"price": {}}}
+					}
+				}
+			},
+			"tags": {"type": "array", "items": {}}
+		}
+	}`, string(data))
+
+	// The inferred schema is a valid schema
+	_, err = Parse(data)
+	require.Nil(t, err)
+}
@@ -0,0 +1,211 @@
+// Package schema validates the model of a template against a JSON Schema and infers
+// a starting schema from the variables that are referenced by a template.
+//
+// The following subset of JSON Schema is supported: type, properties, required,
+// additionalProperties, items, enum, const, minimum, maximum, exclusiveMinimum, exclusiveMaximum,
+// minLength, maxLength, pattern, minItems, maxItems and the formats date and date-time. Schemas
+// can be boolean schemas, like `false`. Annotations without effect on the validation, like title
+// or default, are allowed, other keywords are rejected, as the model could not be validated.
+package schema
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"regexp"
+	"sort"
+
+	"github.com/microfast-ch/rea/internal/engine"
+	"github.com/microfast-ch/rea/internal/utils"
+)
+
+var ErrSchema = errors.New("schemaErr")
+
+// Draft is the JSON Schema version of inferred schemas.
+const Draft = "https://json-schema.org/draft/2020-12/schema"
+
+// Schema is a JSON Schema for the data of a model.
+type Schema struct {
+	Schema      string `json:"$schema,omitempty"`
+	Title       string `json:"title,omitempty"`
+	Description string `json:"description,omitempty"`
+
+	Type  Types `json:"type,omitempty"`
+	Enum  []any `json:"enum,omitempty"`
+	Const any   `json:"const,omitempty"`
+
+	// Objects
+	Properties           map[string]*Schema `json:"properties,omitempty"`
+	Required             []string           `json:"required,omitempty"`
+	AdditionalProperties *Schema            `json:"additionalProperties,omitempty"`
+
+	// Arrays
+	Items    *Schema `json:"items,omitempty"`
+	MinItems *int    `json:"minItems,omitempty"`
+	MaxItems *int    `json:"maxItems,omitempty"`
+
+	// Numbers
+	Minimum          *float64 `json:"minimum,omitempty"`
+	Maximum          *float64 `json:"maximum,omitempty"`
+	ExclusiveMinimum *float64 `json:"exclusiveMinimum,omitempty"`
+	ExclusiveMaximum *float64 `json:"exclusiveMaximum,omitempty"`
+
+	// Strings
+	MinLength *int   `json:"minLength,omitempty"`
+	MaxLength *int   `json:"maxLength,omitempty"`
+	Pattern   string `json:"pattern,omitempty"`
+	Format    string `json:"format,omitempty"`
+
+	pattern *regexp.Regexp
+	reject  bool     // The boolean schema `false`, which no value is valid against
+	unknown []string // Keywords that are not supported, reported by compile with their path
+}
+
+// keywords are the supported keywords and the annotations, which have no effect on the validation.
+var keywords = map[string]bool{
+	"$schema": true, "$id": true, "$comment": true, "title": true, "description": true,
+	"default": true, "examples": true, "deprecated": true, "readOnly": true, "writeOnly": true,
+	"type": true, "enum": true, "const": true,
+	"properties": true, "required": true, "additionalProperties": true,
+	"items": true, "minItems": true, "maxItems": true,
+	"minimum": true, "maximum": true, "exclusiveMinimum": true, "exclusiveMaximum": true,
+	"minLength": true, "maxLength": true, "pattern": true, "format": true,
+}
+
+// schemaFields has the fields of Schema, without its methods for JSON.
+type schemaFields Schema
+
+// Types are the allowed types of a value, written as a single string if there is only one.
+type Types []string
+
+// Parse parses the JSON Schema.
+func Parse(data []byte) (*Schema, error) {
+	s := &Schema{}
+
+	err := json.Unmarshal(data, s)
+	if err != nil {
+		return nil, utils.FormatError(ErrSchema, fmt.Sprintf("parsing schema: %v", err))
+	}
+
+	err = s.compile("")
+	if err != nil {
+		return nil, err
+	}
+
+	return s, nil
+}
+
+// UnmarshalJSON reads a schema object or a boolean schema.
+func (s *Schema) UnmarshalJSON(data []byte) error {
+	switch string(bytes.TrimSpace(data)) {
+	case "true":
+		*s = Schema{}
+		return nil
+	case "false":
+		*s = Schema{reject: true}
+		return nil
+	}
+
+	var raw map[string]json.RawMessage
+
+	err := json.Unmarshal(data, &raw)
+	if err != nil {
+		return err
+	}
+
+	err = json.Unmarshal(data, (*schemaFields)(s))
+	if err != nil {
+		return err
+	}
+
+	for k := range raw {
+		if !keywords[k] {
+			s.unknown = append(s.unknown, k)
+		}
+	}
+
+	sort.Strings(s.unknown)
+
+	return nil
+}
+
+// MarshalJSON writes the schema, boolean schemas as `false`.
+func (s *Schema) MarshalJSON() ([]byte, error) {
+	if s.reject {
+		return []byte("false"), nil
+	}
+
+	return json.Marshal((*schemaFields)(s))
+}
+
+// UnmarshalJSON reads a single type or a list of types.
+func (t *Types) UnmarshalJSON(data []byte) error {
+	var single string
+	if err := json.Unmarshal(data, &single); err == nil {
+		*t = Types{single}
+		return nil
+	}
+
+	return json.Unmarshal(data, (*[]string)(t))
+}
+
+// MarshalJSON writes a single type as string.
+func (t Types) MarshalJSON() ([]byte, error) {
+	if len(t) == 1 {
+		return json.Marshal(t[0])
+	}
+
+	return json.Marshal([]string(t))
+}
+
+// compile checks the keywords and types and compiles the patterns of the schema and its subschemas.
+func (s *Schema) compile(path string) error {
+	if len(s.unknown) > 0 {
+		return utils.FormatError(ErrSchema, fmt.Sprintf("unsupported keyword %q at %s", s.unknown[0], schemaPath(path)))
+	}
+
+	for _, t := range s.Type {
+		switch t {
+		case "null", "boolean", "object", "array", "number", "integer", "string":
+		default:
+			return utils.FormatError(ErrSchema, fmt.Sprintf("unknown type %q at %s", t, schemaPath(path)))
+		}
+	}
+
+	if s.Pattern != "" {
+		re, err := regexp.Compile(s.Pattern)
+		if err != nil {
+			return utils.FormatError(ErrSchema, fmt.Sprintf("invalid pattern at %s: %v", schemaPath(path), err))
+		}
+
+		s.pattern = re
+	}
+
+	for name, prop := range s.Properties {
+		if err := prop.compile(engine.ChildPath(path, name)); err != nil {
+			return err
+		}
+	}
+
+	if s.AdditionalProperties != nil {
+		if err := s.AdditionalProperties.compile(engine.ChildPath(path, "*")); err != nil {
+			return err
+		}
+	}
+
+	if s.Items != nil {
+		return s.Items.compile(path + "[]")
+	}
+
+	return nil
+}
+
+// schemaPath returns the path for messages, which is empty for the whole model.
+func schemaPath(path string) string {
+	if path == "" {
+		return "model"
+	}
+
+	return path
+}
@@ -0,0 +1,59 @@
+package schema
+
+import (
+	"encoding/json"
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestParse(t *testing.T) {
+	s, err := Parse([]byte(`{
+		"type": "object",
+		"properties": {
+			"name": {"type": ["string", "null"], "pattern": "^[A-Z]"},
+			"items": {"type": "array", "items": {"type": "integer"}, "minItems": 1}
+		},
+		"required": ["name"],
+		"additionalProperties": false
+	}`))
+	require.Nil(t, err)
+
+	require.Equal(t, Types{"object"}, s.Type)
+	require.Equal(t, Types{"string", "null"}, s.Properties["name"].Type)
+	require.True(t, s.AdditionalProperties.reject)
+	require.Equal(t, 1, *s.Properties["items"].MinItems)
+
+	// Boolean schemas and single types are written as they were read
+	data, err := json.Marshal(s)
+	require.Nil(t, err)
+	require.Contains(t, string(data), `"additionalProperties":false`)
+	require.Contains(t, string(data), `"items":{"type":"integer"}`)
+
+	for _, invalid := range []string{
+		`{"type": "text"}`,
+		`{"properties": {"name": {"pattern": "("}}}`,
+		`{"type": 1}`,
+		`[]`,
+	} {
+		_, err := Parse([]byte(invalid))
+		require.True(t, errors.Is(err, ErrSchema), invalid)
+	}
+
+	// Keywords that are not supported are rejected with their path, annotations are allowed
+	for schema, want := range map[string]string{
+		`{"$defs": {}, "$ref": "#/$defs/a"}`:                               `unsupported keyword "$defs" at model`,
+		`{"properties": {"a": {"anyOf": [{"type": "string"}]}}}`:           `unsupported keyword "anyOf" at a`,
+		`{"items": {"multipleOf": 2}}`:                                     `unsupported keyword "multipleOf" at []`,
+		`{"additionalProperties": {"patternProperties": {}}}`:              `unsupported keyword "patternProperties" at ["*"]`,
+		`{"properties": {"list": {"type": "array", "uniqueItems": true}}}`: `unsupported keyword "uniqueItems" at list`,
+	} {
+		_, err := Parse([]byte(schema))
+		require.ErrorIs(t, err, ErrSchema, schema)
+		require.Contains(t, err.Error(), want, schema)
+	}
+
+	_, err = Parse([]byte(`{"$id": "x", "$comment": "c", "title": "t", "default": {}, "examples": [], "deprecated": false}`))
+	require.Nil(t, err)
+}
@@ -0,0 +1,269 @@
+package schema
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"reflect"
+	"sort"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/microfast-ch/rea/internal/engine"
+)
+
+var ErrValidation = errors.New("validationErr")
+
+// Violation is a value of the model that doesn't match the schema.
+type Violation struct {
+	Path    string // Path of the value as in templates, like `order.items[2].price`
+	Message string
+}
+
+// ValidationError holds all violations of a model.
+type ValidationError struct {
+	Violations []Violation
+}
+
+func (e *ValidationError) Error() string {
+	lines := make([]string, len(e.Violations))
+	for i, v := range e.Violations {
+		lines[i] = fmt.Sprintf("%s: %s", schemaPath(v.Path), v.Message)
+	}
+
+	return fmt.Sprintf("[%s] model does not match schema: %s", ErrValidation, strings.Join(lines, "; "))
+}
+
+func (e *ValidationError) Unwrap() error {
+	return ErrValidation
+}
+
+// Validate validates the data of a model against the schema. All violations are returned
+// at once as *ValidationError. Lists are indexed from 1 in the paths, as in templates.
+func (s *Schema) Validate(data map[string]any) error {
+	var violations []Violation
+
+	s.validate(data, "", &violations)
+
+	if len(violations) > 0 {
+		return &ValidationError{Violations: violations}
+	}
+
+	return nil
+}
+
+func (s *Schema) validate(v any, path string, violations *[]Violation) {
+	report := func(format string, args ...any) {
+		*violations = append(*violations, Violation{Path: path, Message: fmt.Sprintf(format, args...)})
+	}
+
+	if s.reject {
+		report("is not allowed")
+		return
+	}
+
+	if len(s.Type) > 0 && !s.hasType(v) {
+		report("expected %s, got %s", strings.Join(s.Type, " or "), typeOf(v))
+		return
+	}
+
+	if len(s.Enum) > 0 && !containsValue(s.Enum, v) {
+		report("must be one of %s", formatValues(s.Enum))
+	}
+
+	if s.Const != nil && !equalValues(s.Const, v) {
+		report("must be %s", formatValues([]any{s.Const}))
+	}
+
+	switch v := v.(type) {
+	case map[string]any:
+		s.validateObject(v, path, violations)
+	case []any:
+		s.validateArray(v, path, violations)
+	case string:
+		s.validateString(v, report)
+	case time.Time:
+		// Dates of YAML models are valid strings with a date format
+	default:
+		if f, ok := toFloat(v); ok {
+			s.validateNumber(f, report)
+		}
+	}
+}
+
+func (s *Schema) validateObject(v map[string]any, path string, violations *[]Violation) {
+	for _, name := range s.Required {
+		if _, ok := v[name]; !ok {
+			*violations = append(*violations, Violation{Path: engine.ChildPath(path, name), Message: "is missing"})
+		}
+	}
+
+	keys := make([]string, 0, len(v))
+	for k := range v {
+		keys = append(keys, k)
+	}
+
+	sort.Strings(keys)
+
+	for _, k := range keys {
+		if prop, ok := s.Properties[k]; ok {
+			prop.validate(v[k], engine.ChildPath(path, k), violations)
+		} else if s.AdditionalProperties != nil {
+			s.AdditionalProperties.validate(v[k], engine.ChildPath(path, k), violations)
+		}
+	}
+}
+
+func (s *Schema) validateArray(v []any, path string, violations *[]Violation) {
+	if s.MinItems != nil && len(v) < *s.MinItems {
+		*violations = append(*violations, Violation{Path: path, Message: fmt.Sprintf("must have at least %d items", *s.MinItems)})
+	}
+
+	if s.MaxItems != nil && len(v) > *s.MaxItems {
+		*violations = append(*violations, Violation{Path: path, Message: fmt.Sprintf("must have at most %d items", *s.MaxItems)})
+	}
+
+	if s.Items != nil {
+		for i, item := range v {
+			s.Items.validate(item, fmt.Sprintf("%s[%d]", path, i+1), violations)
+		}
+	}
+}
+
+func (s *Schema) validateString(v string, report func(string, ...any)) {
+	length := utf8.RuneCountInString(v)
+
+	if s.MinLength != nil && length < *s.MinLength {
+		report("must be at least %d characters long", *s.MinLength)
+	}
+
+	if s.MaxLength != nil && length > *s.MaxLength {
+		report("must be at most %d characters long", *s.MaxLength)
+	}
+
+	if s.pattern != nil && !s.pattern.MatchString(v) {
+		report("must match pattern %s", s.Pattern)
+	}
+
+	if layout, ok := dateFormats[s.Format]; ok {
+		if _, err := time.Parse(layout, v); err != nil {
+			report("must be a %s", s.Format)
+		}
+	}
+}
+
+func (s *Schema) validateNumber(f float64, report func(string, ...any)) {
+	if s.Minimum != nil && f < *s.Minimum {
+		report("must be at least %v", *s.Minimum)
+	}
+
+	if s.Maximum != nil && f > *s.Maximum {
+		report("must be at most %v", *s.Maximum)
+	}
+
+	if s.ExclusiveMinimum != nil && f <= *s.ExclusiveMinimum {
+		report("must be greater than %v", *s.ExclusiveMinimum)
+	}
+
+	if s.ExclusiveMaximum != nil && f >= *s.ExclusiveMaximum {
+		report("must be less than %v", *s.ExclusiveMaximum)
+	}
+}
+
+// dateFormats are the layouts of the supported formats.
+var dateFormats = map[string]string{
+	"date":      "2006-01-02",
+	"date-time": time.RFC3339,
+}
+
+// hasType reports if the value has one of the types of the schema.
+func (s *Schema) hasType(v any) bool {
+	got := typeOf(v)
+
+	for _, t := range s.Type {
+		switch {
+		case t == got:
+			return true
+		case t == "number" && got == "integer":
+			return true
+		case t == "string" && got == "date":
+			return true
+		}
+	}
+
+	return false
+}
+
+// typeOf returns the JSON Schema type of a model value. Dates of YAML models are `date`.
+func typeOf(v any) string {
+	switch v := v.(type) {
+	case nil:
+		return "null"
+	case bool:
+		return "boolean"
+	case string:
+		return "string"
+	case time.Time:
+		return "date"
+	case map[string]any:
+		return "object"
+	case []any:
+		return "array"
+	default:
+		if f, ok := toFloat(v); ok {
+			if f == math.Trunc(f) {
+				return "integer"
+			}
+
+			return "number"
+		}
+
+		return fmt.Sprintf("%T", v)
+	}
+}
+
+// toFloat returns numbers of the model as float.
+func toFloat(v any) (float64, bool) {
+	rv := reflect.ValueOf(v)
+
+	switch rv.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return float64(rv.Int()), true
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return float64(rv.Uint()), true
+	case reflect.Float32, reflect.Float64:
+		return rv.Float(), true
+	default:
+		return 0, false
+	}
+}
+
+// equalValues compares a value of the schema with a value of the model. Numbers are compared by value.
+func equalValues(want, got any) bool {
+	if w, ok := toFloat(want); ok {
+		g, ok := toFloat(got)
+		return ok && w == g
+	}
+
+	return reflect.DeepEqual(want, got)
+}
+
+func containsValue(values []any, v any) bool {
+	for _, want := range values {
+		if equalValues(want, v) {
+			return true
+		}
+	}
+
+	return false
+}
+
+func formatValues(values []any) string {
+	formatted := make([]string, len(values))
+	for i, v := range values {
+		formatted[i] = fmt.Sprintf("%#v", v)
+	}
+
+	return strings.Join(formatted, ", ")
+}
@@ -0,0 +1,98 @@
+package schema
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+const orderSchema = `{
+	"type": "object",
+	"properties": {
+		"customer": {
+			"type": "object",
+			"properties": {
+				"name": {"type": "string", "minLength": 2},
+				"email": {"type": "string", "pattern": "@"}
+			},
+			"required": ["name", "email"]
+		},
+		"order": {
+			"type": "object",
+			"properties": {
+				"date": {"type": "string", "format": "date"},
+				"status": {"enum": ["open", "paid"]},
+				"items": {
+					"type": "array",
+					"minItems": 1,
+					"items": {
+						"type": "object",
+						"properties": {
+							"name": {"type": "string"},
+							"price": {"type": "number", "minimum": 0},
+							"quantity": {"type": "integer", "exclusiveMinimum": 0}
+						},
+						"required": ["name", "price"],
+						"additionalProperties": false
+					}
+				}
+			},
+			"required": ["items"]
+		}
+	},
+	"required": ["customer", "order"]
+}`
+
+func TestValidate(t *testing.T) {
+	s, err := Parse([]byte(orderSchema))
+	require.Nil(t, err)
+
+	valid := map[string]any{
+		"customer": map[string]any{"name": "Ada", "email": "ada@example.com"},
+		"order": map[string]any{
+			"date":   time.Date(2022, 1, 31, 0, 0, 0, 0, time.UTC),
+			"status": "paid",
+			"items": []any{
+				map[string]any{"name": "Pen", "price": 1.5, "quantity": 2},
+				map[string]any{"name": "Ink", "price": 3},
+			},
+		},
+	}
+	require.Nil(t, s.Validate(valid))
+
+	invalid := map[string]any{
+		"customer": map[string]any{"name": "A"},
+		"order": map[string]any{
+			"date":   "31.01.2022",
+			"status": "lost",
+			"items": []any{
+				map[string]any{"name": "Pen", "price": 1.5, "quantity": 0},
+				map[string]any{"name": "Ink", "price": "3.00", "color": "blue"},
+				"Paper",
+			},
+		},
+	}
+
+	err = s.Validate(invalid)
+	require.True(t, errors.Is(err, ErrValidation))
+
+	var verr *ValidationError
+	require.True(t, errors.As(err, &verr))
+	require.Equal(t, []Violation{
+		{Path: "customer.email", Message: "is missing"},
+		{Path: "customer.name", Message: "must be at least 2 characters long"},
+		{Path: "order.date", Message: "must be a date"},
+		{Path: "order.items[1].quantity", Message: "must be greater than 0"},
+		{Path: "order.items[2].color", Message: "is not allowed"},
+		{Path: "order.items[2].price", Message: "expected number, got string"},
+		{Path: "order.items[3]", Message: "expected object, got string"},
+		{Path: "order.status", Message: `must be one of "open", "paid"`},
+	}, verr.Violations)
+
+	require.Contains(t, err.Error(), "order.items[2].price: expected number, got string; ")
+
+	err = s.Validate(map[string]any{"order": map[string]any{"items": []any{}}})
+	require.EqualError(t, err, "[validationErr] model does not match schema: customer: is missing; order.items: must have at least 1 items")
+}
@@ -0,0 +1,94 @@
+package stdlib
+
+import (
+	"time"
+
+	"github.com/Shopify/go-lua"
+)
+
+// dateMetaTable is the name of the metatable that marks a table as date.
+const dateMetaTable = "rea.date"
+
+// date creates a date table from its components.
+// Usage: `date(2022, 1, 31)` or `date(2022, 1, 31, 13, 30, 0)`.
+func date(l *lua.State) int {
+	t := time.Date(
+		lua.CheckInteger(l, 1),
+		time.Month(lua.CheckInteger(l, 2)),
+		lua.CheckInteger(l, 3),
+		lua.OptInteger(l, 4, 0),
+		lua.OptInteger(l, 5, 0),
+		lua.OptInteger(l, 6, 0),
+		0, time.UTC)
+
+	PushDate(l, t)
+
+	return 1
+}
+
+// PushDate pushes a date table with the fields year, month, day, hour, min and sec on the stack.
+// Dates are converted to strings as `2006-01-02` or `2006-01-02 15:04:05`, if they have a time.
+func PushDate(l *lua.State, t time.Time) {
+	l.CreateTable(0, 6)
+
+	for _, f := range []struct {
+		name  string
+		value int
+	}{
+		{"year", t.Year()},
+		{"month", int(t.Month())},
+		{"day", t.Day()},
+		{"hour", t.Hour()},
+		{"min", t.Minute()},
+		{"sec", t.Second()},
+	} {
+		l.PushInteger(f.value)
+		l.SetField(-2, f.name)
+	}
+
+	if lua.NewMetaTable(l, dateMetaTable) {
+		l.PushGoFunction(func(l *lua.State) int {
+			t, _ := ToDate(l, 1)
+			l.PushString(formatDate(t))
+
+			return 1
+		})
+		l.SetField(-2, "__tostring")
+	}
+
+	l.SetMetaTable(-2)
+}
+
+// ToDate returns the date at the given index, if it is a date table.
+func ToDate(l *lua.State, index int) (time.Time, bool) {
+	if !l.IsTable(index) || !l.MetaTable(index) {
+		return time.Time{}, false
+	}
+
+	lua.MetaTableNamed(l, dateMetaTable)
+	isDate := l.RawEqual(-1, -2)
+	l.Pop(2)
+
+	if !isDate {
+		return time.Time{}, false
+	}
+
+	fields := [6]int{}
+
+	for i, name := range []string{"year", "month", "day", "hour", "min", "sec"} {
+		l.Field(index, name)
+		fields[i], _ = l.ToInteger(-1)
+		l.Pop(1)
+	}
+
+	return time.Date(fields[0], time.Month(fields[1]), fields[2], fields[3], fields[4], fields[5], 0, time.UTC), true
+}
+
+// formatDate formats a date without the time, if the time is midnight.
+func formatDate(t time.Time) string {
+	if t.Hour() == 0 && t.Minute() == 0 && t.Second() == 0 {
+		return t.Format("2006-01-02")
+	}
+
+	return t.Format("2006-01-02 15:04:05")
+}
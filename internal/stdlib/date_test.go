@@ -0,0 +1,44 @@
+package stdlib
+
+import (
+	"testing"
+	"time"
+
+	"github.com/Shopify/go-lua"
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestDate(t *testing.T) {
+	got := runLua(t, `
+d = date(2022, 1, 31)
+Emit(tostring(d), d.year, d.month, d.day)
+Emit(tostring(date(2022, 1, 31, 13, 30, 5)))`)
+
+	want := []string{
+		"2022-01-31 2022 1 31",
+		"2022-01-31 13:30:05",
+	}
+
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Errorf("date() mismatch (-want +got):\n%s", diff)
+	}
+}
+
+func TestToDate(t *testing.T) {
+	l := lua.NewState()
+	want := time.Date(2022, 1, 31, 13, 30, 5, 0, time.UTC)
+
+	PushDate(l, want)
+
+	got, ok := ToDate(l, -1)
+	if !ok || !got.Equal(want) {
+		t.Errorf("ToDate() = %s, %t, want %s", got, ok, want)
+	}
+
+	// Plain tables are no dates
+	l.NewTable()
+
+	if _, ok := ToDate(l, -1); ok {
+		t.Errorf("ToDate() on a plain table should fail")
+	}
+}
@@ -0,0 +1,81 @@
+// Package stdlib implements the rea specific lua functions that are available
+// in every template, next to the restricted base library of safelua.
+package stdlib
+
+import (
+	"github.com/Shopify/go-lua"
+)
+
+// Functions are the standard library functions, which are registered as lua globals by Add.
+var Functions = []lua.RegistryFunction{
+	{Name: "each", Function: each},
+	{Name: "date", Function: date},
+}
+
+// Add registers the standard library functions in the given lua state.
+func Add(l *lua.State) {
+	for _, f := range Functions {
+		l.Register(f.Name, f.Function)
+	}
+}
+
+// each returns an iterator over the array part of the given table. On each
+// iteration it yields the value and a loop table with the following fields:
+//
+//	index:  position of the value, starting at 1
+//	length: number of values
+//	first:  true on the first iteration
+//	last:   true on the last iteration
+//	even:   true if index is even
+//	odd:    true if index is odd
+//
+// Usage: `for v, loop in each(items) do ... end`.
+func each(l *lua.State) int {
+	lua.CheckType(l, 1, lua.TypeTable)
+
+	length := lua.LengthEx(l, 1)
+	index := 0
+
+	// The table is kept as upvalue of the iterator
+	l.PushValue(1)
+	l.PushGoClosure(func(l *lua.State) int {
+		index++
+		if index > length {
+			l.PushNil()
+			return 1
+		}
+
+		l.PushValue(lua.UpValueIndex(1))
+		l.PushInteger(index)
+		l.Table(-2)
+		l.Remove(-2) // remove the table, keep the value
+		pushLoop(l, index, length)
+
+		return 2
+	}, 1)
+
+	return 1
+}
+
+// pushLoop pushes the loop table for the given index on the stack.
+func pushLoop(l *lua.State, index, length int) {
+	l.CreateTable(0, 6)
+
+	l.PushInteger(index)
+	l.SetField(-2, "index")
+
+	l.PushInteger(length)
+	l.SetField(-2, "length")
+
+	l.PushBoolean(index == 1)
+	l.SetField(-2, "first")
+
+	l.PushBoolean(index == length)
+	l.SetField(-2, "last")
+
+	l.PushBoolean(index%2 == 0)
+	l.SetField(-2, "even")
+
+	l.PushBoolean(index%2 == 1)
+	l.SetField(-2, "odd")
+}
@@ -0,0 +1,83 @@
+package stdlib
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/Shopify/go-lua"
+	"github.com/google/go-cmp/cmp"
+	"github.com/microfast-ch/rea/internal/safelua"
+)
+
+// runLua executes the given script and returns all strings passed to `Emit`.
+func runLua(t *testing.T, script string) []string {
+	t.Helper()
+
+	l := lua.NewState()
+	safelua.Add(l)
+	Add(l)
+
+	got := []string{}
+
+	l.Register("Emit", func(l *lua.State) int {
+		parts := []string{}
+		for i := 1; i <= l.Top(); i++ {
+			s, _ := lua.ToStringMeta(l, i)
+			parts = append(parts, s)
+			l.Pop(1)
+		}
+
+		got = append(got, strings.Join(parts, " "))
+
+		return 0
+	})
+
+	if err := lua.DoString(l, script); err != nil {
+		t.Fatalf("executing lua: %s: %s", err, lua.CheckString(l, -1))
+	}
+
+	return got
+}
+
+func TestEach(t *testing.T) {
+	got := runLua(t, `
+items = {"a", "b", "c"}
+for v, loop in each(items) do
+	Emit(v, loop.index, loop.length, loop.first, loop.last, loop.even, loop.odd)
+end`)
+
+	want := []string{
+		"a 1 3 true false false true",
+		"b 2 3 false false true false",
+		"c 3 3 false true false true",
+	}
+
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Errorf("each() mismatch (-want +got):\n%s", diff)
+	}
+}
+
+func TestEachEmpty(t *testing.T) {
+	got := runLua(t, `
+for v, loop in each({}) do
+	Emit(v)
+end`)
+
+	if diff := cmp.Diff([]string{}, got); diff != "" {
+		t.Errorf("each() mismatch (-want +got):\n%s", diff)
+	}
+}
+
+func TestEachSeparator(t *testing.T) {
+	got := runLua(t, `
+s = ""
+for v, loop in each({"x", "y", "z"}) do
+	s = s .. v
+	if not loop.last then s = s .. ", " end
+end
+Emit(s)`)
+
+	if diff := cmp.Diff([]string{"x, y, z"}, got); diff != "" {
+		t.Errorf("each() mismatch (-want +got):\n%s", diff)
+	}
+}
@@ -0,0 +1,255 @@
+package utils
+
+import (
+	"bufio"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"unicode/utf8"
+)
+
+var ErrXMLMarshalling = errors.New("xmlMarshallingErr")
+
+// XML unmarshaling and marshaling in Go is currently (1.18) not canonical.
+// - https://github.com/golang/go/issues/9519
+// - https://github.com/golang/go/issues/13400#issuecomment-168334855
+//
+// Usual go marshaling:
+//    <p xmlns="text" xmlns:text="text" text:style-name="P2">A4,3</p>
+// Marshaling as required for OpenDocuments:
+//    <text:p text:style-name="P2">A4,2</text:p>
+//
+// The decoder resolves the prefixes to the namespace URLs, so the XMLEncoder tracks the
+// namespace declarations of the written elements and maps the URLs back to their prefixes.
+
+const xmlURL = "http://www.w3.org/XML/1998/namespace"
+
+// nsBinding binds a prefix to a namespace URL. The default namespace has an empty prefix.
+type nsBinding struct {
+	prefix string
+	url    string
+}
+
+// nsScope holds the namespace declarations of an open element.
+type nsScope struct {
+	name     xml.Name
+	bindings []nsBinding
+}
+
+// XMLEncoder writes XML tokens as read by xml.Decoder.Token. Other than xml.Encoder it keeps the
+// namespace prefixes and declarations of the elements as they were in the decoded document.
+type XMLEncoder struct {
+	w         *bufio.Writer
+	scopes    []nsScope
+	generated int
+}
+
+// NewXMLEncoder returns a new encoder that writes to w.
+func NewXMLEncoder(w io.Writer) *XMLEncoder {
+	return &XMLEncoder{w: bufio.NewWriter(w)}
+}
+
+// EncodeToken writes the token. Start and end elements need to be balanced.
+func (e *XMLEncoder) EncodeToken(t xml.Token) error {
+	switch v := t.(type) {
+	case xml.StartElement:
+		e.writeStart(v)
+	case xml.EndElement:
+		return e.writeEnd(v)
+	case xml.CharData:
+		escape(e.w, string(v), false)
+	case xml.Comment:
+		if strings.Contains(string(v), "--") {
+			return FormatError(ErrXMLMarshalling, "comment must not contain \"--\"")
+		}
+
+		fmt.Fprintf(e.w, "<!--%s-->", v)
+	case xml.ProcInst:
+		if strings.Contains(string(v.Inst), "?>") {
+			return FormatError(ErrXMLMarshalling, "processing instruction must not contain \"?>\"")
+		}
+
+		if len(v.Inst) == 0 {
+			fmt.Fprintf(e.w, "<?%s?>", v.Target)
+		} else {
+			fmt.Fprintf(e.w, "<?%s %s?>", v.Target, v.Inst)
+		}
+	case xml.Directive:
+		fmt.Fprintf(e.w, "<!%s>", v)
+	case nil:
+		return nil
+	default:
+		return FormatError(ErrXMLMarshalling, fmt.Sprintf("invalid token type %T", t))
+	}
+
+	return nil
+}
+
+// Flush writes the buffered XML to the underlying writer.
+func (e *XMLEncoder) Flush() error {
+	err := e.w.Flush()
+	if err != nil {
+		return FormatError(ErrXMLMarshalling, fmt.Sprintf("unable to write to buffer: %v", err))
+	}
+
+	return nil
+}
+
+func (e *XMLEncoder) writeStart(start xml.StartElement) {
+	// The declarations of the element are in scope for its own name and attributes
+	scope := nsScope{name: start.Name}
+
+	for _, attr := range start.Attr {
+		switch {
+		case attr.Name.Space == "xmlns":
+			scope.bindings = append(scope.bindings, nsBinding{prefix: attr.Name.Local, url: attr.Value})
+		case attr.Name.Space == "" && attr.Name.Local == "xmlns":
+			scope.bindings = append(scope.bindings, nsBinding{url: attr.Value})
+		}
+	}
+
+	e.scopes = append(e.scopes, scope)
+	declared := len(scope.bindings)
+
+	e.w.WriteByte('<')
+	e.w.WriteString(e.qualifiedName(start.Name, true))
+
+	for _, attr := range start.Attr {
+		if attr.Name.Local == "" {
+			continue
+		}
+
+		e.w.WriteByte(' ')
+
+		switch {
+		case attr.Name.Space == "xmlns":
+			e.w.WriteString("xmlns:" + attr.Name.Local)
+		case attr.Name.Space == "" && attr.Name.Local == "xmlns":
+			e.w.WriteString("xmlns")
+		default:
+			e.w.WriteString(e.qualifiedName(attr.Name, false))
+		}
+
+		e.w.WriteString(`="`)
+		escape(e.w, attr.Value, true)
+		e.w.WriteByte('"')
+	}
+
+	// Namespaces without declaration in the written document get one on the element
+	for _, b := range e.scopes[len(e.scopes)-1].bindings[declared:] {
+		fmt.Fprintf(e.w, ` xmlns:%s="`, b.prefix)
+		escape(e.w, b.url, true)
+		e.w.WriteByte('"')
+	}
+
+	e.w.WriteByte('>')
+}
+
+func (e *XMLEncoder) writeEnd(end xml.EndElement) error {
+	if len(e.scopes) == 0 {
+		return FormatError(ErrXMLMarshalling, fmt.Sprintf("end element %s without start element", end.Name.Local))
+	}
+
+	if start := e.scopes[len(e.scopes)-1].name; start != end.Name {
+		return FormatError(ErrXMLMarshalling,
+			fmt.Sprintf("end element %s does not match start element %s", end.Name.Local, start.Local))
+	}
+
+	e.w.WriteString("</")
+	e.w.WriteString(e.qualifiedName(end.Name, true))
+	e.w.WriteByte('>')
+
+	e.scopes = e.scopes[:len(e.scopes)-1]
+
+	return nil
+}
+
+// qualifiedName returns the name with the prefix bound to its namespace.
+func (e *XMLEncoder) qualifiedName(name xml.Name, element bool) string {
+	prefix := e.prefix(name.Space, element)
+	if prefix == "" {
+		return name.Local
+	}
+
+	return prefix + ":" + name.Local
+}
+
+// prefix returns the prefix bound to the namespace. Only elements can use the default namespace.
+func (e *XMLEncoder) prefix(space string, element bool) string {
+	if space == "" {
+		return ""
+	}
+
+	if space == xmlURL {
+		return "xml"
+	}
+
+	for i := len(e.scopes) - 1; i >= 0; i-- {
+		for _, b := range e.scopes[i].bindings {
+			if b.url == space && (element || b.prefix != "") && e.lookup(b.prefix) == space {
+				return b.prefix
+			}
+		}
+	}
+
+	// Undeclared prefixes of document fragments are kept as they are
+	if !strings.ContainsAny(space, ":/") {
+		return space
+	}
+
+	e.generated++
+	b := nsBinding{prefix: fmt.Sprintf("ns%d", e.generated), url: space}
+	e.scopes[len(e.scopes)-1].bindings = append(e.scopes[len(e.scopes)-1].bindings, b)
+
+	return b.prefix
+}
+
+// lookup returns the namespace the prefix is bound to in the current scope.
+func (e *XMLEncoder) lookup(prefix string) string {
+	for i := len(e.scopes) - 1; i >= 0; i-- {
+		for _, b := range e.scopes[i].bindings {
+			if b.prefix == prefix {
+				return b.url
+			}
+		}
+	}
+
+	return ""
+}
+
+// escape writes the text with the characters escaped that are not allowed in character data
+// or attribute values. Whitespace in attributes is escaped, so it isn't normalized when read.
+func escape(w *bufio.Writer, s string, attr bool) {
+	for _, r := range s {
+		switch {
+		case r == '&':
+			w.WriteString("&amp;")
+		case r == '<':
+			w.WriteString("&lt;")
+		case r == '>':
+			w.WriteString("&gt;")
+		case r == '\r':
+			w.WriteString("&#xD;")
+		case attr && r == '"':
+			w.WriteString("&quot;")
+		case attr && r == '\n':
+			w.WriteString("&#xA;")
+		case attr && r == '\t':
+			w.WriteString("&#x9;")
+		case !IsXMLChar(r):
+			w.WriteRune(utf8.RuneError)
+		default:
+			w.WriteRune(r)
+		}
+	}
+}
+
+// IsXMLChar reports whether the rune is allowed in XML 1.0 documents.
+func IsXMLChar(r rune) bool {
+	return r == 0x09 || r == 0x0A || r == 0x0D ||
+		r >= 0x20 && r <= 0xD7FF ||
+		r >= 0xE000 && r <= 0xFFFD ||
+		r >= 0x10000 && r <= 0x10FFFF
+}
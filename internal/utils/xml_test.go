@@ -1,12 +1,17 @@
-package engine
+package utils
 
 import (
+	"archive/zip"
+	"bytes"
 	"encoding/xml"
+	"io"
+	"io/ioutil"
 	"strings"
 	"testing"
 
 	"github.com/djboris9/xmltree"
 	"github.com/google/go-cmp/cmp"
+	"github.com/stretchr/testify/require"
 )
 
 func TestCustomEncoder(t *testing.T) {
@@ -64,17 +69,136 @@ func TestCustomEncoderLarge(t *testing.T) {
 	}
 }
 
+func TestXMLEncoderNamespaces(t *testing.T) {
+	xmlData := `<office:document xmlns:office="urn:office" xmlns:text="urn:text" xmlns="urn:default">` +
+		`<text:p text:style-name="P1" xml:lang="en">A</text:p><body><text:span xmlns:text="urn:other" text:a="b">` +
+		`</text:span></body></office:document>`
+	require.Equal(t, xmlData, encodeXML(t, xmlData))
+
+	// Namespaces that are not declared in the written document are declared where used
+	buf := new(bytes.Buffer)
+	enc := NewXMLEncoder(buf)
+	require.Nil(t, enc.EncodeToken(xml.StartElement{
+		Name: xml.Name{Space: "urn:a", Local: "root"},
+		Attr: []xml.Attr{{Name: xml.Name{Space: "urn:b", Local: "attr"}, Value: "v"}},
+	}))
+	require.Nil(t, enc.EncodeToken(xml.EndElement{Name: xml.Name{Space: "urn:a", Local: "root"}}))
+	require.Nil(t, enc.Flush())
+	require.Equal(t, `<ns1:root ns2:attr="v" xmlns:ns1="urn:a" xmlns:ns2="urn:b"></ns1:root>`, buf.String())
+}
+
+func TestXMLEncoderEscape(t *testing.T) {
+	xmlData := `<p a="&quot;&lt;&amp;&gt;&#xA;&#x9;'">"&lt;&amp;&gt;'` + "\n\t" + `&#xD;</p>`
+	require.Equal(t, xmlData, encodeXML(t, xmlData))
+
+	buf := new(bytes.Buffer)
+	enc := NewXMLEncoder(buf)
+	require.Nil(t, enc.EncodeToken(xml.CharData("a\x00b")))
+	require.Nil(t, enc.Flush())
+	require.Equal(t, "a\uFFFDb", buf.String())
+}
+
+func TestXMLEncoderBalance(t *testing.T) {
+	enc := NewXMLEncoder(ioutil.Discard)
+	require.ErrorIs(t, enc.EncodeToken(xml.EndElement{Name: xml.Name{Local: "p"}}), ErrXMLMarshalling)
+
+	require.Nil(t, enc.EncodeToken(xml.StartElement{Name: xml.Name{Local: "p"}}))
+	require.ErrorIs(t, enc.EncodeToken(xml.EndElement{Name: xml.Name{Local: "span"}}), ErrXMLMarshalling)
+}
+
+// Documents written by office suites keep their prefixes and declarations when written again.
+func TestXMLEncoderRoundTrip(t *testing.T) {
+	tests := []struct {
+		file string
+		part string
+	}{
+		{"Basic1.ott", "content.xml"},
+		{"Basic1.ott", "styles.xml"},
+		{"Basic1.docx", "word/document.xml"},
+		{"Spreadsheet1.xlsx", "xl/workbook.xml"},
+		{"Conditional1.fodt", ""},
+	}
+
+	for _, tc := range tests {
+		data := readTestPart(t, tc.file, tc.part)
+		got := encodeXML(t, string(data))
+		require.Equal(t, rawTokens(t, data), rawTokens(t, []byte(got)), tc.file)
+	}
+}
+
+// encodeXML decodes the XML and encodes the tokens again.
+func encodeXML(t *testing.T, data string) string {
+	t.Helper()
+
+	tree, err := xmltree.Parse([]byte(data))
+	require.Nil(t, err)
+
+	nodePath := []*xmltree.Node{}
+	err = xmltree.Walk(tree, func(node *xmltree.Node, depth uint) error {
+		nodePath = append(nodePath, node)
+		return nil
+	})
+	require.Nil(t, err)
+
+	return serializeNodePathCustom(t, nodePath)
+}
+
+// rawTokens returns the tokens with their prefixes as written in the XML.
+func rawTokens(t *testing.T, data []byte) []xml.Token {
+	t.Helper()
+
+	d := xml.NewDecoder(bytes.NewReader(data))
+	tokens := []xml.Token{}
+
+	for {
+		tok, err := d.RawToken()
+		if err == io.EOF {
+			return tokens
+		}
+
+		require.Nil(t, err)
+		tokens = append(tokens, xml.CopyToken(tok))
+	}
+}
+
+// readTestPart reads the part of a package in testdata, or the file itself if part is empty.
+func readTestPart(t *testing.T, file, part string) []byte {
+	t.Helper()
+
+	if part == "" {
+		data, err := ioutil.ReadFile("../../testdata/" + file)
+		require.Nil(t, err)
+
+		return data
+	}
+
+	r, err := zip.OpenReader("../../testdata/" + file)
+	require.Nil(t, err)
+	defer r.Close()
+
+	fd, err := r.Open(part)
+	require.Nil(t, err)
+	defer fd.Close()
+
+	data, err := ioutil.ReadAll(fd)
+	require.Nil(t, err)
+
+	return data
+}
+
 func serializeNodePathCustom(t *testing.T, nodePath []*xmltree.Node) string {
 	var buf strings.Builder
-	enc := xml.NewEncoder(&buf)
+	enc := NewXMLEncoder(&buf)
 
 	for i := range nodePath {
-		if err := EncodeToken(enc, &buf, nodePath[i].Token); err != nil {
+		if err := enc.EncodeToken(nodePath[i].Token); err != nil {
 			t.Errorf("encoding token %d: %s", i, err)
 		}
 	}
 
-	enc.Flush()
+	if err := enc.Flush(); err != nil {
+		t.Error(err)
+	}
 
 	return buf.String()
 }
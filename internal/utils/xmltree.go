@@ -0,0 +1,66 @@
+package utils
+
+import (
+	"encoding/xml"
+	"strings"
+
+	"github.com/djboris9/xmltree"
+)
+
+// FindChild returns the first child element of node with the given name or nil.
+func FindChild(node *xmltree.Node, space, local string) *xmltree.Node {
+	if node == nil {
+		return nil
+	}
+
+	for _, n := range node.Nodes {
+		if elem, ok := n.Token.(xml.StartElement); ok && elem.Name.Space == space && elem.Name.Local == local {
+			return n
+		}
+	}
+
+	return nil
+}
+
+// ElementChildren returns the child elements of node.
+func ElementChildren(node *xmltree.Node) []*xmltree.Node {
+	if node == nil {
+		return nil
+	}
+
+	children := []*xmltree.Node{}
+
+	for _, n := range node.Nodes {
+		if _, ok := n.Token.(xml.StartElement); ok {
+			children = append(children, n)
+		}
+	}
+
+	return children
+}
+
+// AttrValue returns the value of the attribute with the given name and if the element has it.
+func AttrValue(elem xml.StartElement, space, local string) (string, bool) {
+	for _, a := range elem.Attr {
+		if a.Name.Space == space && a.Name.Local == local {
+			return a.Value, true
+		}
+	}
+
+	return "", false
+}
+
+// NodeText returns the concatenated char data of the node and its descendants.
+func NodeText(node *xmltree.Node) string {
+	var b strings.Builder
+
+	_ = xmltree.Walk(node, func(n *xmltree.Node, depth uint) error {
+		if d, ok := n.Token.(xml.CharData); ok {
+			b.Write(d)
+		}
+
+		return nil
+	})
+
+	return b.String()
+}
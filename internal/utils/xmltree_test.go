@@ -0,0 +1,30 @@
+package utils
+
+import (
+	"encoding/xml"
+	"testing"
+
+	"github.com/djboris9/xmltree"
+	"github.com/stretchr/testify/require"
+)
+
+func TestTreeHelpers(t *testing.T) {
+	tree, err := xmltree.Parse([]byte(`<a:root xmlns:a="urn:a"><a:item a:id="1">One <a:b>two</a:b></a:item>text<a:item/></a:root>`))
+	require.Nil(t, err)
+
+	root := FindChild(tree, "urn:a", "root")
+	require.NotNil(t, root)
+	require.Nil(t, FindChild(root, "urn:a", "missing"))
+	require.Nil(t, FindChild(nil, "urn:a", "root"))
+	require.Len(t, ElementChildren(root), 2)
+
+	item := FindChild(root, "urn:a", "item")
+	id, ok := AttrValue(item.Token.(xml.StartElement), "urn:a", "id")
+	require.True(t, ok)
+	require.Equal(t, "1", id)
+
+	_, ok = AttrValue(item.Token.(xml.StartElement), "", "id")
+	require.False(t, ok)
+
+	require.Equal(t, "One two", NodeText(item))
+}
@@ -0,0 +1,75 @@
+package utils
+
+import (
+	"archive/zip"
+	"compress/flate"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+)
+
+// ZipModTime is the modification time of the entries of deterministic packages.
+// It is the earliest time a zip archive can hold.
+var ZipModTime = time.Date(1980, time.January, 1, 0, 0, 0, 0, time.UTC)
+
+// NewZipWriter returns a zip writer that compresses with a fixed level,
+// so the same entries result in the same archive.
+func NewZipWriter(w io.Writer) *zip.Writer {
+	zipWriter := zip.NewWriter(w)
+	zipWriter.RegisterCompressor(zip.Deflate, func(out io.Writer) (io.WriteCloser, error) {
+		return flate.NewWriter(out, flate.DefaultCompression)
+	})
+
+	return zipWriter
+}
+
+// CopyZipFile copies the file with its compressed data to the zip writer. With fixedModTime the
+// copy gets ZipModTime as modification time, otherwise it keeps the one of the file.
+func CopyZipFile(zipWriter *zip.Writer, f *zip.File, fixedModTime bool) error {
+	header := &zip.FileHeader{
+		Name:               f.Name,
+		Comment:            f.Comment,
+		Method:             f.Method,
+		Flags:              f.Flags &^ 0x8, // The sizes are known, no data descriptor is required
+		ModifiedTime:       f.ModifiedTime,
+		ModifiedDate:       f.ModifiedDate,
+		Extra:              f.Extra,
+		ExternalAttrs:      f.ExternalAttrs,
+		CreatorVersion:     f.CreatorVersion,
+		CRC32:              f.CRC32,
+		CompressedSize64:   f.CompressedSize64,
+		UncompressedSize64: f.UncompressedSize64,
+	}
+
+	// Extra fields can hold further timestamps, so they are left out as well
+	if fixedModTime {
+		header.ModifiedTime = 0
+		header.ModifiedDate = 1<<5 | 1 // 1980-01-01
+		header.Extra = nil
+		header.ExternalAttrs = 0
+		header.CreatorVersion = 0
+	}
+
+	w, err := zipWriter.CreateRaw(header)
+	if err != nil {
+		return fmt.Errorf("creating %s: %w", f.Name, err)
+	}
+
+	// Directories have no data, even if the compressed size of an empty stream is recorded
+	if strings.HasSuffix(f.Name, "/") {
+		return nil
+	}
+
+	r, err := f.OpenRaw()
+	if err != nil {
+		return fmt.Errorf("opening %s: %w", f.Name, err)
+	}
+
+	_, err = io.Copy(w, r)
+	if err != nil {
+		return fmt.Errorf("copying %s: %w", f.Name, err)
+	}
+
+	return nil
+}